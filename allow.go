@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// allowedBucketsEnv names the environment variable holding the bucket
+// allowlist, for environments such as CI where flags are easy to forget.
+const allowedBucketsEnv = "GCSLS_ALLOWED_BUCKETS"
+
+// splitAllowlist splits a comma-separated list of bucket globs.
+func splitAllowlist(v string) []string {
+	var patterns []string
+	for _, p := range strings.Split(v, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+// validateAllowlist checks that every glob in a bucket allowlist is valid.
+func validateAllowlist(source, v string) error {
+	for _, p := range splitAllowlist(v) {
+		if !doublestar.ValidatePattern(p) {
+			return fmt.Errorf("invalid bucket pattern %q in %s", p, source)
+		}
+	}
+	return nil
+}
+
+// checkBucketAllowed returns an error if a bucket allowlist is configured
+// and bucket does not match it. When both the environment variable and the
+// flag are set, the bucket must match both, so a flag can narrow but never
+// widen the environment's restriction.
+func checkBucketAllowed(bucket string, opts *options) error {
+	lists := []struct{ source, value string }{
+		{allowedBucketsEnv, opts.allowedBucketsEnv},
+		{"--allowed-buckets", opts.allowedBuckets},
+	}
+	for _, l := range lists {
+		if l.value == "" {
+			continue
+		}
+		allowed := false
+		for _, p := range splitAllowlist(l.value) {
+			if ok, _ := doublestar.Match(p, bucket); ok {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("bucket %q is not allowed by %s (%s)", bucket, l.source, l.value)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// asOfResolver reconstructs the set of live objects at a past point in time
+// from a versioned listing. GCS returns all generations of an object name
+// consecutively, so only the generations of the current name are held.
+type asOfResolver struct {
+	at   time.Time
+	name string
+	best *storage.ObjectAttrs
+}
+
+// add considers one generation of an object. When a new object name begins,
+// it returns the generation of the previous name that was live at the
+// requested time, or nil if there was none.
+func (r *asOfResolver) add(attrs *storage.ObjectAttrs) *storage.ObjectAttrs {
+	var done *storage.ObjectAttrs
+	if attrs.Name != r.name {
+		done = r.flush()
+		r.name = attrs.Name
+	}
+	if liveAt(attrs, r.at) && (r.best == nil || attrs.Generation > r.best.Generation) {
+		r.best = attrs
+	}
+	return done
+}
+
+// flush returns the resolved generation of the current object name, if any,
+// and resets the resolver for the next name.
+func (r *asOfResolver) flush() *storage.ObjectAttrs {
+	best := r.best
+	r.best = nil
+	return best
+}
+
+// liveAt reports whether a generation existed and was the live version at t:
+// it was created at or before t and had not been deleted or replaced by then.
+func liveAt(attrs *storage.ObjectAttrs, t time.Time) bool {
+	if attrs.Created.After(t) {
+		return false
+	}
+	return attrs.Deleted.IsZero() || attrs.Deleted.After(t)
+}
+
+// parseTimestamp parses a timestamp given on the command line, either in
+// RFC 3339 form or as a plain UTC date (YYYY-MM-DD).
+func parseTimestamp(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02", v); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp %q: use RFC 3339 (2024-01-02T15:04:05Z) or YYYY-MM-DD", v)
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+// readPatterns reads the --batch-stat patterns from r, one gs:// URL per
+// line. Blank lines and lines starting with # are skipped.
+func readPatterns(r io.Reader) ([]string, error) {
+	var patterns []string
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !strings.HasPrefix(line, "gs://") {
+			return nil, fmt.Errorf("stdin:%d: expected a gs://bucket/pattern, got %q", n, line)
+		}
+		patterns = append(patterns, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read patterns from stdin: %w", err)
+	}
+	if len(patterns) == 0 {
+		return nil, fmt.Errorf("no patterns on stdin")
+	}
+	return patterns, nil
+}
+
+// fetchResult is the outcome of fetching one object's full attributes.
+type fetchResult struct {
+	listed *storage.ObjectAttrs
+	attrs  *storage.ObjectAttrs
+	err    error
+}
+
+// batchFetcher fetches the full attributes of each matched object for
+// --batch-stat, up to concurrency at a time, and hands them to emit in the
+// order the objects were submitted, so the output follows the listing even
+// though the fetches finish in any order. Like workerPool, the first
+// failure cancels its context unless keepGoing is set.
+type batchFetcher struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	client *storage.Client
+	emit   func(*storage.ObjectAttrs) error
+
+	keepGoing bool
+	// status receives the per-object errors reported under keepGoing,
+	// unless errors records every failure instead.
+	status io.Writer
+	errors *errorLog
+	// emitError, when set, also writes each failed fetch to the output, in
+	// its place in the listing order.
+	emitError func(listed *storage.ObjectAttrs, err error) error
+
+	// pending holds one result per submitted object, in submission order;
+	// slots bounds the fetches in flight.
+	pending chan chan fetchResult
+	slots   chan struct{}
+	done    chan struct{}
+
+	// Below are only touched by the goroutine that calls emit, until done
+	// is closed.
+	firstErr error
+	failures int
+	objects  int
+	bytes    int64
+	// total is only touched by the submitting goroutine.
+	total int
+}
+
+// newBatchFetcher starts a fetcher that passes full attributes to emit. The
+// returned fetcher's ctx should be used for the listing, so that it stops
+// when the fetcher is cancelled.
+func newBatchFetcher(ctx context.Context, client *storage.Client, concurrency int, keepGoing bool,
+	status io.Writer, emit func(*storage.ObjectAttrs) error) *batchFetcher {
+	ctx, cancel := context.WithCancel(ctx)
+	f := &batchFetcher{
+		ctx:       ctx,
+		cancel:    cancel,
+		client:    client,
+		emit:      emit,
+		keepGoing: keepGoing,
+		status:    status,
+		pending:   make(chan chan fetchResult, concurrency),
+		slots:     make(chan struct{}, concurrency),
+		done:      make(chan struct{}),
+	}
+	go f.deliver()
+	return f
+}
+
+// submit starts fetching the attributes of a listed object, blocking while
+// concurrency fetches are in flight. It returns the context error once the
+// fetcher has been cancelled.
+func (f *batchFetcher) submit(listed *storage.ObjectAttrs) error {
+	select {
+	case f.slots <- struct{}{}:
+	case <-f.ctx.Done():
+		return f.ctx.Err()
+	}
+	res := make(chan fetchResult, 1)
+	select {
+	case f.pending <- res:
+	case <-f.ctx.Done():
+		<-f.slots
+		return f.ctx.Err()
+	}
+	f.total++
+	go func() {
+		defer func() { <-f.slots }()
+		// The listed generation pins the fetch to the version that matched.
+		attrs, err := f.client.Bucket(listed.Bucket).Object(listed.Name).Generation(listed.Generation).Attrs(f.ctx)
+		res <- fetchResult{listed: listed, attrs: attrs, err: err}
+	}()
+	return nil
+}
+
+// deliver waits for each result in submission order and emits it.
+func (f *batchFetcher) deliver() {
+	defer close(f.done)
+	for res := range f.pending {
+		r := <-res
+		// After a failure without keepGoing, the rest is only drained.
+		if f.firstErr != nil && !f.keepGoing {
+			continue
+		}
+		if r.err != nil {
+			if f.fail(r.listed, r.err) && f.emitError != nil {
+				if err := f.emitError(r.listed, r.err); err != nil {
+					f.writeFailed(err)
+				}
+			}
+			continue
+		}
+		f.objects++
+		f.bytes += r.attrs.Size
+		if err := f.emit(r.attrs); err != nil {
+			f.writeFailed(err)
+		}
+	}
+}
+
+// writeFailed stops the fetcher because the output cannot be written, which
+// ends the run even under keepGoing.
+func (f *batchFetcher) writeFailed(err error) {
+	if f.firstErr == nil || f.keepGoing {
+		f.firstErr = fmt.Errorf("failed to write output: %w", err)
+	}
+	f.keepGoing = false
+	f.cancel()
+}
+
+// fail records a failed fetch, cancelling the fetcher unless keepGoing is
+// set. It reports whether the failure counts, which fetches aborted because
+// of an earlier failure do not.
+func (f *batchFetcher) fail(listed *storage.ObjectAttrs, err error) bool {
+	if f.firstErr != nil && errors.Is(err, context.Canceled) {
+		return false
+	}
+	f.failures++
+	if f.firstErr == nil {
+		f.firstErr = fmt.Errorf("gs://%s/%s: failed to fetch object attributes: %w", listed.Bucket, listed.Name, err)
+	}
+	logged := f.errors.object(listed, err)
+	if f.keepGoing {
+		if !logged {
+			fmt.Fprintf(f.status, "Error: gs://%s/%s: %v\n", listed.Bucket, listed.Name, err)
+		}
+		return true
+	}
+	f.cancel()
+	return true
+}
+
+// errorRecord is the --ndjson-errors record of an object whose attributes
+// could not be fetched. Its "type" field tells it apart from the object
+// records.
+type errorRecord struct {
+	Type       string `json:"type"`
+	Bucket     string `json:"bucket"`
+	Name       string `json:"name"`
+	Generation int64  `json:"generation"`
+	Error      string `json:"error"`
+}
+
+// writeErrorRecord writes the error record of a failed fetch, framed as
+// writeJSONRecord describes when framed is set.
+func writeErrorRecord(w io.Writer, listed *storage.ObjectAttrs, err error, framed bool) error {
+	return writeJSONRecord(w, errorRecord{
+		Type:       "error",
+		Bucket:     listed.Bucket,
+		Name:       listed.Name,
+		Generation: listed.Generation,
+		Error:      err.Error(),
+	}, framed)
+}
+
+// wait stops accepting objects, waits until every fetched object has been
+// emitted, and returns the first failure (or a failure count under
+// keepGoing). It is safe to call more than once.
+func (f *batchFetcher) wait() error {
+	select {
+	case <-f.done:
+	default:
+		close(f.pending)
+		<-f.done
+	}
+	f.cancel()
+	if f.firstErr == nil {
+		return nil
+	}
+	if f.keepGoing {
+		return fmt.Errorf("%d of %d attribute fetches failed", f.failures, f.total)
+	}
+	return f.firstErr
+}
+
+// printSummary writes the totals of the fetched objects.
+func (f *batchFetcher) printSummary(w io.Writer, patterns int) {
+	fmt.Fprintf(w, "Fetched the attributes of %d objects (%s) matching %d patterns\n",
+		f.objects, formatBytes(f.bytes), patterns)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"cloud.google.com/go/storage"
+)
+
+// scanBuckets runs the scans of up to n buckets at once for
+// --bucket-concurrency, the scans of each bucket one after another in the
+// order given. All the scans share one lock, which a scan only releases
+// while it waits for the next object from GCS: everything else, from
+// matching to writing the output, happens one object at a time, so the
+// scans share stats, filters and output as safely as the sequential loop
+// does and lines of different buckets never mix. Matches of different
+// buckets are interleaved, each bucket's in its listing order. Like the
+// sequential loop, it stops a bucket's scans when emit returns
+// errBucketLimitReached, or skip reports the bucket done, and every scan
+// on errLimitReached.
+func scanBuckets(ctx context.Context, client *storage.Client, scans []listTarget, n int, opts *options,
+	filters []objectFilter, stats *scanStats, skip func(listTarget) bool, emit func(*storage.ObjectAttrs) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var order []string
+	byBucket := make(map[string][]listTarget)
+	for _, t := range scans {
+		if byBucket[t.bucket] == nil {
+			order = append(order, t.bucket)
+		}
+		byBucket[t.bucket] = append(byBucket[t.bucket], t)
+	}
+
+	var mu sync.Mutex
+	stats.shared = &mu
+	defer func() { stats.shared = nil }()
+	var (
+		wg       sync.WaitGroup
+		firstErr error
+		slots    = make(chan struct{}, n)
+	)
+	for _, bucket := range order {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			mu.Lock()
+			defer mu.Unlock()
+			for _, t := range byBucket[bucket] {
+				if ctx.Err() != nil {
+					return
+				}
+				if skip(t) {
+					continue
+				}
+				err := scanMatches(ctx, client, t, opts, filters, stats, emit)
+				switch {
+				case errors.Is(err, errBucketLimitReached):
+					return
+				case errors.Is(err, errLimitReached):
+					cancel()
+					return
+				case err != nil:
+					// Once the first failure or the limit has cancelled the
+					// other scans, their own errors say nothing new.
+					if firstErr == nil && ctx.Err() == nil {
+						firstErr = err
+					}
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return firstErr
+}
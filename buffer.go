@@ -0,0 +1,28 @@
+package main
+
+import "fmt"
+
+// checkBuffered returns an error once a mode that must hold its matches in
+// memory, named by what, holds more than limit of them, so that a careless
+// pattern over a huge bucket fails early with a clear message instead of
+// exhausting memory. A limit of 0 means no limit; --max-buffered sets it
+// for every such mode.
+func checkBuffered(held, limit int, what string) error {
+	if limit > 0 && held > limit {
+		return fmt.Errorf("%s holds more than %d matches in memory, the --max-buffered limit; "+
+			"narrow the pattern or raise the limit", what, limit)
+	}
+	return nil
+}
+
+// sortSpillLimit returns how many matches --sort holds in memory before
+// spilling to disk: --sort-buffer-limit, lowered to --max-buffered when
+// that is smaller. Sorting can spill, so it stays within the limit without
+// failing.
+func sortSpillLimit(opts *options) int {
+	limit := opts.sortBufferLimit
+	if opts.maxBuffered > 0 && (limit == 0 || limit > opts.maxBuffered) {
+		limit = opts.maxBuffered
+	}
+	return limit
+}
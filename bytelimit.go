@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"cloud.google.com/go/storage"
+)
+
+// byteBudget is the --limit-bytes budget: the total size of the matches
+// taken so far, and whether a match no longer fit.
+type byteBudget struct {
+	limit   int64
+	used    int64
+	objects int
+	hit     bool
+}
+
+// newByteBudget returns the budget of limit bytes, or nil for no limit.
+func newByteBudget(limit int64) *byteBudget {
+	if limit <= 0 {
+		return nil
+	}
+	return &byteBudget{limit: limit}
+}
+
+// take reports whether attrs still fits into the budget, counting it if so.
+// The first match that would go over the budget ends the listing, so the
+// matches taken are a prefix of the listing that totals at most the limit.
+// A nil budget takes everything.
+func (b *byteBudget) take(attrs *storage.ObjectAttrs) bool {
+	if b == nil {
+		return true
+	}
+	if attrs.Size > b.limit-b.used {
+		b.hit = true
+		return false
+	}
+	b.used += attrs.Size
+	b.objects++
+	return true
+}
+
+// report notes on status that the budget ended the listing, if it did.
+func (b *byteBudget) report(status io.Writer) {
+	if b == nil || !b.hit {
+		return
+	}
+	fmt.Fprintf(status, "Stopped at the --limit-bytes budget of %s: %d matches totalling %s (%d bytes)\n",
+		formatBytes(b.limit), b.objects, formatBytes(b.used), b.used)
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// cacheVersion identifies the layout of --cache-list files, so that a file
+// written by an incompatible version is relisted instead of misread.
+const cacheVersion = 1
+
+// cacheHeader is the first JSON value of a --cache-list file; one JSON
+// object per listed object follows it.
+type cacheHeader struct {
+	Version int          `json:"version"`
+	Created time.Time    `json:"created"`
+	Scans   []cachedScan `json:"scans"`
+}
+
+// cachedScan describes one listing stored in a cache file.
+type cachedScan struct {
+	Bucket string `json:"bucket"`
+	Prefix string `json:"prefix"`
+	// StartOffset is the first name listed, set when the listing started
+	// after --after.
+	StartOffset string `json:"startOffset,omitempty"`
+	Versions    bool   `json:"versions"`
+	SoftDeleted bool   `json:"softDeleted"`
+	// Full is set when the listing used the full projection, which adds
+	// the owners.
+	Full bool `json:"full"`
+	// Fingerprint, written with --cache-revalidate, sums up the top level
+	// of the prefix when the listing started; see topLevelFingerprint.
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+// covers reports whether the cached listing holds every object a scan of
+// target would list under opts.
+func (s cachedScan) covers(target listTarget, opts *options) bool {
+	query := buildQuery(target.prefix, opts)
+	return s.Bucket == target.bucket && strings.HasPrefix(target.prefix, s.Prefix) &&
+		s.StartOffset <= max(query.StartOffset, query.Prefix) && s.Versions == query.Versions && s.SoftDeleted == query.SoftDeleted &&
+		(s.Full || query.Projection != storage.ProjectionFull)
+}
+
+// listCache serves listings from a --cache-list file, or records them into
+// a new one. A cache that is missing, older than --cache-ttl or does not
+// cover every scan of the run is replaced by listing the bucket again. With
+// --cache-revalidate, the age limit is --cache-max-age instead, but a cache
+// is only served once the top level of each cached prefix is found
+// unchanged.
+type listCache struct {
+	path string
+	// reading holds the scans of a valid cache file served in place of
+	// the bucket; it is nil when the run lists the bucket.
+	reading []cachedScan
+
+	// When writing, objects go to a temporary file and the scans whose
+	// listing completed are collected; commit then writes the header and
+	// moves the objects into place.
+	objects  *os.File
+	w        *bufio.Writer
+	enc      *json.Encoder
+	complete []cachedScan
+	// fingerprints holds the top-level fingerprint of each scan, by bucket
+	// and prefix, taken before it was listed.
+	fingerprints map[string]string
+}
+
+// openListCache returns the cache for a run of scans, or nil without
+// --cache-list. With --use-cache, a valid cache file is served; otherwise
+// the scans are listed and recorded.
+func openListCache(ctx context.Context, client *storage.Client, scans []listTarget, opts *options, status io.Writer) (*listCache, error) {
+	if opts.cacheList == "" {
+		return nil, nil
+	}
+	c := &listCache{path: opts.cacheList}
+	maxAge, ageFlag := opts.cacheTTL, "--cache-ttl"
+	if opts.cacheRevalidate {
+		maxAge, ageFlag = opts.cacheMaxAge, "--cache-max-age"
+	}
+	if opts.useCache {
+		header, err := readCacheHeader(c.path)
+		if err != nil {
+			return nil, err
+		}
+		switch age := time.Since(header.Created); {
+		case header.Version == 0:
+			fmt.Fprintf(status, "No listing is cached in %s yet\n", c.path)
+		case header.Version != cacheVersion:
+			fmt.Fprintf(status, "Relisting: %s was written by another version\n", c.path)
+		case age > maxAge:
+			fmt.Fprintf(status, "Relisting: %s is %s old, older than %s\n", c.path, age.Round(time.Second), ageFlag)
+		case !c.coversAll(header.Scans, scans, opts):
+			fmt.Fprintf(status, "Relisting: %s does not cover these patterns and options\n", c.path)
+		default:
+			changed := false
+			if opts.cacheRevalidate {
+				if changed, err = c.changed(ctx, client, header.Scans, status); err != nil {
+					return nil, err
+				}
+			}
+			if !changed {
+				fmt.Fprintf(status, "Using the listing cached in %s %s ago\n", c.path, age.Round(time.Second))
+				c.reading = header.Scans
+				return c, nil
+			}
+		}
+	}
+	if opts.cacheRevalidate {
+		c.fingerprints = make(map[string]string)
+		for _, t := range scans {
+			sum, err := topLevelFingerprint(ctx, client, t.bucket, buildQuery(t.prefix, opts))
+			if err != nil {
+				return nil, err
+			}
+			c.fingerprints[t.bucket+"/"+t.prefix] = sum
+		}
+	}
+	f, err := os.CreateTemp(filepath.Dir(c.path), ".gcsls-cache-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to write listing cache: %w", err)
+	}
+	c.objects = f
+	c.w = bufio.NewWriter(f)
+	c.enc = json.NewEncoder(c.w)
+	return c, nil
+}
+
+// readCacheHeader reads the header of the cache file at path, or returns a
+// zero header if there is no file yet.
+func readCacheHeader(path string) (cacheHeader, error) {
+	var header cacheHeader
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return header, nil
+	}
+	if err != nil {
+		return header, fmt.Errorf("failed to read listing cache: %w", err)
+	}
+	defer f.Close()
+	if err := json.NewDecoder(bufio.NewReader(f)).Decode(&header); err != nil {
+		return header, fmt.Errorf("failed to read listing cache %s: %w", path, err)
+	}
+	return header, nil
+}
+
+// changed revalidates the cached scans, reporting whether the top level of
+// any of them differs from when it was cached. A scan cached without a
+// fingerprint cannot be revalidated and counts as changed.
+func (c *listCache) changed(ctx context.Context, client *storage.Client, cached []cachedScan, status io.Writer) (bool, error) {
+	for _, s := range cached {
+		if s.Fingerprint == "" {
+			fmt.Fprintf(status, "Relisting: %s was written without --cache-revalidate\n", c.path)
+			return true, nil
+		}
+		query := &storage.Query{Prefix: s.Prefix, StartOffset: s.StartOffset, Versions: s.Versions, SoftDeleted: s.SoftDeleted}
+		sum, err := topLevelFingerprint(ctx, client, s.Bucket, query)
+		if err != nil {
+			return false, err
+		}
+		if sum != s.Fingerprint {
+			fmt.Fprintf(status, "Relisting: gs://%s/%s changed since it was cached in %s\n", s.Bucket, s.Prefix, c.path)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// topLevelFingerprint sums up what one page of a "/"-delimited listing of
+// the query's prefix returns: the names, generations and metagenerations
+// of the objects directly under it and the names of its subdirectories.
+// It costs a single request, and changes whenever an object at the top is
+// added, replaced, deleted or updated, or a subdirectory appears or goes
+// away. Changes deeper down, or past the first page, go unnoticed.
+func topLevelFingerprint(ctx context.Context, client *storage.Client, bucket string, query *storage.Query) (string, error) {
+	q := &storage.Query{
+		Prefix:      query.Prefix,
+		StartOffset: query.StartOffset,
+		Versions:    query.Versions,
+		SoftDeleted: query.SoftDeleted,
+		Delimiter:   "/",
+	}
+	q.SetAttrSelection([]string{"Name", "Generation", "Metageneration"})
+	var page []*storage.ObjectAttrs
+	more, err := iterator.NewPager(client.Bucket(bucket).Objects(ctx, q), maxPageSize, "").NextPage(&page)
+	if err != nil {
+		return "", fmt.Errorf("failed to revalidate listing cache for gs://%s/%s: %w", bucket, query.Prefix, err)
+	}
+	h := sha256.New()
+	for _, attrs := range page {
+		if attrs.Prefix != "" {
+			fmt.Fprintf(h, "dir %s\n", attrs.Prefix)
+		} else {
+			fmt.Fprintf(h, "obj %s %d %d\n", attrs.Name, attrs.Generation, attrs.Metageneration)
+		}
+	}
+	fmt.Fprintf(h, "more %t\n", more != "")
+	return hex.EncodeToString(h.Sum(nil)[:16]), nil
+}
+
+// coversAll reports whether every scan is covered by one of the cached
+// listings.
+func (c *listCache) coversAll(cached []cachedScan, scans []listTarget, opts *options) bool {
+	for _, t := range scans {
+		if !slices.ContainsFunc(cached, func(s cachedScan) bool { return s.covers(t, opts) }) {
+			return false
+		}
+	}
+	return true
+}
+
+// serving reports whether listings come from the cache file.
+func (c *listCache) serving() bool {
+	return c != nil && c.reading != nil
+}
+
+// list returns a next function with the contract of ObjectIterator.Next
+// over the cached objects that listing bucket with query would return, in
+// the order they were listed. stop closes the file.
+func (c *listCache) list(bucket string, query *storage.Query) (next func() (*storage.ObjectAttrs, error), stop func(), err error) {
+	f, err := os.Open(c.path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read listing cache: %w", err)
+	}
+	dec := json.NewDecoder(bufio.NewReader(f))
+	var header cacheHeader
+	if err := dec.Decode(&header); err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("failed to read listing cache %s: %w", c.path, err)
+	}
+	next = func() (*storage.ObjectAttrs, error) {
+		for {
+			var attrs storage.ObjectAttrs
+			err := dec.Decode(&attrs)
+			if err == io.EOF {
+				return nil, iterator.Done
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to read listing cache %s: %w", c.path, err)
+			}
+			if attrs.Bucket == bucket && strings.HasPrefix(attrs.Name, query.Prefix) && attrs.Name >= query.StartOffset {
+				return &attrs, nil
+			}
+		}
+	}
+	return next, func() { f.Close() }, nil
+}
+
+// prepare adjusts the query of a listing that is being recorded, so that
+// the cache holds every attribute, whatever the options of later runs
+// need.
+func (c *listCache) prepare(query *storage.Query) {
+	if c != nil && c.enc != nil {
+		query.SetAttrSelection(nil)
+	}
+}
+
+// record adds a listed object to the cache being written.
+func (c *listCache) record(attrs *storage.ObjectAttrs) error {
+	if c == nil || c.enc == nil {
+		return nil
+	}
+	if err := c.enc.Encode(attrs); err != nil {
+		return fmt.Errorf("failed to write listing cache: %w", err)
+	}
+	return nil
+}
+
+// finished records that the listing of target ran to the end, so that the
+// cache covers it.
+func (c *listCache) finished(target listTarget, query *storage.Query) {
+	if c == nil || c.enc == nil {
+		return
+	}
+	c.complete = append(c.complete, cachedScan{
+		Bucket:      target.bucket,
+		Prefix:      query.Prefix,
+		StartOffset: query.StartOffset,
+		Versions:    query.Versions,
+		SoftDeleted: query.SoftDeleted,
+		Full:        query.Projection == storage.ProjectionFull,
+		Fingerprint: c.fingerprints[target.bucket+"/"+target.prefix],
+	})
+}
+
+// commit writes the cache file from the recorded objects. The file is
+// replaced atomically, so that an interrupted run leaves the previous
+// cache in place.
+func (c *listCache) commit(status io.Writer) error {
+	if c == nil || c.enc == nil || len(c.complete) == 0 {
+		return nil
+	}
+	if err := c.w.Flush(); err != nil {
+		return fmt.Errorf("failed to write listing cache: %w", err)
+	}
+	if _, err := c.objects.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to write listing cache: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), ".gcsls-cache-*")
+	if err != nil {
+		return fmt.Errorf("failed to write listing cache: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	header := cacheHeader{Version: cacheVersion, Created: time.Now().UTC(), Scans: c.complete}
+	err = json.NewEncoder(tmp).Encode(header)
+	if err == nil {
+		_, err = io.Copy(tmp, c.objects)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), c.path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write listing cache: %w", err)
+	}
+	fmt.Fprintf(status, "Cached the listing in %s\n", c.path)
+	return nil
+}
+
+// close removes the temporary file of a cache being written. It does
+// nothing for a nil cache.
+func (c *listCache) close() {
+	if c != nil && c.objects != nil {
+		c.objects.Close()
+		os.Remove(c.objects.Name())
+	}
+}
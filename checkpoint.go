@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// checkpointInterval is how often the checkpoint file is rewritten during
+// a listing.
+const checkpointInterval = 10 * time.Second
+
+// checkpoint persists how far a listing has progressed, so that a listing
+// interrupted by a crash or preemption can resume with --after.
+type checkpoint struct {
+	path string
+	// current is the name being listed; it may still have generations (or,
+	// with --as-of, a resolved version) to come.
+	current string
+	// done is the last name whose processing is complete.
+	done      string
+	saved     string
+	lastWrite time.Time
+}
+
+// loadCheckpoint returns the object name recorded in the checkpoint file at
+// path, or "" if there is no checkpoint yet.
+func loadCheckpoint(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+	return strings.TrimSuffix(string(b), "\n"), nil
+}
+
+// newCheckpoint returns a checkpoint that writes to path.
+func newCheckpoint(path string) *checkpoint {
+	return &checkpoint{path: path, lastWrite: time.Now()}
+}
+
+// passed records that the listing has handled every generation of name seen
+// so far. A name only counts as done once the listing has moved past it,
+// since versioned listings return several entries per name.
+func (c *checkpoint) passed(name string) error {
+	if name == c.current {
+		return nil
+	}
+	c.done, c.current = c.current, name
+	if time.Since(c.lastWrite) < checkpointInterval {
+		return nil
+	}
+	return c.save()
+}
+
+// save writes the last completed name to the checkpoint file. The file is
+// replaced atomically so that an interruption never leaves it truncated.
+func (c *checkpoint) save() error {
+	c.lastWrite = time.Now()
+	if c.done == "" || c.done == c.saved {
+		return nil
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), ".gcsls-checkpoint-*")
+	if err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := fmt.Fprintln(tmp, c.done); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), c.path); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	c.saved = c.done
+	return nil
+}
+
+// remove deletes the checkpoint file once the listing has completed.
+func (c *checkpoint) remove() error {
+	if err := os.Remove(c.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to remove checkpoint: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// errListingUnverified is returned by --verify-listing for a listing that
+// is truncated or altered, so that scripts can test the exit status.
+var errListingUnverified = errors.New("listing failed verification")
+
+// checksumTrailerPrefix starts the trailer line --manifest-checksum ends a
+// plain listing or a manifest with. Readers of either already skip lines
+// starting with "#".
+const checksumTrailerPrefix = "# gcsls-checksum "
+
+// checksumRecord is the --manifest-checksum trailer of an NDJSON stream.
+// Its "type" field tells it apart from the object records.
+type checksumRecord struct {
+	Type    string `json:"type"`
+	Objects int    `json:"objects"`
+	SHA256  string `json:"sha256"`
+}
+
+// writeChecksumTrailer ends the listing written to out with the number of
+// objects in it and the SHA-256 of every byte written before, as a record
+// with ndjson or a line otherwise. A listing cut short loses its trailer,
+// and a listing changed after the fact no longer matches it.
+func writeChecksumTrailer(out *outputWriter, objects int, ndjson bool) error {
+	sum := hex.EncodeToString(out.checksum.Sum(nil))
+	if ndjson {
+		return writeJSONLine(out, checksumRecord{Type: "checksum", Objects: objects, SHA256: sum})
+	}
+	_, err := fmt.Fprintf(out, "%sobjects=%d sha256=%s\n", checksumTrailerPrefix, objects, sum)
+	return err
+}
+
+// parseChecksumTrailer parses the last line of a listing as a trailer
+// written by writeChecksumTrailer, returning the object count and SHA-256
+// it records.
+func parseChecksumTrailer(line string) (objects int, sum string, err error) {
+	if strings.HasPrefix(line, "{") {
+		var rec checksumRecord
+		if err := json.Unmarshal([]byte(line), &rec); err != nil || rec.Type != "checksum" {
+			return 0, "", fmt.Errorf("the last record is not a checksum trailer")
+		}
+		return rec.Objects, rec.SHA256, nil
+	}
+	fields, ok := strings.CutPrefix(line, checksumTrailerPrefix)
+	if !ok {
+		return 0, "", fmt.Errorf("the last line is not a checksum trailer")
+	}
+	for _, f := range strings.Fields(fields) {
+		switch key, value, _ := strings.Cut(f, "="); key {
+		case "objects":
+			if objects, err = strconv.Atoi(value); err != nil {
+				return 0, "", fmt.Errorf("invalid object count %q in checksum trailer", value)
+			}
+		case "sha256":
+			sum = value
+		}
+	}
+	if sum == "" {
+		return 0, "", fmt.Errorf("checksum trailer has no sha256")
+	}
+	return objects, sum, nil
+}
+
+// verifyListing checks the listing at path, a local file or a gs:// URL
+// such as a --write-listing object, against its --manifest-checksum
+// trailer. A listing written with --gzip is decompressed first. It prints
+// the outcome to stdout and returns errListingUnverified if the trailer is
+// missing or does not match.
+func verifyListing(ctx context.Context, path string, opts *options, stdout, status io.Writer) error {
+	var r io.ReadCloser
+	if strings.HasPrefix(path, "gs://") {
+		bucket, name, err := parseListingURL(path)
+		if err != nil {
+			return fmt.Errorf("invalid --verify-listing: %w", err)
+		}
+		client, err := newStorageClient(ctx, opts, status)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+		if r, err = client.Bucket(bucket).Object(name).NewReader(ctx); err != nil {
+			return fmt.Errorf("failed to read --verify-listing: %w", err)
+		}
+	} else {
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("failed to read --verify-listing: %w", err)
+		}
+		r = f
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to read --verify-listing %s: %w", path, err)
+	}
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err == nil {
+			data, err = io.ReadAll(zr)
+		}
+		if err != nil {
+			fmt.Fprintf(stdout, "%s: FAILED: cannot decompress: %v; the listing may be truncated\n", path, err)
+			return errListingUnverified
+		}
+	}
+
+	// The trailer is the last line, and everything before it is what it
+	// sums up.
+	body := bytes.TrimSuffix(data, []byte("\n"))
+	i := bytes.LastIndexByte(body, '\n') + 1
+	objects, want, err := parseChecksumTrailer(string(body[i:]))
+	if err != nil {
+		fmt.Fprintf(stdout, "%s: FAILED: %v; the listing may be truncated\n", path, err)
+		return errListingUnverified
+	}
+	got := sha256.Sum256(data[:i])
+	if hex.EncodeToString(got[:]) != want {
+		fmt.Fprintf(stdout, "%s: FAILED: the contents do not match the checksum trailer\n", path)
+		return errListingUnverified
+	}
+	fmt.Fprintf(stdout, "%s: OK, %d objects\n", path, objects)
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"cloud.google.com/go/storage"
+)
+
+// chunkDelimiter separates consecutive chunks in text output.
+const chunkDelimiter = "---"
+
+// flusher is implemented by writers that buffer, such as outputWriter.
+type flusher interface {
+	Flush() error
+}
+
+// flushChunk makes a completed chunk visible to the reader right away.
+func flushChunk(w io.Writer) error {
+	if f, ok := w.(flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// chunkFormatter groups the lines of a text formatter into chunks of size
+// objects, separated by a delimiter line. Each chunk is flushed as soon as
+// it is complete.
+type chunkFormatter struct {
+	formatter
+	size  int
+	count int
+}
+
+// object writes one object, preceded by a delimiter when it starts a new
+// chunk.
+func (f *chunkFormatter) object(w io.Writer, attrs *storage.ObjectAttrs) error {
+	if f.count > 0 && f.count%f.size == 0 {
+		if _, err := fmt.Fprintln(w, chunkDelimiter); err != nil {
+			return err
+		}
+	}
+	if err := f.formatter.object(w, attrs); err != nil {
+		return err
+	}
+	f.count++
+	if f.count%f.size == 0 {
+		return flushChunk(w)
+	}
+	return nil
+}
+
+// chunkedJSONFormatter prints the matched objects as one JSON array of up
+// to size elements per line. Only the current chunk is held in memory.
+type chunkedJSONFormatter struct {
+	size    int
+	extra   annotations
+	records []objectRecord
+}
+
+// header writes nothing; every chunk is a complete array.
+func (f *chunkedJSONFormatter) header(w io.Writer, targets []listTarget) error {
+	return nil
+}
+
+// object adds an object to the current chunk, writing the chunk once full.
+func (f *chunkedJSONFormatter) object(w io.Writer, attrs *storage.ObjectAttrs) error {
+	f.records = append(f.records, f.extra.record(attrs))
+	if len(f.records) < f.size {
+		return nil
+	}
+	if err := f.writeChunk(w); err != nil {
+		return err
+	}
+	return flushChunk(w)
+}
+
+// footer writes the final, possibly partial, chunk.
+func (f *chunkedJSONFormatter) footer(w io.Writer) error {
+	return f.writeChunk(w)
+}
+
+// writeChunk writes the pending records as one array line.
+func (f *chunkedJSONFormatter) writeChunk(w io.Writer) error {
+	if len(f.records) == 0 {
+		return nil
+	}
+	b, err := json.Marshal(f.records)
+	if err != nil {
+		return err
+	}
+	f.records = f.records[:0]
+	_, err = fmt.Fprintf(w, "%s\n", b)
+	return err
+}
+
+// machineReadable reports that JSON output must not contain status messages.
+func (f *chunkedJSONFormatter) machineReadable() bool {
+	return true
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// ageGradient runs from green through yellow to red in the 256-color
+// palette, for --color-by-age.
+var ageGradient = []int{46, 82, 118, 154, 190, 226, 220, 214, 208, 202, 196}
+
+// ageColors colors timestamps by how old they are: up to fresh is green,
+// from stale on is red, and the ages in between are spread over the
+// gradient on a logarithmic scale, so that hours and months both get a
+// useful range of colors.
+type ageColors struct {
+	fresh time.Duration
+	stale time.Duration
+	now   time.Time
+}
+
+// newAgeColors returns the coloring for --color-by-age, or nil if it was
+// not requested or standard output is not a terminal. NO_COLOR turns it off
+// as well.
+func newAgeColors(opts *options) *ageColors {
+	if !opts.colorByAge || os.Getenv("NO_COLOR") != "" || !term.IsTerminal(int(os.Stdout.Fd())) {
+		return nil
+	}
+	return &ageColors{fresh: opts.fresh, stale: opts.stale, now: time.Now()}
+}
+
+// paint returns text in the color for the age of t.
+func (c *ageColors) paint(text string, t time.Time) string {
+	if c == nil || t.IsZero() {
+		return text
+	}
+	pos := 0.0
+	if age := c.now.Sub(t); age > c.fresh {
+		pos = math.Log(float64(age)/float64(c.fresh)) / math.Log(float64(c.stale)/float64(c.fresh))
+	}
+	i := int(math.Round(min(pos, 1) * float64(len(ageGradient)-1)))
+	return fmt.Sprintf("\033[38;5;%dm%s\033[0m", ageGradient[i], text)
+}
+
+// parseAge parses a duration that may also be given in whole days, such as
+// "30d", since time.ParseDuration stops at hours.
+func parseAge(v string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid age %q", v)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid age %q: must be a positive duration such as 1h or 30d", v)
+	}
+	return d, nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+// errListingsDiffer is returned by --compare when the two sides do not hold
+// the same names, so that scripts can test the exit status like with diff.
+var errListingsDiffer = errors.New("listings differ")
+
+// compareListings lists two patterns and prints the names found under only
+// one of them, relative to each pattern's directory: "< name" for names only
+// in the first, "> name" for names only in the second and, with
+// --show-common, "= name" for names in both.
+func compareListings(ctx context.Context, first, second string, opts *options, stdout, status io.Writer) error {
+	// Both paths are checked before any request is made.
+	targets, err := resolveTargets([]string{first, second}, opts)
+	if err != nil {
+		return err
+	}
+
+	client, err := newStorageClient(ctx, opts, status)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if err := resolveSinceFile(ctx, client, opts); err != nil {
+		return err
+	}
+
+	left, err := listRelativeNames(ctx, client, targets[0], opts)
+	if err != nil {
+		return err
+	}
+	right, err := listRelativeNames(ctx, client, targets[1], opts)
+	if err != nil {
+		return err
+	}
+
+	out := newOutputWriter(stdout, opts.flushEvery)
+	defer out.Flush()
+
+	var onlyLeft, onlyRight, common int
+	i, j := 0, 0
+	for i < len(left) || j < len(right) {
+		switch {
+		case j == len(right) || (i < len(left) && left[i] < right[j]):
+			onlyLeft++
+			fmt.Fprintf(out, "< %s\n", left[i])
+			i++
+		case i == len(left) || right[j] < left[i]:
+			onlyRight++
+			fmt.Fprintf(out, "> %s\n", right[j])
+			j++
+		default:
+			common++
+			if opts.showCommon {
+				fmt.Fprintf(out, "= %s\n", left[i])
+			}
+			i++
+			j++
+		}
+	}
+	if err := out.Flush(); err != nil {
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+
+	fmt.Fprintf(status, "Only in %s: %d, only in %s: %d, in both: %d\n", first, onlyLeft, second, onlyRight, common)
+	if onlyLeft > 0 || onlyRight > 0 {
+		return errListingsDiffer
+	}
+	return nil
+}
+
+// listRelativeNames returns the sorted, distinct names matching the target,
+// with the directory part of the pattern's literal prefix removed so that
+// names under different prefixes can be compared.
+func listRelativeNames(ctx context.Context, client *storage.Client, target listTarget, opts *options) ([]string, error) {
+	base := target.relativeBase()
+
+	var names []string
+	err := scanMatches(ctx, client, target, opts, buildFilters(opts), newScanStats(target.prefix),
+		func(attrs *storage.ObjectAttrs) error {
+			name := attrs.Name
+			if opts.urlDecode {
+				name = decodeName(name)
+			}
+			names = append(names, strings.TrimPrefix(name, base))
+			return checkBuffered(len(names), opts.maxBuffered, "--compare")
+		})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", target.url(), err)
+	}
+	// Listings come back sorted by full name, which keeps the relative
+	// names sorted too; versioned listings repeat names.
+	slices.Sort(names)
+	return slices.Compact(names), nil
+}
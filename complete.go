@@ -0,0 +1,63 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// completePrefixCommand is the subcommand that prints completions for
+// shell completion scripts, as in gcsls complete-prefix gs://bucket/lo.
+const completePrefixCommand = "complete-prefix"
+
+// completePrefix prints the completions of partial, a gs:// URL cut short
+// in its object part, one gs:// URL per line in name order: the
+// directories whose names start with the partial last segment, with their
+// trailing "/", and the objects directly beside them. It costs a single
+// listing request with a "/" delimiter, so a directory with more entries
+// than a page holds completes from the first page only. Wildcards are
+// completed as the literal characters.
+func completePrefix(ctx context.Context, partial string, opts *options, stdout, status io.Writer) error {
+	bucket, prefix, err := parseGCSPath(partial)
+	if err != nil {
+		return err
+	}
+	client, err := newStorageClient(ctx, opts, status)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	query := &storage.Query{Prefix: prefix, Delimiter: "/"}
+	query.SetAttrSelection([]string{"Name"})
+	pageSize := maxPageSize
+	if opts.pageSize > 0 {
+		pageSize = opts.pageSize
+	}
+	var page []*storage.ObjectAttrs
+	if _, err := iterator.NewPager(client.Bucket(bucket).Objects(ctx, query), pageSize, "").NextPage(&page); err != nil {
+		return fmt.Errorf("failed to list gs://%s/%s: %w", bucket, prefix, err)
+	}
+	// The page holds the objects and the directories each in name order;
+	// completion lists them as one. A directory placeholder named like the
+	// partial path is what was typed already.
+	names := make([]string, 0, len(page))
+	for _, attrs := range page {
+		if name := cmp.Or(attrs.Prefix, attrs.Name); name != prefix || prefix == "" {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "gs://%s/%s\n", bucket, name)
+	}
+	_, err = io.WriteString(stdout, b.String())
+	return err
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+	"unicode/utf8"
+
+	"cloud.google.com/go/storage"
+)
+
+// Formats accepted by --compliance-report.
+const (
+	complianceTable = "table"
+	complianceCSV   = "csv"
+)
+
+// complianceColumns are the headings of the --compliance-report table,
+// and complianceHeader the column names of its CSV, in the same order.
+var (
+	complianceColumns = []string{"Object", "Temporary Hold", "Event-Based Hold", "Retention", "Retain Until", "Policy Until", "Immutable"}
+	complianceHeader  = []string{"url", "temporaryHold", "eventBasedHold", "retentionMode", "retainUntil", "policyRetainUntil", "immutable"}
+)
+
+// isImmutable reports whether the object can be neither deleted nor
+// replaced at now: it is under a temporary or event-based hold, its own
+// retention configuration, or its bucket's retention policy.
+func isImmutable(attrs *storage.ObjectAttrs, now time.Time) bool {
+	return attrs.TemporaryHold || attrs.EventBasedHold ||
+		attrs.Retention != nil && attrs.Retention.RetainUntil.After(now) ||
+		attrs.RetentionExpirationTime.After(now)
+}
+
+// complianceFormatter renders --compliance-report: a row per match with
+// its holds, its retention and the expiry of the bucket's retention policy
+// for it, and whether any of them keeps it from being changed now. The CSV
+// is written as the matches come; the table is drawn once the listing is
+// done, like --table.
+type complianceFormatter struct {
+	csv   bool
+	paths pathRenderer
+	times timeFormat
+	now   time.Time
+	// limit is the --max-buffered cap on the number of rows the table
+	// holds.
+	limit     int
+	rows      [][]string
+	immutable int
+}
+
+// newComplianceFormatter returns the formatter of --compliance-report in
+// the given format. The CSV keeps RFC 3339 times unless unix times were
+// asked for, so that it stays parseable.
+func newComplianceFormatter(format string, paths pathRenderer, opts *options) *complianceFormatter {
+	f := &complianceFormatter{csv: format == complianceCSV, paths: paths, times: opts.timeFormat, now: time.Now(), limit: opts.maxBuffered}
+	if f.csv {
+		f.times = f.times.forJSON()
+	}
+	return f
+}
+
+// header writes the CSV's column names, or for the table the listing
+// banner.
+func (f *complianceFormatter) header(w io.Writer, targets []listTarget) error {
+	if f.csv {
+		return writeCSVRecord(w, complianceHeader)
+	}
+	return pathFormatter{paths: f.paths}.header(w, targets)
+}
+
+// object writes the CSV row of a matched object, or adds its table row.
+func (f *complianceFormatter) object(w io.Writer, attrs *storage.ObjectAttrs) error {
+	immutable := isImmutable(attrs, f.now)
+	if immutable {
+		f.immutable++
+	}
+	var mode, until string
+	if attrs.Retention != nil {
+		mode, until = attrs.Retention.Mode, f.times.render(attrs.Retention.RetainUntil)
+	}
+	if f.csv {
+		return writeCSVRecord(w, []string{
+			newObjectResult(attrs).gsURL(),
+			fmt.Sprint(attrs.TemporaryHold), fmt.Sprint(attrs.EventBasedHold),
+			mode, until, f.times.render(attrs.RetentionExpirationTime), fmt.Sprint(immutable),
+		})
+	}
+	yes := func(b bool) string {
+		if b {
+			return "yes"
+		}
+		return "no"
+	}
+	if mode == "" {
+		mode = "none"
+	}
+	f.rows = append(f.rows, []string{
+		f.paths.render(attrs), yes(attrs.TemporaryHold), yes(attrs.EventBasedHold),
+		mode, until, f.times.render(attrs.RetentionExpirationTime), yes(immutable),
+	})
+	return checkBuffered(len(f.rows), f.limit, "--compliance-report table")
+}
+
+// footer draws the table and a line with how many of the matches are
+// immutable. Nothing is drawn when there were no matches, and the CSV ends
+// after its last row.
+func (f *complianceFormatter) footer(w io.Writer) error {
+	if f.csv || len(f.rows) == 0 {
+		return nil
+	}
+	widths := make([]int, len(complianceColumns))
+	for i, heading := range complianceColumns {
+		widths[i] = utf8.RuneCountInString(heading)
+	}
+	for _, row := range f.rows {
+		for i, cell := range row {
+			widths[i] = max(widths[i], utf8.RuneCountInString(cell))
+		}
+	}
+	lines := []string{tableRule(widths, "┌", "┬", "┐"), tableRow(widths, complianceColumns, -1), tableRule(widths, "├", "┼", "┤")}
+	for _, row := range f.rows {
+		lines = append(lines, tableRow(widths, row, -1))
+	}
+	lines = append(lines, tableRule(widths, "└", "┴", "┘"))
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "TOTAL: %d objects, %d immutable, %d deletable\n", len(f.rows), f.immutable, len(f.rows)-f.immutable)
+	return err
+}
+
+// machineReadable reports whether the report is the CSV, which must not
+// contain status messages.
+func (f *complianceFormatter) machineReadable() bool {
+	return f.csv
+}
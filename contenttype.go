@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+// errContentTypeMismatch is returned by --assert-content-type when some
+// matches have another content type, so that scripts can test the exit
+// status.
+var errContentTypeMismatch = errors.New("content types differ")
+
+// contentTypeCheck checks the content type of each match against the
+// one --assert-content-type expects, and reports the matches that differ.
+type contentTypeCheck struct {
+	want string
+	// mediaType is want without parameters, when it has none: it is then
+	// compared to the media type of the object's, so that
+	// application/json accepts "application/json; charset=utf-8".
+	mediaType  string
+	w          io.Writer
+	checked    int
+	mismatched int
+}
+
+// parseContentType checks the TYPE of --assert-content-type.
+func parseContentType(v string) (string, error) {
+	if _, _, err := mime.ParseMediaType(v); err != nil {
+		return "", fmt.Errorf("invalid content type %q: %w", v, err)
+	}
+	return v, nil
+}
+
+// newContentTypeCheck returns the check of --assert-content-type, writing
+// its report to w, or nil without it.
+func newContentTypeCheck(opts *options, w io.Writer) *contentTypeCheck {
+	if opts.assertContentType == "" {
+		return nil
+	}
+	c := &contentTypeCheck{want: opts.assertContentType, w: w}
+	if mediaType, params, _ := mime.ParseMediaType(c.want); len(params) == 0 {
+		c.mediaType = mediaType
+	}
+	return c
+}
+
+// check reports the match if its content type is not the expected one.
+func (c *contentTypeCheck) check(attrs *storage.ObjectAttrs) {
+	if c == nil {
+		return
+	}
+	c.checked++
+	if c.accepts(attrs.ContentType) {
+		return
+	}
+	c.mismatched++
+	got := attrs.ContentType
+	if got == "" {
+		got = "(none)"
+	}
+	fmt.Fprintf(c.w, "Content type mismatch: gs://%s/%s is %s\n", attrs.Bucket, attrs.Name, got)
+}
+
+// accepts reports whether got is the expected content type. Types and
+// subtypes are compared without regard to case, as MIME defines them.
+func (c *contentTypeCheck) accepts(got string) bool {
+	if c.mediaType == "" {
+		return strings.EqualFold(got, c.want)
+	}
+	mediaType, _, err := mime.ParseMediaType(got)
+	return err == nil && mediaType == c.mediaType
+}
+
+// result prints how many matches differed and returns
+// errContentTypeMismatch if any did.
+func (c *contentTypeCheck) result() error {
+	if c == nil || c.mismatched == 0 {
+		return nil
+	}
+	fmt.Fprintf(c.w, "%d of %d matches do not have content type %s\n", c.mismatched, c.checked, c.want)
+	return errContentTypeMismatch
+}
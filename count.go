@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"os"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"golang.org/x/term"
+	"google.golang.org/api/iterator"
+)
+
+// approxSampleSize is how many sub-prefixes --approx lists in full. When
+// there are no more sub-prefixes than that, all are listed and the count
+// is exact.
+const approxSampleSize = 20
+
+// approxZ is the normal quantile for the 95% confidence margin reported
+// by --approx.
+const approxZ = 1.96
+
+// countMatches prints the number of objects matching the patterns instead
+// of listing them, or with --histogram the number per segment value and
+// with --size-histogram and --by-day or --by-hour the number and bytes per
+// size range or period. The
+// listing asks only for names, and with --approx only a sample of the
+// sub-prefixes is listed at all.
+func countMatches(ctx context.Context, gcsPaths []string, opts *options, stdout, status io.Writer) error {
+	targets, err := resolveTargets(gcsPaths, opts)
+	if err != nil {
+		return err
+	}
+
+	client, err := newStorageClient(ctx, opts, status)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if err := resolveSinceFile(ctx, client, opts); err != nil {
+		return err
+	}
+
+	names, err := loadNameSet(opts.includeNames)
+	if err != nil {
+		return err
+	}
+	filters := buildFilters(opts)
+	if names != nil {
+		filters = append([]objectFilter{names.accept}, filters...)
+	}
+	owners := &ownerFilter{pattern: opts.owner}
+	if owners.active() {
+		filters = append(filters, owners.accept)
+	}
+	partitioned := &partitionSpecFilter{keys: opts.partitionSpec}
+	if partitioned.active() {
+		filters = append(filters, partitioned.accept)
+	}
+	var dedupe *dedupeFilter
+	if opts.dedupeBy != "" {
+		dedupe = newDedupeFilter(opts.dedupeBy, opts.maxBuffered)
+		filters = append(filters, dedupe.accept)
+	}
+
+	scans := mergeTargets(targets)
+	stats := newScanStats(targetPrefixes(scans)...)
+	if len(targets) > 1 {
+		stats.countPatterns(targets)
+	}
+	progress := newCountProgress(status)
+	stats.progress = progress.update
+	cache, err := openListCache(ctx, client, scans, opts, status)
+	if err != nil {
+		return err
+	}
+	defer cache.close()
+	stats.cache = cache
+	stats.throttle = opts.throttle
+	stats.rates = opts.rates
+	if opts.inventory != "" {
+		if stats.inventory, err = newInventoryReport(client, opts.inventory, opts.inventoryNameColumn); err != nil {
+			return err
+		}
+	}
+	if !opts.assumeExists && !cache.serving() && stats.inventory == nil {
+		if err := checkBucketsExist(ctx, client, scans); err != nil {
+			return err
+		}
+	}
+
+	if opts.approx {
+		var total, variance float64
+		for _, t := range scans {
+			est, err := estimateMatches(ctx, client, t, opts, filters, stats, status)
+			if err != nil {
+				return err
+			}
+			total += est.count
+			variance += est.variance
+		}
+		progress.done()
+		margin := approxZ * math.Sqrt(variance)
+		if margin == 0 {
+			_, err = fmt.Fprintf(stdout, "%d\n", int64(total))
+		} else {
+			_, err = fmt.Fprintf(stdout, "~%d (±%d at 95%% confidence)\n", int64(math.Round(total)), int64(math.Ceil(margin)))
+		}
+	} else {
+		var histogram *segmentHistogram
+		if opts.histogramSegment > 0 {
+			histogram = newSegmentHistogram(opts.histogramSegment, opts.minGroup)
+		}
+		var sizes *sizeHistogram
+		if opts.sizeHistogram {
+			sizes = newSizeHistogram(opts.sizeBreaks)
+		}
+		var times *timeHistogram
+		if opts.timeHistogram != "" {
+			times = newTimeHistogram(opts.timeHistogram, opts.timeField, opts.timeZone)
+		}
+		for _, t := range scans {
+			err := scanMatches(ctx, client, t, opts, filters, stats, func(attrs *storage.ObjectAttrs) error {
+				stats.matched++
+				if histogram != nil {
+					histogram.add(attrs)
+				}
+				if sizes != nil {
+					sizes.add(attrs)
+				}
+				if times != nil {
+					times.add(attrs)
+				}
+				return dedupe.check()
+			})
+			if err != nil {
+				progress.done()
+				return err
+			}
+		}
+		progress.done()
+		if err := cache.commit(status); err != nil {
+			return err
+		}
+		switch {
+		case histogram != nil:
+			err = histogram.print(stdout, status)
+		case sizes != nil:
+			err = sizes.print(stdout)
+		case times != nil:
+			err = times.print(stdout)
+		default:
+			_, err = fmt.Fprintf(stdout, "%d\n", stats.matched)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+	names.reportMissing(status)
+	partitioned.reportSkipped(status)
+	if opts.stats {
+		stats.print(status)
+	}
+	stats.printRates(status)
+	// A count does not fetch sizes.
+	if opts.metricsFile != "" {
+		if err := stats.appendMetrics(opts.metricsFile, targets, -1); err != nil {
+			return err
+		}
+	}
+	// An estimate cannot tell that a pattern matched nothing.
+	if !opts.approx {
+		return stats.checkMatched(targets, opts.requireAllMatch, status)
+	}
+	return nil
+}
+
+// countEstimate is the estimated number of matches under one pattern and
+// the variance of that estimate.
+type countEstimate struct {
+	count    float64
+	variance float64
+}
+
+// estimateMatches estimates the matches under a target from a random sample
+// of its sub-prefixes. Objects directly under the prefix are counted
+// exactly; the sub-prefixes ("directories") are listed with a delimiter,
+// approxSampleSize of them are counted in full, and their mean count is
+// extrapolated to all of them. The variance includes the finite population
+// correction, so it is zero when every sub-prefix was counted.
+func estimateMatches(ctx context.Context, client *storage.Client, target listTarget,
+	opts *options, filters []objectFilter, stats *scanStats, status io.Writer) (countEstimate, error) {
+	match := newTargetMatcher(target, opts)
+
+	query := buildQuery(target.prefix, opts)
+	query.Delimiter = "/"
+	var direct float64
+	var subPrefixes []string
+	it := listObjects(ctx, client, target.bucket, query, opts)
+	for {
+		if err := ctx.Err(); err != nil {
+			return countEstimate{}, err
+		}
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return countEstimate{}, fmt.Errorf("failed to iterate objects: %w", err)
+		}
+		if attrs.Prefix != "" {
+			subPrefixes = append(subPrefixes, attrs.Prefix)
+			continue
+		}
+		stats.scanned++
+		if err := checkScanCost(stats, opts); err != nil {
+			return countEstimate{}, err
+		}
+		i, err := match(attrs.Name)
+		if err != nil {
+			return countEstimate{}, err
+		}
+		if i >= 0 && acceptAll(filters, attrs) {
+			direct++
+			stats.matched++
+		}
+		if stats.progress != nil {
+			stats.progress(stats.scanned, stats.matched)
+		}
+	}
+
+	n := len(subPrefixes)
+	sample := subPrefixes
+	if n > approxSampleSize {
+		sample = make([]string, approxSampleSize)
+		for i, j := range rand.Perm(n)[:approxSampleSize] {
+			sample[i] = subPrefixes[j]
+		}
+	}
+
+	counts := make([]float64, len(sample))
+	for i, p := range sample {
+		sub := target
+		sub.prefix = p
+		err := scanMatches(ctx, client, sub, opts, filters, stats, func(*storage.ObjectAttrs) error {
+			counts[i]++
+			stats.matched++
+			return nil
+		})
+		if err != nil {
+			return countEstimate{}, err
+		}
+	}
+	if len(sample) < n {
+		fmt.Fprintf(status, "Sampled %d of %d prefixes under gs://%s/%s\n", len(sample), n, target.bucket, target.prefix)
+	}
+
+	est := countEstimate{count: direct}
+	if len(sample) == 0 {
+		return est, nil
+	}
+	k := float64(len(sample))
+	var sum float64
+	for _, c := range counts {
+		sum += c
+	}
+	mean := sum / k
+	est.count += mean * float64(n)
+	if k > 1 {
+		var ss float64
+		for _, c := range counts {
+			ss += (c - mean) * (c - mean)
+		}
+		sampleVariance := ss / (k - 1)
+		est.variance = float64(n) * float64(n) * sampleVariance / k * (1 - k/float64(n))
+	}
+	return est, nil
+}
+
+// countProgress shows how far a --count has got. On a terminal the line is
+// redrawn in place a few times a second; otherwise a line is written every
+// countProgressInterval.
+type countProgress struct {
+	w     io.Writer
+	tty   bool
+	last  time.Time
+	shown bool
+}
+
+// countProgressInterval is how often progress is logged when stderr is not
+// a terminal.
+const countProgressInterval = 10 * time.Second
+
+// newCountProgress returns a progress reporter writing to w.
+func newCountProgress(w io.Writer) *countProgress {
+	tty := false
+	if f, ok := w.(*os.File); ok {
+		tty = term.IsTerminal(int(f.Fd()))
+	}
+	return &countProgress{w: w, tty: tty, last: time.Now()}
+}
+
+// update reports the running totals, at most a few times a second.
+func (p *countProgress) update(scanned, matched int) {
+	interval := countProgressInterval
+	if p.tty {
+		interval = 200 * time.Millisecond
+	}
+	if time.Since(p.last) < interval {
+		return
+	}
+	p.last = time.Now()
+	if p.tty {
+		fmt.Fprintf(p.w, "\rCounting: %d scanned, %d matched", scanned, matched)
+		p.shown = true
+	} else {
+		fmt.Fprintf(p.w, "Counting: %d scanned, %d matched\n", scanned, matched)
+	}
+}
+
+// done clears the progress line from the terminal.
+func (p *countProgress) done() {
+	if p.shown {
+		fmt.Fprint(p.w, "\r\033[K")
+		p.shown = false
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+// csekKeySize is the size of a customer-supplied encryption key: GCS only
+// accepts AES-256 keys.
+const csekKeySize = 32
+
+// parseCSEKKey decodes a customer-supplied encryption key given in base64,
+// the form gsutil and gcloud use.
+func parseCSEKKey(v string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(v))
+	if err != nil {
+		return nil, fmt.Errorf("the key must be base64-encoded: %w", err)
+	}
+	if len(key) != csekKeySize {
+		return nil, fmt.Errorf("the key must be %d bytes (AES-256), got %d", csekKeySize, len(key))
+	}
+	return key, nil
+}
+
+// readCSEKKeyFile reads a base64 customer-supplied encryption key from a
+// file, which keeps it out of the command line and shell history.
+func readCSEKKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseCSEKKey(string(data))
+}
+
+// readHandle returns the handle for reading the listed generation of an
+// object, with the --csek-key attached when one is set. Listing an
+// encrypted object only reveals the hash of its key, so a missing or wrong
+// key is reported here by name instead of as the API's generic 400 error.
+func readHandle(client *storage.Client, attrs *storage.ObjectAttrs, key []byte) (*storage.ObjectHandle, error) {
+	h := client.Bucket(attrs.Bucket).Object(attrs.Name).Generation(attrs.Generation)
+	if attrs.CustomerKeySHA256 == "" {
+		return h, nil
+	}
+	if key == nil {
+		return nil, fmt.Errorf("the object is encrypted with a customer-supplied key; pass it with --csek-key or --csek-key-file")
+	}
+	sum := sha256.Sum256(key)
+	if base64.StdEncoding.EncodeToString(sum[:]) != attrs.CustomerKeySHA256 {
+		return nil, fmt.Errorf("the object is encrypted with a different customer-supplied key than --csek-key")
+	}
+	return h.Key(key), nil
+}
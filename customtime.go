@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// parseCustomTimeCutoff parses the WHEN of --custom-time-older-than, an
+// age or a timestamp, into the time a custom time must be before. An
+// age, such as 90d, counts back from now.
+func parseCustomTimeCutoff(v string, now time.Time) (time.Time, error) {
+	if age, err := parseAge(v); err == nil {
+		return now.Add(-age), nil
+	}
+	if t, err := parseTimestamp(v); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("expected an age such as 90d or a timestamp (RFC 3339 or YYYY-MM-DD), got %q", v)
+}
+
+// customTimeBefore returns the filter of --custom-time-older-than, which
+// keeps objects whose custom time is before cutoff. An object without a
+// custom time is dropped, as lifecycle rules on the custom time skip it.
+func customTimeBefore(cutoff time.Time) objectFilter {
+	return func(attrs *storage.ObjectAttrs) bool {
+		return !attrs.CustomTime.IsZero() && attrs.CustomTime.Before(cutoff)
+	}
+}
+
+// describeCustomTime returns the --with-custom-time annotation of an
+// object: its custom time, or "unset".
+func describeCustomTime(t time.Time) string {
+	if t.IsZero() {
+		return "unset"
+	}
+	return formatTime(t)
+}
+
+// recordCustomTime is the "customTime" field of a JSON record: left out
+// without --with-custom-time, and null for an object without a custom
+// time.
+type recordCustomTime struct {
+	set bool
+	t   time.Time
+	// times renders the custom time.
+	times timeFormat
+}
+
+// IsZero reports whether the field is left out of the record.
+func (c recordCustomTime) IsZero() bool {
+	return !c.set
+}
+
+// MarshalJSON writes the custom time, or null.
+func (c recordCustomTime) MarshalJSON() ([]byte, error) {
+	if c.t.IsZero() {
+		return []byte("null"), nil
+	}
+	return json.Marshal(c.times.render(c.t))
+}
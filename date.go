@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dateLayouts are the forms a "date" capture of --extract-date may take.
+var dateLayouts = []string{"2006-01-02", "20060102", "2006/01/02", "2006_01_02", "2006.01.02", time.RFC3339Nano}
+
+// dateExtractor pulls a date out of object names for --extract-date. The
+// regular expression either captures the whole date in a group named
+// "date", in one of dateLayouts, or its parts in groups named "year",
+// "month" and "day".
+type dateExtractor struct {
+	re *regexp.Regexp
+	// date, year, month and day are the indexes of the named groups, or -1.
+	date, year, month, day int
+}
+
+// newDateExtractor compiles an --extract-date expression.
+func newDateExtractor(expr string) (*dateExtractor, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --extract-date expression: %w", err)
+	}
+	names := re.SubexpNames()
+	d := &dateExtractor{re: re, date: slices.Index(names, "date"), year: slices.Index(names, "year"),
+		month: slices.Index(names, "month"), day: slices.Index(names, "day")}
+	if d.date < 0 && (d.year < 0 || d.month < 0 || d.day < 0) {
+		return nil, fmt.Errorf("invalid --extract-date expression %q: it needs a (?P<date>...) group, "+
+			"or (?P<year>...), (?P<month>...) and (?P<day>...) groups", expr)
+	}
+	return d, nil
+}
+
+// extract returns the date in an object name as YYYY-MM-DD. It reports
+// false when the expression does not match the name or the captured text
+// is not a valid date.
+func (d *dateExtractor) extract(name string) (string, bool) {
+	m := d.re.FindStringSubmatch(name)
+	if m == nil {
+		return "", false
+	}
+	if d.date >= 0 {
+		for _, layout := range dateLayouts {
+			if t, err := time.Parse(layout, m[d.date]); err == nil {
+				return t.Format(time.DateOnly), true
+			}
+		}
+		return "", false
+	}
+	year, err1 := strconv.Atoi(m[d.year])
+	month, err2 := strconv.Atoi(m[d.month])
+	day, err3 := strconv.Atoi(m[d.day])
+	if err1 != nil || err2 != nil || err3 != nil {
+		return "", false
+	}
+	// time.Date normalizes a day like April 31 into May, which gives the
+	// invalid ones away.
+	t := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+	if t.Year() != year || int(t.Month()) != month || t.Day() != day {
+		return "", false
+	}
+	return t.Format(time.DateOnly), true
+}
+
+// column returns the date of an object name for the long listing, or "-"
+// when it has none.
+func (d *dateExtractor) column(name string) string {
+	if date, ok := d.extract(name); ok {
+		return date
+	}
+	return "-"
+}
+
+// compare orders two names by their dates, with names without a date
+// first.
+func (d *dateExtractor) compare(a, b string) int {
+	// YYYY-MM-DD sorts like the date, and "" before any of them.
+	da, _ := d.extract(a)
+	db, _ := d.extract(b)
+	return strings.Compare(da, db)
+}
+
+// recordDate is the "date" field of a JSON record: left out without
+// --extract-date, and null for a name the expression finds no date in.
+type recordDate struct {
+	set   bool
+	value string
+}
+
+// IsZero reports whether the field is left out of the record.
+func (d recordDate) IsZero() bool {
+	return !d.set
+}
+
+// MarshalJSON writes the date as a string, or null.
+func (d recordDate) MarshalJSON() ([]byte, error) {
+	if d.value == "" {
+		return []byte("null"), nil
+	}
+	return json.Marshal(d.value)
+}
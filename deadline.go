@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// deadlineFromEnv reads the deadline for the whole run from the environment
+// variable name, for callers such as job runners that hand their own
+// deadline down to the commands they start. The value is either a duration
+// from now ("90s") or an RFC 3339 timestamp. An unset or empty variable
+// means no deadline.
+func deadlineFromEnv(name string, now time.Time) (time.Time, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(v); err == nil {
+		if d <= 0 {
+			return time.Time{}, fmt.Errorf("$%s: duration %q must be positive", name, v)
+		}
+		return now.Add(d), nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("$%s: %q is neither a duration nor an RFC 3339 timestamp", name, v)
+	}
+	return t, nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+// decodeName URL-decodes an object name for --url-decode. Names that are
+// not valid percent-encoding are returned unchanged.
+func decodeName(name string) string {
+	decoded, err := url.PathUnescape(name)
+	if err != nil {
+		return name
+	}
+	return decoded
+}
+
+// encodedSafePrefix shortens a literal prefix of decoded names to the part
+// that every encoding of it shares. Encoders leave letters, digits and
+// "-_.~" alone, but anything else, "/" included, may be stored as %XX.
+func encodedSafePrefix(prefix string) string {
+	i := strings.IndexFunc(prefix, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' ||
+			r == '-' || r == '_' || r == '.' || r == '~')
+	})
+	if i < 0 {
+		return prefix
+	}
+	return prefix[:i]
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+// TestDecodeName checks how --url-decode reads names: percent-encoded ones
+// are decoded, and plain or badly encoded ones are kept as stored.
+func TestDecodeName(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"logs%2F2024%2Fa.log", "logs/2024/a.log"},
+		{"a%20b.txt", "a b.txt"},
+		{"%C3%A9t%C3%A9", "été"},
+		{"logs/2024/a.log", "logs/2024/a.log"},
+		{"a+b", "a+b"},
+		{"bad%zz.log", "bad%zz.log"},
+		{"cut%2", "cut%2"},
+		{"100%", "100%"},
+	}
+	for _, tt := range tests {
+		if got := decodeName(tt.name); got != tt.want {
+			t.Errorf("decodeName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestEncodedSafePrefix checks that --url-decode cuts the literal prefix
+// at its first character that is not unreserved, since an encoder may
+// store it as %XX.
+func TestEncodedSafePrefix(t *testing.T) {
+	tests := []struct {
+		prefix, want string
+	}{
+		{"", ""},
+		{"logs/2024/", "logs"},
+		{"Az09-_.~", "Az09-_.~"},
+		{"a b", "a"},
+		{"%41", ""},
+		{"été/", ""},
+		{"report+final", "report"},
+	}
+	for _, tt := range tests {
+		if got := encodedSafePrefix(tt.prefix); got != tt.want {
+			t.Errorf("encodedSafePrefix(%q) = %q, want %q", tt.prefix, got, tt.want)
+		}
+	}
+}
+
+// TestURLDecode checks that an object stored with an encoded name matches
+// a glob of the decoded name only with --url-decode, which also shows the
+// decoded name, and that plain and badly encoded names match either way.
+func TestURLDecode(t *testing.T) {
+	fake := newFakeGCS(t, "enc", []string{"logs%2F2024%2Fa.log", "logs/2024/%zz.log", "logs/2024/b.log", "logs%2Fother%2Fc.log"}, 100)
+	tests := []struct {
+		flags      []string
+		want       []string
+		wantPrefix string
+	}{
+		{nil, []string{"logs/2024/%zz.log", "logs/2024/b.log"}, "logs/2024/"},
+		{[]string{"--url-decode"}, []string{"logs/2024/a.log", "logs/2024/%zz.log", "logs/2024/b.log"}, "logs"},
+	}
+	for _, tt := range tests {
+		before := len(fake.prefixes())
+		got := listedNames(t, append(tt.flags, "gs://enc/logs/2024/*.log")...)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("listing with %v = %v, want %v", tt.flags, got, tt.want)
+		}
+		if prefixes := fake.prefixes()[before:]; !slices.Equal(prefixes, []string{tt.wantPrefix}) {
+			t.Errorf("listing with %v used prefixes %q, want %q", tt.flags, prefixes, tt.wantPrefix)
+		}
+	}
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+// priorObject is one object of the listing --compare-to-listing reads.
+// What it records of the object depends on the listing's format: a
+// generation of 0, a size of -1 and an empty CRC32C stand for unknown.
+type priorObject struct {
+	bucket     string
+	name       string
+	generation int64
+	size       int64
+	crc32c     string
+}
+
+// url returns the object's gs:// URL.
+func (o priorObject) url() string {
+	return "gs://" + o.bucket + "/" + o.name
+}
+
+// changed reports whether attrs, the object as listed now, differs from
+// the prior one, judged by what the prior listing recorded: the generation
+// if it has one, since it changes whenever the content is rewritten, and
+// otherwise the size and CRC32C. Without any of them, only additions and
+// removals can be told.
+func (o priorObject) changed(attrs *storage.ObjectAttrs) bool {
+	switch {
+	case o.generation != 0:
+		return o.generation != attrs.Generation
+	case o.size >= 0 && o.size != attrs.Size:
+		return true
+	case o.crc32c != "":
+		return o.crc32c != encodeCRC32C(attrs.CRC32C)
+	}
+	return false
+}
+
+// priorRecord is the part of a JSON listing record --compare-to-listing
+// reads. Field names match case-insensitively, so it reads both the
+// records of --json and --ndjson and the objects of a --cache-list file.
+type priorRecord struct {
+	// Type is set on the summary and error records of --ndjson, which
+	// describe no object.
+	Type       string `json:"type"`
+	Bucket     string `json:"bucket"`
+	Name       string `json:"name"`
+	Generation int64  `json:"generation"`
+	// Scans is set on the header of a --cache-list file.
+	Scans json.RawMessage `json:"scans"`
+}
+
+// readPriorListing reads the listing at path, a local file or a gs:// URL
+// such as a --write-listing object. It takes the output of --json,
+// --ndjson and --manifest, a --cache-list file, and plain listings with
+// one gs:// URL per line, optionally generation-pinned as with
+// --with-generation.
+func readPriorListing(ctx context.Context, client *storage.Client, path string) ([]priorObject, error) {
+	var r io.ReadCloser
+	var err error
+	if strings.HasPrefix(path, "gs://") {
+		var bucket, name string
+		if bucket, name, err = parseListingURL(path); err != nil {
+			return nil, fmt.Errorf("invalid --compare-to-listing: %w", err)
+		}
+		r, err = client.Bucket(bucket).Object(name).NewReader(ctx)
+	} else {
+		r, err = os.Open(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read --compare-to-listing: %w", err)
+	}
+	defer r.Close()
+
+	br := bufio.NewReader(r)
+	header := strings.Join(manifestHeader, ",")
+	first, _ := br.Peek(len(header))
+	var objects []priorObject
+	switch trimmed := strings.TrimLeft(string(first), " \t\r\n"); {
+	case string(first) == header:
+		objects, err = readPriorManifest(br)
+	case strings.HasPrefix(trimmed, "["), strings.HasPrefix(trimmed, "{"):
+		objects, err = readPriorJSON(br, trimmed[0] == '[')
+	default:
+		objects, err = readPriorURLs(br)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read --compare-to-listing %s: %w", path, err)
+	}
+	return objects, nil
+}
+
+// readPriorJSON reads a JSON array of records, or with array unset a
+// stream of JSON values, skipping those that describe no object.
+func readPriorJSON(r io.Reader, array bool) ([]priorObject, error) {
+	dec := json.NewDecoder(r)
+	if array {
+		// The array is read element by element, so that it may be as
+		// long as a stream.
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+	}
+	var objects []priorObject
+	for !array || dec.More() {
+		var rec priorRecord
+		if err := dec.Decode(&rec); err == io.EOF && !array {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if rec.Type != "" || rec.Scans != nil || rec.Name == "" {
+			continue
+		}
+		// A record without a generation was written without the object's
+		// attributes, so a size of 0 in it means nothing either.
+		objects = append(objects, priorObject{bucket: rec.Bucket, name: rec.Name, generation: rec.Generation, size: -1})
+	}
+	return objects, nil
+}
+
+// readPriorManifest reads a --manifest CSV, whose sizes and CRC32C
+// checksums tell changed objects apart.
+func readPriorManifest(r io.Reader) ([]priorObject, error) {
+	rows := csv.NewReader(r)
+	rows.FieldsPerRecord = len(manifestHeader)
+	// Rows start with a gs:// URL, so a "#" only starts the trailer of
+	// --manifest-checksum.
+	rows.Comment = '#'
+	records, err := rows.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	var objects []priorObject
+	for i, record := range records[1:] {
+		bucket, name, err := parseGCSPath(record[0])
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", i+2, err)
+		}
+		size, err := strconv.ParseInt(record[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: invalid size %q", i+2, record[1])
+		}
+		objects = append(objects, priorObject{bucket: bucket, name: name, size: size, crc32c: record[3]})
+	}
+	return objects, nil
+}
+
+// readPriorURLs reads one gs:// URL per line, skipping blank lines, lines
+// starting with # and the banner and notes of the plain listing, so that
+// its saved output can be read as it is. A URL ending in #GENERATION
+// records the generation.
+func readPriorURLs(r io.Reader) ([]priorObject, error) {
+	var objects []priorObject
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") || isListingBanner(line) {
+			continue
+		}
+		var generation int64
+		if i := strings.LastIndexByte(line, '#'); i >= 0 {
+			if g, err := strconv.ParseInt(line[i+1:], 10, 64); err == nil && g > 0 {
+				line, generation = line[:i], g
+			}
+		}
+		bucket, name, err := parseGCSPath(line)
+		if err == nil && name == "" {
+			err = fmt.Errorf("no object name")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d (%q): %w", n, line, err)
+		}
+		objects = append(objects, priorObject{bucket: bucket, name: name, generation: generation, size: -1})
+	}
+	return objects, scanner.Err()
+}
+
+// isListingBanner reports whether a line of a plain listing is part of
+// its banner, which lists the patterns indented under a first line with
+// several of them, or the note that nothing matched.
+func isListingBanner(line string) bool {
+	return strings.HasPrefix(line, "Listing objects ") || strings.HasPrefix(line, "  ") ||
+		line == "No objects found matching the pattern."
+}
+
+// listingChange is one line of the --compare-to-listing report, the JSON
+// record of it with --ndjson.
+type listingChange struct {
+	Change     string `json:"change"`
+	Bucket     string `json:"bucket"`
+	Name       string `json:"name"`
+	Generation int64  `json:"generation,omitempty"`
+	Size       *int64 `json:"size,omitempty"`
+	// PreviousGeneration and PreviousSize are what the prior listing
+	// recorded of a changed or removed object, when it did.
+	PreviousGeneration int64  `json:"previousGeneration,omitempty"`
+	PreviousSize       *int64 `json:"previousSize,omitempty"`
+}
+
+// listingChangeMarks are the marks of the text report, by change.
+var listingChangeMarks = map[string]string{"added": "+", "removed": "-", "changed": "~", "unchanged": "="}
+
+// compareToListing lists the patterns and reports how the matches differ
+// from the prior listing at path, one line per object sorted by URL: "+"
+// before the URL of an object added since, "-" before one removed and "~"
+// before one changed, and with --show-common "=" before the others. With --ndjson, each line is a
+// JSON record instead. Only the prior objects the patterns and name filters
+// match take part, so a listing of a whole bucket can be compared against
+// one prefix at a time. It returns errListingsDiffer when anything changed.
+func compareToListing(ctx context.Context, path string, gcsPaths []string, opts *options, stdout, status io.Writer) error {
+	targets, err := resolveTargets(gcsPaths, opts)
+	if err != nil {
+		return err
+	}
+
+	client, err := newStorageClient(ctx, opts, status)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	prior, err := readPriorListing(ctx, client, path)
+	if err != nil {
+		return err
+	}
+	scans := mergeTargets(targets)
+	matchers := make([]func(string) (int, error), len(scans))
+	for i, t := range scans {
+		matchers[i] = newTargetMatcher(t, opts)
+	}
+	nameFilters := buildNameFilters(opts)
+	remaining := make(map[string]priorObject)
+	for _, o := range prior {
+		for i, t := range scans {
+			if t.bucket != o.bucket {
+				continue
+			}
+			j, err := matchers[i](o.name)
+			if err != nil {
+				return err
+			}
+			if j >= 0 && acceptAll(nameFilters, &storage.ObjectAttrs{Bucket: o.bucket, Name: o.name}) {
+				remaining[o.url()] = o
+				break
+			}
+		}
+	}
+
+	stats := newScanStats(targetPrefixes(scans)...)
+	stats.throttle = opts.throttle
+	stats.rates = opts.rates
+	if !opts.assumeExists {
+		if err := checkBucketsExist(ctx, client, scans); err != nil {
+			return err
+		}
+	}
+	var changes []listingChange
+	counts := make(map[string]int)
+	for _, t := range scans {
+		err := scanMatches(ctx, client, t, opts, buildFilters(opts), stats, func(attrs *storage.ObjectAttrs) error {
+			stats.matched++
+			url := newObjectResult(attrs).gsURL()
+			c := listingChange{Change: "added", Bucket: attrs.Bucket, Name: attrs.Name, Generation: attrs.Generation, Size: &attrs.Size}
+			if o, ok := remaining[url]; ok {
+				delete(remaining, url)
+				c.Change = "unchanged"
+				if o.changed(attrs) {
+					c.Change = "changed"
+				}
+				c.PreviousGeneration = o.generation
+				if o.size >= 0 {
+					c.PreviousSize = &o.size
+				}
+			}
+			counts[c.Change]++
+			if c.Change != "unchanged" || opts.showCommon {
+				changes = append(changes, c)
+			}
+			return checkBuffered(len(changes)+len(remaining), opts.maxBuffered, "--compare-to-listing")
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, o := range remaining {
+		c := listingChange{Change: "removed", Bucket: o.bucket, Name: o.name, PreviousGeneration: o.generation}
+		if o.size >= 0 {
+			c.PreviousSize = &o.size
+		}
+		counts[c.Change]++
+		changes = append(changes, c)
+	}
+	slices.SortFunc(changes, func(a, b listingChange) int {
+		return cmp.Or(strings.Compare(a.Bucket, b.Bucket), strings.Compare(a.Name, b.Name))
+	})
+
+	out := newOutputWriter(stdout, opts.flushEvery)
+	defer out.Flush()
+	for _, c := range changes {
+		if opts.ndjson {
+			err = writeJSONRecord(out, c, opts.framedNDJSON)
+		} else {
+			_, err = fmt.Fprintf(out, "%s gs://%s/%s\n", listingChangeMarks[c.Change], c.Bucket, c.Name)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write output: %w", err)
+		}
+	}
+	if err := out.Flush(); err != nil {
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+
+	fmt.Fprintf(status, "Added: %d, removed: %d, changed: %d, unchanged: %d\n",
+		counts["added"], counts["removed"], counts["changed"], counts["unchanged"])
+	if opts.stats {
+		stats.print(status)
+	}
+	stats.printRates(status)
+	if counts["added"]+counts["removed"]+counts["changed"] > 0 {
+		return errListingsDiffer
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// listDirTree prints the directories under each prefix down to
+// opts.depth levels, one gs:// URL per line, each directory before the
+// ones inside it, without listing the objects below the last level. Each
+// directory costs one listing with a "/" delimiter, which returns its
+// subdirectories and the objects directly in it but nothing deeper, so
+// the structure of a large bucket shows for a fraction of a full listing.
+func listDirTree(ctx context.Context, gcsPaths []string, opts *options, stdout, status io.Writer) error {
+	for _, p := range gcsPaths {
+		if _, name, err := parseGCSPath(p); err == nil && hasWildcard(name) {
+			return fmt.Errorf("--dirs takes a directory such as gs://bucket/logs/, not a pattern: %s", p)
+		}
+	}
+	targets, err := resolveTargets(gcsPaths, opts)
+	if err != nil {
+		return err
+	}
+
+	client, err := newStorageClient(ctx, opts, status)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if !opts.assumeExists {
+		if err := checkBucketsExist(ctx, client, targets); err != nil {
+			return err
+		}
+	}
+
+	stats := newScanStats(targetPrefixes(targets)...)
+	stats.throttle = opts.throttle
+	stats.rates = opts.rates
+	out := newOutputWriter(stdout, opts.flushEvery)
+	defer out.Flush()
+
+	dirs := 0
+	// walk lists dir and prints its subdirectories, each followed by its
+	// own subdirectories while levels remain.
+	var walk func(t listTarget, dir string, level int) error
+	walk = func(t listTarget, dir string, level int) error {
+		sub := t
+		sub.prefix = dir
+		prefixes, err := listDirs(ctx, client, sub, opts, stats)
+		if err != nil {
+			return fmt.Errorf("gs://%s/%s: %w", t.bucket, dir, err)
+		}
+		for _, p := range prefixes {
+			dirs++
+			if _, err := fmt.Fprintf(out, "gs://%s/%s\n", t.bucket, p); err != nil {
+				return fmt.Errorf("failed to write output: %w", err)
+			}
+			if level < opts.depth {
+				if err := walk(t, p, level+1); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	for _, t := range targets {
+		// A prefix that does not end in "/" stands for the directory
+		// it is in, as gs://bucket/logs stands for the top level.
+		if err := walk(t, t.relativeBase(), 1); err != nil {
+			return err
+		}
+	}
+	if err := out.Flush(); err != nil {
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+
+	levels := "level"
+	if opts.depth > 1 {
+		levels = "levels"
+	}
+	fmt.Fprintf(status, "%d directories in %d %s, %d objects seen beside them\n", dirs, opts.depth, levels, stats.scanned)
+	if opts.stats {
+		stats.print(status)
+	}
+	stats.printRates(status)
+	return nil
+}
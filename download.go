@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/storage"
+)
+
+// errNoChecksum reports a download that could not be checked because the
+// object was listed without its CRC32C.
+var errNoChecksum = errors.New("the object has no CRC32C; the partial file was kept")
+
+// Download layouts control how object names map to local paths.
+const (
+	// layoutFull keeps the entire object name as the local relative path.
+	layoutFull = "full"
+	// layoutRelative keeps the object name relative to the listing prefix.
+	layoutRelative = "relative"
+	// layoutFlat keeps only the base name, failing on collisions.
+	layoutFlat = "flat"
+)
+
+// downloader copies matched objects into a local directory.
+type downloader struct {
+	client *storage.Client
+	// key is the customer-supplied encryption key for encrypted objects.
+	key    []byte
+	dir    string
+	layout string
+	// base is the directory part of the listing prefix, stripped from object
+	// names in the relative layout.
+	base string
+	out  *lockedWriter
+	// verify checks each download against the object's CRC32C before the
+	// file is kept.
+	verify bool
+	// resume keeps the partial file of a failed download, and continues it
+	// from where it stopped on the next run.
+	resume bool
+
+	mu      sync.Mutex
+	claimed map[string]string // local path -> object URL
+	// verified, mismatched and unchecked count the --verify outcomes.
+	verified, mismatched, unchecked int
+}
+
+// newDownloader returns a downloader writing into dir. The prefix is the
+// server-side listing prefix, used by the relative layout.
+func newDownloader(client *storage.Client, key []byte, dir, layout, prefix string, out io.Writer) *downloader {
+	base := ""
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		base = prefix[:i+1]
+	}
+	return &downloader{
+		client:  client,
+		key:     key,
+		dir:     dir,
+		layout:  layout,
+		base:    base,
+		out:     &lockedWriter{w: out},
+		claimed: make(map[string]string),
+	}
+}
+
+// localPath returns the local file path for an object name under the
+// configured layout. It refuses names that would escape the target directory.
+func (d *downloader) localPath(name string) (string, error) {
+	rel := name
+	switch d.layout {
+	case layoutRelative:
+		rel = strings.TrimPrefix(name, d.base)
+	case layoutFlat:
+		rel = path.Base(name)
+	}
+	if rel == "" || rel == "." || rel == "/" {
+		return "", fmt.Errorf("object name %q has no file name under the %s layout", name, d.layout)
+	}
+
+	local := filepath.Join(d.dir, filepath.FromSlash(rel))
+	within, err := filepath.Rel(d.dir, local)
+	if err != nil || within == ".." || strings.HasPrefix(within, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("object name %q would be written outside %s", name, d.dir)
+	}
+	return local, nil
+}
+
+// claim reserves a local path for an object so that two objects never write
+// the same file, which can happen with the flat layout or when listing
+// several buckets.
+func (d *downloader) claim(local string, attrs *storage.ObjectAttrs) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if other, ok := d.claimed[local]; ok {
+		return fmt.Errorf("local path %s collides with %s", local, other)
+	}
+	d.claimed[local] = newObjectResult(attrs).gsURL()
+	return nil
+}
+
+// download is an objectFunc that copies one object to its local path.
+func (d *downloader) download(ctx context.Context, attrs *storage.ObjectAttrs) error {
+	// Zero-byte "directory" placeholders have no content to download.
+	if strings.HasSuffix(attrs.Name, "/") {
+		return nil
+	}
+
+	local, err := d.localPath(attrs.Name)
+	if err != nil {
+		return err
+	}
+	if err := d.claim(local, attrs); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(local), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	h, err := readHandle(d.client, attrs, d.key)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so an interrupted download never
+	// leaves a truncated file under the final name. With --resume it is the
+	// object's partial file, which may already hold the start of the object.
+	sum := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	var (
+		tmp    *os.File
+		offset int64
+	)
+	if d.resume {
+		tmp, offset, err = openPartial(local, attrs)
+	} else {
+		tmp, err = os.CreateTemp(filepath.Dir(local), "."+filepath.Base(local)+".*.tmp")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create local file: %w", err)
+	}
+	if _, err := io.Copy(sum, io.NewSectionReader(tmp, 0, offset)); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to read partial file: %w", err)
+	}
+	// A partial file holding the whole object only needs checking.
+	decompressed := false
+	if offset < attrs.Size || offset == 0 {
+		r, err := h.NewRangeReader(ctx, offset, -1)
+		if err != nil {
+			tmp.Close()
+			d.discard(tmp.Name())
+			return fmt.Errorf("failed to open object: %w", err)
+		}
+		_, err = io.Copy(io.MultiWriter(tmp, sum), r)
+		r.Close()
+		if err != nil {
+			tmp.Close()
+			d.discard(tmp.Name())
+			return fmt.Errorf("failed to download object: %w", err)
+		}
+		decompressed = r.Attrs.Decompressed
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write local file: %w", err)
+	}
+	result, err := d.check(attrs, decompressed, offset > 0, sum.Sum32())
+	if errors.Is(err, errNoChecksum) {
+		// Nothing shows the bytes are wrong, so the partial file is kept
+		// for a later --resume to check.
+		d.discard(tmp.Name())
+		return err
+	} else if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), local); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write local file: %w", err)
+	}
+
+	fmt.Fprintf(d.out.to(ctx), "gs://%s/%s -> %s%s\n", attrs.Bucket, attrs.Name, local, result)
+	return nil
+}
+
+// discard removes the temporary file of a failed download, unless --resume
+// keeps it to continue from.
+func (d *downloader) discard(tmp string) {
+	if !d.resume {
+		os.Remove(tmp)
+	}
+}
+
+// openPartial opens the --resume partial file of the object at local,
+// creating it if there is none, and returns it positioned at its end along
+// with the number of bytes it already holds. The file
+// is named after the object's generation, so the partial download of a
+// version since replaced is removed and the download starts over. So is one
+// longer than the object, and one of a gzip-encoded object, whose range
+// reads GCS does not serve decompressed.
+func openPartial(local string, attrs *storage.ObjectAttrs) (*os.File, int64, error) {
+	dir, base := filepath.Dir(local), "."+filepath.Base(local)+"."
+	partial := filepath.Join(dir, base+strconv.FormatInt(attrs.Generation, 10)+".partial")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, e := range entries {
+		gen, ok := strings.CutPrefix(e.Name(), base)
+		if gen, ok = strings.CutSuffix(gen, ".partial"); !ok || filepath.Join(dir, e.Name()) == partial {
+			continue
+		}
+		if _, err := strconv.ParseInt(gen, 10, 64); err == nil {
+			os.Remove(filepath.Join(dir, e.Name()))
+		}
+	}
+
+	f, err := os.OpenFile(partial, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, 0, err
+	}
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err == nil && (offset > attrs.Size || attrs.ContentEncoding == "gzip") {
+		offset = 0
+		if err = f.Truncate(0); err == nil {
+			_, err = f.Seek(0, io.SeekStart)
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, offset, nil
+}
+
+// check compares the CRC32C of the bytes written with the object's under
+// --verify, and returns the note added to the file's output line. An
+// object served decompressed has no checksum for the bytes received, so it
+// is counted as unchecked. A resumed download is always checked, since
+// its start was written by an earlier run; its output line says so.
+func (d *downloader) check(attrs *storage.ObjectAttrs, decompressed, resumed bool, got uint32) (string, error) {
+	if !d.verify && !resumed {
+		return "", nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if decompressed {
+		d.unchecked++
+		return "  (crc32c not checked: served decompressed)", nil
+	}
+	// A zero CRC32C is what an object listed without its checksum carries.
+	// It is most likely missing rather than real, and a resumed download
+	// cannot be accepted without it.
+	if attrs.CRC32C == 0 && got != 0 {
+		if !resumed {
+			d.unchecked++
+			return "  (crc32c not checked: no checksum listed)", nil
+		}
+		return "", fmt.Errorf("cannot check the resumed download: %w", errNoChecksum)
+	}
+	if got != attrs.CRC32C {
+		if d.verify {
+			d.mismatched++
+		}
+		return "", fmt.Errorf("crc32c mismatch: downloaded %s, object has %s; the file was not kept",
+			encodeCRC32C(got), encodeCRC32C(attrs.CRC32C))
+	}
+	note := "  (crc32c ok)"
+	if resumed {
+		note = "  (resumed, crc32c ok)"
+	}
+	if d.verify {
+		d.verified++
+	}
+	return note, nil
+}
+
+// printVerified writes the --verify totals. It writes nothing for a nil
+// downloader or without --verify.
+func (d *downloader) printVerified(w io.Writer) {
+	if d == nil || !d.verify {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fmt.Fprintf(w, "Verified the CRC32C of %d downloads: %d matched, %d mismatched, %d not checked\n",
+		d.verified+d.mismatched, d.verified, d.mismatched, d.unchecked)
+}
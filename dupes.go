@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+// duplicateBasenamesFormatter collects the matches by base name and, once
+// the listing is done, prints every base name found in more than one
+// directory together with the objects that have it, which catches the same
+// file dropped into several partitions.
+type duplicateBasenamesFormatter struct {
+	paths pathRenderer
+	// groups maps each base name to its objects, in listing order, and
+	// names to the full names already in a group, so that several
+	// generations of one object count once.
+	groups map[string][]string
+	names  map[string]bool
+	// minSize is the smallest group printed, at least 2.
+	minSize int
+	// limit is the --max-buffered cap on the number of names held.
+	limit int
+}
+
+// newDuplicateBasenamesFormatter returns a formatter printing the groups of
+// at least minSize objects, and holding at most limit names, or any number
+// if limit is 0.
+func newDuplicateBasenamesFormatter(paths pathRenderer, minSize, limit int) *duplicateBasenamesFormatter {
+	return &duplicateBasenamesFormatter{
+		paths:   paths,
+		groups:  make(map[string][]string),
+		names:   make(map[string]bool),
+		minSize: max(minSize, 2),
+		limit:   limit,
+	}
+}
+
+// header writes nothing; the groups are only known at the end.
+func (f *duplicateBasenamesFormatter) header(w io.Writer, targets []listTarget) error {
+	return nil
+}
+
+// object adds a matched object to the group of its base name. Directory
+// placeholders have no base name of their own and are skipped.
+func (f *duplicateBasenamesFormatter) object(w io.Writer, attrs *storage.ObjectAttrs) error {
+	if strings.HasSuffix(attrs.Name, "/") {
+		return nil
+	}
+	key := attrs.Bucket + "/" + attrs.Name
+	if f.names[key] {
+		return nil
+	}
+	f.names[key] = true
+	base := path.Base(attrs.Name)
+	f.groups[base] = append(f.groups[base], f.paths.render(attrs))
+	return checkBuffered(len(f.names), f.limit, "--duplicate-basenames")
+}
+
+// footer writes each group of at least minSize objects in base name order:
+// the base name and the group's size, then one indented URL per object.
+func (f *duplicateBasenamesFormatter) footer(w io.Writer) error {
+	var bases []string
+	for base, urls := range f.groups {
+		if len(urls) >= f.minSize {
+			bases = append(bases, base)
+		}
+	}
+	slices.Sort(bases)
+	for _, base := range bases {
+		if _, err := fmt.Fprintf(w, "%s (%d)\n", base, len(f.groups[base])); err != nil {
+			return err
+		}
+		for _, url := range f.groups[base] {
+			if _, err := fmt.Fprintf(w, "  %s\n", url); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// machineReadable reports that the groups are meant for reading; status
+// messages may share stdout.
+func (f *duplicateBasenamesFormatter) machineReadable() bool {
+	return false
+}
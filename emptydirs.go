@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// errDirHasMatch stops the scan of a directory at its first real match.
+var errDirHasMatch = errors.New("directory has a match")
+
+// reportEmptyDirs prints the directories directly under each pattern's
+// prefix that hold no match other than placeholders, sorted by URL. The
+// directories come from a listing with a "/" delimiter; each is then
+// scanned with the pattern and filters until its first match, so that a
+// busy directory costs only as much as it takes to find one.
+func reportEmptyDirs(ctx context.Context, gcsPaths []string, opts *options, stdout, status io.Writer) error {
+	targets, err := resolveTargets(gcsPaths, opts)
+	if err != nil {
+		return err
+	}
+
+	client, err := newStorageClient(ctx, opts, status)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if err := resolveSinceFile(ctx, client, opts); err != nil {
+		return err
+	}
+
+	filters := buildFilters(opts)
+	owners := &ownerFilter{pattern: opts.owner}
+	if owners.active() {
+		filters = append(filters, owners.accept)
+	}
+	partitioned := &partitionSpecFilter{keys: opts.partitionSpec}
+	if partitioned.active() {
+		filters = append(filters, partitioned.accept)
+	}
+
+	scans := mergeTargets(targets)
+	if !opts.assumeExists {
+		if err := checkBucketsExist(ctx, client, scans); err != nil {
+			return err
+		}
+	}
+	stats := newScanStats(targetPrefixes(scans)...)
+	stats.throttle = opts.throttle
+	stats.rates = opts.rates
+	var empty []string
+	dirs := 0
+	for _, t := range scans {
+		prefixes, err := listDirs(ctx, client, t, opts, stats)
+		if err != nil {
+			return err
+		}
+		dirs += len(prefixes)
+		for _, p := range prefixes {
+			sub := t
+			sub.prefix = p
+			err := scanMatches(ctx, client, sub, opts, filters, stats, func(attrs *storage.ObjectAttrs) error {
+				if strings.HasSuffix(attrs.Name, "/") {
+					return nil
+				}
+				stats.matched++
+				return errDirHasMatch
+			})
+			if errors.Is(err, errDirHasMatch) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			empty = append(empty, "gs://"+t.bucket+"/"+p)
+		}
+	}
+	slices.Sort(empty)
+
+	out := newOutputWriter(stdout, opts.flushEvery)
+	defer out.Flush()
+	for _, url := range empty {
+		if _, err := fmt.Fprintln(out, url); err != nil {
+			return fmt.Errorf("failed to write output: %w", err)
+		}
+	}
+	if err := out.Flush(); err != nil {
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+	fmt.Fprintf(status, "%d of %d directories have no matching objects\n", len(empty), dirs)
+	if opts.stats {
+		stats.print(status)
+	}
+	stats.printRates(status)
+	return nil
+}
+
+// listDirs returns the directory prefixes directly under the target's
+// prefix, as a listing with a "/" delimiter reports them. The objects
+// listed beside them count as scanned.
+func listDirs(ctx context.Context, client *storage.Client, target listTarget, opts *options, stats *scanStats) ([]string, error) {
+	query := buildQuery(target.prefix, opts)
+	query.Delimiter = "/"
+	var prefixes []string
+	it := listObjects(ctx, client, target.bucket, query, opts)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return prefixes, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate objects: %w", err)
+		}
+		if attrs.Prefix != "" {
+			prefixes = append(prefixes, attrs.Prefix)
+			continue
+		}
+		stats.scanned++
+		if err := checkScanCost(stats, opts); err != nil {
+			return nil, err
+		}
+	}
+}
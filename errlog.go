@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"cloud.google.com/go/storage"
+)
+
+// errorLog writes the records of --errors-to, one JSON line for each
+// object whose operation failed and each pattern that matched nothing, so
+// that a batch job's diagnostics stay apart from its results. It is safe
+// for concurrent use; a nil log records nothing.
+type errorLog struct {
+	path string
+	mu   sync.Mutex
+	f    *os.File
+	w    *bufio.Writer
+	// count is the number of records written, and err the first failure
+	// to write one, reported by close.
+	count int
+	err   error
+}
+
+// patternErrorRecord is the --errors-to record of a pattern that matched
+// nothing.
+type patternErrorRecord struct {
+	Type    string `json:"type"`
+	Pattern string `json:"pattern"`
+	Error   string `json:"error"`
+}
+
+// openErrorLog creates, or truncates, the file at path.
+func openErrorLog(path string) (*errorLog, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create --errors-to file: %w", err)
+	}
+	return &errorLog{path: path, f: f, w: bufio.NewWriter(f)}, nil
+}
+
+// write adds one record.
+func (l *errorLog) write(record any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := writeJSONLine(l.w, record); err != nil && l.err == nil {
+		l.err = err
+	}
+	l.count++
+}
+
+// object records the failure of an operation on attrs, in the form of
+// the --ndjson-errors records, and reports whether it was recorded.
+func (l *errorLog) object(attrs *storage.ObjectAttrs, err error) bool {
+	if l == nil {
+		return false
+	}
+	l.write(errorRecord{Type: "error", Bucket: attrs.Bucket, Name: attrs.Name, Generation: attrs.Generation, Error: err.Error()})
+	return true
+}
+
+// pattern records that the pattern url matched nothing.
+func (l *errorLog) pattern(url string) {
+	if l != nil {
+		l.write(patternErrorRecord{Type: "no-matches", Pattern: url, Error: "0 matches"})
+	}
+}
+
+// close writes out the records and closes the file, telling status how
+// many there were if any.
+func (l *errorLog) close(status io.Writer) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	err := l.err
+	if flushErr := l.w.Flush(); err == nil {
+		err = flushErr
+	}
+	if closeErr := l.f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write --errors-to file %s: %w", l.path, err)
+	}
+	if l.count > 0 {
+		fmt.Fprintf(status, "%d error record(s) written to %s\n", l.count, l.path)
+	}
+	return nil
+}
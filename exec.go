@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/storage"
+)
+
+// execBatchSize caps how many URLs are passed to a single command in
+// --exec '... {} +' mode, keeping the command line well under system limits.
+const execBatchSize = 500
+
+// execCommand runs a user-supplied command for matched objects, with "{}"
+// replaced by each object's gs:// URL. The command is split into arguments
+// up front and run directly, without a shell, so object names can never be
+// interpreted as shell syntax.
+type execCommand struct {
+	args []string
+	// batch is set for the `{} +` form, which passes many URLs to one
+	// invocation instead of running the command once per object.
+	batch bool
+	urls  []string
+	// keepGoing counts failed batches instead of stopping at the first one.
+	keepGoing bool
+	// status receives the failures counted under keepGoing.
+	status   io.Writer
+	runs     int
+	failures int
+	// mu guards the queued URLs and the counts.
+	mu sync.Mutex
+}
+
+// parseExecCommand parses the --exec argument.
+func parseExecCommand(command string) (*execCommand, error) {
+	args, err := splitCommand(command)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --exec command: %w", err)
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("invalid --exec command: command is empty")
+	}
+
+	c := &execCommand{args: args}
+	if n := len(args); n >= 2 && args[n-2] == "{}" && args[n-1] == "+" {
+		c.batch = true
+		c.args = args[:n-1]
+		return c, nil
+	}
+	if !strings.Contains(command, "{}") {
+		return nil, fmt.Errorf("invalid --exec command: it must contain {} where the object URL goes")
+	}
+	return c, nil
+}
+
+// run is an objectFunc that runs the command once for a single object, or
+// queues it in the batched form.
+func (c *execCommand) run(ctx context.Context, attrs *storage.ObjectAttrs) error {
+	if c.batch {
+		return c.add(ctx, attrs)
+	}
+	url := newObjectResult(attrs).gsURL()
+	args := make([]string, len(c.args))
+	for i, a := range c.args {
+		args[i] = strings.ReplaceAll(a, "{}", url)
+	}
+	return runCommand(ctx, args)
+}
+
+// add queues an object for the batched form, running the command once the
+// batch is full.
+func (c *execCommand) add(ctx context.Context, attrs *storage.ObjectAttrs) error {
+	// The pool's workers add objects concurrently.
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.urls = append(c.urls, newObjectResult(attrs).gsURL())
+	if len(c.urls) >= execBatchSize {
+		return c.flush(ctx)
+	}
+	return nil
+}
+
+// flush runs the command for any queued objects in the batched form.
+func (c *execCommand) flush(ctx context.Context) error {
+	if len(c.urls) == 0 {
+		return nil
+	}
+	// The trailing "{}" expands to every queued URL.
+	args := append(append([]string{}, c.args[:len(c.args)-1]...), c.urls...)
+	c.urls = c.urls[:0]
+	c.runs++
+	if err := runCommand(ctx, args); err != nil {
+		if !c.keepGoing {
+			return err
+		}
+		c.failures++
+		fmt.Fprintf(c.status, "Error: %v\n", err)
+	}
+	return nil
+}
+
+// finish runs the final partial batch and reports whether any batch failed.
+func (c *execCommand) finish(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.flush(ctx); err != nil {
+		return err
+	}
+	if c.failures > 0 {
+		return fmt.Errorf("%d of %d command invocations failed", c.failures, c.runs)
+	}
+	return nil
+}
+
+// runCommand runs args with the tool's standard streams attached. Commands
+// write straight to the process's stdout rather than the listing's writer,
+// since concurrent invocations would otherwise interleave inside it.
+func runCommand(ctx context.Context, args []string) error {
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = nil
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("command %q failed: %w", args[0], err)
+	}
+	return nil
+}
+
+// splitCommand splits a command line into words the way a POSIX shell
+// would for simple commands: whitespace separates words, single quotes
+// preserve text literally, and double quotes and backslashes escape.
+func splitCommand(s string) ([]string, error) {
+	var (
+		words   []string
+		cur     strings.Builder
+		inWord  bool
+		quote   rune
+		escaped bool
+	)
+	for _, r := range s {
+		switch {
+		case escaped:
+			cur.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+		case quote == '"':
+			switch r {
+			case '"':
+				quote = 0
+			case '\\':
+				escaped = true
+			default:
+				cur.WriteRune(r)
+			}
+		case r == '\\':
+			escaped, inWord = true, true
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, cur.String())
+				cur.Reset()
+				inWord = false
+			}
+		default:
+			cur.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if escaped {
+		return nil, fmt.Errorf("trailing backslash")
+	}
+	if inWord {
+		words = append(words, cur.String())
+	}
+	return words, nil
+}
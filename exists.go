@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"cloud.google.com/go/storage"
+)
+
+// Exit statuses of --exists.
+const (
+	existsFound    = 0
+	existsNotFound = 1
+	existsFailed   = 2
+)
+
+// probeObject looks up the object at url, a gs:// path without wildcards,
+// with a single request, and returns the --exists exit status: whether it
+// exists, or that the lookup failed. Only a 404 for the object counts as
+// not found; any other failure, such as a timeout once retries are used
+// up, is reported on status as a failure, so that a script never takes a
+// transient error to mean the object is gone. Since GCS answers 404 for
+// an object in a missing bucket too, a 404 is followed by a lookup of the
+// bucket, unless --assume-exists is set, and a missing bucket is a
+// failure. With long set, the size of an existing object is printed.
+func probeObject(ctx context.Context, url string, opts *options, stdout, status io.Writer) int {
+	bucket, name, err := parseGCSPath(url)
+	if err == nil && (name == "" || hasWildcard(name)) {
+		err = fmt.Errorf("--exists needs the URL of one object, without wildcards, got %q", url)
+	}
+	if err == nil {
+		err = checkBucketAllowed(bucket, opts)
+	}
+	if err != nil {
+		fmt.Fprintf(status, "Error: %v\n", err)
+		return existsFailed
+	}
+	client, err := newStorageClient(ctx, opts, status)
+	if err != nil {
+		fmt.Fprintf(status, "Error: %v\n", err)
+		return existsFailed
+	}
+	defer client.Close()
+
+	attrs, err := client.Bucket(bucket).Object(name).Attrs(ctx)
+	switch {
+	case err == nil:
+		if opts.long {
+			fmt.Fprintln(stdout, attrs.Size)
+		}
+		return existsFound
+	case !errors.Is(err, storage.ErrObjectNotExist):
+		fmt.Fprintf(status, "Error: failed to look up %s: %v\n", url, err)
+		return existsFailed
+	}
+	// As for the listings, a failure to read the bucket's metadata leaves
+	// the object's 404 standing.
+	if !opts.assumeExists {
+		if _, err := client.Bucket(bucket).Attrs(ctx); errors.Is(err, storage.ErrBucketNotExist) {
+			fmt.Fprintf(status, "Error: bucket gs://%s does not exist; check the name and the project it belongs to\n", bucket)
+			return existsFailed
+		}
+	}
+	return existsNotFound
+}
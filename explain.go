@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// planQuery is one listing request sequence of a scan: the server-side
+// prefix and the name range it is bounded to, if any.
+type planQuery struct {
+	Prefix      string `json:"prefix"`
+	StartOffset string `json:"startOffset,omitempty"`
+	EndOffset   string `json:"endOffset,omitempty"`
+}
+
+// planPattern is one pattern of a scan, split where client-side matching
+// takes over from the server-side prefix.
+type planPattern struct {
+	URL   string `json:"url"`
+	Label string `json:"label,omitempty"`
+	// Literal is the text before the first wildcard, and Rest what the
+	// matcher checks after it.
+	Literal string `json:"literal"`
+	Rest    string `json:"rest"`
+	// Prefix is the pattern's own server-side prefix, which options such
+	// as --ignore-case may cut shorter than Literal.
+	Prefix string `json:"prefix"`
+}
+
+// planScan is one scan of a bucket, covering every pattern merged into it.
+type planScan struct {
+	Bucket string `json:"bucket"`
+	Prefix string `json:"prefix"`
+	// FullScan is set when nothing narrows the listing, so every object in
+	// the bucket is listed and matched client-side.
+	FullScan bool          `json:"fullScan"`
+	Queries  []planQuery   `json:"queries"`
+	Patterns []planPattern `json:"patterns"`
+}
+
+// queryPlan is what --explain prints: how the patterns become listing
+// requests and how the listed names are matched.
+type queryPlan struct {
+	Matcher        string     `json:"matcher"`
+	MatcherOptions []string   `json:"matcherOptions"`
+	Generations    string     `json:"generations"`
+	Fields         []string   `json:"fields"`
+	Scans          []planScan `json:"scans"`
+}
+
+// buildQueryPlan works out the plan for the patterns the way a listing
+// does, from parsing and merging the targets to splitting the queries for
+// --shards or --prefixes-from, without contacting GCS.
+func buildQueryPlan(gcsPaths []string, opts *options) (*queryPlan, error) {
+	targets, err := resolveTargets(gcsPaths, opts)
+	if err != nil {
+		return nil, err
+	}
+	plan := &queryPlan{Matcher: opts.globSyntax, MatcherOptions: []string{}, Generations: "live", Fields: attrSelection(opts)}
+	for _, o := range []struct {
+		set  bool
+		name string
+	}{
+		{opts.unanchored, "unanchored"},
+		{opts.matchOn == matchOnURL, "match-on=url"},
+		{opts.ignoreCase, "ignore-case"},
+		{opts.urlDecode, "url-decode"},
+		{opts.normalizeSlashes, "normalize-slashes"},
+		{opts.invertMatch, "invert-match"},
+		{!opts.matchPrefixObjects, "match-empty-prefix-objects=false"},
+	} {
+		if o.set {
+			plan.MatcherOptions = append(plan.MatcherOptions, o.name)
+		}
+	}
+	switch {
+	case opts.softDeleted:
+		plan.Generations = "soft-deleted"
+	case opts.versions || !opts.asOf.IsZero():
+		plan.Generations = "all"
+	}
+
+	for _, t := range mergeTargets(targets) {
+		query := buildQuery(t.prefix, opts)
+		scan := planScan{Bucket: t.bucket, Prefix: t.prefix, FullScan: t.prefix == "" && query.StartOffset == "" && opts.prefixes == nil}
+		switch {
+		case opts.shards > 1:
+			for _, q := range shardQueries(query, opts.shards) {
+				scan.Queries = append(scan.Queries, planQuery{Prefix: q.Prefix, StartOffset: q.StartOffset, EndOffset: q.EndOffset})
+			}
+		case opts.prefixes != nil:
+			for _, p := range narrowPrefixes(t.prefix, opts.prefixes) {
+				scan.Queries = append(scan.Queries, planQuery{Prefix: p, StartOffset: query.StartOffset})
+			}
+		default:
+			scan.Queries = []planQuery{{Prefix: query.Prefix, StartOffset: query.StartOffset}}
+		}
+		if scan.Queries == nil {
+			scan.Queries = []planQuery{}
+		}
+		for _, p := range t.patterns() {
+			literal, rest := splitPattern(p.pattern)
+			// An unanchored pattern is matched whole, anywhere in the name.
+			if opts.unanchored {
+				literal, rest = "", p.pattern
+			}
+			scan.Patterns = append(scan.Patterns, planPattern{URL: p.url(), Label: p.label, Literal: literal, Rest: rest, Prefix: p.prefix})
+		}
+		plan.Scans = append(plan.Scans, scan)
+	}
+	return plan, nil
+}
+
+// explainPlan prints the query plan of the patterns for --explain, as text
+// or, with --json or --ndjson, as a queryPlan object.
+func explainPlan(w io.Writer, gcsPaths []string, opts *options) error {
+	plan, err := buildQueryPlan(gcsPaths, opts)
+	if err != nil {
+		return err
+	}
+	if opts.json || opts.ndjson {
+		data, err := json.MarshalIndent(plan, "", "  ")
+		if opts.ndjson {
+			data, err = json.Marshal(plan)
+		}
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", data)
+		return err
+	}
+
+	var b strings.Builder
+	matcher := plan.Matcher + " glob"
+	if len(plan.MatcherOptions) > 0 {
+		matcher += " (" + strings.Join(plan.MatcherOptions, ", ") + ")"
+	}
+	fmt.Fprintf(&b, "Matcher:     %s\n", matcher)
+	fmt.Fprintf(&b, "Generations: %s\n", plan.Generations)
+	fmt.Fprintf(&b, "Fields:      %s\n", strings.Join(plan.Fields, ", "))
+	for _, s := range plan.Scans {
+		fmt.Fprintf(&b, "\nScan gs://%s with prefix %q\n", s.Bucket, s.Prefix)
+		for _, q := range s.Queries {
+			line := fmt.Sprintf("  query: prefix %q", q.Prefix)
+			if q.StartOffset != "" {
+				line += fmt.Sprintf(", from %q", q.StartOffset)
+			}
+			if q.EndOffset != "" {
+				line += fmt.Sprintf(", before %q", q.EndOffset)
+			}
+			fmt.Fprintln(&b, line)
+		}
+		if len(s.Queries) == 0 {
+			fmt.Fprintln(&b, "  no query: none of the --prefixes-from prefixes can match")
+		}
+		for _, p := range s.Patterns {
+			line := fmt.Sprintf("  pattern %s: literal %q, then matched client-side: %q", p.URL, p.Literal, p.Rest)
+			if p.Label != "" {
+				line += fmt.Sprintf(", label %q", p.Label)
+			}
+			if p.Prefix != p.Literal {
+				line += fmt.Sprintf(", server-side prefix cut to %q", p.Prefix)
+			}
+			fmt.Fprintln(&b, line)
+		}
+		if s.FullScan {
+			fmt.Fprintf(&b, "  full scan: every object in gs://%s is listed and matched client-side\n", s.Bucket)
+		}
+	}
+	_, err = io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// exploreEntry is one line of a directory in the explorer: a subdirectory,
+// whose name ends in "/", or an object directly in it.
+type exploreEntry struct {
+	name string
+	size int64
+}
+
+// dir reports whether the entry is a subdirectory.
+func (e exploreEntry) dir() bool {
+	return strings.HasSuffix(e.name, "/")
+}
+
+// exploreLevel is one directory of the explorer. Its listing, with a "/"
+// delimiter, is read a page at a time as the cursor nears the end of what
+// has been fetched, so a directory of millions of objects opens as fast as
+// a small one. The cursor is kept for when the user comes back up to it.
+type exploreLevel struct {
+	prefix  string
+	entries []exploreEntry
+	it      *storage.ObjectIterator
+	done    bool
+	cursor  int
+	offset  int
+}
+
+// fill reads the listing until the level holds at least n entries or the
+// listing ends.
+func (l *exploreLevel) fill(ctx context.Context, n int) error {
+	for !l.done && len(l.entries) < n {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		attrs, err := l.it.Next()
+		if err == iterator.Done {
+			l.done = true
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to list %s: %w", l.prefix, err)
+		}
+		// The placeholder object of the directory itself is not an entry
+		// of it.
+		if attrs.Prefix == "" && attrs.Name == l.prefix {
+			continue
+		}
+		if attrs.Prefix != "" {
+			l.entries = append(l.entries, exploreEntry{name: attrs.Prefix})
+		} else {
+			l.entries = append(l.entries, exploreEntry{name: attrs.Name, size: attrs.Size})
+		}
+	}
+	return nil
+}
+
+// exploreBucket runs the --explore browser, starting at the directory of
+// the single path given, and prints the URL chosen in it. Each directory is
+// listed when it is first opened, never the tree below it. The browser is
+// drawn on stderr, so stdout carries only the chosen URL.
+func exploreBucket(ctx context.Context, gcsPaths []string, opts *options, stdout, status io.Writer) error {
+	if len(gcsPaths) != 1 {
+		return fmt.Errorf("--explore takes a single directory such as gs://bucket/logs/")
+	}
+	if _, name, err := parseGCSPath(gcsPaths[0]); err == nil && hasWildcard(name) {
+		return fmt.Errorf("--explore takes a directory such as gs://bucket/logs/, not a pattern: %s", gcsPaths[0])
+	}
+	t, err := resolveTarget(gcsPaths[0], opts)
+	if err != nil {
+		return err
+	}
+	if !interactiveTerminal() {
+		return fmt.Errorf("--explore needs a terminal on stdin and stderr")
+	}
+
+	client, err := newStorageClient(ctx, opts, status)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if !opts.assumeExists {
+		if err := checkBucketsExist(ctx, client, []listTarget{t}); err != nil {
+			return err
+		}
+	}
+
+	levels := make(map[string]*exploreLevel)
+	open := func(prefix string) *exploreLevel {
+		if l, ok := levels[prefix]; ok {
+			return l
+		}
+		query := buildQuery(prefix, opts)
+		query.Delimiter = "/"
+		l := &exploreLevel{prefix: prefix, it: listObjects(ctx, client, t.bucket, query, opts)}
+		levels[prefix] = l
+		return l
+	}
+
+	// A path that does not end in "/" stands for the directory it is in.
+	chosen, err := browseLevels(ctx, t.bucket, open(t.relativeBase()), open)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(stdout, "gs://%s/%s\n", t.bucket, chosen)
+	return err
+}
+
+// browseLevels shows level and lets the user move between directories:
+// Right or Enter on a subdirectory opens it, Left or Backspace goes up to
+// the parent, and Enter on an object or Space on any entry chooses it,
+// whose name is returned. An empty directory chooses itself.
+func browseLevels(ctx context.Context, bucket string, level *exploreLevel, open func(prefix string) *exploreLevel) (string, error) {
+	ui, err := newTerminalUI()
+	if err != nil {
+		return "", err
+	}
+	defer ui.close()
+
+	for {
+		rows := max(ui.height()-3, 1)
+		// One entry past the screen tells whether there is more to scroll to.
+		if err := level.fill(ctx, level.offset+rows+1); err != nil {
+			return "", err
+		}
+		level.cursor = min(max(level.cursor, 0), max(len(level.entries)-1, 0))
+		if level.cursor < level.offset {
+			level.offset = level.cursor
+		}
+		if level.cursor >= level.offset+rows {
+			level.offset = level.cursor - rows + 1
+			continue
+		}
+		ui.draw(exploreFrame(bucket, level, rows))
+
+		key, r, err := ui.readKey()
+		if err != nil {
+			return "", err
+		}
+		current := exploreEntry{name: level.prefix}
+		if len(level.entries) > 0 {
+			current = level.entries[level.cursor]
+		}
+		switch {
+		case key == keyUp:
+			level.cursor--
+		case key == keyDown:
+			level.cursor++
+		case (key == keyRight || key == keyEnter) && current.dir() && current.name != level.prefix:
+			level = open(current.name)
+		case key == keyEnter && !current.dir(), key == keyRune && r == ' ':
+			return current.name, nil
+		case key == keyLeft || key == keyBackspace:
+			if level.prefix != "" {
+				parent := strings.TrimSuffix(level.prefix, "/")
+				level = open(parent[:strings.LastIndex(parent, "/")+1])
+			}
+		case key == keyCancel:
+			return "", errSelectionCancelled
+		}
+	}
+}
+
+// exploreFrame returns the lines showing rows entries of level from its
+// scroll offset, under a title naming the directory and over a line of
+// key hints.
+func exploreFrame(bucket string, level *exploreLevel, rows int) []string {
+	count := fmt.Sprint(len(level.entries))
+	if !level.done {
+		count += "+"
+	}
+	lines := []string{fmt.Sprintf("gs://%s/%s  (%s entries)", bucket, level.prefix, count)}
+	for i := level.offset; i < level.offset+rows && i < len(level.entries); i++ {
+		e := level.entries[i]
+		marker := "  "
+		if i == level.cursor {
+			marker = "> "
+		}
+		line := marker + strings.TrimPrefix(e.name, level.prefix)
+		if !e.dir() {
+			line += "  " + formatBytes(e.size)
+		}
+		lines = append(lines, line)
+	}
+	if len(level.entries) == 0 {
+		lines = append(lines, "  (empty)")
+	}
+	return append(lines, "→ open  ← up  Enter on an object or Space: print its URL  Esc cancel")
+}
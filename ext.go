@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// parseExtensions parses the comma-separated --ext list, accepting entries
+// with or without a leading dot.
+func parseExtensions(v string) ([]string, error) {
+	var exts []string
+	for _, e := range strings.Split(v, ",") {
+		e = strings.TrimPrefix(strings.TrimSpace(e), ".")
+		if e == "" {
+			return nil, fmt.Errorf("empty extension in %q", v)
+		}
+		if strings.ContainsAny(e, "/*?[]{}\\,") {
+			return nil, fmt.Errorf("extension %q must not contain '/' or wildcard characters", e)
+		}
+		exts = append(exts, e)
+	}
+	return exts, nil
+}
+
+// extensionPattern builds the recursive pattern matching any of exts under
+// the literal directory dir, e.g. "data/**/*.{csv,json}".
+func extensionPattern(dir string, exts []string) (string, error) {
+	if hasWildcard(dir) {
+		return "", fmt.Errorf("--ext cannot be combined with a wildcard pattern (%q); "+
+			"give a directory such as gs://bucket/data/ instead", dir)
+	}
+	if dir != "" && !strings.HasSuffix(dir, "/") {
+		dir += "/"
+	}
+	if len(exts) == 1 {
+		return dir + "**/*." + exts[0], nil
+	}
+	return dir + "**/*.{" + strings.Join(exts, ",") + "}", nil
+}
+
+// hasWildcard reports whether a pattern contains glob syntax.
+func hasWildcard(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[{\\")
+}
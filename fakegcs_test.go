@@ -0,0 +1,194 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeObject is one generation of an object served by fakeGCS.
+type fakeObject struct {
+	// bucket holds the object, the fake's own bucket when empty.
+	bucket     string
+	name       string
+	generation int64
+	size       int64
+	// noncurrent marks a generation replaced or deleted since, listed only
+	// with versions=true.
+	noncurrent bool
+	// softDeleted marks a generation listed only with softDeleted=true.
+	softDeleted bool
+}
+
+// fakeGCS is a minimal stand-in for the GCS JSON API, enough for the
+// listings of the tests: bucket lookups and object listings by prefix and
+// start offset, of live, all or soft-deleted generations, served pageSize
+// objects at a time.
+type fakeGCS struct {
+	bucket   string
+	objects  []fakeObject
+	pageSize int
+	// onPage, when set, is called with the number of pages served so far
+	// before each listing page is answered.
+	onPage func(page int)
+	// failPages lists the listing pages, counted as pagesServed counts
+	// them, that are answered with a 503 in place of their objects.
+	failPages []int
+
+	mu      sync.Mutex
+	pages   int
+	queries []string
+}
+
+// newFakeGCS starts a fake GCS serving bucket with a live generation of
+// each of the named objects, and points the storage client at it for the
+// rest of the test.
+func newFakeGCS(t *testing.T, bucket string, names []string, pageSize int) *fakeGCS {
+	t.Helper()
+	f := &fakeGCS{bucket: bucket, pageSize: pageSize}
+	for _, name := range names {
+		f.objects = append(f.objects, fakeObject{name: name, generation: 1, size: 1})
+	}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	t.Setenv("STORAGE_EMULATOR_HOST", strings.TrimPrefix(srv.URL, "http://"))
+	return f
+}
+
+// pagesServed returns the number of listing pages answered so far.
+func (f *fakeGCS) pagesServed() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.pages
+}
+
+// prefixes returns the prefix of each listing started so far, in order.
+func (f *fakeGCS) prefixes() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.queries)
+}
+
+// hasBucket reports whether the fake serves bucket: its own, or one that
+// holds an object.
+func (f *fakeGCS) hasBucket(bucket string) bool {
+	return bucket == f.bucket || slices.ContainsFunc(f.objects, func(o fakeObject) bool { return o.bucket == bucket })
+}
+
+// ServeHTTP answers bucket lookups and object listings.
+func (f *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/storage/v1/b/"), "/")
+	switch {
+	case f.hasBucket(bucket) && rest == "":
+		writeFakeJSON(w, map[string]any{"kind": "storage#bucket", "name": bucket})
+	case f.hasBucket(bucket) && rest == "o":
+		f.list(w, r, bucket)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		writeFakeJSON(w, map[string]any{"error": map[string]any{"code": 404, "message": "Not Found"}})
+	}
+}
+
+// list serves one page of the objects of bucket under the prefix,
+// continuing from the index in the page token.
+func (f *fakeGCS) list(w http.ResponseWriter, r *http.Request, bucket string) {
+	q := r.URL.Query()
+	f.mu.Lock()
+	f.pages++
+	page := f.pages
+	if q.Get("pageToken") == "" {
+		f.queries = append(f.queries, q.Get("prefix"))
+	}
+	f.mu.Unlock()
+	if f.onPage != nil {
+		f.onPage(page)
+	}
+	if slices.Contains(f.failPages, page) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		writeFakeJSON(w, map[string]any{"error": map[string]any{"code": 503, "message": "Backend Error"}})
+		return
+	}
+
+	var matched []fakeObject
+	for _, o := range f.objects {
+		switch {
+		case cmp.Or(o.bucket, f.bucket) != bucket:
+		case !strings.HasPrefix(o.name, q.Get("prefix")) || o.name < q.Get("startOffset"):
+		case q.Get("softDeleted") == "true":
+			if o.softDeleted {
+				matched = append(matched, o)
+			}
+		case o.softDeleted:
+		case q.Get("versions") == "true" || !o.noncurrent:
+			matched = append(matched, o)
+		}
+	}
+	slices.SortStableFunc(matched, func(a, b fakeObject) int {
+		if a.name != b.name {
+			return strings.Compare(a.name, b.name)
+		}
+		return int(a.generation - b.generation)
+	})
+	start, _ := strconv.Atoi(q.Get("pageToken"))
+	pageSize := f.pageSize
+	if n, err := strconv.Atoi(q.Get("maxResults")); err == nil && n > 0 {
+		pageSize = min(pageSize, n)
+	}
+	end := min(start+pageSize, len(matched))
+	updated := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).Format(time.RFC3339Nano)
+	items := []map[string]any{}
+	for _, o := range matched[start:end] {
+		item := map[string]any{
+			"kind": "storage#object", "bucket": bucket, "name": o.name,
+			"size": strconv.FormatInt(o.size, 10), "generation": strconv.FormatInt(o.generation, 10),
+			"updated": updated, "timeCreated": updated,
+		}
+		if o.noncurrent || o.softDeleted {
+			item["timeDeleted"] = updated
+		}
+		items = append(items, item)
+	}
+	resp := map[string]any{"kind": "storage#objects", "items": items}
+	if end < len(matched) {
+		resp["nextPageToken"] = strconv.Itoa(end)
+	}
+	writeFakeJSON(w, resp)
+}
+
+// writeFakeJSON writes v as the JSON body of a response.
+func writeFakeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// runListing runs gcsls with args against the fake GCS set up for the test
+// and returns its output.
+func runListing(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	opts, paths, err := parseArgs(args)
+	if err != nil {
+		return "", err
+	}
+	var out strings.Builder
+	err = listObjectsWithWildcard(t.Context(), paths, opts, &out, io.Discard)
+	return out.String(), err
+}
+
+// listedNames runs a --names-only listing with args and returns the names
+// it printed, in order.
+func listedNames(t *testing.T, args ...string) []string {
+	t.Helper()
+	out, err := runListing(t, append([]string{"--names-only"}, args...)...)
+	if err != nil {
+		t.Fatalf("listing %v: %v", args, err)
+	}
+	return strings.Fields(out)
+}
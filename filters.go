@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// objectFilter decides whether a name-matched object is kept, based on its
+// attributes.
+type objectFilter func(attrs *storage.ObjectAttrs) bool
+
+// buildFilters returns the attribute filters requested by the options. An
+// object is kept only if every filter accepts it.
+func buildFilters(opts *options) []objectFilter {
+	var filters []objectFilter
+	if opts.sinceGeneration >= 0 {
+		since := opts.sinceGeneration
+		filters = append(filters, func(attrs *storage.ObjectAttrs) bool {
+			return attrs.Generation > since
+		})
+	}
+	if !opts.since.IsZero() {
+		filters = append(filters, updatedSince(opts.since))
+	}
+	for _, m := range opts.metadataMatches {
+		filters = append(filters, m.accept)
+	}
+	if opts.underRetention {
+		filters = append(filters, underRetention(time.Now()))
+	}
+	if !opts.customTimeOlderThan.IsZero() {
+		filters = append(filters, customTimeBefore(opts.customTimeOlderThan))
+	}
+	// A generation that is live has not been deleted or replaced.
+	if opts.liveOnly {
+		filters = append(filters, func(attrs *storage.ObjectAttrs) bool {
+			return attrs.Deleted.IsZero()
+		})
+	}
+	return append(filters, buildNameFilters(opts)...)
+}
+
+// buildNameFilters returns the filters among buildFilters that only look
+// at the object name, which --two-phase applies before fetching the rest
+// of the attributes.
+func buildNameFilters(opts *options) []objectFilter {
+	var filters []objectFilter
+	if opts.after != "" {
+		after := opts.after
+		filters = append(filters, func(attrs *storage.ObjectAttrs) bool {
+			return attrs.Name > after
+		})
+	}
+	for _, m := range opts.partitionMatches {
+		filters = append(filters, m.accept)
+	}
+	if opts.maxNameLength > 0 || opts.minSegments > 0 || opts.maxSegments > 0 || opts.nameTemplate != nil {
+		filters = append(filters, nameShapeFilter(opts))
+	}
+	if opts.ignore != nil {
+		filters = append(filters, opts.ignore.accept)
+	}
+	if opts.contains != "" {
+		contains := nameContains(opts)
+		filters = append(filters, func(attrs *storage.ObjectAttrs) bool {
+			return contains(attrs.Name)
+		})
+	}
+	return filters
+}
+
+// nameContains returns a function reporting whether a name contains the
+// --contains substring, folding case with --ignore-case and looking at the
+// decoded name with --url-decode, like the pattern itself.
+func nameContains(opts *options) func(name string) bool {
+	substr, ignoreCase, urlDecode := opts.contains, opts.ignoreCase, opts.urlDecode
+	if ignoreCase {
+		substr = strings.ToLower(substr)
+	}
+	return func(name string) bool {
+		if urlDecode {
+			name = decodeName(name)
+		}
+		if ignoreCase {
+			name = strings.ToLower(name)
+		}
+		return strings.Contains(name, substr)
+	}
+}
+
+// nameShapeFilter keeps the objects whose names break one of the
+// --max-name-length, --min-segments and --max-segments bounds or do not
+// conform to --name-template, or with --invert those that keep within all
+// of them. The length is in bytes, which is what GCS limits, and a
+// trailing "/" does not start a segment.
+func nameShapeFilter(opts *options) objectFilter {
+	maxLength, minSegments, maxSegments, invert := opts.maxNameLength, opts.minSegments, opts.maxSegments, opts.invert
+	template := opts.nameTemplate
+	return func(attrs *storage.ObjectAttrs) bool {
+		segments := strings.Count(strings.TrimSuffix(attrs.Name, "/"), "/") + 1
+		violates := (maxLength > 0 && len(attrs.Name) > maxLength) ||
+			(minSegments > 0 && segments < minSegments) ||
+			(maxSegments > 0 && segments > maxSegments) ||
+			(template != nil && !template.MatchString(attrs.Name))
+		return violates != invert
+	}
+}
+
+// acceptAll reports whether every filter accepts the object.
+func acceptAll(filters []objectFilter, attrs *storage.ObjectAttrs) bool {
+	for _, f := range filters {
+		if !f(attrs) {
+			return false
+		}
+	}
+	return true
+}
+
+// metadataMatch is one --match-metadata KEY=GLOB condition on an object's
+// custom metadata.
+type metadataMatch struct {
+	key     string
+	pattern string
+}
+
+// parseMetadataMatch parses a --match-metadata value.
+func parseMetadataMatch(v string) (metadataMatch, error) {
+	key, pattern, ok := strings.Cut(v, "=")
+	if !ok || key == "" {
+		return metadataMatch{}, fmt.Errorf("expected KEY=GLOB, got %q", v)
+	}
+	if !doublestar.ValidatePattern(pattern) {
+		return metadataMatch{}, fmt.Errorf("invalid pattern %q for metadata key %q", pattern, key)
+	}
+	return metadataMatch{key: key, pattern: pattern}, nil
+}
+
+// accept is the objectFilter keeping objects whose value for the key
+// matches the glob. Objects without the key are dropped.
+func (m metadataMatch) accept(attrs *storage.ObjectAttrs) bool {
+	value, ok := attrs.Metadata[m.key]
+	if !ok {
+		return false
+	}
+	// The pattern was validated when parsed, so Match cannot fail here.
+	matched, _ := doublestar.Match(m.pattern, value)
+	return matched
+}
+
+// ownerFilter keeps objects whose owner entity (e.g. user-alice@example.com)
+// matches a glob. It remembers whether any object reported an owner at all,
+// since buckets with uniform bucket-level access never do.
+type ownerFilter struct {
+	pattern   string
+	seenOwner bool
+}
+
+// active reports whether an owner pattern was given.
+func (f *ownerFilter) active() bool {
+	return f.pattern != ""
+}
+
+// accept is the objectFilter for the owner pattern.
+func (f *ownerFilter) accept(attrs *storage.ObjectAttrs) bool {
+	if attrs.Owner == "" {
+		return false
+	}
+	f.seenOwner = true
+	// The pattern was validated up front, so Match cannot fail here.
+	matched, _ := doublestar.Match(f.pattern, attrs.Owner)
+	return matched
+}
+
+// Keys accepted by --dedupe-by.
+const (
+	dedupeBasename = "basename"
+	dedupeCRC32C   = "crc32c"
+	dedupeMD5      = "md5"
+)
+
+// dedupeFilter keeps only the first object seen for each key, such as the
+// base name or the content hash. It must be the last filter, so that only
+// objects that are otherwise kept take up a key.
+type dedupeFilter struct {
+	by      string
+	seen    map[string]bool
+	dropped int
+	// limit is the --max-buffered cap on the number of keys remembered.
+	limit int
+}
+
+// newDedupeFilter returns a dedupeFilter for a --dedupe-by key that
+// remembers at most limit keys, or any number if limit is 0.
+func newDedupeFilter(by string, limit int) *dedupeFilter {
+	return &dedupeFilter{by: by, seen: make(map[string]bool), limit: limit}
+}
+
+// check returns an error once the filter remembers more keys than its
+// limit. A filter cannot stop the scan itself, so whoever receives the
+// accepted objects calls check.
+func (f *dedupeFilter) check() error {
+	if f == nil {
+		return nil
+	}
+	return checkBuffered(len(f.seen), f.limit, "--dedupe-by")
+}
+
+// accept is the objectFilter that drops repeated keys.
+func (f *dedupeFilter) accept(attrs *storage.ObjectAttrs) bool {
+	var key string
+	switch f.by {
+	case dedupeBasename:
+		key = path.Base(attrs.Name)
+	case dedupeCRC32C:
+		key = fmt.Sprintf("%08x/%d", attrs.CRC32C, attrs.Size)
+	case dedupeMD5:
+		// Composite objects have no MD5 hash and are never duplicates.
+		if len(attrs.MD5) == 0 {
+			return true
+		}
+		key = string(attrs.MD5)
+	}
+	if f.seen[key] {
+		f.dropped++
+		return false
+	}
+	f.seen[key] = true
+	return true
+}
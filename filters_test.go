@@ -0,0 +1,43 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// TestLiveOnly checks that --live-only keeps the live generation of each
+// name in a --versions listing, and leaves out names deleted since.
+func TestLiveOnly(t *testing.T) {
+	fake := newFakeGCS(t, "live", nil, 100)
+	fake.objects = []fakeObject{
+		{name: "data/a", generation: 1, size: 1, noncurrent: true},
+		{name: "data/a", generation: 2, size: 1, noncurrent: true},
+		{name: "data/a", generation: 3, size: 1},
+		{name: "data/b", generation: 7, size: 1},
+		{name: "data/deleted", generation: 4, size: 1, noncurrent: true},
+		{name: "data/deleted", generation: 5, size: 1, noncurrent: true},
+	}
+	tests := []struct {
+		flags []string
+		want  []string
+	}{
+		{nil, []string{"gs://live/data/a#3", "gs://live/data/b#7"}},
+		{[]string{"--versions"}, []string{"gs://live/data/a#1", "gs://live/data/a#2", "gs://live/data/a#3", "gs://live/data/b#7", "gs://live/data/deleted#4", "gs://live/data/deleted#5"}},
+		{[]string{"--versions", "--live-only"}, []string{"gs://live/data/a#3", "gs://live/data/b#7"}},
+	}
+	for _, tt := range tests {
+		out, err := runListing(t, append(tt.flags, "--with-generation", "gs://live/data/**")...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The first line is the header.
+		got := strings.Split(strings.TrimSpace(out), "\n")[1:]
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("listing with %v = %v, want %v", tt.flags, got, tt.want)
+		}
+	}
+	if _, _, err := parseArgs([]string{"--live-only", "gs://live/data/**"}); err == nil {
+		t.Error("--live-only without --versions was accepted")
+	}
+}
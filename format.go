@@ -0,0 +1,544 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// formatter renders matched objects in one output format. Every built-in
+// format implements it, and the scan loop only ever talks to a formatter,
+// so a new format is a new implementation picked by newFormatter from its
+// flag, with the attributes it reads added to attrSelection.
+type formatter interface {
+	// header writes anything that precedes the first object.
+	header(w io.Writer, targets []listTarget) error
+	// object writes a single matched object.
+	object(w io.Writer, attrs *storage.ObjectAttrs) error
+	// footer writes anything that follows the last object.
+	footer(w io.Writer) error
+	// machineReadable reports whether the output is meant for other
+	// programs, in which case status messages go to stderr instead.
+	machineReadable() bool
+}
+
+// annotations is per-object information that does not come from the
+// listing itself, added to the output when the options ask for it.
+type annotations struct {
+	// lifecycle gives the action the bucket's lifecycle rules would take.
+	lifecycle *lifecyclePreview
+	// labels gives the --pattern-file label of the matched pattern.
+	labels *objectLabels
+	// tags gives the --tag tags of the object.
+	tags *objectTagger
+	// times renders the record's timestamps.
+	times timeFormat
+	// metageneration adds the generation and metageneration, which
+	// together identify the exact state of an object.
+	metageneration bool
+	// partitions adds the Hive-style partitions of the object's name.
+	partitions bool
+	// dates, when set, adds the date found in the object's name.
+	dates *dateExtractor
+	// retention adds the object's retention configuration, judged at now.
+	retention bool
+	now       time.Time
+	// customTime adds the object's custom time.
+	customTime bool
+}
+
+// record builds the JSON record for an object, with its annotations.
+func (a annotations) record(attrs *storage.ObjectAttrs) objectRecord {
+	r := newObjectRecord(attrs, a.times)
+	r.Label = a.labels.of(attrs)
+	r.Tags = a.tags.of(attrs)
+	r.Lifecycle = a.lifecycle.action(attrs)
+	if a.metageneration {
+		r.Metageneration = attrs.Metageneration
+	}
+	if a.partitions {
+		r.Partitions = partitionMap(attrs.Name)
+	}
+	if a.dates != nil {
+		r.Date.set = true
+		r.Date.value, _ = a.dates.extract(attrs.Name)
+	}
+	if a.retention {
+		r.Retention = recordRetention{set: true, r: attrs.Retention, times: a.times}
+	}
+	if a.customTime {
+		r.CustomTime = recordCustomTime{set: true, t: attrs.CustomTime, times: a.times}
+	}
+	return r
+}
+
+// newFormatter returns the formatter selected by the output options for a
+// listing of targets, adding extra to each object. Formats that report on
+// the side, such as --rename, write their report to status.
+func newFormatter(opts *options, targets []listTarget, extra annotations, status io.Writer) formatter {
+	paths := pathRenderer{
+		withGeneration:   opts.withGeneration,
+		urlDecode:        opts.urlDecode,
+		normalizeSlashes: opts.normalizeDisplay,
+		subst:            opts.subst,
+		namesOnly:        opts.namesOnly,
+		localBase:        opts.localBase,
+		scheme:           opts.uriScheme,
+		extra:            extra,
+	}
+	if opts.relative {
+		paths.base = targets[0].relativeBase()
+	}
+	if opts.chunk > 0 {
+		if opts.json {
+			return &chunkedJSONFormatter{size: opts.chunk, extra: extra}
+		}
+		if opts.long {
+			return &chunkFormatter{formatter: &longFormatter{paths: paths, times: opts.timeFormat, ages: newAgeColors(opts), dates: opts.extractDate}, size: opts.chunk}
+		}
+		return &chunkFormatter{formatter: pathFormatter{paths: paths}, size: opts.chunk}
+	}
+	if opts.segment > 0 {
+		if opts.json {
+			return &segmentedJSONFormatter{segment: opts.segment, extra: extra}
+		}
+		if opts.long {
+			return &segmentFormatter{formatter: &longFormatter{paths: paths, times: opts.timeFormat, ages: newAgeColors(opts), dates: opts.extractDate}, segment: opts.segment}
+		}
+		return &segmentFormatter{formatter: pathFormatter{paths: paths}, segment: opts.segment}
+	}
+	switch {
+	case opts.selectMode && interactiveTerminal():
+		return &selectFormatter{paths: paths, limit: opts.maxBuffered}
+	case opts.duplicateBasenames:
+		return newDuplicateBasenamesFormatter(paths, opts.minGroup, opts.maxBuffered)
+	case opts.rename != nil:
+		return newRenameFormatter(paths, opts.rename, opts.maxBuffered, status)
+	case opts.summaryOnly:
+		return summaryOnlyFormatter{}
+	case opts.table:
+		return newTableFormatter(paths, opts)
+	case opts.complianceReport != "":
+		return newComplianceFormatter(opts.complianceReport, paths, opts)
+	case opts.emitScript != "":
+		return newScriptFormatter(opts.emitScript, opts.versions, status)
+	case opts.manifest:
+		return manifestFormatter{}
+	case opts.json:
+		return &jsonFormatter{indent: opts.jsonPretty, extra: extra}
+	case opts.ndjson:
+		return ndjsonFormatter{extra: extra, framed: opts.framedNDJSON}
+	case opts.binary:
+		return &binaryFormatter{}
+	case opts.long:
+		return &longFormatter{paths: paths, times: opts.timeFormat, ages: newAgeColors(opts), dates: opts.extractDate}
+	default:
+		return pathFormatter{paths: paths}
+	}
+}
+
+// pathRenderer builds the printed location of an object.
+type pathRenderer struct {
+	// withGeneration appends "#<generation>", gsutil's syntax for a URL
+	// pinned to one version of an object.
+	withGeneration bool
+	// urlDecode shows the URL-decoded name instead of the stored one.
+	urlDecode bool
+	// normalizeSlashes shows the name with repeated slashes collapsed.
+	normalizeSlashes bool
+	// subst, when set, rewrites the name, and the result is shown in place
+	// of the URL.
+	subst *substitution
+	// namesOnly shows the object name without the bucket and scheme, with
+	// base removed from its start.
+	namesOnly bool
+	base      string
+	// localBase, when set, is prepended to bare names as a local directory.
+	localBase string
+	// scheme starts each URL in place of "gs".
+	scheme string
+	// extra adds a label before, and the lifecycle action and generations
+	// after, each line.
+	extra annotations
+}
+
+// render returns the object's URL.
+func (p pathRenderer) render(attrs *storage.ObjectAttrs) string {
+	name := attrs.Name
+	if p.urlDecode {
+		name = decodeName(name)
+	}
+	if p.normalizeSlashes {
+		name = collapseSlashes(name)
+	}
+	if p.namesOnly {
+		name = strings.TrimPrefix(name, p.base)
+	}
+	if p.subst != nil {
+		return p.subst.apply(name)
+	}
+	if p.namesOnly && p.localBase != "" {
+		return strings.TrimSuffix(p.localBase, string(filepath.Separator)) + string(filepath.Separator) + filepath.FromSlash(name)
+	}
+	if p.namesOnly {
+		return name
+	}
+	url := fmt.Sprintf("%s://%s/%s", p.scheme, attrs.Bucket, name)
+	if p.withGeneration {
+		url = fmt.Sprintf("%s#%d", url, attrs.Generation)
+	}
+	return url
+}
+
+// validScheme reports whether s is a URI scheme as RFC 3986 defines it: a
+// letter followed by letters, digits, "+", "-" or ".".
+func validScheme(s string) bool {
+	for i, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		case i > 0 && (r >= '0' && r <= '9' || r == '+' || r == '-' || r == '.'):
+		default:
+			return false
+		}
+	}
+	return s != ""
+}
+
+// annotate adds the object's annotations to its output line: the label
+// and the comma-separated tags first, each followed by a tab, and the lifecycle action, the generation and
+// metageneration, the retention and the custom time at the end.
+func (p pathRenderer) annotate(attrs *storage.ObjectAttrs, line string) string {
+	if label := p.extra.labels.of(attrs); label != "" {
+		line = label + "\t" + line
+	}
+	if tags := p.extra.tags.of(attrs); tags != nil {
+		line = strings.Join(tags, ",") + "\t" + line
+	}
+	if action := p.extra.lifecycle.action(attrs); action != "" {
+		line += "  lifecycle=" + action
+	}
+	if p.extra.metageneration {
+		line += fmt.Sprintf("  generation=%d metageneration=%d", attrs.Generation, attrs.Metageneration)
+	}
+	if p.extra.retention {
+		line += "  retention=" + describeRetention(attrs.Retention, p.extra.now)
+	}
+	if p.extra.customTime {
+		line += "  customTime=" + describeCustomTime(attrs.CustomTime)
+	}
+	return line
+}
+
+// pathFormatter prints one URL per line, the default output.
+type pathFormatter struct {
+	paths pathRenderer
+}
+
+// header writes the human-readable listing banner, except for bare names,
+// which are meant to be fed to other tools.
+func (f pathFormatter) header(w io.Writer, targets []listTarget) error {
+	if f.paths.namesOnly {
+		return nil
+	}
+	if len(targets) == 1 {
+		_, err := fmt.Fprintf(w, "Listing objects in gs://%s matching pattern: %s\n", targets[0].bucket, targets[0].pattern)
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "Listing objects matching %d patterns:\n", len(targets)); err != nil {
+		return err
+	}
+	for _, t := range targets {
+		line := t.url()
+		if t.label != "" {
+			line = t.label + ": " + line
+		}
+		if _, err := fmt.Fprintf(w, "  %s\n", line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// object writes the object's URL.
+func (f pathFormatter) object(w io.Writer, attrs *storage.ObjectAttrs) error {
+	_, err := fmt.Fprintln(w, f.paths.annotate(attrs, f.paths.render(attrs)))
+	return err
+}
+
+// footer writes nothing for the plain listing.
+func (pathFormatter) footer(w io.Writer) error {
+	return nil
+}
+
+// machineReadable reports that the plain listing includes status messages,
+// unless it prints bare names.
+func (f pathFormatter) machineReadable() bool {
+	return f.paths.namesOnly
+}
+
+// longFormatter prints size, update time and URL per object, like
+// `gsutil ls -l`, followed by a total line. ages, when set, colors the
+// update time, and dates adds a column with the date in the name.
+type longFormatter struct {
+	paths   pathRenderer
+	times   timeFormat
+	ages    *ageColors
+	dates   *dateExtractor
+	objects int
+	bytes   int64
+}
+
+// header writes the human-readable listing banner.
+func (f *longFormatter) header(w io.Writer, targets []listTarget) error {
+	return pathFormatter{}.header(w, targets)
+}
+
+// object writes one long-format line. The owner is shown when the listing
+// returned it.
+func (f *longFormatter) object(w io.Writer, attrs *storage.ObjectAttrs) error {
+	f.objects++
+	f.bytes += attrs.Size
+	updated := f.ages.paint(f.times.render(attrs.Updated), attrs.Updated)
+	line := fmt.Sprintf("%10d  %s  %s", attrs.Size, updated, f.paths.render(attrs))
+	if f.dates != nil {
+		line = fmt.Sprintf("%10d  %s  %-10s  %s", attrs.Size, updated, f.dates.column(attrs.Name), f.paths.render(attrs))
+	}
+	if attrs.Owner != "" {
+		line += "  owner=" + attrs.Owner
+	}
+	_, err := fmt.Fprintln(w, f.paths.annotate(attrs, line))
+	return err
+}
+
+// footer writes the object and byte totals.
+func (f *longFormatter) footer(w io.Writer) error {
+	if f.objects == 0 {
+		return nil
+	}
+	_, err := fmt.Fprintf(w, "TOTAL: %d objects, %d bytes (%s)\n", f.objects, f.bytes, formatBytes(f.bytes))
+	return err
+}
+
+// machineReadable reports that the long listing includes status messages.
+func (f *longFormatter) machineReadable() bool {
+	return false
+}
+
+// objectRecord is the JSON representation of a matched object. A bigquery
+// tag overrides the column type that --emit-schema derives from the Go
+// type.
+type objectRecord struct {
+	Bucket       string `json:"bucket"`
+	Name         string `json:"name"`
+	Generation   int64  `json:"generation"`
+	Size         int64  `json:"size"`
+	Updated      string `json:"updated" bigquery:"TIMESTAMP"`
+	Created      string `json:"created" bigquery:"TIMESTAMP"`
+	StorageClass string `json:"storageClass"`
+	ContentType  string `json:"contentType,omitempty"`
+	Owner        string `json:"owner,omitempty"`
+	// Label is the --pattern-file label of the pattern the object matched.
+	Label string `json:"label,omitempty"`
+	// Tags are the --tag tags of the object, ["untagged"] if none matched.
+	Tags []string `json:"tags,omitempty"`
+	// Lifecycle is the action a lifecycle rule would take on the object,
+	// set with --honor-lifecycle-preview.
+	Lifecycle string `json:"lifecycle,omitempty"`
+	// Metageneration is set with --with-metageneration. It is never 0 for
+	// an existing object.
+	Metageneration int64 `json:"metageneration,omitempty"`
+	// Partitions are the key=value directories of the name by key, set
+	// with --partition-spec.
+	Partitions map[string]string `json:"partitions,omitempty"`
+	// Date is the date --extract-date found in the name, null if none.
+	Date recordDate `json:"date,omitzero" bigquery:"DATE"`
+	// Retention is the object's retention mode and time, set with
+	// --with-retention; null if it has none.
+	Retention recordRetention `json:"retention,omitzero"`
+	// CustomTime is the object's custom time, set with --with-custom-time;
+	// null if it has none.
+	CustomTime recordCustomTime `json:"customTime,omitzero" bigquery:"TIMESTAMP"`
+}
+
+// newObjectRecord builds the JSON record for an object, with its
+// timestamps rendered by times.
+func newObjectRecord(attrs *storage.ObjectAttrs, times timeFormat) objectRecord {
+	return objectRecord{
+		Bucket:       attrs.Bucket,
+		Name:         attrs.Name,
+		Generation:   attrs.Generation,
+		Size:         attrs.Size,
+		Updated:      times.render(attrs.Updated),
+		Created:      times.render(attrs.Created),
+		StorageClass: attrs.StorageClass,
+		ContentType:  attrs.ContentType,
+		Owner:        attrs.Owner,
+	}
+}
+
+// jsonFormatter prints the matched objects as a JSON array, one element per
+// line, streaming each element as it is matched. With indent, elements are
+// spread over several lines and indented by two spaces per level instead.
+type jsonFormatter struct {
+	indent bool
+	extra  annotations
+	count  int
+}
+
+// header opens the JSON array.
+func (f *jsonFormatter) header(w io.Writer, targets []listTarget) error {
+	_, err := io.WriteString(w, "[")
+	return err
+}
+
+// object writes one array element.
+func (f *jsonFormatter) object(w io.Writer, attrs *storage.ObjectAttrs) error {
+	record := f.extra.record(attrs)
+	var b []byte
+	var err error
+	if f.indent {
+		b, err = json.MarshalIndent(record, "  ", "  ")
+	} else {
+		b, err = json.Marshal(record)
+	}
+	if err != nil {
+		return err
+	}
+	sep := ",\n"
+	if f.count == 0 {
+		sep = "\n"
+	}
+	if f.indent {
+		sep += "  "
+	}
+	f.count++
+	_, err = fmt.Fprintf(w, "%s%s", sep, b)
+	return err
+}
+
+// footer closes the JSON array.
+func (f *jsonFormatter) footer(w io.Writer) error {
+	if f.count == 0 {
+		_, err := io.WriteString(w, "]\n")
+		return err
+	}
+	_, err := io.WriteString(w, "\n]\n")
+	return err
+}
+
+// machineReadable reports that JSON output must not contain status messages.
+func (f *jsonFormatter) machineReadable() bool {
+	return true
+}
+
+// ndjsonFormatter prints one JSON object per line, a format that stream
+// processors can consume without waiting for the listing to end. With
+// framed set, each object is written as a frame instead, as
+// writeJSONRecord describes.
+type ndjsonFormatter struct {
+	extra  annotations
+	framed bool
+}
+
+// header writes nothing; every line stands on its own.
+func (ndjsonFormatter) header(w io.Writer, targets []listTarget) error {
+	return nil
+}
+
+// object writes the object's record.
+func (f ndjsonFormatter) object(w io.Writer, attrs *storage.ObjectAttrs) error {
+	return writeJSONRecord(w, f.extra.record(attrs), f.framed)
+}
+
+// footer writes nothing; every line stands on its own.
+func (ndjsonFormatter) footer(w io.Writer) error {
+	return nil
+}
+
+// machineReadable reports that NDJSON output must not contain status messages.
+func (ndjsonFormatter) machineReadable() bool {
+	return true
+}
+
+// writeJSONLine writes v as a single line of JSON.
+func writeJSONLine(w io.Writer, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", b)
+	return err
+}
+
+// writeJSONRecord writes v as one record of an NDJSON stream: a line of
+// JSON, or with framed set, for --framed-ndjson, a 4-byte big-endian
+// length followed by that many bytes of JSON, so that a reader can take
+// each record whole without looking for its end.
+func writeJSONRecord(w io.Writer, v any, framed bool) error {
+	if !framed {
+		return writeJSONLine(w, v)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if uint64(len(b)) > math.MaxUint32 {
+		return fmt.Errorf("a JSON record of %d bytes is too long for a frame", len(b))
+	}
+	_, err = w.Write(append(binary.BigEndian.AppendUint32(nil, uint32(len(b))), b...))
+	return err
+}
+
+// selectFormatter collects the matched URLs and, once the listing is done,
+// lets the user pick one interactively. Only the chosen URL is printed.
+type selectFormatter struct {
+	paths pathRenderer
+	urls  []string
+	// limit is the --max-buffered cap on the number of URLs held.
+	limit int
+}
+
+// header writes nothing; the picker is drawn on stderr.
+func (f *selectFormatter) header(w io.Writer, targets []listTarget) error {
+	return nil
+}
+
+// object remembers a matched object for the picker.
+func (f *selectFormatter) object(w io.Writer, attrs *storage.ObjectAttrs) error {
+	f.urls = append(f.urls, f.paths.render(attrs))
+	return checkBuffered(len(f.urls), f.limit, "--select")
+}
+
+// footer runs the picker and prints the chosen URL.
+func (f *selectFormatter) footer(w io.Writer) error {
+	if len(f.urls) == 0 {
+		return nil
+	}
+	choice, err := pickItem(f.urls, "Select an object")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, choice)
+	return err
+}
+
+// machineReadable reports that only the selection may appear on stdout.
+func (f *selectFormatter) machineReadable() bool {
+	return true
+}
+
+// formatTime renders a timestamp in UTC RFC 3339 form, or an empty string
+// for the zero time.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"path"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// Matchers accepted by --glob-syntax.
+const (
+	globDoublestar = "doublestar"
+	// globPath uses the standard library's path.Match, as tools built on it
+	// do: no "**", no {a,b} alternatives, and [^...] for negation.
+	globPath = "path"
+)
+
+// globMatch reports whether name matches the object pattern under the
+// --glob-syntax matcher. With path syntax, a pattern that is "**" or ends
+// in "/**" still matches everything below its directory, since that is
+// how an empty pattern or one ending in "/" reaches the matcher; any other
+// "**" is two stars, which path.Match treats like one.
+func globMatch(syntax, pattern, name string) (bool, error) {
+	if syntax != globPath {
+		return doublestar.Match(pattern, name)
+	}
+	if pattern == "**" {
+		return true, nil
+	}
+	if dir, ok := strings.CutSuffix(pattern, "/**"); ok {
+		// The directory's segments are matched against as many leading
+		// segments of the name, and anything may follow.
+		n := strings.Count(dir, "/") + 1
+		segments := strings.SplitN(name, "/", n+1)
+		if len(segments) <= n {
+			// The name has no part below the directory; the pattern's
+			// validity is still checked.
+			_, err := path.Match(dir, "")
+			return false, err
+		}
+		return path.Match(dir, strings.Join(segments[:n], "/"))
+	}
+	return path.Match(pattern, name)
+}
+
+// globMatchAnywhere reports whether the object pattern matches some part of
+// name under --unanchored, rather than all of it. Since * never crosses /,
+// that is the case exactly when *pattern* matches a run of whole segments
+// of the name, so each run is tried in turn: a name of n segments takes up
+// to n(n+1)/2 matches.
+func globMatchAnywhere(syntax, pattern, name string) (bool, error) {
+	if !strings.HasPrefix(pattern, "*") {
+		pattern = "*" + pattern
+	}
+	if !strings.HasSuffix(pattern, "*") {
+		pattern += "*"
+	}
+	segments := strings.Split(name, "/")
+	for i := range segments {
+		for j := i + 1; j <= len(segments); j++ {
+			matched, err := globMatch(syntax, pattern, strings.Join(segments[i:j], "/"))
+			if matched || err != nil {
+				return matched, err
+			}
+		}
+	}
+	return false, nil
+}
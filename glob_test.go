@@ -0,0 +1,121 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+// TestGlobMatch checks where the doublestar and path.Match syntaxes of
+// --glob-syntax agree and where they differ, mostly over "**", braces and
+// bracket negation.
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern, name    string
+		doublestar, path bool
+	}{
+		{"*.csv", "a.csv", true, true},
+		{"*.csv", "a/b.csv", false, false},
+		{"**", "a/b/c", true, true},
+		{"**/x.txt", "x.txt", true, false},
+		{"**/x.txt", "a/b/x.txt", true, false},
+		{"**/x.txt", "a/x.txt", true, true},
+		{"a/**/b", "a/b", true, false},
+		{"a/**/b", "a/x/b", true, true},
+		{"a/**/b", "a/x/y/b", true, false},
+		{"logs/**", "logs/app/1.log", true, true},
+		{"logs/**", "logs", true, false},
+		{"{a,b}.txt", "b.txt", true, false},
+		{"{a,b}.txt", "{a,b}.txt", false, true},
+		{"[!a]*", "bx", true, false},
+		{"[!a]*", "!x", true, true},
+		{"[!a]*", "ax", false, true},
+		{"[^a]*", "bx", true, true},
+		{"a?c", "abc", true, true},
+		{"a?c", "a/c", false, false},
+		{`\*`, "*", true, true},
+		{`\*`, "x", false, false},
+	}
+	for _, tt := range tests {
+		for _, engine := range []struct {
+			syntax string
+			want   bool
+		}{{globDoublestar, tt.doublestar}, {globPath, tt.path}} {
+			got, err := globMatch(engine.syntax, tt.pattern, tt.name)
+			if err != nil {
+				t.Errorf("globMatch(%s, %q, %q): %v", engine.syntax, tt.pattern, tt.name, err)
+				continue
+			}
+			if got != engine.want {
+				t.Errorf("globMatch(%s, %q, %q) = %v, want %v", engine.syntax, tt.pattern, tt.name, got, engine.want)
+			}
+		}
+	}
+}
+
+// TestGlobMatchInvalid checks that both syntaxes reject a malformed
+// pattern instead of matching nothing.
+func TestGlobMatchInvalid(t *testing.T) {
+	for _, syntax := range []string{globDoublestar, globPath} {
+		for _, pattern := range []string{"data/[abc", "logs/[]"} {
+			if _, err := globMatch(syntax, pattern, "data/a"); err == nil {
+				t.Errorf("globMatch(%s, %q) accepted an invalid pattern", syntax, pattern)
+			}
+		}
+	}
+}
+
+// TestGlobMatchAnywhere checks what a pattern matches by default, anchored
+// to the whole name, and with --unanchored, anywhere in it.
+func TestGlobMatchAnywhere(t *testing.T) {
+	tests := []struct {
+		pattern, name        string
+		anchored, unanchored bool
+	}{
+		{"foo*.log", "foo1.log", true, true},
+		{"foo*.log", "x/foo1.log", false, true},
+		{"foo*.log", "x/foo1.log.gz", false, true},
+		{"foo*.log", "x/barfoo1.log", false, true},
+		{"report", "a/b/q1-report.pdf", false, true},
+		{"logs/*.txt", "logs/a.txt", true, true},
+		{"logs/*.txt", "app/logs/a.txt", false, true},
+		{"logs/*.txt", "logs/a/b.txt", false, false},
+		{"a?c", "x/a/c", false, false},
+		{"**/x.txt", "a/x.txt.bak", false, true},
+		{"*.CSV", "a.csv", false, false},
+	}
+	for _, tt := range tests {
+		if got, err := globMatch(globDoublestar, tt.pattern, tt.name); err != nil || got != tt.anchored {
+			t.Errorf("globMatch(%q, %q) = %v, %v, want %v", tt.pattern, tt.name, got, err, tt.anchored)
+		}
+		if got, err := globMatchAnywhere(globDoublestar, tt.pattern, tt.name); err != nil || got != tt.unanchored {
+			t.Errorf("globMatchAnywhere(%q, %q) = %v, %v, want %v", tt.pattern, tt.name, got, err, tt.unanchored)
+		}
+	}
+}
+
+// TestUnanchored checks that an --unanchored listing starts at the top of
+// the bucket and keeps the names the pattern matches part of.
+func TestUnanchored(t *testing.T) {
+	fake := newFakeGCS(t, "anchor", []string{"app/logs/a.txt", "foo/x.txt", "foo1.log", "logs/a.txt", "logs/b/c.txt", "x/foo2.log.gz"}, 100)
+	tests := []struct {
+		flags      []string
+		pattern    string
+		want       []string
+		wantPrefix string
+	}{
+		{nil, "logs/*.txt", []string{"logs/a.txt"}, "logs/"},
+		{[]string{"--unanchored"}, "logs/*.txt", []string{"app/logs/a.txt", "logs/a.txt"}, ""},
+		{nil, "foo*.log", []string{"foo1.log"}, "foo"},
+		{[]string{"--unanchored"}, "foo*.log", []string{"foo1.log", "x/foo2.log.gz"}, ""},
+	}
+	for _, tt := range tests {
+		before := len(fake.prefixes())
+		got := listedNames(t, append(tt.flags, "gs://anchor/"+tt.pattern)...)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s with %v matched %v, want %v", tt.pattern, tt.flags, got, tt.want)
+		}
+		if prefixes := fake.prefixes()[before:]; !slices.Equal(prefixes, []string{tt.wantPrefix}) {
+			t.Errorf("%s with %v listed with prefixes %q, want %q", tt.pattern, tt.flags, prefixes, tt.wantPrefix)
+		}
+	}
+}
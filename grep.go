@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"cloud.google.com/go/storage"
+)
+
+// Limits of --grep: the first grepSniffSize bytes of an object tell
+// whether it is text, and a line longer than grepMaxLine fails the object
+// rather than being held in memory whole.
+const (
+	grepSniffSize = 8 * 1024
+	grepMaxLine   = 1024 * 1024
+)
+
+// newGrepPattern compiles the --grep pattern: a literal string unless
+// --grep-regex is set, matched case-insensitively with --grep-ignore-case.
+func newGrepPattern(opts *options) (*regexp.Regexp, error) {
+	expr := opts.grep
+	if !opts.grepRegex {
+		expr = regexp.QuoteMeta(expr)
+	}
+	if opts.grepIgnoreCase {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --grep pattern: %w", err)
+	}
+	return re, nil
+}
+
+// grepObject returns an objectFunc that prints each line of the object's
+// content that re matches, as the object's URL, a colon and the line, like
+// grep -r. At most maxBytes bytes of each object are read when maxBytes is
+// positive. Objects that look binary are skipped. key, when set, is the
+// customer-supplied encryption key for encrypted objects.
+func grepObject(client *storage.Client, key []byte, re *regexp.Regexp, maxBytes int64, w io.Writer) objectFunc {
+	out := &lockedWriter{w: w}
+	return func(ctx context.Context, attrs *storage.ObjectAttrs) error {
+		// Placeholders and empty objects have no lines to search.
+		if attrs.Size == 0 || strings.HasSuffix(attrs.Name, "/") {
+			return nil
+		}
+		h, err := readHandle(client, attrs, key)
+		if err != nil {
+			return err
+		}
+		length := int64(-1)
+		if maxBytes > 0 {
+			length = maxBytes
+		}
+		r, err := h.NewRangeReader(ctx, 0, length)
+		if err != nil {
+			return fmt.Errorf("failed to open object: %w", err)
+		}
+		defer r.Close()
+
+		br := bufio.NewReaderSize(r, grepSniffSize)
+		// As with --head, a zero byte or invalid UTF-8 at the start means
+		// the content is not text.
+		start, err := br.Peek(grepSniffSize)
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("failed to read object: %w", err)
+		}
+		if bytes.IndexByte(start, 0) >= 0 || !utf8.Valid(trimPartialRune(start)) {
+			return nil
+		}
+
+		url := newObjectResult(attrs).gsURL()
+		lines := bufio.NewScanner(br)
+		lines.Buffer(make([]byte, 0, 64*1024), grepMaxLine)
+		for lines.Scan() {
+			line := bytes.TrimSuffix(lines.Bytes(), []byte{'\r'})
+			if !re.Match(line) {
+				continue
+			}
+			// Each line is written whole, so that lines of objects searched
+			// at the same time never mix.
+			if _, err := fmt.Fprintf(out.to(ctx), "%s:%s\n", url, line); err != nil {
+				return err
+			}
+		}
+		if err := lines.Err(); errors.Is(err, bufio.ErrTooLong) {
+			return fmt.Errorf("line longer than %d bytes", grepMaxLine)
+		} else if err != nil {
+			return fmt.Errorf("failed to read object: %w", err)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"cloud.google.com/go/storage"
+)
+
+// headReadLimit caps how many bytes --head reads from each object, so that
+// a file with very long lines never turns a preview into a download.
+const headReadLimit = 64 * 1024
+
+// headObject returns an objectFunc that prints each object's URL followed by
+// its first lines lines, indented. Objects that look binary are noted
+// instead of printed. key, when set, is the customer-supplied encryption
+// key for encrypted objects.
+func headObject(client *storage.Client, key []byte, lines int, w io.Writer) objectFunc {
+	out := &lockedWriter{w: w}
+	return func(ctx context.Context, attrs *storage.ObjectAttrs) error {
+		var b strings.Builder
+		fmt.Fprintf(&b, "gs://%s/%s:\n", attrs.Bucket, attrs.Name)
+
+		// Placeholders and empty objects have nothing to preview.
+		if attrs.Size > 0 && !strings.HasSuffix(attrs.Name, "/") {
+			limit := min(attrs.Size, headReadLimit)
+			h, err := readHandle(client, attrs, key)
+			if err != nil {
+				return err
+			}
+			r, err := h.NewRangeReader(ctx, 0, limit)
+			if err != nil {
+				return fmt.Errorf("failed to open object: %w", err)
+			}
+			data, err := io.ReadAll(r)
+			r.Close()
+			if err != nil {
+				return fmt.Errorf("failed to read object: %w", err)
+			}
+			writePreview(&b, data, lines)
+		}
+
+		// Build the whole block first so previews of concurrently read
+		// objects never interleave.
+		_, err := io.WriteString(out.to(ctx), b.String())
+		return err
+	}
+}
+
+// writePreview writes up to lines lines of data to b, indented.
+func writePreview(b *strings.Builder, data []byte, lines int) {
+	// A zero byte, or invalid UTF-8 before the final (possibly cut)
+	// character, means the content is not text.
+	if bytes.IndexByte(data, 0) >= 0 || !utf8.Valid(trimPartialRune(data)) {
+		b.WriteString("    (binary content)\n")
+		return
+	}
+	for i, line := range strings.SplitAfter(string(data), "\n") {
+		if i == lines || line == "" {
+			break
+		}
+		b.WriteString("    ")
+		b.WriteString(strings.TrimRight(line, "\r\n"))
+		b.WriteString("\n")
+	}
+}
+
+// trimPartialRune drops an incomplete UTF-8 sequence at the end of data,
+// which a byte-limited read can leave behind.
+func trimPartialRune(data []byte) []byte {
+	for i := 1; i < utf8.UTFMax && i <= len(data); i++ {
+		if utf8.RuneStart(data[len(data)-i]) {
+			if !utf8.FullRune(data[len(data)-i:]) {
+				return data[:len(data)-i]
+			}
+			break
+		}
+	}
+	return data
+}
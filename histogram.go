@@ -0,0 +1,87 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+// parseHistogram parses the --histogram value, segment=N, and returns the
+// 1-based index of the path segment to group on.
+func parseHistogram(v string) (int, error) {
+	key, value, ok := strings.Cut(v, "=")
+	if !ok || key != "segment" {
+		return 0, fmt.Errorf("expected segment=N")
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("the segment must be a positive integer, got %q", value)
+	}
+	return n, nil
+}
+
+// segmentHistogram counts matches by the value of one /-separated segment
+// of their names, such as the year in year=2024/month=01/... layouts.
+type segmentHistogram struct {
+	segment int
+	// minCount hides the values with fewer matches.
+	minCount int
+	counts   map[string]int
+	// short counts the matches with fewer than segment segments.
+	short int
+}
+
+// newSegmentHistogram returns a histogram grouping on the 1-based segment
+// that prints only the values with at least minCount matches.
+func newSegmentHistogram(segment, minCount int) *segmentHistogram {
+	return &segmentHistogram{segment: segment, minCount: minCount, counts: make(map[string]int)}
+}
+
+// add counts one match under the value of its segment. The object's own
+// name counts as its last segment, so a/b.txt has two.
+func (h *segmentHistogram) add(attrs *storage.ObjectAttrs) {
+	parts := strings.SplitN(attrs.Name, "/", h.segment+1)
+	if len(parts) < h.segment || parts[h.segment-1] == "" {
+		h.short++
+		return
+	}
+	h.counts[parts[h.segment-1]]++
+}
+
+// print writes one "value: count" line per segment value, the most common
+// first and ties in name order, and notes on status how many matches had
+// no such segment and how many values were too rare to show.
+func (h *segmentHistogram) print(w, status io.Writer) error {
+	values := make([]string, 0, len(h.counts))
+	hidden := 0
+	for v, n := range h.counts {
+		if n < h.minCount {
+			hidden++
+			continue
+		}
+		values = append(values, v)
+	}
+	slices.SortFunc(values, func(a, b string) int {
+		if c := cmp.Compare(h.counts[b], h.counts[a]); c != 0 {
+			return c
+		}
+		return strings.Compare(a, b)
+	})
+	for _, v := range values {
+		if _, err := fmt.Fprintf(w, "%s: %d\n", v, h.counts[v]); err != nil {
+			return err
+		}
+	}
+	if h.short > 0 {
+		fmt.Fprintf(status, "%d matches have no segment %d and are not in the histogram\n", h.short, h.segment)
+	}
+	if hidden > 0 {
+		fmt.Fprintf(status, "%d values with fewer than %d matches are not shown\n", hidden, h.minCount)
+	}
+	return nil
+}
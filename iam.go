@@ -0,0 +1,157 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+
+	"cloud.google.com/go/storage"
+)
+
+// Where the access of an --emit-iam-policy-bindings record comes from.
+const (
+	// accessFromACL is the object's own access control list, which decides
+	// who may read it in a bucket with fine-grained access control.
+	accessFromACL = "object-acl"
+	// accessFromBucketIAM is the IAM policy of the bucket, the only source
+	// of access under uniform bucket-level access, where object ACLs are
+	// disabled.
+	accessFromBucketIAM = "bucket-iam"
+)
+
+// accessBinding is one role and every member holding it.
+type accessBinding struct {
+	Role    string   `json:"role"`
+	Members []string `json:"members"`
+}
+
+// accessRecord is the --ndjson record of --emit-iam-policy-bindings.
+type accessRecord struct {
+	Bucket   string          `json:"bucket"`
+	Name     string          `json:"name"`
+	Source   string          `json:"source"`
+	Bindings []accessBinding `json:"bindings"`
+}
+
+// bucketAccess is what a bucket's metadata and policy say about all of its
+// objects, looked up once for every worker.
+type bucketAccess struct {
+	once     sync.Once
+	uniform  bool
+	bindings []accessBinding
+	err      error
+}
+
+// accessReporter prints who has access to each matched object, for access
+// reviews. GCS has no IAM policy for a single object: under uniform
+// bucket-level access every object gets the bindings of its bucket's
+// policy, and otherwise the object's ACL, which is then reported alone,
+// without the bucket-wide grants that apply on top of it.
+type accessReporter struct {
+	client *storage.Client
+	ndjson bool
+	out    *lockedWriter
+
+	mu      sync.Mutex
+	buckets map[string]*bucketAccess
+}
+
+// newAccessReporter returns a reporter writing to w, one line per binding
+// member or, with ndjson, one accessRecord per object.
+func newAccessReporter(client *storage.Client, ndjson bool, w io.Writer) *accessReporter {
+	return &accessReporter{client: client, ndjson: ndjson, out: &lockedWriter{w: w}, buckets: make(map[string]*bucketAccess)}
+}
+
+// bucket returns the access settings of the named bucket, fetching its
+// metadata and, under uniform bucket-level access, its IAM policy the first
+// time. A failure is kept, so the objects of an unreadable bucket all report
+// it without a request each.
+func (a *accessReporter) bucket(ctx context.Context, name string) (*bucketAccess, error) {
+	a.mu.Lock()
+	b, ok := a.buckets[name]
+	if !ok {
+		b = &bucketAccess{}
+		a.buckets[name] = b
+	}
+	a.mu.Unlock()
+	b.once.Do(func() {
+		attrs, err := a.client.Bucket(name).Attrs(ctx)
+		if err != nil {
+			b.err = fmt.Errorf("failed to get the access settings of gs://%s: %w", name, err)
+			return
+		}
+		if b.uniform = attrs.UniformBucketLevelAccess.Enabled; !b.uniform {
+			return
+		}
+		policy, err := a.client.Bucket(name).IAM().Policy(ctx)
+		if err != nil {
+			b.err = fmt.Errorf("failed to get the IAM policy of gs://%s: %w", name, err)
+			return
+		}
+		for _, role := range policy.Roles() {
+			members := policy.Members(role)
+			slices.Sort(members)
+			b.bindings = append(b.bindings, accessBinding{Role: string(role), Members: members})
+		}
+		slices.SortFunc(b.bindings, func(x, y accessBinding) int { return cmp.Compare(x.Role, y.Role) })
+	})
+	return b, b.err
+}
+
+// report is the objectFunc printing the bindings of one object.
+func (a *accessReporter) report(ctx context.Context, attrs *storage.ObjectAttrs) error {
+	b, err := a.bucket(ctx, attrs.Bucket)
+	if err != nil {
+		return err
+	}
+	rec := accessRecord{Bucket: attrs.Bucket, Name: attrs.Name, Source: accessFromBucketIAM, Bindings: b.bindings}
+	if !b.uniform {
+		obj := a.client.Bucket(attrs.Bucket).Object(attrs.Name)
+		if attrs.Generation != 0 {
+			obj = obj.Generation(attrs.Generation)
+		}
+		rules, err := obj.ACL().List(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get ACL: %w", err)
+		}
+		rec.Source, rec.Bindings = accessFromACL, aclBindings(rules)
+	}
+
+	w := a.out.to(ctx)
+	if a.ndjson {
+		if rec.Bindings == nil {
+			rec.Bindings = []accessBinding{}
+		}
+		return writeJSONLine(w, rec)
+	}
+	for _, binding := range rec.Bindings {
+		for _, member := range binding.Members {
+			if _, err := fmt.Fprintf(w, "gs://%s/%s\t%s\t%s\t%s\n", attrs.Bucket, attrs.Name, rec.Source, binding.Role, member); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// aclBindings groups ACL entries by role, OWNER, WRITER and READER in
+// that order, the entities of each sorted.
+func aclBindings(rules []storage.ACLRule) []accessBinding {
+	var bindings []accessBinding
+	for _, role := range []storage.ACLRole{storage.RoleOwner, storage.RoleWriter, storage.RoleReader} {
+		var members []string
+		for _, r := range rules {
+			if r.Role == role {
+				members = append(members, string(r.Entity))
+			}
+		}
+		if members != nil {
+			slices.Sort(members)
+			bindings = append(bindings, accessBinding{Role: string(role), Members: members})
+		}
+	}
+	return bindings
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// ignoreRule is one pattern line of an --ignore-file.
+type ignoreRule struct {
+	// pattern is the line as a doublestar glob over the whole path: lines
+	// without an inner "/" are prefixed with "**/" so that they match at
+	// any depth, as in gitignore.
+	pattern string
+	// negate re-includes what earlier rules excluded ("!" lines).
+	negate bool
+	// dirOnly only matches directories (lines ending in "/").
+	dirOnly bool
+}
+
+// ignoreRules excludes the objects an --ignore-file matches, following the
+// gitignore rules: the last matching line decides, "!" re-includes, and a
+// path is excluded along with everything below it once a directory on the
+// way is, which no later "!" line can undo. Paths are object names, so
+// patterns anchored with "/" start at the bucket root.
+type ignoreRules struct {
+	rules []ignoreRule
+}
+
+// readIgnoreFile parses the gitignore-style file at path.
+func readIgnoreFile(path string) (*ignoreRules, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := &ignoreRules{}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		rule, ok := parseIgnoreRule(strings.TrimSuffix(scanner.Text(), "\r"))
+		if !ok {
+			continue
+		}
+		if !doublestar.ValidatePattern(rule.pattern) {
+			return nil, fmt.Errorf("%s:%d: invalid pattern %q", path, n, scanner.Text())
+		}
+		r.rules = append(r.rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// parseIgnoreRule parses one line of an ignore file. It reports false for
+// blank lines and comments.
+func parseIgnoreRule(line string) (ignoreRule, bool) {
+	var rule ignoreRule
+	// Trailing spaces are dropped unless escaped with a backslash.
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+	switch {
+	case line == "" || strings.HasPrefix(line, "#"):
+		return rule, false
+	case strings.HasPrefix(line, "!"):
+		rule.negate = true
+		line = line[1:]
+	case strings.HasPrefix(line, `\!`), strings.HasPrefix(line, `\#`):
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return rule, false
+	}
+	// A "/" at the start or in the middle anchors the pattern to the root.
+	if strings.Contains(line, "/") {
+		line = strings.TrimPrefix(line, "/")
+	} else {
+		line = "**/" + line
+	}
+	rule.pattern = line
+	return rule, true
+}
+
+// accept is the objectFilter that drops ignored objects.
+func (r *ignoreRules) accept(attrs *storage.ObjectAttrs) bool {
+	return !r.ignored(attrs.Name)
+}
+
+// ignored reports whether an object name is excluded. A name ending in "/"
+// is a directory placeholder and is matched as a directory.
+func (r *ignoreRules) ignored(name string) bool {
+	isDir := strings.HasSuffix(name, "/")
+	name = strings.TrimSuffix(name, "/")
+	for i := strings.IndexByte(name, '/'); i >= 0; {
+		if r.decide(name[:i], true) {
+			return true
+		}
+		next := strings.IndexByte(name[i+1:], '/')
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+	return r.decide(name, isDir)
+}
+
+// decide applies the rules to one path, the last matching rule winning.
+func (r *ignoreRules) decide(path string, isDir bool) bool {
+	excluded := false
+	for _, rule := range r.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		// The patterns were validated when read, so Match cannot fail.
+		if matched, _ := doublestar.Match(rule.pattern, path); matched {
+			excluded = !rule.negate
+		}
+	}
+	return excluded
+}
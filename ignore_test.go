@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeIgnoreFile writes lines to an ignore file in a temporary directory
+// and returns its path.
+func writeIgnoreFile(t *testing.T, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), ".gcslsignore")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestIgnoreRules checks the gitignore semantics of --ignore-file:
+// comments, anchoring, directory patterns and the precedence of negation.
+func TestIgnoreRules(t *testing.T) {
+	path := writeIgnoreFile(t,
+		"# build output",
+		"",
+		"*.log",
+		"!keep.log",
+		"build/",
+		"!build/keep.txt",
+		"/root-only.txt",
+		"docs/**/*.tmp",
+		`\#hash`,
+		`\!bang`,
+		"!important.cfg",
+		"*.cfg",
+		"trailing   ",
+	)
+	rules, err := readIgnoreFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		ignored bool
+	}{
+		{"a.log", true},
+		{"x/y/a.log", true},
+		{"keep.log", false},
+		{"x/keep.log", false},
+		{"build/out.o", true},
+		{"x/build/out.o", true},
+		// A "!" line cannot re-include what an excluded directory holds.
+		{"build/keep.txt", true},
+		{"build", false},
+		{"build/", true},
+		{"root-only.txt", true},
+		{"x/root-only.txt", false},
+		{"docs/a/b.tmp", true},
+		{"docs/b.tmp", true},
+		{"other/docs/b.tmp", false},
+		{"#hash", true},
+		{"!bang", true},
+		// The last matching line wins, so a "!" before the rule is undone.
+		{"important.cfg", true},
+		{"trailing", true},
+		{"readme.md", false},
+	}
+	for _, tt := range tests {
+		if got := rules.ignored(tt.name); got != tt.ignored {
+			t.Errorf("ignored(%q) = %v, want %v", tt.name, got, tt.ignored)
+		}
+	}
+}
+
+// TestReadIgnoreFileInvalid checks that an invalid pattern is reported with
+// its line number.
+func TestReadIgnoreFileInvalid(t *testing.T) {
+	path := writeIgnoreFile(t, "*.log", "data/[abc")
+	_, err := readIgnoreFile(path)
+	if err == nil || !strings.Contains(err.Error(), ":2: invalid pattern") {
+		t.Errorf("readIgnoreFile error = %v, want one for line 2", err)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// incrementalState is the --incremental state file: the update time up to
+// which earlier runs have listed every match.
+type incrementalState struct {
+	path string
+	// since is the time read from the file, zero before the first run.
+	since time.Time
+	// started is when this run began listing, and latest the newest update
+	// time among its matches.
+	started time.Time
+	latest  time.Time
+}
+
+// loadIncrementalState reads the state file at path. A missing file is the
+// first run, which lists every match.
+func loadIncrementalState(path string) (*incrementalState, error) {
+	s := &incrementalState{path: path, started: time.Now()}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read --incremental state: %w", err)
+	}
+	v := strings.TrimSpace(string(b))
+	if s.since, err = time.Parse(time.RFC3339Nano, v); err != nil {
+		return nil, fmt.Errorf("invalid --incremental state in %s: expected an RFC 3339 timestamp, got %q", path, v)
+	}
+	return s, nil
+}
+
+// seen records a match of the run. It does nothing on a nil state.
+func (s *incrementalState) seen(attrs *storage.ObjectAttrs) {
+	if s != nil && attrs.Updated.After(s.latest) {
+		s.latest = attrs.Updated
+	}
+}
+
+// save advances the state file to the newest update time among the run's
+// matches, so that the next run lists the objects updated after them. It
+// never goes past the time the run started: an object written during the
+// listing may have been passed over already, and the next run lists it
+// again rather than skipping it. With no newer match the file is left as
+// it is. Like the checkpoint, the file is replaced atomically.
+func (s *incrementalState) save() error {
+	next := s.latest
+	if next.After(s.started) {
+		next = s.started
+	}
+	if !next.After(s.since) {
+		return nil
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".gcsls-incremental-*")
+	if err != nil {
+		return fmt.Errorf("failed to write --incremental state: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := fmt.Fprintln(tmp, next.UTC().Format(time.RFC3339Nano)); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write --incremental state: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write --incremental state: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to write --incremental state: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/bmatcuk/doublestar/v4"
+	"google.golang.org/api/iterator"
+)
+
+// inventoryReport serves listings from a Storage Insights inventory report
+// instead of the bucket, for --list-from-inventory. A report is written as
+// one or more CSV shards, either in a bucket (a gs:// pattern) or copied to
+// local files (a local glob). Each row is one object, and the header row
+// names the columns: only the name is required, and bucket, size, updated,
+// timeCreated, storageClass, contentType, generation, crc32c and md5Hash
+// are used when present.
+type inventoryReport struct {
+	client   *storage.Client
+	location string
+	// nameColumn is the header of the name column, or with nameIndex set
+	// (from a 1-based column number), the report has no header row and
+	// only the names are known.
+	nameColumn string
+	nameIndex  int
+	// shards holds the report files once they have been found.
+	shards []string
+}
+
+// newInventoryReport returns a report reading the shards at location. The
+// column is the header of the name column or its 1-based number.
+func newInventoryReport(client *storage.Client, location, column string) (*inventoryReport, error) {
+	r := &inventoryReport{client: client, location: location, nameColumn: column, nameIndex: -1}
+	if n, err := strconv.Atoi(column); err == nil {
+		if n < 1 {
+			return nil, fmt.Errorf("invalid --inventory-name-column %d: column numbers start at 1", n)
+		}
+		r.nameIndex = n - 1
+	}
+	return r, nil
+}
+
+// findShards resolves the report location to its files, in name order.
+// Parquet reports are rejected, since only CSV can be read.
+func (r *inventoryReport) findShards(ctx context.Context) error {
+	if r.shards != nil {
+		return nil
+	}
+	var shards []string
+	if strings.HasPrefix(r.location, "gs://") {
+		bucket, pattern, err := parseGCSPath(r.location)
+		if err != nil {
+			return fmt.Errorf("invalid inventory report location: %w", err)
+		}
+		query := &storage.Query{Prefix: getPrefixFromPattern(pattern)}
+		query.SetAttrSelection([]string{"Name"})
+		it := r.client.Bucket(bucket).Objects(ctx, query)
+		for {
+			attrs, err := it.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("failed to list inventory report %s: %w", r.location, err)
+			}
+			if ok, err := doublestar.Match(pattern, attrs.Name); err != nil {
+				return fmt.Errorf("invalid inventory report pattern %q: %w", r.location, err)
+			} else if ok && !strings.HasSuffix(attrs.Name, "/") {
+				shards = append(shards, "gs://"+bucket+"/"+attrs.Name)
+			}
+		}
+	} else {
+		var err error
+		if shards, err = filepath.Glob(r.location); err != nil {
+			return fmt.Errorf("invalid inventory report pattern %q: %w", r.location, err)
+		}
+		slices.Sort(shards)
+	}
+	if len(shards) == 0 {
+		return fmt.Errorf("no inventory report files match %s", r.location)
+	}
+	for _, s := range shards {
+		if strings.HasSuffix(strings.ToLower(s), ".parquet") {
+			return fmt.Errorf("inventory report %s is Parquet; only CSV reports can be read, so configure the report as CSV", s)
+		}
+	}
+	r.shards = shards
+	return nil
+}
+
+// open returns a reader for one shard.
+func (r *inventoryReport) open(ctx context.Context, shard string) (io.ReadCloser, error) {
+	if !strings.HasPrefix(shard, "gs://") {
+		return os.Open(shard)
+	}
+	bucket, name, _ := parseGCSPath(shard)
+	return r.client.Bucket(bucket).Object(name).NewReader(ctx)
+}
+
+// list returns a next function with the contract of ObjectIterator.Next
+// over the report's objects that listing bucket with query would return,
+// in the order of the report. A report without a bucket column is taken to
+// be of the bucket listed. stop closes the shard being read.
+func (r *inventoryReport) list(ctx context.Context, bucket string, query *storage.Query) (next func() (*storage.ObjectAttrs, error), stop func(), err error) {
+	if err := r.findShards(ctx); err != nil {
+		return nil, nil, err
+	}
+	var (
+		shard   int
+		current io.ReadCloser
+		rows    *csv.Reader
+		columns map[string]int
+	)
+	stop = func() {
+		if current != nil {
+			current.Close()
+			current = nil
+		}
+	}
+	next = func() (*storage.ObjectAttrs, error) {
+		for {
+			if rows == nil {
+				if shard == len(r.shards) {
+					return nil, iterator.Done
+				}
+				f, err := r.open(ctx, r.shards[shard])
+				if err != nil {
+					return nil, fmt.Errorf("failed to read inventory report %s: %w", r.shards[shard], err)
+				}
+				stop()
+				current = f
+				rows = csv.NewReader(f)
+				rows.FieldsPerRecord = -1
+				if columns, err = r.readHeader(rows, r.shards[shard]); err != nil {
+					return nil, err
+				}
+				shard++
+			}
+			record, err := rows.Read()
+			if err == io.EOF {
+				rows = nil
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to read inventory report %s: %w", r.shards[shard-1], err)
+			}
+			attrs, err := inventoryObject(record, columns, bucket)
+			if err != nil {
+				line, _ := rows.FieldPos(0)
+				return nil, fmt.Errorf("%s:%d: %w", r.shards[shard-1], line, err)
+			}
+			if attrs.Bucket == bucket && strings.HasPrefix(attrs.Name, query.Prefix) && attrs.Name >= query.StartOffset {
+				return attrs, nil
+			}
+		}
+	}
+	return next, stop, nil
+}
+
+// readHeader returns the index of each known column in a shard. Without a
+// header row, only the configured name column is known.
+func (r *inventoryReport) readHeader(rows *csv.Reader, shard string) (map[string]int, error) {
+	if r.nameIndex >= 0 {
+		return map[string]int{"name": r.nameIndex}, nil
+	}
+	header, err := rows.Read()
+	if err == io.EOF {
+		return map[string]int{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read inventory report %s: %w", shard, err)
+	}
+	columns := make(map[string]int)
+	for i, h := range header {
+		columns[strings.TrimSpace(h)] = i
+	}
+	if i, ok := columns[r.nameColumn]; ok {
+		columns["name"] = i
+	} else {
+		return nil, fmt.Errorf("inventory report %s has no %q column; name it with --inventory-name-column", shard, r.nameColumn)
+	}
+	return columns, nil
+}
+
+// inventoryObject builds the attributes of one report row. Columns the
+// report does not have are left at their zero values.
+func inventoryObject(record []string, columns map[string]int, bucket string) (*storage.ObjectAttrs, error) {
+	field := func(name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+	attrs := &storage.ObjectAttrs{
+		Bucket:       bucket,
+		Name:         field("name"),
+		StorageClass: field("storageClass"),
+		ContentType:  field("contentType"),
+	}
+	if b := field("bucket"); b != "" {
+		attrs.Bucket = b
+	}
+	var err error
+	if v := field("size"); v != "" {
+		if attrs.Size, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid size %q", v)
+		}
+	}
+	if v := field("generation"); v != "" {
+		if attrs.Generation, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid generation %q", v)
+		}
+	}
+	if v := field("updated"); v != "" {
+		if attrs.Updated, err = time.Parse(time.RFC3339Nano, v); err != nil {
+			return nil, fmt.Errorf("invalid updated time %q", v)
+		}
+	}
+	if v := field("timeCreated"); v != "" {
+		if attrs.Created, err = time.Parse(time.RFC3339Nano, v); err != nil {
+			return nil, fmt.Errorf("invalid timeCreated %q", v)
+		}
+	}
+	if v := field("crc32c"); v != "" {
+		sum, err := base64.StdEncoding.DecodeString(v)
+		if err != nil || len(sum) != 4 {
+			return nil, fmt.Errorf("invalid crc32c %q", v)
+		}
+		attrs.CRC32C = binary.BigEndian.Uint32(sum)
+	}
+	if v := field("md5Hash"); v != "" {
+		if attrs.MD5, err = base64.StdEncoding.DecodeString(v); err != nil {
+			return nil, fmt.Errorf("invalid md5Hash %q", v)
+		}
+	}
+	if attrs.Name == "" {
+		return nil, fmt.Errorf("the row has an empty name")
+	}
+	return attrs, nil
+}
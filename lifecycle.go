@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// lifecyclePreview evaluates the lifecycle rules of the listed buckets
+// against matched objects, to show what lifecycle management would do to
+// each of them now.
+type lifecyclePreview struct {
+	rules map[string][]storage.LifecycleRule
+	now   time.Time
+}
+
+// loadLifecyclePreview fetches the lifecycle rules of every bucket among
+// the targets.
+func loadLifecyclePreview(ctx context.Context, client *storage.Client, targets []listTarget) (*lifecyclePreview, error) {
+	p := &lifecyclePreview{rules: make(map[string][]storage.LifecycleRule), now: time.Now()}
+	for _, t := range targets {
+		if _, ok := p.rules[t.bucket]; ok {
+			continue
+		}
+		attrs, err := client.Bucket(t.bucket).Attrs(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get lifecycle rules of gs://%s: %w", t.bucket, err)
+		}
+		p.rules[t.bucket] = attrs.Lifecycle.Rules
+	}
+	return p, nil
+}
+
+// storageClassRank returns the position of a class in storageClassOrder,
+// from the most to the least expensive to store. Lifecycle rules only move
+// objects to a colder class, and when several SetStorageClass rules apply,
+// GCS picks the class with the lowest at-rest price. The legacy classes
+// rank with STANDARD.
+func storageClassRank(class string) int {
+	return max(0, slices.Index(storageClassOrder, class))
+}
+
+// action describes what the bucket's lifecycle rules would do to the object
+// today: "Delete", "SetStorageClass:<class>", or "" if no rule applies. As
+// in GCS, Delete takes precedence over any storage class change. A Delete
+// rule that depends on how many newer versions a noncurrent version has,
+// which the listing cannot tell, is reported with an "if" clause.
+func (p *lifecyclePreview) action(attrs *storage.ObjectAttrs) string {
+	if p == nil {
+		return ""
+	}
+	var del, class string
+	for _, r := range p.rules[attrs.Bucket] {
+		ok, newer := p.applies(r.Condition, attrs)
+		if !ok {
+			continue
+		}
+		switch r.Action.Type {
+		case storage.DeleteAction:
+			if newer == 0 {
+				return storage.DeleteAction
+			}
+			if del == "" {
+				del = fmt.Sprintf("%s if at least %d newer versions exist", storage.DeleteAction, newer)
+			}
+		case storage.SetStorageClassAction:
+			if newer > 0 || storageClassRank(r.Action.StorageClass) <= storageClassRank(attrs.StorageClass) {
+				continue
+			}
+			if class == "" || storageClassRank(r.Action.StorageClass) > storageClassRank(class) {
+				class = r.Action.StorageClass
+			}
+		}
+	}
+	var actions []string
+	if class != "" {
+		actions = append(actions, storage.SetStorageClassAction+":"+class)
+	}
+	if del != "" {
+		actions = append(actions, del)
+	}
+	return strings.Join(actions, ", ")
+}
+
+// applies reports whether every condition of a rule holds for the object.
+// When they do except for NumNewerVersions on a noncurrent version, it also
+// returns that number.
+func (p *lifecyclePreview) applies(c storage.LifecycleCondition, attrs *storage.ObjectAttrs) (bool, int64) {
+	noncurrent := !attrs.Deleted.IsZero()
+	days := func(since time.Time) int64 {
+		return int64(p.now.Sub(since) / (24 * time.Hour))
+	}
+
+	switch c.Liveness {
+	case storage.Live:
+		if noncurrent {
+			return false, 0
+		}
+	case storage.Archived:
+		if !noncurrent {
+			return false, 0
+		}
+	}
+	if c.AgeInDays > 0 && days(attrs.Created) < c.AgeInDays {
+		return false, 0
+	}
+	if !c.CreatedBefore.IsZero() && !attrs.Created.Before(c.CreatedBefore) {
+		return false, 0
+	}
+	if c.DaysSinceCustomTime > 0 && (attrs.CustomTime.IsZero() || days(attrs.CustomTime) < c.DaysSinceCustomTime) {
+		return false, 0
+	}
+	if !c.CustomTimeBefore.IsZero() && (attrs.CustomTime.IsZero() || !attrs.CustomTime.Before(c.CustomTimeBefore)) {
+		return false, 0
+	}
+	if c.DaysSinceNoncurrentTime > 0 && (!noncurrent || days(attrs.Deleted) < c.DaysSinceNoncurrentTime) {
+		return false, 0
+	}
+	if !c.NoncurrentTimeBefore.IsZero() && (!noncurrent || !attrs.Deleted.Before(c.NoncurrentTimeBefore)) {
+		return false, 0
+	}
+	if len(c.MatchesStorageClasses) > 0 && !slices.Contains(c.MatchesStorageClasses, attrs.StorageClass) {
+		return false, 0
+	}
+	if len(c.MatchesPrefix) > 0 && !slices.ContainsFunc(c.MatchesPrefix, func(s string) bool { return strings.HasPrefix(attrs.Name, s) }) {
+		return false, 0
+	}
+	if len(c.MatchesSuffix) > 0 && !slices.ContainsFunc(c.MatchesSuffix, func(s string) bool { return strings.HasSuffix(attrs.Name, s) }) {
+		return false, 0
+	}
+	// The live version has no newer versions; how many a noncurrent one has
+	// is not part of its listing entry.
+	if c.NumNewerVersions > 0 {
+		if !noncurrent {
+			return false, 0
+		}
+		return true, c.NumNewerVersions
+	}
+	return true, 0
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"cloud.google.com/go/storage"
+)
+
+// lineCountChunk is how much --line-count reads from an object at a time,
+// so that counting a large log never holds more than this in memory.
+const lineCountChunk = 256 * 1024
+
+// countLines returns an objectFunc that prints each object's URL and the
+// number of newline bytes in it, tab-separated, like wc -l. The object is
+// streamed in chunks. If the first chunk looks binary, the object's size in
+// bytes is printed instead and the rest is not read. key, when set, is the
+// customer-supplied encryption key for encrypted objects.
+func countLines(client *storage.Client, key []byte, w io.Writer) objectFunc {
+	out := &lockedWriter{w: w}
+	return func(ctx context.Context, attrs *storage.ObjectAttrs) error {
+		url := newObjectResult(attrs).gsURL()
+
+		// Placeholders and empty objects have no lines to count.
+		if attrs.Size == 0 || strings.HasSuffix(attrs.Name, "/") {
+			_, err := fmt.Fprintf(out.to(ctx), "%s\t0\n", url)
+			return err
+		}
+		h, err := readHandle(client, attrs, key)
+		if err != nil {
+			return err
+		}
+		r, err := h.NewReader(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to open object: %w", err)
+		}
+		defer r.Close()
+
+		buf := make([]byte, lineCountChunk)
+		var lines int64
+		for first := true; ; first = false {
+			n, err := io.ReadFull(r, buf)
+			data := buf[:n]
+			// As with --head, a zero byte or invalid UTF-8 at the start
+			// means the content is not text.
+			if first && (bytes.IndexByte(data, 0) >= 0 || !utf8.Valid(trimPartialRune(data))) {
+				_, err := fmt.Fprintf(out.to(ctx), "%s\t(binary, %d bytes)\n", url, attrs.Size)
+				return err
+			}
+			lines += int64(bytes.Count(data, []byte{'\n'}))
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read object: %w", err)
+			}
+		}
+		_, err = fmt.Fprintf(out.to(ctx), "%s\t%d\n", url, lines)
+		return err
+	}
+}
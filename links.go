@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+// Link objects point at other objects by a convention of the tools that
+// write them; GCS itself has no links. A link is read with at most
+// linkReadLimit bytes, and a chain of links is followed for at most
+// maxLinkHops links before it is reported as a loop.
+const (
+	linkReadLimit = 4 * 1024
+	maxLinkHops   = 8
+)
+
+// linkMarker tells --resolve-links which objects are links: those with
+// contentType, whose content is the gs:// URL of the target, or those with
+// the custom metadata key metadataKey, whose value is the URL.
+type linkMarker struct {
+	contentType string
+	metadataKey string
+}
+
+// parseLinkMarker parses the content-type=TYPE or metadata=KEY of
+// --resolve-links.
+func parseLinkMarker(v string) (*linkMarker, error) {
+	kind, value, ok := strings.Cut(v, "=")
+	if !ok || value == "" {
+		return nil, fmt.Errorf("expected content-type=TYPE or metadata=KEY, got %q", v)
+	}
+	switch kind {
+	case "content-type":
+		return &linkMarker{contentType: value}, nil
+	case "metadata":
+		return &linkMarker{metadataKey: value}, nil
+	}
+	return nil, fmt.Errorf("expected content-type=TYPE or metadata=KEY, got %q", v)
+}
+
+// target returns the URL the object links to, or "" if it is not a link.
+// key, when set, is the customer-supplied encryption key for encrypted
+// links.
+func (m *linkMarker) target(ctx context.Context, client *storage.Client, attrs *storage.ObjectAttrs, key []byte) (string, error) {
+	var url string
+	switch {
+	case m.metadataKey != "":
+		v, ok := attrs.Metadata[m.metadataKey]
+		if !ok {
+			return "", nil
+		}
+		url = v
+	case attrs.ContentType == m.contentType:
+		h, err := readHandle(client, attrs, key)
+		if err != nil {
+			return "", err
+		}
+		r, err := h.NewRangeReader(ctx, 0, linkReadLimit)
+		if err != nil {
+			return "", fmt.Errorf("failed to open link: %w", err)
+		}
+		data, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return "", fmt.Errorf("failed to read link: %w", err)
+		}
+		url = string(data)
+	default:
+		return "", nil
+	}
+	url = strings.TrimSpace(url)
+	if _, name, err := parseGCSPath(url); err != nil || name == "" {
+		return "", fmt.Errorf("link target %q is not a gs:// object URL", url)
+	}
+	return url, nil
+}
+
+// resolveLinks returns an objectFunc that prints each object's URL and,
+// for a link, the chain of links it leads through to the object at its
+// end, as "gs://b/link -> gs://b/target". A target that does not exist is
+// noted as missing.
+func resolveLinks(client *storage.Client, key []byte, marker *linkMarker, w io.Writer) objectFunc {
+	out := &lockedWriter{w: w}
+	return func(ctx context.Context, attrs *storage.ObjectAttrs) error {
+		chain := []string{newObjectResult(attrs).gsURL()}
+		for current := attrs; ; {
+			url, err := marker.target(ctx, client, current, key)
+			// The pool names the matched object in its errors, so only a
+			// link further down the chain needs naming.
+			if err != nil && len(chain) > 1 {
+				return fmt.Errorf("%s: %w", chain[len(chain)-1], err)
+			}
+			if err != nil {
+				return err
+			}
+			if url == "" {
+				break
+			}
+			chain = append(chain, url)
+			if len(chain) > maxLinkHops+1 {
+				return fmt.Errorf("more than %d links in a row; is there a loop?", maxLinkHops)
+			}
+			bucket, name, _ := parseGCSPath(url)
+			current, err = client.Bucket(bucket).Object(name).Attrs(ctx)
+			if errors.Is(err, storage.ErrObjectNotExist) {
+				chain[len(chain)-1] += " (missing)"
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("failed to look up link target %s: %w", url, err)
+			}
+		}
+		_, err := fmt.Fprintln(out.to(ctx), strings.Join(chain, " -> "))
+		return err
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+)
+
+// Modes accepted by --listing-mode.
+const (
+	listingFast       = "fast"
+	listingConsistent = "consistent"
+)
+
+// maxListingResumes is how many times in a row --listing-mode consistent
+// restarts a listing that fails without returning a new object in between.
+const maxListingResumes = 3
+
+// listingCursor remembers how far a listing has got, to tell the objects a
+// restarted listing returns again from new ones. GCS lists objects in name
+// order, and the generations of a name one after the other, so only the
+// generations of the last name need remembering: any name before it was
+// already returned.
+type listingCursor struct {
+	started     bool
+	name        string
+	generations map[int64]bool
+}
+
+// repeat reports whether attrs was returned before, and records it
+// otherwise.
+func (c *listingCursor) repeat(attrs *storage.ObjectAttrs) bool {
+	switch {
+	case c.started && attrs.Name < c.name:
+		return true
+	case c.started && attrs.Name == c.name:
+		if c.generations[attrs.Generation] {
+			return true
+		}
+	default:
+		c.started, c.name, c.generations = true, attrs.Name, make(map[int64]bool)
+	}
+	c.generations[attrs.Generation] = true
+	return false
+}
+
+// resumable reports whether a listing that failed with err is worth
+// restarting: it was not cancelled, and GCS did not turn it down for good,
+// as it does a bucket that does not exist or may not be listed.
+func resumable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return true
+	}
+	switch apiErr.Code {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return true
+	}
+	return apiErr.Code >= http.StatusInternalServerError
+}
+
+// resumeListing returns a next function with the contract of
+// ObjectIterator.Next that lists from start with list and, when the
+// listing fails once retries of its page are used up, starts it again from
+// the last name it returned. Objects the new listing returns again are
+// skipped, so none is returned twice, even if list restarts from the
+// beginning. The listing fails after maxListingResumes restarts that
+// return nothing new.
+func resumeListing(ctx context.Context, start string, list func(start string) func() (*storage.ObjectAttrs, error)) func() (*storage.ObjectAttrs, error) {
+	var cursor listingCursor
+	next := list(start)
+	failures := 0
+	return func() (*storage.ObjectAttrs, error) {
+		for {
+			attrs, err := next()
+			if err == nil {
+				if cursor.repeat(attrs) {
+					continue
+				}
+				failures = 0
+				return attrs, nil
+			}
+			if err == iterator.Done || failures == maxListingResumes || !resumable(ctx, err) {
+				return nil, err
+			}
+			failures++
+			// StartOffset is inclusive, so the last name is listed again
+			// along with any generations of it not yet returned.
+			next = list(max(start, cursor.name))
+		}
+	}
+}
+
+// listMatches starts the listing of bucket that a scan matches against.
+// With --listing-mode consistent, a listing that fails part way is resumed
+// as resumeListing describes, with the same query from a later offset.
+func listMatches(ctx context.Context, client *storage.Client, bucket string, query *storage.Query, opts *options) func() (*storage.ObjectAttrs, error) {
+	if opts.listingMode != listingConsistent {
+		return listObjects(ctx, client, bucket, query, opts).Next
+	}
+	return resumeListing(ctx, query.StartOffset, func(start string) func() (*storage.ObjectAttrs, error) {
+		q := *query
+		q.StartOffset = start
+		return listObjects(ctx, client, bucket, &q, opts).Next
+	})
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// TestResumeListing checks the restarts of a listing that fails part way
+// against fake listings, the cases of --self-test among them.
+func TestResumeListing(t *testing.T) {
+	for _, c := range resumeTestCases {
+		if got := selfTestResume(c); got != c.want {
+			t.Errorf("%s: listed %q, want %q", c.note, got, c.want)
+		}
+	}
+}
+
+// TestListingModeConsistent checks that a listing whose page fails once
+// retries are used up is resumed by --listing-mode consistent without
+// listing any object twice or leaving one out, and fails with fast.
+func TestListingModeConsistent(t *testing.T) {
+	var names []string
+	for i := range 50 {
+		names = append(names, fmt.Sprintf("logs/%02d.log", i))
+	}
+	fake := newFakeGCS(t, "resume", names, 10)
+	// Pages 3 and 5 fail, whichever page the restart began at.
+	fake.failPages = []int{3, 5}
+
+	// No budget, so the failed page is not retried and the listing fails.
+	got := listedNames(t, "--listing-mode", "consistent", "--retry-budget", "0", "--assume-exists", "gs://resume/logs/**")
+	if !slices.Equal(got, names) {
+		t.Errorf("consistent listing = %v, want each of the %d objects once", got, len(names))
+	}
+
+	fake.failPages = []int{fake.pagesServed() + 3}
+	if _, err := runListing(t, "--listing-mode", "fast", "--retry-budget", "0", "--assume-exists", "gs://resume/logs/**"); err == nil {
+		t.Error("fast listing with a failed page succeeded")
+	}
+}
+
+// TestListingModeConsistentVersions checks that a listing of all versions
+// resumed among the generations of a name lists each generation once.
+func TestListingModeConsistentVersions(t *testing.T) {
+	fake := newFakeGCS(t, "gens", nil, 2)
+	for g := range int64(5) {
+		fake.objects = append(fake.objects, fakeObject{name: "data/x", generation: g + 1, size: 1, noncurrent: g < 4})
+	}
+	fake.objects = append(fake.objects, fakeObject{name: "data/y", generation: 1, size: 1})
+	// The second page, generations 3 and 4 of x, fails; the restart from x
+	// lists generations 1 and 2 again.
+	fake.failPages = []int{2}
+
+	out, err := runListing(t, "--listing-mode", "consistent", "--retry-budget", "0", "--assume-exists", "--versions", "--with-generation", "gs://gens/data/**")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first line is the header.
+	got := strings.Split(strings.TrimSpace(out), "\n")[1:]
+	want := []string{"gs://gens/data/x#1", "gs://gens/data/x#2", "gs://gens/data/x#3", "gs://gens/data/x#4", "gs://gens/data/x#5", "gs://gens/data/y#1"}
+	if !slices.Equal(got, want) {
+		t.Errorf("resumed listing of versions = %v, want %v", got, want)
+	}
+}
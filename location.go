@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+// parseLocations splits a --location value into its comma-separated
+// locations. GCS reports locations in upper case, such as US-CENTRAL1 or
+// EU, so they are compared without regard to case.
+func parseLocations(v string) ([]string, error) {
+	var locs []string
+	for _, loc := range strings.Split(v, ",") {
+		loc = strings.TrimSpace(loc)
+		if loc == "" {
+			return nil, fmt.Errorf("invalid --location %q: empty location", v)
+		}
+		locs = append(locs, strings.ToUpper(loc))
+	}
+	return locs, nil
+}
+
+// skippedBucket is a bucket --location left out, and where it is.
+type skippedBucket struct {
+	bucket   string
+	location string
+}
+
+// filterByLocation drops the targets in buckets outside locations, before
+// anything is listed, and returns the rest along with the buckets dropped
+// in the order of targets. Each bucket is looked up once, however many
+// patterns it has, and the lookup stands in for that of checkBucketsExist.
+func filterByLocation(ctx context.Context, client *storage.Client, targets []listTarget, locations []string) ([]listTarget, []skippedBucket, error) {
+	var (
+		kept    []listTarget
+		skipped []skippedBucket
+		where   = make(map[string]string)
+	)
+	for _, t := range targets {
+		loc, ok := where[t.bucket]
+		if !ok {
+			attrs, err := client.Bucket(t.bucket).Attrs(ctx)
+			if errors.Is(err, storage.ErrBucketNotExist) {
+				return nil, nil, fmt.Errorf("bucket gs://%s does not exist; check the name and the project it belongs to", t.bucket)
+			}
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to get the location of gs://%s for --location: %w", t.bucket, err)
+			}
+			loc = attrs.Location
+			where[t.bucket] = loc
+			if !slices.Contains(locations, strings.ToUpper(loc)) {
+				skipped = append(skipped, skippedBucket{bucket: t.bucket, location: loc})
+			}
+		}
+		if slices.Contains(locations, strings.ToUpper(loc)) {
+			kept = append(kept, t)
+		}
+	}
+	return kept, skipped, nil
+}
@@ -1,28 +1,781 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"crypto/sha256"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"math/rand/v2"
 	"os"
+	"os/signal"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"cloud.google.com/go/storage"
 	"github.com/bmatcuk/doublestar/v4"
-	"google.golang.org/api/iterator"
+	"golang.org/x/term"
 )
 
+// options holds the settings collected from the command-line flags.
+type options struct {
+	// concurrency bounds the number of per-object operations run at once.
+	concurrency int
+	// keepGoing reports per-object failures and continues instead of
+	// stopping at the first one.
+	keepGoing bool
+	// errorsTo writes a JSON record of each per-object failure and each
+	// pattern that matched nothing to this file.
+	errorsTo string
+	// objectTimeout bounds each per-object operation; 0 leaves only the
+	// deadline of the whole run.
+	objectTimeout time.Duration
+	// stat fetches and prints the full metadata of every matched object.
+	stat bool
+	// versions lists every generation of each object, not just the live one.
+	versions bool
+	// liveOnly keeps only the live generations of a --versions listing.
+	liveOnly bool
+	// softDeleted lists soft-deleted objects instead of live ones.
+	softDeleted bool
+	// asOf, when set, limits output to the generation of each object that
+	// was live at that time.
+	asOf time.Time
+	// head, when positive, prints the first head lines of each matched
+	// object.
+	head int
+	// lineCount prints the number of lines in each matched object.
+	lineCount bool
+	// signURLs, when positive, prints a signed download URL valid for this
+	// long for each matched object, signed as signingAccount if set.
+	signURLs       time.Duration
+	signingAccount string
+	// emitIAMBindings prints who has access to each matched object: the
+	// bucket's IAM bindings under uniform bucket-level access, or else the
+	// object's ACL.
+	emitIAMBindings bool
+	// resolveLinks, when set, follows each matched link object, marked as
+	// it describes, to the object it points at.
+	resolveLinks *linkMarker
+	// grep prints the lines of each matched object's content that contain
+	// it, or that it matches as a regular expression with grepRegex.
+	grep           string
+	grepRegex      bool
+	grepIgnoreCase bool
+	// grepMaxBytes, when positive, is how much of each object --grep
+	// reads at most.
+	grepMaxBytes int64
+	// csekKey is the customer-supplied encryption key attached to reads of
+	// object contents.
+	csekKey []byte
+	// batchStat reads the patterns from stdin and outputs the full metadata
+	// of every match, fetched concurrently, in listing order.
+	batchStat bool
+	// downloadTo is the local directory matched objects are downloaded into.
+	downloadTo string
+	// verify checks each download against the object's CRC32C.
+	verify bool
+	// resume continues interrupted downloads from their partial files.
+	resume bool
+	// exec is a command run for each matched object, with {} replaced by
+	// the object's URL.
+	exec string
+	// layout selects how object names map to local paths when downloading.
+	layout string
+	// matchOnlyObjects makes a pattern ending in "/" match only the object
+	// literally named that way (a directory placeholder) instead of
+	// everything under it.
+	matchOnlyObjects bool
+	// matchPrefixes explicitly requests the default trailing-slash behavior.
+	matchPrefixes bool
+	// matchPrefixObjects keeps an object whose name equals the pattern's
+	// literal prefix, such as "logs/" for logs/**, among the matches.
+	matchPrefixObjects bool
+	// flushEvery flushes standard output after this many lines (0 = only at
+	// the end).
+	flushEvery int
+	// emitScript, when set, prints a shell script of the given kind instead
+	// of the listing.
+	emitScript string
+	// manifest prints a CSV manifest of url, size, mtime and crc32c instead
+	// of the listing.
+	manifest bool
+	// stats prints scan efficiency statistics to stderr at the end.
+	stats bool
+	// metadataMatches keeps only objects whose custom metadata matches
+	// every one of these conditions.
+	metadataMatches []metadataMatch
+	// partitionMatches keeps only objects whose Hive-style partitions match
+	// every one of these conditions.
+	partitionMatches []partitionMatch
+	// partitionSpec lists the partition keys matched names must have, in
+	// order; JSON records then include the partitions.
+	partitionSpec []string
+	// extractDate, when set, finds a date in each matched name for JSON
+	// records, the long listing and --sort date.
+	extractDate *dateExtractor
+	// maxNameLength, minSegments and maxSegments bound the length of object
+	// names and their number of "/"-separated segments; objects outside the
+	// bounds are kept. 0 leaves a bound unset.
+	maxNameLength int
+	minSegments   int
+	maxSegments   int
+	// nameTemplate, from --name-template, matches the names that follow
+	// the naming scheme; the others are kept.
+	nameTemplate *regexp.Regexp
+	// invert keeps the objects within the name bounds instead.
+	invert bool
+	// sinceGeneration, when 0 or more, keeps only objects whose generation is
+	// greater than it.
+	sinceGeneration int64
+	// sinceFile is a gs:// object or local file; only objects updated after
+	// it was last modified are kept.
+	sinceFile string
+	// since is the modification time of sinceFile, resolved once before
+	// the listing.
+	since time.Time
+	// incremental is a state file holding the update time up to which
+	// earlier runs listed every match; see --incremental.
+	incremental string
+	// owner keeps only objects whose owner entity matches this glob.
+	owner string
+	// includeNames, when set, is a file of exact object names; only objects
+	// with one of them are matched.
+	includeNames string
+	// long prints size and update time alongside each object.
+	long bool
+	// timeFormat renders the timestamps of -l and the JSON records.
+	timeFormat timeFormat
+	// humanTime adds the time of the listing to the banner and the age of
+	// each time to the --stat blocks.
+	humanTime bool
+	// json prints the matched objects as a JSON array.
+	json bool
+	// jsonPretty indents the --json array for reading in a terminal. It
+	// implies json.
+	jsonPretty bool
+	// ndjson prints one JSON object per matched object and line.
+	ndjson bool
+	// framedNDJSON writes each --ndjson record after its length instead of
+	// on a line of its own; it implies ndjson.
+	framedNDJSON bool
+	// manifestChecksum ends the listing with a trailer holding its object
+	// count and checksum.
+	manifestChecksum bool
+	// verifyListing, when set, checks the listing in this file or gs://
+	// object against its manifestChecksum trailer instead of listing.
+	verifyListing string
+	// binary writes each matched object as a length-prefixed binary record.
+	binary bool
+	// pageSize is the number of objects requested per listing page; 0 uses
+	// the API's default.
+	pageSize int
+	// shards, when greater than 1, lists each prefix as this many
+	// concurrent queries over disjoint name ranges.
+	shards int
+	// bucketConcurrency, when greater than 1, lists up to this many
+	// buckets at once.
+	bucketConcurrency int
+	// prefixesFrom, when set, is a file of object name prefixes that are
+	// listed instead of the pattern's own prefix; prefixes holds them once
+	// read.
+	prefixesFrom string
+	prefixes     []string
+	// prefixConcurrency bounds the number of --prefixes-from prefixes
+	// listed at once.
+	prefixConcurrency int
+	// listingMode is listingFast, where a listing fails once the retries
+	// of a page are used up, or listingConsistent, where it is resumed
+	// from where it stopped without returning any object twice.
+	listingMode string
+	// maxScanCost, when positive, stops the run once its listings have
+	// needed more than this many requests (Class A operations).
+	maxScanCost int
+	// inventory, when set, is the location of an inventory report that is
+	// matched instead of listing the bucket; inventoryNameColumn names its
+	// column of object names.
+	inventory           string
+	inventoryNameColumn string
+	// assumeExists skips the lookup of each bucket before it is listed.
+	assumeExists bool
+	// locations, when set, skips the buckets located elsewhere, in upper
+	// case as GCS reports them.
+	locations []string
+	// exists looks up the one object named by the path instead of
+	// listing, and reports whether it exists through the exit status.
+	exists bool
+	// deadline, when set, ends the run with an error once it passes.
+	deadline time.Time
+	// metricsFile, when set, gets a CSV record of each run's statistics
+	// appended.
+	metricsFile string
+	// sortBufferLimit is how many matches --sort holds in memory before
+	// spilling sorted runs to temporary files; 0 never spills.
+	sortBufferLimit int
+	// maxBuffered caps how many matches any mode holds in memory; 0 means
+	// no limit.
+	maxBuffered int
+	// retryBudget bounds the total time spent waiting between retries of
+	// failed requests.
+	retryBudget time.Duration
+	// retryOnlyIdempotent leaves failed reads of whatever version of an
+	// object is live unretried, since a retry might read another one.
+	retryOnlyIdempotent bool
+	// retryJitterSeed seeds the backoff jitter when retryJitterSeedSet, so
+	// that a run retries with the same delays again. The flag is left out
+	// of the help: it is there to reproduce retry timings in tests.
+	retryJitterSeed    uint64
+	retryJitterSeedSet bool
+	// throttleOn429 slows requests down while GCS answers with rate limit
+	// errors; throttle paces them.
+	throttleOn429 bool
+	throttle      *adaptiveThrottle
+	// rateReport prints throughput and listing latency at the end; rates
+	// collects the timings.
+	rateReport bool
+	rates      *rateRecorder
+	// poolMetrics reports on the per-object worker pool as it runs.
+	poolMetrics bool
+	// globSyntax selects the matcher of object patterns: doublestar, or
+	// path for the rules of path.Match.
+	globSyntax string
+	// twoPhase lists names only and fetches the full attributes of the
+	// objects that pass the name filters.
+	twoPhase bool
+	// verbose prints debug messages, such as retries and the listing
+	// queries sent, to stderr.
+	verbose bool
+	// trace exports OpenTelemetry spans of the run, its listing pages and
+	// per-object operations over OTLP.
+	trace bool
+	// notificationPreview prints the bucket-notification prefix filter for
+	// the pattern instead of listing it.
+	notificationPreview bool
+	// emitSchema prints the BigQuery schema of the --ndjson records instead
+	// of a listing.
+	emitSchema bool
+	// explain prints how the patterns would be listed and matched instead
+	// of listing them.
+	explain bool
+	// selfTest runs the built-in glob matching checks instead of a listing.
+	selfTest bool
+	// count prints the number of matches instead of listing them.
+	count bool
+	// approx estimates the --count from a sample of the sub-prefixes.
+	approx bool
+	// histogramSegment, when positive, counts the matches per value of
+	// this 1-based path segment instead of in total.
+	histogramSegment int
+	// sizeHistogram counts the matches and their bytes per range of sizes
+	// between sizeBreaks.
+	sizeHistogram bool
+	sizeBreaks    []sizeBreak
+	// sizeBreaksSet records an explicit --size-breaks.
+	sizeBreaksSet bool
+	// timeHistogram counts the matches and their bytes per day or hour,
+	// timeBucketDay or timeBucketHour, of their timeField, in timeZone.
+	timeHistogram string
+	timeField     string
+	timeZone      *time.Location
+	// timeFieldSet records an explicit --time-field.
+	timeFieldSet bool
+	// minGroup hides the groups of --histogram and --duplicate-basenames
+	// with fewer objects.
+	minGroup int
+	// sink sends the matches to a Pub/Sub topic or socket instead of stdout.
+	sink string
+	// splunkHEC posts the matches as events to this Splunk HTTP Event
+	// Collector instead of stdout, authenticating with splunkToken.
+	splunkHEC   string
+	splunkToken string
+	// splunkBatch is the number of events per HEC request, and
+	// splunkFlushInterval how often a partial batch is sent.
+	splunkBatch         int
+	splunkFlushInterval time.Duration
+	// writeListing uploads the output to this GCS object instead of
+	// writing it to stdout.
+	writeListing string
+	// gzip compresses the output written to stdout or --write-listing;
+	// a --write-listing name ending in .gz implies it.
+	gzip bool
+	// matchReport ends an NDJSON stream with a record of scan statistics.
+	matchReport bool
+	// ndjsonErrors writes a record for each object whose --batch-stat
+	// fetch failed into the NDJSON stream.
+	ndjsonErrors bool
+	// withGeneration appends "#<generation>" to each printed URL.
+	withGeneration bool
+	// withMetageneration adds each object's generation and metageneration
+	// to the output.
+	withMetageneration bool
+	// withRetention adds each object's retention mode and retain-until
+	// time to the output.
+	withRetention bool
+	// underRetention keeps only objects whose retention has not expired.
+	underRetention bool
+	// withCustomTime adds each object's custom time to the output.
+	withCustomTime bool
+	// customTimeOlderThan, when set, keeps only objects whose custom time
+	// is before it.
+	customTimeOlderThan time.Time
+	// uriScheme replaces "gs" in printed URLs, such as "s3" for tools that
+	// expect S3 URIs.
+	uriScheme string
+	// extensions, when set, replaces the pattern with a recursive match of
+	// these file extensions under the given directory.
+	extensions []string
+	// namesOnly prints bare object names instead of gs:// URLs.
+	namesOnly bool
+	// relative, with namesOnly, prints names relative to the directory of
+	// the pattern's literal prefix.
+	relative bool
+	// localBase, when set, is a local directory prepended to each
+	// relative name, so that the output reads like local paths. It
+	// implies namesOnly and relative.
+	localBase string
+	// subst rewrites each printed name; see --subst.
+	subst *substitution
+	// urlDecode matches and prints object names URL-decoded.
+	urlDecode bool
+	// normalizeSlashes collapses repeated slashes in names, and in the
+	// pattern, before matching.
+	normalizeSlashes bool
+	// normalizeDisplay also prints names with repeated slashes collapsed.
+	normalizeDisplay bool
+	// requireAllMatch fails the run if any of the patterns matched nothing.
+	requireAllMatch bool
+	// assertContentType fails the run if any match has another content
+	// type, after reporting each one.
+	assertContentType string
+	// detectMutation fails the run if the matches changed during the scan,
+	// as far as a re-check of mutationSample of them afterwards can tell.
+	detectMutation bool
+	mutationSample int
+	// invertMatch keeps the objects under the prefix that the pattern does
+	// not match, like grep -v.
+	invertMatch bool
+	// ignoreCase matches the pattern case-insensitively.
+	ignoreCase bool
+	// unanchored lets the pattern match any part of the name instead of
+	// all of it, and lists the whole bucket.
+	unanchored bool
+	// contains, when set, keeps only the objects whose name contains it.
+	contains string
+	// ignore, when set, holds the --ignore-file rules excluding objects.
+	ignore *ignoreRules
+	// matchOn selects what the pattern is matched against: the object name
+	// or its full gs:// URL.
+	matchOn string
+	// matchStdin matches the pattern against names read from stdin instead
+	// of listing the bucket.
+	matchStdin bool
+	// incompleteUploads lists unfinished multipart uploads instead of
+	// objects.
+	incompleteUploads bool
+	// emptyDirs reports the directories under each prefix that hold no
+	// match other than placeholders, instead of listing the matches.
+	emptyDirs bool
+	// dirs prints the directory tree under each prefix, depth levels
+	// deep, instead of the objects.
+	dirs  bool
+	depth int
+	// explore browses the directories under a prefix interactively and
+	// prints the URL chosen.
+	explore bool
+	// findMissing is a file of expected gs:// objects; the ones that do
+	// not exist are printed instead of a listing.
+	findMissing string
+	// compare lists two patterns and prints the names found under only one
+	// of them.
+	compare bool
+	// compareToListing is a listing saved by an earlier run; the matches
+	// added, removed or changed since are printed instead of a listing.
+	compareToListing string
+	// showCommon also prints the names found under both patterns, or
+	// unchanged since the prior listing.
+	showCommon bool
+	// allowedBuckets restricts the buckets that may be listed to those
+	// matching one of its comma-separated globs.
+	allowedBuckets string
+	// allowedBucketsEnv is the same restriction from GCSLS_ALLOWED_BUCKETS.
+	allowedBucketsEnv string
+	// sortBy orders the matches by this key before output; the listing
+	// order is kept when it is empty.
+	sortBy string
+	// reverse sorts in descending order.
+	reverse bool
+	// collate is the order --sort puts names in: collateByte, the order
+	// GCS lists them in, collateCaseInsensitive or collateLocale.
+	collate string
+	// topLargest and topOldest, when positive, output only that many of the
+	// largest or least recently updated matches.
+	topLargest int
+	topOldest  int
+	// newest and oldest output only the most and least recently updated
+	// match.
+	newest bool
+	oldest bool
+	// sample, when positive, outputs only a uniformly random sample of
+	// that many matches, drawn with seed; seedSet records an explicit
+	// --seed.
+	sample  int
+	seed    uint64
+	seedSet bool
+	// ordered merges the scans of several patterns into one listing in
+	// name order, and writes the output of per-object operations in the
+	// order of the listing, holding at most reorderWindow objects.
+	ordered       bool
+	reorderWindow int
+	// dedupeBy drops matches whose base name or content hash was already
+	// seen.
+	dedupeBy string
+	// limit stops the listing after this many matches; 0 means no limit.
+	limit int
+	// limitBytes stops the listing before the total size of the matches
+	// would exceed it; 0 means no limit.
+	limitBytes int64
+	// perBucketLimit caps the matches taken from any one bucket when
+	// several patterns are listed; 0 means no limit.
+	perBucketLimit int
+	// after starts the listing after this object name.
+	after string
+	// complianceReport prints the holds and retention of each match as a
+	// table or CSV, the format it names.
+	complianceReport string
+	// strictGlob rejects patterns whose literal prefix is too short to
+	// narrow the listing, unless after bounds it.
+	strictGlob bool
+	// checkpoint is a file recording listing progress, used to resume an
+	// interrupted listing.
+	checkpoint string
+	// cacheList is a file the listing is written to, and with useCache
+	// served from while it is younger than cacheTTL.
+	cacheList string
+	useCache  bool
+	cacheTTL  time.Duration
+	// cacheRevalidate checks each cached prefix for changes before the
+	// cache is served, which lets it be used up to cacheMaxAge.
+	cacheRevalidate bool
+	cacheMaxAge     time.Duration
+	// chunk groups the output into blocks of this many objects; 0 disables
+	// chunking.
+	chunk int
+	// segment groups the output by the first this many segments of the
+	// names, as they stream in listing order; 0 disables grouping.
+	segment int
+	// selectMode lets the user pick one matched object interactively.
+	selectMode bool
+	// rename, when set, prints the plan of the --rename substitution
+	// instead of the matches.
+	rename *substitution
+	// duplicateBasenames prints the groups of matches that share a base
+	// name instead of the matches themselves.
+	duplicateBasenames bool
+	// wait re-lists until the pattern matches at least expectCount objects.
+	wait bool
+	// expectCount is the number of matches --wait waits for.
+	expectCount int
+	// interval is the delay between listings in --wait mode.
+	interval time.Duration
+	// timeout bounds how long --wait waits; 0 waits forever.
+	timeout time.Duration
+	// table draws the matches as a bordered table once the listing is done;
+	// tableNameWidth caps its name column.
+	table          bool
+	tableNameWidth int
+	// colorByAge colors the update time in -l output by age, from green
+	// at fresh to red at stale.
+	colorByAge bool
+	fresh      time.Duration
+	stale      time.Duration
+	// classSummary prints object counts and bytes per storage class at the end.
+	classSummary bool
+	// summaryOnly prints the totals of the matches instead of the matches.
+	summaryOnly bool
+	// patternFile reads labelled patterns from a file instead of the
+	// command line.
+	patternFile string
+	// labels holds the label of each pattern read from patternFile, in
+	// order; it is nil without a pattern file.
+	labels []string
+	// tags label each match with the NAME of the first --tag whose GLOB
+	// its name matches, or of every one with allTags.
+	tags    []objectTag
+	allTags bool
+	// lifecyclePreview annotates each match with the action the bucket's
+	// lifecycle rules would take on it.
+	lifecyclePreview bool
+}
+
 // showHelp displays the usage information for the tool.
 func showHelp() {
 	fmt.Printf("gcsls - List Google Cloud Storage objects with wildcard support\n\n")
 	fmt.Printf("USAGE:\n")
-	fmt.Printf("  %s [OPTIONS] \"gs://bucket/object-pattern\"\n\n", os.Args[0])
+	fmt.Printf("  %s [OPTIONS] \"gs://bucket/object-pattern\" [\"gs://bucket/object-pattern\" ...]\n", os.Args[0])
+	fmt.Printf("  %s %s [OPTIONS] gs://bucket/partial-path\n", os.Args[0], completePrefixCommand)
+	fmt.Printf("                      Print the completions of a partial path, for shell completion scripts\n\n")
 	fmt.Printf("OPTIONS:\n")
-	fmt.Printf("  -h, --help    Show this help message and exit\n\n")
+	fmt.Printf("  -h, --help          Show this help message and exit\n")
+	fmt.Printf("  --                  End of options; the next argument is the pattern even if it starts with -\n")
+	fmt.Printf("  --versions          List every generation of each object, not just the live one\n")
+	fmt.Printf("  --live-only         With --versions, keep only the live generation of each name\n")
+	fmt.Printf("  --soft-deleted      List soft-deleted objects instead of live ones\n")
+	fmt.Printf("  --as-of TIMESTAMP   List the objects as they were live at TIMESTAMP (RFC 3339 or YYYY-MM-DD)\n")
+	fmt.Printf("  -l, --long          Print size and update time for each object, plus a total\n")
+	fmt.Printf("  --time-format FMT   Show times as rfc3339 (default), unix, date, relative or a Go layout;\n")
+	fmt.Printf("                      JSON records only honor unix\n")
+	fmt.Printf("  --human-time        Note when the listing was taken under its banner, and ages after --stat times\n")
+	fmt.Printf("  --table             Draw the matches as a table of name, size, update age and storage class\n")
+	fmt.Printf("  --compliance-report FORMAT\n")
+	fmt.Printf("                      Report each match's holds, retention and whether it is immutable, as a table or csv\n")
+	fmt.Printf("  --table-name-width N\n")
+	fmt.Printf("                      With --table, cut names longer than N with an ellipsis (default: fit the terminal)\n")
+	fmt.Printf("  --color-by-age      With -l on a terminal, color update times from green (fresh) to red (stale)\n")
+	fmt.Printf("  --fresh AGE         With --color-by-age, the age still shown green, e.g. 1h (default 1h)\n")
+	fmt.Printf("  --stale AGE         With --color-by-age, the age shown red, e.g. 30d (default 30d)\n")
+	fmt.Printf("  --json              Print the matched objects as a JSON array\n")
+	fmt.Printf("  --json-pretty       Like --json, indented for reading\n")
+	fmt.Printf("  --ndjson            Print one JSON object per line for each matched object\n")
+	fmt.Printf("  --binary            Write each matched object as a length-prefixed binary record (see README)\n")
+	fmt.Printf("  --framed-ndjson     Like --ndjson, but write each record after its length as 4 bytes, big-endian\n")
+	fmt.Printf("  --match-report-json With --ndjson, end with a {\"type\":\"summary\"} record of scan statistics\n")
+	fmt.Printf("  --ndjson-errors     With --ndjson and --batch-stat, write a {\"type\":\"error\"} record for each failed fetch\n")
+	fmt.Printf("  --manifest-checksum End the listing with a trailer of its object count and SHA-256, to detect truncation\n")
+	fmt.Printf("  --verify-listing FILE\n")
+	fmt.Printf("                      Check a listing saved with --manifest-checksum (a local file or gs:// URL) against its trailer\n")
+	fmt.Printf("  --count             Print only the number of matching objects\n")
+	fmt.Printf("  --approx            With --count, estimate from a sample of sub-prefixes, with a margin\n")
+	fmt.Printf("  --histogram segment=N\n")
+	fmt.Printf("                      Count the matches per value of the Nth /-separated name segment\n")
+	fmt.Printf("  --size-histogram    Count the matches and their bytes per size range\n")
+	fmt.Printf("  --size-breaks LIST  With --size-histogram, the comma-separated range boundaries (default %s)\n", defaultSizeBreaks)
+	fmt.Printf("  --by-day            Count the matches and their bytes per day, oldest first\n")
+	fmt.Printf("  --by-hour           Count the matches and their bytes per hour, oldest first\n")
+	fmt.Printf("  --time-field FIELD  With --by-day or --by-hour, the time to group by: updated (default) or created\n")
+	fmt.Printf("  --timezone ZONE     With --by-day or --by-hour, the IANA time zone days and hours begin in,\n")
+	fmt.Printf("                      such as America/New_York or Local (default UTC)\n")
+	fmt.Printf("  --min-group N       With --histogram or --duplicate-basenames, hide groups of fewer than N objects\n")
+	fmt.Printf("  --sink URL          Send one JSON record per match to pubsub://project/topic,\n")
+	fmt.Printf("                      unix:///path or tcp://host:port instead of stdout\n")
+	fmt.Printf("  --splunk-hec URL    Post one JSON event per match to a Splunk HTTP Event Collector instead of stdout\n")
+	fmt.Printf("  --splunk-token TOKEN\n")
+	fmt.Printf("                      The HEC token for --splunk-hec (default $%s)\n", splunkTokenEnv)
+	fmt.Printf("  --splunk-batch N    With --splunk-hec, the events per request (default 100)\n")
+	fmt.Printf("  --splunk-flush-interval DURATION\n")
+	fmt.Printf("                      With --splunk-hec, how often to send a partial batch (default 5s)\n")
+	fmt.Printf("  --write-listing URL Upload the output to the GCS object URL instead of stdout, if the run succeeds\n")
+	fmt.Printf("  --gzip              Compress the output written to a file or --write-listing with gzip\n")
+	fmt.Printf("                      (implied by a --write-listing name ending in .gz)\n")
+	fmt.Printf("  --with-generation   Print generation-pinned URLs (gs://bucket/name#generation)\n")
+	fmt.Printf("  --with-metageneration\n")
+	fmt.Printf("                      Add each object's generation and metageneration to the output and JSON\n")
+	fmt.Printf("  --with-retention    Add each object's retention mode and retain-until time to the output and JSON\n")
+	fmt.Printf("  --under-retention   Match only objects under an unexpired retention configuration\n")
+	fmt.Printf("  --with-custom-time  Add each object's custom time, or unset, to the output and JSON\n")
+	fmt.Printf("  --custom-time-older-than WHEN\n")
+	fmt.Printf("                      Match only objects whose custom time is before WHEN, an age such as 90d or a timestamp\n")
+	fmt.Printf("  --uri-scheme SCHEME Print URLs as SCHEME://bucket/name, such as s3, instead of gs://\n")
+	fmt.Printf("  --chunk N           Group output into blocks of N objects (one JSON array per line with --json)\n")
+	fmt.Printf("  --segment N         Start a new block whenever the first N name segments change, such as dt=.../ for 1\n")
+	fmt.Printf("  --select            Pick one match interactively and print only its URL\n")
+	fmt.Printf("  --owner GLOB        Match only objects whose owner entity matches GLOB\n")
+	fmt.Printf("  --include-names FILE\n")
+	fmt.Printf("                      Match only objects named exactly like a line of FILE, reporting names not found\n")
+	fmt.Printf("  --since-generation N\n")
+	fmt.Printf("                      Match only objects with a generation greater than N\n")
+	fmt.Printf("  --since-file REF    Match only objects updated after REF, a gs:// object or local file, was modified\n")
+	fmt.Printf("  --incremental FILE  Match only objects updated since the last successful run with FILE, then record this one\n")
+	fmt.Printf("  --match-metadata KEY=GLOB\n")
+	fmt.Printf("                      Match only objects whose custom metadata KEY matches GLOB; repeatable, all must match\n")
+	fmt.Printf("  --partition KEY=GLOB\n")
+	fmt.Printf("                      Match only objects whose key=value directory for KEY matches GLOB; repeatable\n")
+	fmt.Printf("  --partition-spec KEYS\n")
+	fmt.Printf("                      Match only objects partitioned by the comma-separated KEYS, in order, and add\n")
+	fmt.Printf("                      the partitions to JSON records\n")
+	fmt.Printf("  --extract-date REGEX\n")
+	fmt.Printf("                      Add the date REGEX captures from each name, in a (?P<date>...) group or year,\n")
+	fmt.Printf("                      month and day groups, to JSON records and -l, and allow --sort date\n")
+	fmt.Printf("  --max-name-length N Match only objects whose name is longer than N bytes\n")
+	fmt.Printf("  --min-segments N    Match only objects with fewer than N /-separated name segments\n")
+	fmt.Printf("  --max-segments N    Match only objects with more than N /-separated name segments\n")
+	fmt.Printf("  --name-template T   Match only objects whose name does not follow T, such as\n")
+	fmt.Printf("                      logs/{service}/{yyyy}/{mm}/{dd}/{uuid}.log (see README)\n")
+	fmt.Printf("  --invert            Match the objects within the name bounds and template above instead\n")
+	fmt.Printf("  --class-summary     Print object counts and bytes per storage class at the end\n")
+	fmt.Printf("  --summary-only      Print only the totals: matches, bytes, storage classes and time taken\n")
+	fmt.Printf("  --honor-lifecycle-preview\n")
+	fmt.Printf("                      Show the action the bucket's lifecycle rules would take on each match today\n")
+	fmt.Printf("  --match-prefixes    A pattern ending in / matches everything under it (default)\n")
+	fmt.Printf("  --match-only-objects\n")
+	fmt.Printf("                      A pattern ending in / matches only the object with that exact name\n")
+	fmt.Printf("  --match-empty-prefix-objects=false\n")
+	fmt.Printf("                      Exclude an object named exactly like the pattern's literal prefix (e.g. logs/)\n")
+	fmt.Printf("  --emit-script KIND  Print a gsutil-rm or tf-import shell script for the matches\n")
+	fmt.Printf("  --manifest          Print a CSV manifest (url,size,mtime,crc32c) of the matches\n")
+	fmt.Printf("  --flush-every N     Flush output after every N lines; 0 buffers until the end (default 1)\n")
+	fmt.Printf("  --wait              Re-list until the pattern matches, then print the matches\n")
+	fmt.Printf("  --expect-count N    With --wait, wait for at least N matches (default 1)\n")
+	fmt.Printf("  --interval D        With --wait, time between listings (default 30s)\n")
+	fmt.Printf("  --timeout D         With --wait, give up and exit non-zero after D (default: never)\n")
+	fmt.Printf("  --ext LIST          Match files with these extensions (e.g. csv,json) recursively\n")
+	fmt.Printf("  --ignore-case       Match the pattern case-insensitively\n")
+	fmt.Printf("  --unanchored        Match the pattern against any part of the name, not the whole name;\n")
+	fmt.Printf("                      lists the whole bucket\n")
+	fmt.Printf("  --contains SUBSTR   Match only objects whose name contains SUBSTR, no glob needed\n")
+	fmt.Printf("  --ignore-file PATH  Exclude the objects matched by a gitignore-style file, such as .gcslsignore\n")
+	fmt.Printf("  --require-all-match Exit non-zero if any pattern matched nothing (each one is reported to stderr)\n")
+	fmt.Printf("  --assert-content-type TYPE\n")
+	fmt.Printf("                      Exit non-zero if any match has another content type (each one is reported to stderr)\n")
+	fmt.Printf("  --detect-mutation   Exit non-zero if the matches changed during the scan, as far as can be told cheaply\n")
+	fmt.Printf("  --mutation-sample N With --detect-mutation, how many matches to look up again afterwards (default 100)\n")
+	fmt.Printf("  --invert-match      Match the objects under the pattern's prefix that the pattern does not match\n")
+	fmt.Printf("  --match-on WHAT     Match the pattern against the object name or its full gs:// url (default name)\n")
+	fmt.Printf("  --compare           Compare two patterns: gcsls --compare gs://a/src/ gs://b/dst/\n")
+	fmt.Printf("  --dirs              Print the directories under each prefix instead of the objects\n")
+	fmt.Printf("  --depth N           With --dirs, descend N directory levels (default 1)\n")
+	fmt.Printf("  --explore           Browse the directories under a prefix interactively and print the URL chosen\n")
+	fmt.Printf("  --compare-to-listing FILE\n")
+	fmt.Printf("                      Print the matches added (+), removed (-) or changed (~) since the listing\n")
+	fmt.Printf("                      in FILE, a local file or gs:// URL saved by an earlier run\n")
+	fmt.Printf("  --show-common       With --compare or --compare-to-listing, also print names in both\n")
+	fmt.Printf("  --incomplete-uploads\n")
+	fmt.Printf("                      List unfinished XML API multipart uploads matching the pattern, with their age\n")
+	fmt.Printf("  --match-report-empty-dirs\n")
+	fmt.Printf("                      Print the directories under the pattern's prefix with no matches but placeholders\n")
+	fmt.Printf("  --find-missing FILE Print the gs:// objects listed in FILE (or - for stdin) that do not exist\n")
+	fmt.Printf("  --exists            Exit 0 if the object at the path (no wildcards) exists, 1 if not, 2 on error; -l prints its size\n")
+	fmt.Printf("  --match-stdin       Match the pattern against names read from stdin, without GCS access\n")
+	fmt.Printf("  --names-only        Print object names without the gs://bucket/ part\n")
+	fmt.Printf("  --relative          With --names-only, print names relative to the pattern's directory\n")
+	fmt.Printf("  --local-base DIR    Print local paths: DIR followed by each name relative to the pattern's directory\n")
+	fmt.Printf("  --subst /RE/REPL/   Print each name rewritten by a regexp substitution instead of its URL\n")
+	fmt.Printf("  --url-decode        Match and print object names URL-decoded (a%%2Fb.pdf as a/b.pdf)\n")
+	fmt.Printf("  --normalize-slashes Match names with repeated slashes collapsed (logs//a.log as logs/a.log)\n")
+	fmt.Printf("  --normalize-display With --normalize-slashes, also print names with repeated slashes collapsed\n")
+	fmt.Printf("  --allowed-buckets LIST  Refuse buckets not matching one of these comma-separated globs\n")
+	fmt.Printf("  --sort KEY          Sort matches by name, size, updated, depth or date before output\n")
+	fmt.Printf("  --reverse           With --sort, sort in descending order\n")
+	fmt.Printf("  --collate ORDER     With --sort, order names by byte, case-insensitive or locale (default byte)\n")
+	fmt.Printf("  --top-largest N     Print only the N largest matches, largest first\n")
+	fmt.Printf("  --top-oldest N      Print only the N least recently updated matches, oldest first\n")
+	fmt.Printf("  --newest            Print only the most recently updated match, or exit 1 if none\n")
+	fmt.Printf("  --oldest            Print only the least recently updated match, or exit 1 if none\n")
+	fmt.Printf("  --sample N          Print only a uniformly random sample of N matches, in listing order\n")
+	fmt.Printf("  --seed N            With --sample, seed the random choice to get the same sample again\n")
+	fmt.Printf("  --ordered           Print the matches of several patterns in one name order, merging as they list,\n")
+	fmt.Printf("                      and the output of per-object operations in listing order\n")
+	fmt.Printf("  --reorder-window N  With --ordered, how many objects operations may run ahead of the oldest\n")
+	fmt.Printf("                      unfinished one (default 4 times --concurrency)\n")
+	fmt.Printf("  --sort-buffer-limit N  With --sort, spill to temporary files beyond N matches (default 100000)\n")
+	fmt.Printf("  --max-buffered N    Fail when --dedupe-by, --select, --compare, --duplicate-basenames or --rename hold\n")
+	fmt.Printf("                      more than N matches in memory; --sort spills to disk at N instead\n")
+	fmt.Printf("  --dedupe-by KEY     Keep only the first match per basename, crc32c or md5\n")
+	fmt.Printf("  --duplicate-basenames\n")
+	fmt.Printf("                      Print only the groups of matches that share a base name across directories\n")
+	fmt.Printf("  --rename s/RE/REPL/ Print the old -> new URL of each match a regexp rename would move, flagging collisions\n")
+	fmt.Printf("  --limit N           Stop after N matches\n")
+	fmt.Printf("  --limit-bytes SIZE  Stop before the matches total more than SIZE, such as 10G\n")
+	fmt.Printf("  --per-bucket-limit N  Take at most N matches from each bucket when listing several patterns\n")
+	fmt.Printf("  --after NAME        Start the listing after the object NAME\n")
+	fmt.Printf("  --strict-glob       Reject patterns whose literal prefix is under 2 characters, unless --after is given\n")
+	fmt.Printf("  --checkpoint FILE   Record progress in FILE and resume from it; removed on success\n")
+	fmt.Printf("  --cache-list FILE   Save the full listing of the patterns' prefixes with all attributes in FILE\n")
+	fmt.Printf("  --use-cache         With --cache-list, match against FILE instead of listing, while it is fresh\n")
+	fmt.Printf("  --cache-ttl D       With --use-cache, list again once FILE is older than D (default 1h)\n")
+	fmt.Printf("  --cache-revalidate  With --cache-list, check each cached prefix's top level for changes before use\n")
+	fmt.Printf("  --cache-max-age D   With --cache-revalidate, list again once FILE is older than D (default 24h)\n")
+	fmt.Printf("  --bucket-notification-preview\n")
+	fmt.Printf("                      Print the Pub/Sub notification prefix filter for the pattern, without GCS access\n")
+	fmt.Printf("  --pattern-file FILE List the \"label: gs://bucket/pattern\" lines of FILE, labelling each match\n")
+	fmt.Printf("  --tag NAME=GLOB     Tag matches whose name matches GLOB with NAME, \"untagged\" if none does (repeatable)\n")
+	fmt.Printf("  --all-tags          With --tag, give each match every tag that matches it, not just the first\n")
+	fmt.Printf("  --emit-schema       Print the BigQuery schema of the --ndjson records and exit\n")
+	fmt.Printf("  --self-test         Check the glob matcher against built-in cases, without GCS access\n")
+	fmt.Printf("  --explain           Print the server-side queries and client-side matching of the patterns\n")
+	fmt.Printf("                      and exit, without GCS access; as JSON with --json or --ndjson\n")
+	fmt.Printf("  --glob-syntax WHICH Match object patterns with doublestar (default, with ** and {a,b}) or path,\n")
+	fmt.Printf("                      the rules of Go's path.Match\n")
+	fmt.Printf("  --stats             Print scan statistics (prefix, scanned, matched, time) to stderr\n")
+	fmt.Printf("  --metrics-file FILE Append a CSV record of the run's scan statistics to FILE\n")
+	fmt.Printf("  --stat              Fetch and print the full metadata of each matched object\n")
+	fmt.Printf("  --head N            Print the first N lines of each matched object under its URL\n")
+	fmt.Printf("  --line-count        Print each matched object's URL and number of lines, tab-separated\n")
+	fmt.Printf("  --sign-urls DURATION\n")
+	fmt.Printf("                      Print each matched object's URL and a signed download URL valid for DURATION, tab-separated\n")
+	fmt.Printf("  --signing-account EMAIL\n")
+	fmt.Printf("                      With --sign-urls, sign through IAM as the service account EMAIL\n")
+	fmt.Printf("  --emit-iam-policy-bindings\n")
+	fmt.Printf("                      Print who has access to each matched object: its URL, the source of the access\n")
+	fmt.Printf("                      (bucket-iam or object-acl), the role and the member, tab-separated\n")
+	fmt.Printf("  --resolve-links MARK\n")
+	fmt.Printf("                      Print the object each link points at, links marked by content-type=TYPE or metadata=KEY\n")
+	fmt.Printf("  --grep TEXT         Print each line of the matched objects that contains TEXT, prefixed with the object's URL\n")
+	fmt.Printf("  --grep-regex        With --grep, match TEXT as a regular expression\n")
+	fmt.Printf("  --grep-ignore-case  With --grep, ignore case when matching lines\n")
+	fmt.Printf("  --grep-max-bytes SIZE\n")
+	fmt.Printf("                      With --grep, read at most SIZE bytes of each object, such as 64M\n")
+	fmt.Printf("  --batch-stat        Read patterns from stdin and output the full metadata of every match\n")
+	fmt.Printf("  --download-to DIR   Download each matched object into the local directory DIR\n")
+	fmt.Printf("  --csek-key KEY      Read objects encrypted with a customer-supplied key with KEY, in base64\n")
+	fmt.Printf("  --csek-key-file FILE\n")
+	fmt.Printf("                      Like --csek-key, with the base64 key read from FILE\n")
+	fmt.Printf("  --verify            With --download-to, check each file's CRC32C and drop mismatched downloads\n")
+	fmt.Printf("  --resume            With --download-to, keep the partial file of a failed download and continue it\n")
+	fmt.Printf("                      on the next run\n")
+	fmt.Printf("  --layout LAYOUT     Local path layout for downloads: full, relative, or flat (default full)\n")
+	fmt.Printf("  --exec CMD          Run CMD for each match with {} replaced by its URL; end CMD\n")
+	fmt.Printf("                      with '{} +' to pass many URLs to each invocation\n")
+	fmt.Printf("  --concurrency N     Run up to N per-object operations at once (default 8)\n")
+	fmt.Printf("  --pool-metrics      Report active workers, waiting matches and idle time of the per-object pool to stderr\n")
+	fmt.Printf("  --keep-going        Report per-object failures and continue instead of stopping\n")
+	fmt.Printf("  --errors-to FILE    Write a JSON record of each object that failed and pattern that matched nothing to FILE\n")
+	fmt.Printf("  --object-timeout D  Fail a per-object operation that takes longer than D (default: no limit)\n")
+	fmt.Printf("  --context-deadline-from-env VAR\n")
+	fmt.Printf("                      Stop with an error at the deadline in $VAR (a duration or RFC 3339 time)\n")
+	fmt.Printf("  --page-size N       Objects per listing request, 1 to 1000 (default 1000)\n")
+	fmt.Printf("  --shards N          List each prefix as N concurrent queries over name ranges; output is unordered\n")
+	fmt.Printf("  --bucket-concurrency N\n")
+	fmt.Printf("                      List up to N buckets at once; their matches are interleaved (default 1)\n")
+	fmt.Printf("  --prefixes-from FILE\n")
+	fmt.Printf("                      List each prefix in FILE, one per line, and match the pattern against their objects\n")
+	fmt.Printf("  --prefix-concurrency N\n")
+	fmt.Printf("                      List up to N --prefixes-from prefixes at once; output is unordered (default 8)\n")
+	fmt.Printf("  --listing-mode MODE fast, or consistent to resume a failed listing without repeating objects (default fast)\n")
+	fmt.Printf("  --max-scan-cost N   Stop once the listings have needed more than N requests (Class A operations)\n")
+	fmt.Printf("  --list-from-inventory REPORT\n")
+	fmt.Printf("                      Match the names in a Storage Insights CSV inventory report (gs:// or local glob)\n")
+	fmt.Printf("                      instead of listing the bucket\n")
+	fmt.Printf("  --inventory-name-column COL\n")
+	fmt.Printf("                      The report's column of object names: a header or a 1-based number (default name)\n")
+	fmt.Printf("  --assume-exists     Skip the check that each bucket exists before listing it (one request less)\n")
+	fmt.Printf("  --location LOC      List only the buckets in LOC, such as us-central1 or EU (repeatable, comma-separated)\n")
+	fmt.Printf("  --retry-budget D    Total time to spend backing off between retries of failed requests (default 2m)\n")
+	fmt.Printf("  --retry-only-idempotent\n")
+	fmt.Printf("                      Retry only requests that return the same result when repeated; reads of\n")
+	fmt.Printf("                      objects not pinned to a generation fail on the first error\n")
+	fmt.Printf("  --throttle-on-429   Halve the request rate on each 429 response, then ramp back up\n")
+	fmt.Printf("  --two-phase         List names only, then fetch the attributes of name-matched objects one by one\n")
+	fmt.Printf("  --rate-report       Print objects and requests per second and page latencies to stderr at the end\n")
+	fmt.Printf("  --trace             Export OpenTelemetry spans over OTLP, as the OTEL_* variables configure\n")
+	fmt.Printf("  -v, --verbose       Print debug messages, such as each retry and listing query, to stderr\n\n")
 	fmt.Printf("EXAMPLES:\n")
 	fmt.Printf("  %s \"gs://my-bucket/logs/**/*.log\"\n", os.Args[0])
 	fmt.Printf("  %s \"gs://my-bucket/data/*.csv\"\n", os.Args[0])
 	fmt.Printf("  %s \"gs://my-bucket/folder/**/data.txt\"\n", os.Args[0])
+	fmt.Printf("  %s --stat --concurrency 16 \"gs://my-bucket/data/*.csv\"\n", os.Args[0])
+	fmt.Printf("  %s --download-to ./logs --layout relative \"gs://my-bucket/logs/**/*.log\"\n", os.Args[0])
+	fmt.Printf("  %s --exec 'gsutil cp {} ./backup/' \"gs://my-bucket/reports/*.pdf\"\n", os.Args[0])
+	fmt.Printf("  %s --emit-script gsutil-rm \"gs://my-bucket/tmp/**\" > cleanup.sh\n", os.Args[0])
+	fmt.Printf("  %s --ext csv,parquet \"gs://my-bucket/data/\"\n", os.Args[0])
+	fmt.Printf("  %s --wait --timeout 1h \"gs://my-bucket/exports/_SUCCESS\"\n", os.Args[0])
+	fmt.Printf("  %s --as-of 2024-01-01T00:00:00Z \"gs://my-bucket/config/**\"\n", os.Args[0])
 	fmt.Printf("  %s \"gs://my-bucket/\"\n\n", os.Args[0])
 	fmt.Printf("DESCRIPTION:\n")
 	fmt.Printf("  This tool lists objects in Google Cloud Storage that match a given pattern.\n")
@@ -36,129 +789,2120 @@ func showHelp() {
 	fmt.Printf("    gcloud auth application-default login\n")
 }
 
+// showUsage prints the short usage reminder shown on invalid invocations.
+func showUsage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [OPTIONS] \"gs://bucket/object-pattern\"\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "Example: %s \"gs://my-bucket/logs/**/*.log\"\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "Use -h or --help for more information.\n")
+}
+
+// parseFlags parses the command-line arguments into options and returns the
+// remaining positional arguments.
+func parseFlags(args []string) (*options, []string, error) {
+	opts := &options{fresh: time.Hour, stale: 30 * 24 * time.Hour}
+
+	fs := flag.NewFlagSet("gcsls", flag.ContinueOnError)
+	fs.Usage = showUsage
+	fs.IntVar(&opts.concurrency, "concurrency", 8, "")
+	fs.BoolVar(&opts.poolMetrics, "pool-metrics", false, "")
+	fs.BoolVar(&opts.keepGoing, "keep-going", false, "")
+	fs.StringVar(&opts.errorsTo, "errors-to", "", "")
+	fs.DurationVar(&opts.objectTimeout, "object-timeout", 0, "")
+	fs.BoolVar(&opts.stat, "stat", false, "")
+	fs.StringVar(&opts.downloadTo, "download-to", "", "")
+	fs.BoolVar(&opts.verify, "verify", false, "")
+	fs.BoolVar(&opts.resume, "resume", false, "")
+	fs.IntVar(&opts.head, "head", 0, "")
+	fs.BoolVar(&opts.lineCount, "line-count", false, "")
+	fs.DurationVar(&opts.signURLs, "sign-urls", 0, "")
+	fs.StringVar(&opts.signingAccount, "signing-account", "", "")
+	fs.BoolVar(&opts.emitIAMBindings, "emit-iam-policy-bindings", false, "")
+	fs.Func("resolve-links", "", func(v string) (err error) {
+		opts.resolveLinks, err = parseLinkMarker(v)
+		return err
+	})
+	fs.StringVar(&opts.grep, "grep", "", "")
+	fs.BoolVar(&opts.grepRegex, "grep-regex", false, "")
+	fs.BoolVar(&opts.grepIgnoreCase, "grep-ignore-case", false, "")
+	fs.Func("grep-max-bytes", "", func(v string) (err error) {
+		opts.grepMaxBytes, err = parseSize(v)
+		return err
+	})
+	fs.BoolVar(&opts.batchStat, "batch-stat", false, "")
+	fs.Func("csek-key", "", func(v string) (err error) {
+		opts.csekKey, err = parseCSEKKey(v)
+		return err
+	})
+	fs.Func("csek-key-file", "", func(v string) (err error) {
+		opts.csekKey, err = readCSEKKeyFile(v)
+		return err
+	})
+	fs.StringVar(&opts.layout, "layout", layoutFull, "")
+	fs.StringVar(&opts.exec, "exec", "", "")
+	fs.BoolVar(&opts.versions, "versions", false, "")
+	fs.BoolVar(&opts.liveOnly, "live-only", false, "")
+	fs.BoolVar(&opts.softDeleted, "soft-deleted", false, "")
+	fs.BoolVar(&opts.classSummary, "class-summary", false, "")
+	fs.BoolVar(&opts.summaryOnly, "summary-only", false, "")
+	fs.BoolVar(&opts.table, "table", false, "")
+	fs.Func("compliance-report", "", func(v string) error {
+		if v != complianceTable && v != complianceCSV {
+			return fmt.Errorf("must be %s or %s", complianceTable, complianceCSV)
+		}
+		opts.complianceReport = v
+		return nil
+	})
+	fs.IntVar(&opts.tableNameWidth, "table-name-width", 0, "")
+	fs.BoolVar(&opts.colorByAge, "color-by-age", false, "")
+	fs.Func("fresh", "", func(v string) (err error) {
+		opts.fresh, err = parseAge(v)
+		return err
+	})
+	fs.Func("stale", "", func(v string) (err error) {
+		opts.stale, err = parseAge(v)
+		return err
+	})
+	fs.BoolVar(&opts.lifecyclePreview, "honor-lifecycle-preview", false, "")
+	fs.StringVar(&opts.owner, "owner", "", "")
+	fs.StringVar(&opts.includeNames, "include-names", "", "")
+	fs.BoolVar(&opts.long, "l", false, "")
+	fs.BoolVar(&opts.long, "long", false, "")
+	fs.Func("time-format", "", func(v string) (err error) {
+		opts.timeFormat, err = parseTimeFormat(v)
+		return err
+	})
+	fs.BoolVar(&opts.humanTime, "human-time", false, "")
+	fs.BoolVar(&opts.json, "json", false, "")
+	fs.BoolVar(&opts.jsonPretty, "json-pretty", false, "")
+	fs.BoolVar(&opts.ndjson, "ndjson", false, "")
+	fs.BoolVar(&opts.framedNDJSON, "framed-ndjson", false, "")
+	fs.BoolVar(&opts.manifestChecksum, "manifest-checksum", false, "")
+	fs.StringVar(&opts.verifyListing, "verify-listing", "", "")
+	fs.BoolVar(&opts.binary, "binary", false, "")
+	fs.BoolVar(&opts.matchReport, "match-report-json", false, "")
+	fs.BoolVar(&opts.ndjsonErrors, "ndjson-errors", false, "")
+	fs.StringVar(&opts.sink, "sink", "", "")
+	fs.StringVar(&opts.splunkHEC, "splunk-hec", "", "")
+	fs.StringVar(&opts.splunkToken, "splunk-token", "", "")
+	fs.IntVar(&opts.splunkBatch, "splunk-batch", 100, "")
+	fs.DurationVar(&opts.splunkFlushInterval, "splunk-flush-interval", 5*time.Second, "")
+	fs.StringVar(&opts.writeListing, "write-listing", "", "")
+	fs.BoolVar(&opts.gzip, "gzip", false, "")
+	fs.BoolVar(&opts.count, "count", false, "")
+	fs.BoolVar(&opts.selfTest, "self-test", false, "")
+	fs.StringVar(&opts.globSyntax, "glob-syntax", globDoublestar, "")
+	fs.BoolVar(&opts.emitSchema, "emit-schema", false, "")
+	fs.BoolVar(&opts.explain, "explain", false, "")
+	fs.StringVar(&opts.patternFile, "pattern-file", "", "")
+	fs.Func("tag", "", func(v string) error {
+		t, err := parseTag(v)
+		if err != nil {
+			return err
+		}
+		opts.tags = append(opts.tags, t)
+		return nil
+	})
+	fs.BoolVar(&opts.allTags, "all-tags", false, "")
+	fs.BoolVar(&opts.notificationPreview, "bucket-notification-preview", false, "")
+	fs.StringVar(&opts.matchOn, "match-on", matchOnName, "")
+	fs.IntVar(&opts.pageSize, "page-size", 0, "")
+	fs.IntVar(&opts.maxScanCost, "max-scan-cost", 0, "")
+	fs.BoolVar(&opts.assumeExists, "assume-exists", false, "")
+	fs.Func("location", "", func(v string) error {
+		locs, err := parseLocations(v)
+		opts.locations = append(opts.locations, locs...)
+		return err
+	})
+	fs.BoolVar(&opts.exists, "exists", false, "")
+	fs.StringVar(&opts.inventory, "list-from-inventory", "", "")
+	fs.StringVar(&opts.inventoryNameColumn, "inventory-name-column", "name", "")
+	fs.IntVar(&opts.shards, "shards", 0, "")
+	fs.IntVar(&opts.bucketConcurrency, "bucket-concurrency", 1, "")
+	fs.StringVar(&opts.prefixesFrom, "prefixes-from", "", "")
+	fs.IntVar(&opts.prefixConcurrency, "prefix-concurrency", 8, "")
+	fs.StringVar(&opts.listingMode, "listing-mode", listingFast, "")
+	fs.Func("context-deadline-from-env", "", func(v string) error {
+		t, err := deadlineFromEnv(v, time.Now())
+		if err != nil {
+			return err
+		}
+		opts.deadline = t
+		return nil
+	})
+	fs.DurationVar(&opts.retryBudget, "retry-budget", 2*time.Minute, "")
+	fs.BoolVar(&opts.retryOnlyIdempotent, "retry-only-idempotent", false, "")
+	fs.Func("retry-jitter-seed", "", func(v string) (err error) {
+		opts.retryJitterSeedSet = true
+		opts.retryJitterSeed, err = strconv.ParseUint(v, 10, 64)
+		return err
+	})
+	fs.BoolVar(&opts.throttleOn429, "throttle-on-429", false, "")
+	fs.BoolVar(&opts.rateReport, "rate-report", false, "")
+	fs.BoolVar(&opts.twoPhase, "two-phase", false, "")
+	fs.BoolVar(&opts.verbose, "v", false, "")
+	fs.BoolVar(&opts.verbose, "verbose", false, "")
+	fs.BoolVar(&opts.trace, "trace", false, "")
+	fs.BoolVar(&opts.approx, "approx", false, "")
+	fs.Func("histogram", "", func(v string) (err error) {
+		opts.histogramSegment, err = parseHistogram(v)
+		return err
+	})
+	fs.BoolVar(&opts.sizeHistogram, "size-histogram", false, "")
+	opts.sizeBreaks, _ = parseSizeBreaks(defaultSizeBreaks)
+	fs.Func("size-breaks", "", func(v string) (err error) {
+		opts.sizeBreaksSet = true
+		opts.sizeBreaks, err = parseSizeBreaks(v)
+		return err
+	})
+	setTimeHistogram := func(unit string) func(string) error {
+		return func(string) error {
+			if opts.timeHistogram != "" && opts.timeHistogram != unit {
+				return fmt.Errorf("only one of --by-day and --by-hour may be given")
+			}
+			opts.timeHistogram = unit
+			return nil
+		}
+	}
+	fs.BoolFunc("by-day", "", setTimeHistogram(timeBucketDay))
+	fs.BoolFunc("by-hour", "", setTimeHistogram(timeBucketHour))
+	opts.timeField, opts.timeZone = timeFieldUpdated, time.UTC
+	fs.Func("time-field", "", func(v string) error {
+		if v != timeFieldUpdated && v != timeFieldCreated {
+			return fmt.Errorf("expected %s or %s", timeFieldUpdated, timeFieldCreated)
+		}
+		opts.timeField, opts.timeFieldSet = v, true
+		return nil
+	})
+	fs.Func("timezone", "", func(v string) (err error) {
+		opts.timeZone, err = time.LoadLocation(v)
+		return err
+	})
+	fs.IntVar(&opts.minGroup, "min-group", 0, "")
+	fs.BoolVar(&opts.selectMode, "select", false, "")
+	fs.BoolVar(&opts.duplicateBasenames, "duplicate-basenames", false, "")
+	fs.Func("rename", "", func(v string) error {
+		s, err := parseRename(v)
+		if err != nil {
+			return err
+		}
+		opts.rename = s
+		return nil
+	})
+	fs.IntVar(&opts.chunk, "chunk", 0, "")
+	fs.IntVar(&opts.segment, "segment", 0, "")
+	fs.BoolVar(&opts.withGeneration, "with-generation", false, "")
+	fs.BoolVar(&opts.withMetageneration, "with-metageneration", false, "")
+	fs.BoolVar(&opts.withRetention, "with-retention", false, "")
+	fs.BoolVar(&opts.underRetention, "under-retention", false, "")
+	fs.BoolVar(&opts.withCustomTime, "with-custom-time", false, "")
+	fs.Func("custom-time-older-than", "", func(v string) error {
+		t, err := parseCustomTimeCutoff(v, time.Now())
+		if err != nil {
+			return err
+		}
+		opts.customTimeOlderThan = t
+		return nil
+	})
+	fs.StringVar(&opts.uriScheme, "uri-scheme", "gs", "")
+	fs.Int64Var(&opts.sinceGeneration, "since-generation", -1, "")
+	fs.StringVar(&opts.sinceFile, "since-file", "", "")
+	fs.StringVar(&opts.incremental, "incremental", "", "")
+	fs.Func("match-metadata", "", func(v string) error {
+		m, err := parseMetadataMatch(v)
+		if err != nil {
+			return err
+		}
+		opts.metadataMatches = append(opts.metadataMatches, m)
+		return nil
+	})
+	fs.Func("partition", "", func(v string) error {
+		m, err := parsePartitionMatch(v)
+		if err != nil {
+			return err
+		}
+		opts.partitionMatches = append(opts.partitionMatches, m)
+		return nil
+	})
+	fs.Func("partition-spec", "", func(v string) (err error) {
+		opts.partitionSpec, err = parsePartitionSpec(v)
+		return err
+	})
+	fs.Func("extract-date", "", func(v string) (err error) {
+		opts.extractDate, err = newDateExtractor(v)
+		return err
+	})
+	fs.IntVar(&opts.maxNameLength, "max-name-length", 0, "")
+	fs.IntVar(&opts.minSegments, "min-segments", 0, "")
+	fs.IntVar(&opts.maxSegments, "max-segments", 0, "")
+	fs.Func("name-template", "", func(v string) (err error) {
+		opts.nameTemplate, err = parseNameTemplate(v)
+		return err
+	})
+	fs.BoolVar(&opts.invert, "invert", false, "")
+	fs.BoolVar(&opts.stats, "stats", false, "")
+	fs.BoolVar(&opts.wait, "wait", false, "")
+	fs.IntVar(&opts.expectCount, "expect-count", 1, "")
+	fs.DurationVar(&opts.interval, "interval", 30*time.Second, "")
+	fs.DurationVar(&opts.timeout, "timeout", 0, "")
+	fs.BoolVar(&opts.matchOnlyObjects, "match-only-objects", false, "")
+	fs.BoolVar(&opts.matchPrefixes, "match-prefixes", false, "")
+	fs.BoolVar(&opts.matchPrefixObjects, "match-empty-prefix-objects", true, "")
+	fs.StringVar(&opts.emitScript, "emit-script", "", "")
+	fs.BoolVar(&opts.manifest, "manifest", false, "")
+	fs.IntVar(&opts.flushEvery, "flush-every", 1, "")
+	fs.StringVar(&opts.after, "after", "", "")
+	fs.BoolVar(&opts.strictGlob, "strict-glob", false, "")
+	fs.IntVar(&opts.limit, "limit", 0, "")
+	fs.Func("limit-bytes", "", func(v string) (err error) {
+		if opts.limitBytes, err = parseSize(v); err == nil && opts.limitBytes == 0 {
+			err = fmt.Errorf("invalid size %q: must be positive", v)
+		}
+		return err
+	})
+	fs.StringVar(&opts.dedupeBy, "dedupe-by", "", "")
+	fs.StringVar(&opts.sortBy, "sort", "", "")
+	fs.StringVar(&opts.collate, "collate", collateByte, "")
+	fs.BoolVar(&opts.reverse, "reverse", false, "")
+	fs.IntVar(&opts.topLargest, "top-largest", 0, "")
+	fs.IntVar(&opts.topOldest, "top-oldest", 0, "")
+	fs.BoolVar(&opts.newest, "newest", false, "")
+	fs.BoolVar(&opts.oldest, "oldest", false, "")
+	fs.IntVar(&opts.sample, "sample", 0, "")
+	fs.Func("seed", "", func(v string) (err error) {
+		opts.seedSet = true
+		opts.seed, err = strconv.ParseUint(v, 10, 64)
+		return err
+	})
+	fs.BoolVar(&opts.ordered, "ordered", false, "")
+	fs.IntVar(&opts.reorderWindow, "reorder-window", 0, "")
+	fs.StringVar(&opts.metricsFile, "metrics-file", "", "")
+	fs.IntVar(&opts.sortBufferLimit, "sort-buffer-limit", 100000, "")
+	fs.IntVar(&opts.maxBuffered, "max-buffered", 0, "")
+	fs.StringVar(&opts.allowedBuckets, "allowed-buckets", "", "")
+	fs.IntVar(&opts.perBucketLimit, "per-bucket-limit", 0, "")
+	fs.BoolVar(&opts.compare, "compare", false, "")
+	fs.BoolVar(&opts.incompleteUploads, "incomplete-uploads", false, "")
+	fs.BoolVar(&opts.emptyDirs, "match-report-empty-dirs", false, "")
+	fs.StringVar(&opts.findMissing, "find-missing", "", "")
+	fs.BoolVar(&opts.matchStdin, "match-stdin", false, "")
+	fs.StringVar(&opts.compareToListing, "compare-to-listing", "", "")
+	fs.BoolVar(&opts.dirs, "dirs", false, "")
+	fs.IntVar(&opts.depth, "depth", 0, "")
+	fs.BoolVar(&opts.explore, "explore", false, "")
+	fs.BoolVar(&opts.showCommon, "show-common", false, "")
+	fs.BoolVar(&opts.ignoreCase, "ignore-case", false, "")
+	fs.BoolVar(&opts.unanchored, "unanchored", false, "")
+	fs.StringVar(&opts.contains, "contains", "", "")
+	fs.Func("ignore-file", "", func(v string) (err error) {
+		opts.ignore, err = readIgnoreFile(v)
+		return err
+	})
+	fs.BoolVar(&opts.invertMatch, "invert-match", false, "")
+	fs.BoolVar(&opts.requireAllMatch, "require-all-match", false, "")
+	fs.BoolVar(&opts.detectMutation, "detect-mutation", false, "")
+	fs.IntVar(&opts.mutationSample, "mutation-sample", 100, "")
+	fs.Func("assert-content-type", "", func(v string) (err error) {
+		opts.assertContentType, err = parseContentType(v)
+		return err
+	})
+	fs.BoolVar(&opts.urlDecode, "url-decode", false, "")
+	fs.BoolVar(&opts.normalizeSlashes, "normalize-slashes", false, "")
+	fs.BoolVar(&opts.normalizeDisplay, "normalize-display", false, "")
+	fs.Func("ext", "", func(v string) error {
+		exts, err := parseExtensions(v)
+		if err != nil {
+			return err
+		}
+		opts.extensions = exts
+		return nil
+	})
+	fs.StringVar(&opts.checkpoint, "checkpoint", "", "")
+	fs.StringVar(&opts.cacheList, "cache-list", "", "")
+	fs.BoolVar(&opts.useCache, "use-cache", false, "")
+	fs.DurationVar(&opts.cacheTTL, "cache-ttl", time.Hour, "")
+	fs.BoolVar(&opts.cacheRevalidate, "cache-revalidate", false, "")
+	fs.DurationVar(&opts.cacheMaxAge, "cache-max-age", 24*time.Hour, "")
+	fs.BoolVar(&opts.namesOnly, "names-only", false, "")
+	fs.BoolVar(&opts.relative, "relative", false, "")
+	fs.StringVar(&opts.localBase, "local-base", "", "")
+	fs.Func("subst", "", func(v string) error {
+		s, err := parseSubstitution(v)
+		if err != nil {
+			return err
+		}
+		opts.subst = s
+		return nil
+	})
+	fs.Func("as-of", "", func(v string) error {
+		t, err := parseTimestamp(v)
+		if err != nil {
+			return err
+		}
+		opts.asOf = t
+		return nil
+	})
+
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	opts.allowedBucketsEnv = os.Getenv(allowedBucketsEnv)
+	if opts.splunkToken == "" {
+		opts.splunkToken = os.Getenv(splunkTokenEnv)
+	}
+	if opts.jsonPretty {
+		opts.json = true
+	}
+	if opts.localBase != "" {
+		opts.namesOnly, opts.relative = true, true
+	}
+	if opts.throttleOn429 {
+		opts.throttle = newAdaptiveThrottle()
+	}
+	if opts.rateReport {
+		opts.rates = &rateRecorder{}
+	}
+	// The framing is a variant of --ndjson, and every rule of it applies.
+	if opts.framedNDJSON {
+		opts.ndjson = true
+	}
+	if opts.dirs && opts.depth == 0 {
+		opts.depth = 1
+	}
+	if !opts.seedSet {
+		opts.seed = rand.Uint64()
+	}
+	return opts, fs.Args(), nil
+}
+
+// conflictsWithDirListing reports whether any option is set that the
+// directory listings of --dirs and --explore cannot honour: they read no
+// object attributes beyond the size and match no names below the
+// directories.
+func (o *options) conflictsWithDirListing() bool {
+	return o.compare || o.compareToListing != "" || o.matchStdin || o.count || o.histogramSegment > 0 || o.sizeHistogram || o.timeHistogram != "" || o.notificationPreview ||
+		o.incompleteUploads || o.emptyDirs || o.findMissing != "" || o.batchStat || o.twoPhase || o.wait || o.checkpoint != "" || o.cacheList != "" ||
+		o.inventory != "" || o.duplicateBasenames || o.shards > 1 ||
+		o.long || o.table || o.binary || o.json || o.ndjson || o.sink != "" || o.splunkHEC != "" || o.writeListing != "" || o.emitScript != "" || o.manifest || o.selectMode || o.chunk > 0 ||
+		o.namesOnly || o.subst != nil || o.withGeneration || o.withMetageneration || o.withRetention || o.withCustomTime || o.lifecyclePreview ||
+		len(o.perObjectOps()) > 0 ||
+		o.versions || o.softDeleted || !o.asOf.IsZero() || o.owner != "" || o.includeNames != "" || o.sinceGeneration >= 0 || o.sinceFile != "" || o.incremental != "" ||
+		!o.since.IsZero() || len(o.metadataMatches) > 0 || o.underRetention || !o.customTimeOlderThan.IsZero() || len(o.partitionMatches) > 0 || len(o.partitionSpec) > 0 ||
+		o.after != "" || o.maxNameLength > 0 || o.minSegments > 0 || o.maxSegments > 0 || o.nameTemplate != nil || o.ignore != nil || o.contains != "" ||
+		o.dedupeBy != "" || o.sortBy != "" || o.ordered || o.topLargest > 0 || o.topOldest > 0 || o.newest || o.oldest || o.sample > 0 ||
+		o.limit > 0 || o.limitBytes > 0 || o.perBucketLimit > 0 || o.classSummary || o.summaryOnly || o.matchReport || o.metricsFile != "" || o.requireAllMatch || o.assertContentType != "" || o.detectMutation
+}
+
+// namedFlag is a flag by its name and whether it is set, for the groups
+// of flags that validate excludes together.
+type namedFlag struct {
+	name string
+	set  bool
+}
+
+// setFlagNames returns the names of the flags that are set, as typed.
+func setFlagNames(flags ...namedFlag) []string {
+	var names []string
+	for _, f := range flags {
+		if f.set {
+			names = append(names, "--"+f.name)
+		}
+	}
+	return names
+}
+
+// perObjectOps returns the per-object operations that are set: those
+// that act on each match, mostly with a request of their own.
+func (o *options) perObjectOps() []string {
+	return setFlagNames(
+		namedFlag{"stat", o.stat}, namedFlag{"head", o.head > 0}, namedFlag{"line-count", o.lineCount},
+		namedFlag{"resolve-links", o.resolveLinks != nil}, namedFlag{"grep", o.grep != ""}, namedFlag{"download-to", o.downloadTo != ""},
+		namedFlag{"exec", o.exec != ""}, namedFlag{"sign-urls", o.signURLs > 0}, namedFlag{"emit-iam-policy-bindings", o.emitIAMBindings})
+}
+
+// reportModes returns the modes that are set which print a report of
+// their own in place of the listing.
+func (o *options) reportModes() []string {
+	return setFlagNames(
+		namedFlag{"count", o.count}, namedFlag{"histogram", o.histogramSegment > 0}, namedFlag{"size-histogram", o.sizeHistogram},
+		namedFlag{"by-" + o.timeHistogram, o.timeHistogram != ""}, namedFlag{"compare", o.compare}, namedFlag{"compare-to-listing", o.compareToListing != ""},
+		namedFlag{"match-stdin", o.matchStdin}, namedFlag{"bucket-notification-preview", o.notificationPreview},
+		namedFlag{"incomplete-uploads", o.incompleteUploads}, namedFlag{"match-report-empty-dirs", o.emptyDirs}, namedFlag{"dirs", o.dirs},
+		namedFlag{"explore", o.explore}, namedFlag{"find-missing", o.findMissing != ""})
+}
+
+// outputFormats returns the output formats that are set, other than the
+// plain listing.
+func (o *options) outputFormats() []string {
+	return setFlagNames(
+		namedFlag{"long", o.long}, namedFlag{"table", o.table}, namedFlag{"binary", o.binary}, namedFlag{"json", o.json},
+		namedFlag{"ndjson", o.ndjson}, namedFlag{"sink", o.sink != ""}, namedFlag{"splunk-hec", o.splunkHEC != ""},
+		namedFlag{"emit-script", o.emitScript != ""}, namedFlag{"manifest", o.manifest}, namedFlag{"select", o.selectMode},
+		namedFlag{"chunk", o.chunk > 0})
+}
+
+// validate checks the parsed options for invalid values and combinations.
+func (o *options) validate() error {
+	if o.colorByAge && !o.long {
+		return fmt.Errorf("--color-by-age requires -l")
+	}
+	if o.fresh >= o.stale {
+		return fmt.Errorf("--fresh %s must be shorter than --stale %s", o.fresh, o.stale)
+	}
+	if o.objectTimeout < 0 {
+		return fmt.Errorf("invalid --object-timeout %s: must not be negative", o.objectTimeout)
+	}
+	if o.concurrency < 1 {
+		return fmt.Errorf("invalid --concurrency %d: must be at least 1", o.concurrency)
+	}
+	// Which objects a listing returns is decided by exactly one of these
+	// modes: live objects (default), every generation (--versions or
+	// --as-of), or soft-deleted objects (--soft-deleted).
+	if o.softDeleted {
+		if o.versions {
+			return fmt.Errorf("--soft-deleted cannot be combined with --versions")
+		}
+		if !o.asOf.IsZero() {
+			return fmt.Errorf("--soft-deleted cannot be combined with --as-of")
+		}
+		// Soft-deleted objects can only be read after being restored.
+		if o.stat || o.downloadTo != "" || o.head > 0 || o.lineCount || o.resolveLinks != nil || o.grep != "" || o.signURLs > 0 || o.emitIAMBindings {
+			return fmt.Errorf("--soft-deleted cannot be combined with --stat, --head, --line-count, --resolve-links, --grep, --download-to, --sign-urls or --emit-iam-policy-bindings")
+		}
+	}
+	if o.liveOnly && !o.versions {
+		return fmt.Errorf("--live-only requires --versions, whose listing it narrows to the live generations")
+	}
+	if o.liveOnly && !o.asOf.IsZero() {
+		return fmt.Errorf("--live-only cannot be combined with --as-of, which picks the generations live at its own time")
+	}
+	switch o.layout {
+	case layoutFull, layoutRelative, layoutFlat:
+	default:
+		return fmt.Errorf("invalid --layout %q: must be one of full, relative, flat", o.layout)
+	}
+	if o.expectCount < 1 {
+		return fmt.Errorf("invalid --expect-count %d: must be at least 1", o.expectCount)
+	}
+	if o.interval < time.Second {
+		return fmt.Errorf("invalid --interval %s: must be at least 1s", o.interval)
+	}
+	if o.timeout < 0 {
+		return fmt.Errorf("invalid --timeout %s: must not be negative", o.timeout)
+	}
+	if o.timeout > 0 && !o.wait {
+		return fmt.Errorf("--timeout requires --wait")
+	}
+	if o.flushEvery < 0 {
+		return fmt.Errorf("invalid --flush-every %d: must be 0 or more", o.flushEvery)
+	}
+	if o.matchOnlyObjects && !o.matchPrefixObjects {
+		return fmt.Errorf("--match-only-objects matches only the object named like the prefix, " +
+			"which --match-empty-prefix-objects=false excludes")
+	}
+	if o.matchOnlyObjects && o.matchPrefixes {
+		return fmt.Errorf("--match-only-objects cannot be combined with --match-prefixes")
+	}
+	switch o.emitScript {
+	case "", scriptGsutilRm, scriptTFImport:
+	default:
+		return fmt.Errorf("invalid --emit-script %q: must be one of gsutil-rm, tf-import", o.emitScript)
+	}
+	if countTrue(o.long, o.json, o.ndjson, o.binary, o.emitScript != "", o.manifest) > 1 {
+		return fmt.Errorf("only one of -l, --json, --json-pretty, --ndjson, --binary, --emit-script and --manifest may be given")
+	}
+	if o.binary && (o.table || o.selectMode || o.chunk > 0 || o.duplicateBasenames || o.sink != "" || o.splunkHEC != "" ||
+		o.namesOnly || o.subst != nil || o.uriScheme != "gs" || o.withGeneration || o.withMetageneration || o.lifecyclePreview ||
+		len(o.perObjectOps()) > 0) {
+		return fmt.Errorf("--binary records have fixed fields and cannot be combined with --table, --select, --chunk, " +
+			"--duplicate-basenames, --sink, options that change the printed names, --with-metageneration, " +
+			"--honor-lifecycle-preview or per-object operations, whose output would corrupt the stream")
+	}
+	if o.matchReport && !o.ndjson {
+		return fmt.Errorf("--match-report-json requires --ndjson")
+	}
+	if o.ndjsonErrors && (!o.ndjson || !o.batchStat) {
+		return fmt.Errorf("--ndjson-errors requires --ndjson and --batch-stat")
+	}
+	// A probe lists nothing, so nothing else applies to it but -l.
+	if o.exists && (o.table || o.binary || o.json || o.ndjson || o.sink != "" || o.splunkHEC != "" || o.writeListing != "" || o.emitScript != "" || o.manifest ||
+		o.selectMode || o.chunk > 0 || o.complianceReport != "" || o.duplicateBasenames || o.summaryOnly || o.manifestChecksum ||
+		len(o.reportModes()) > 0 || o.batchStat || o.patternFile != "" ||
+		o.prefixesFrom != "" || o.verifyListing != "" || o.selfTest || o.emitSchema ||
+		len(o.perObjectOps()) > 0) {
+		return fmt.Errorf("--exists looks up a single object and cannot be combined with output formats other than -l, " +
+			"other modes or per-object operations")
+	}
+	// The trailer is a line of its own, which only line-based listings
+	// have room for.
+	if o.manifestChecksum && (o.json || o.binary || o.framedNDJSON || o.table || o.selectMode || o.chunk > 0 || o.sink != "" || o.splunkHEC != "" ||
+		o.emitScript != "" || o.complianceReport != "" || o.duplicateBasenames || o.summaryOnly ||
+		len(o.reportModes()) > 0 || o.selfTest || o.emitSchema ||
+		len(o.perObjectOps()) > 0) {
+		return fmt.Errorf("--manifest-checksum ends a plain listing, --manifest or --ndjson and cannot be combined with " +
+			"other output formats, other modes or per-object operations")
+	}
+	if o.verifyListing != "" && (o.manifestChecksum || o.writeListing != "" || len(o.reportModes()) > 0 || o.batchStat || o.patternFile != "" || o.prefixesFrom != "" || o.selfTest || o.emitSchema) {
+		return fmt.Errorf("--verify-listing checks a saved listing and cannot be combined with --manifest-checksum, " +
+			"--write-listing or other modes")
+	}
+	if o.sink != "" {
+		if _, _, err := parseSinkSpec(o.sink); err != nil {
+			return err
+		}
+		if o.long || o.json || o.ndjson || o.emitScript != "" || o.manifest || o.selectMode || o.chunk > 0 {
+			return fmt.Errorf("--sink replaces the output format and cannot be combined with " +
+				"-l, --json, --ndjson, --emit-script, --manifest, --select or --chunk")
+		}
+	}
+	if o.splunkHEC != "" {
+		if _, err := parseSplunkURL(o.splunkHEC); err != nil {
+			return err
+		}
+		if o.splunkToken == "" {
+			return fmt.Errorf("--splunk-hec needs a token from --splunk-token or $%s", splunkTokenEnv)
+		}
+		if o.sink != "" || o.long || o.json || o.ndjson || o.emitScript != "" || o.manifest || o.selectMode || o.chunk > 0 {
+			return fmt.Errorf("--splunk-hec replaces the output format and cannot be combined with " +
+				"--sink, -l, --json, --ndjson, --emit-script, --manifest, --select or --chunk")
+		}
+	}
+	if o.splunkBatch <= 0 {
+		return fmt.Errorf("invalid --splunk-batch %d: must be positive", o.splunkBatch)
+	}
+	if o.splunkFlushInterval <= 0 {
+		return fmt.Errorf("invalid --splunk-flush-interval %s: must be positive", o.splunkFlushInterval)
+	}
+	if o.writeListing != "" {
+		if _, _, err := parseListingURL(o.writeListing); err != nil {
+			return fmt.Errorf("invalid --write-listing: %w", err)
+		}
+		if o.sink != "" || o.splunkHEC != "" || o.selectMode || o.count || o.histogramSegment > 0 || o.sizeHistogram || o.timeHistogram != "" || o.compare || o.matchStdin ||
+			o.notificationPreview || o.incompleteUploads || o.emptyDirs || o.findMissing != "" || o.emitSchema || o.selfTest {
+			return fmt.Errorf("--write-listing uploads the listing and cannot be combined with --sink, --select " +
+				"or modes with their own report")
+		}
+	}
+	// Only the listing itself goes through the compressed writer; the
+	// other modes print their reports straight to stdout.
+	if gzipOutput(o) && (o.sink != "" || o.splunkHEC != "" || o.selectMode || o.exists || len(o.reportModes()) > 0 || o.verifyListing != "" || o.emitSchema || o.selfTest) {
+		return fmt.Errorf("--gzip compresses the listing and cannot be combined with --sink, --splunk-hec, --select " +
+			"or modes with their own report")
+	}
+	if o.tableNameWidth < 0 {
+		return fmt.Errorf("invalid --table-name-width %d: must not be negative", o.tableNameWidth)
+	}
+	if o.tableNameWidth > 0 && !o.table {
+		return fmt.Errorf("--table-name-width requires --table")
+	}
+	if o.table && (o.long || o.json || o.ndjson || o.sink != "" || o.splunkHEC != "" || o.emitScript != "" || o.manifest || o.selectMode ||
+		o.chunk > 0 || o.duplicateBasenames) {
+		return fmt.Errorf("--table cannot be combined with -l, --json, --ndjson, --sink, --emit-script, --manifest, " +
+			"--select, --chunk or --duplicate-basenames")
+	}
+	if o.complianceReport != "" && (len(o.outputFormats()) > 0 || o.duplicateBasenames || o.namesOnly || o.subst != nil || o.withGeneration || o.withMetageneration || o.withRetention || o.withCustomTime || o.lifecyclePreview ||
+		len(o.tags) > 0 || o.patternFile != "" || len(o.reportModes()) > 0 ||
+		len(o.perObjectOps()) > 0) {
+		return fmt.Errorf("--compliance-report is an output format of its own and cannot be combined with other " +
+			"output formats or annotations, other modes or per-object operations")
+	}
+	if o.localBase != "" && o.subst != nil {
+		return fmt.Errorf("--local-base cannot be combined with --subst")
+	}
+	if o.subst != nil && (o.json || o.ndjson || o.sink != "" || o.splunkHEC != "" || o.emitScript != "" || o.manifest || o.withGeneration) {
+		return fmt.Errorf("--subst only rewrites printed names and cannot be combined with " +
+			"--json, --ndjson, --sink, --emit-script, --manifest or --with-generation")
+	}
+	if !validScheme(o.uriScheme) {
+		return fmt.Errorf("invalid --uri-scheme %q: must be a URI scheme such as s3, without \"://\"", o.uriScheme)
+	}
+	if o.uriScheme != "gs" && (o.json || o.ndjson || o.sink != "" || o.splunkHEC != "" || o.emitScript != "" || o.manifest || o.namesOnly || o.subst != nil) {
+		return fmt.Errorf("--uri-scheme only changes printed URLs and cannot be combined with " +
+			"--json, --ndjson, --sink, --emit-script, --manifest, --names-only or --subst")
+	}
+	if o.relative && !o.namesOnly {
+		return fmt.Errorf("--relative requires --names-only")
+	}
+	if o.withMetageneration && (o.emitScript != "" || o.manifest || o.selectMode || o.namesOnly || o.duplicateBasenames) {
+		return fmt.Errorf("--with-metageneration cannot be combined with --emit-script, --manifest, --select, " +
+			"--names-only or --duplicate-basenames, whose output has no room for it")
+	}
+	if o.withRetention && (o.emitScript != "" || o.manifest || o.selectMode || o.namesOnly || o.duplicateBasenames || o.binary ||
+		o.count || o.histogramSegment > 0 || o.sizeHistogram || o.timeHistogram != "" || o.compare || o.matchStdin || o.incompleteUploads || o.emptyDirs || o.findMissing != "") {
+		return fmt.Errorf("--with-retention cannot be combined with --emit-script, --manifest, --select, --names-only, " +
+			"--duplicate-basenames, --binary or modes with their own report, whose output has no room for it")
+	}
+	// Inventory reports have no retention column.
+	if o.underRetention && (o.matchStdin || o.incompleteUploads || o.findMissing != "" || o.inventory != "") {
+		return fmt.Errorf("--under-retention needs each object's metadata and cannot be combined with --match-stdin, " +
+			"--incomplete-uploads, --find-missing or --list-from-inventory")
+	}
+	if o.withCustomTime && (o.emitScript != "" || o.manifest || o.selectMode || o.namesOnly || o.duplicateBasenames || o.binary ||
+		o.count || o.histogramSegment > 0 || o.sizeHistogram || o.timeHistogram != "" || o.compare || o.matchStdin || o.incompleteUploads || o.emptyDirs || o.findMissing != "") {
+		return fmt.Errorf("--with-custom-time cannot be combined with --emit-script, --manifest, --select, --names-only, " +
+			"--duplicate-basenames, --binary or modes with their own report, whose output has no room for it")
+	}
+	// Nor have they a custom time column.
+	if !o.customTimeOlderThan.IsZero() && (o.matchStdin || o.incompleteUploads || o.findMissing != "" || o.inventory != "") {
+		return fmt.Errorf("--custom-time-older-than needs each object's metadata and cannot be combined with --match-stdin, " +
+			"--incomplete-uploads, --find-missing or --list-from-inventory")
+	}
+	if o.namesOnly && (o.json || o.ndjson || o.sink != "" || o.splunkHEC != "" || o.emitScript != "" || o.manifest || o.withGeneration) {
+		return fmt.Errorf("--names-only cannot be combined with --json, --ndjson, --sink, --emit-script, --manifest or --with-generation")
+	}
+	if o.relative && o.matchOn == matchOnURL {
+		return fmt.Errorf("--relative cannot be combined with --match-on url")
+	}
+	// A match may lie outside the directory of the pattern's literal part.
+	if o.relative && o.unanchored {
+		return fmt.Errorf("--relative cannot be combined with --unanchored")
+	}
+	switch o.matchOn {
+	case matchOnName, matchOnURL:
+	default:
+		return fmt.Errorf("invalid --match-on %q: must be one of name, url", o.matchOn)
+	}
+	if o.matchOn == matchOnURL && o.compare {
+		return fmt.Errorf("--compare matches names relative to each pattern and cannot be combined with --match-on url")
+	}
+	if o.pageSize < 0 || o.pageSize > maxPageSize {
+		return fmt.Errorf("invalid --page-size %d: must be between 1 and %d", o.pageSize, maxPageSize)
+	}
+	if o.shards < 0 || o.shards > maxShards {
+		return fmt.Errorf("invalid --shards %d: must be between 1 and %d", o.shards, maxShards)
+	}
+	if o.errorsTo != "" && (len(o.reportModes()) > 0 || o.selfTest || o.emitSchema) {
+		return fmt.Errorf("--errors-to records the failures of a listing and cannot be combined with other modes")
+	}
+	if len(o.locations) > 0 && (len(o.reportModes()) > 0 || o.verifyListing != "" || o.exists) {
+		return fmt.Errorf("--location filters the buckets of a listing and cannot be combined with other modes")
+	}
+	if o.bucketConcurrency < 1 {
+		return fmt.Errorf("invalid --bucket-concurrency %d: must be at least 1", o.bucketConcurrency)
+	}
+	// The checkpoint and the cache follow one scan at a time, and an
+	// inventory report is read rather than listed.
+	if o.bucketConcurrency > 1 && (o.ordered || o.checkpoint != "" || o.cacheList != "" || o.inventory != "") {
+		return fmt.Errorf("--bucket-concurrency interleaves the buckets' listings and cannot be combined with " +
+			"--ordered, --checkpoint, --cache-list or --list-from-inventory")
+	}
+	if o.prefixConcurrency < 1 {
+		return fmt.Errorf("invalid --prefix-concurrency %d: must be at least 1", o.prefixConcurrency)
+	}
+	// The prefixes replace the listing of the pattern's prefix, which the
+	// modes with their own listing, a cache and an inventory report do not
+	// go through.
+	if o.prefixesFrom != "" && (o.shards > 1 || o.ordered || o.checkpoint != "" || !o.asOf.IsZero() || o.cacheList != "" || o.inventory != "" ||
+		o.patternFile != "" || o.batchStat || o.compare || o.matchStdin || o.notificationPreview || o.incompleteUploads || o.emptyDirs ||
+		o.dirs || o.findMissing != "") {
+		return fmt.Errorf("--prefixes-from lists out of name order and cannot be combined with --shards, --ordered, --checkpoint, " +
+			"--as-of, --cache-list, --list-from-inventory, --pattern-file or other modes")
+	}
+	if o.listingMode != listingFast && o.listingMode != listingConsistent {
+		return fmt.Errorf("invalid --listing-mode %q: must be one of fast, consistent", o.listingMode)
+	}
+	if o.listingMode == listingConsistent && o.inventory != "" {
+		return fmt.Errorf("--list-from-inventory reads a report rather than listing the bucket and cannot be combined with --listing-mode consistent")
+	}
+	if o.shards > 1 && (o.ordered || o.checkpoint != "" || !o.asOf.IsZero()) {
+		return fmt.Errorf("--shards lists out of name order and cannot be combined with --ordered, --checkpoint or --as-of")
+	}
+	// The checkpoint passes an object once it is listed, so nothing may
+	// still be waiting to handle it when the run stops.
+	if o.checkpoint != "" && (o.sortBy != "" || o.topLargest > 0 || o.topOldest > 0 || o.newest || o.oldest || o.sample > 0 ||
+		len(o.perObjectOps()) > 0) {
+		return fmt.Errorf("--checkpoint records objects as they are listed and cannot be combined with --sort, --top-largest, " +
+			"--top-oldest, --newest, --oldest, --sample or per-object operations, which finish with them later")
+	}
+	if o.useCache && o.cacheList == "" {
+		return fmt.Errorf("--use-cache requires --cache-list")
+	}
+	if o.cacheTTL <= 0 {
+		return fmt.Errorf("invalid --cache-ttl %s: must be positive", o.cacheTTL)
+	}
+	if o.cacheMaxAge <= 0 {
+		return fmt.Errorf("invalid --cache-max-age %s: must be positive", o.cacheMaxAge)
+	}
+	if o.cacheRevalidate && o.cacheList == "" {
+		return fmt.Errorf("--cache-revalidate requires --cache-list")
+	}
+	if o.cacheList != "" && (o.compare || o.wait || o.approx || o.matchStdin || o.incompleteUploads ||
+		o.notificationPreview || o.selfTest || o.emitSchema) {
+		return fmt.Errorf("--cache-list cannot be combined with --compare, --wait, --approx, --match-stdin, " +
+			"--incomplete-uploads, --bucket-notification-preview, --self-test or --emit-schema")
+	}
+	if o.inventoryNameColumn != "name" && o.inventory == "" {
+		return fmt.Errorf("--inventory-name-column requires --list-from-inventory")
+	}
+	if o.inventory != "" && (o.versions || o.softDeleted || !o.asOf.IsZero() || o.shards > 1 || o.checkpoint != "" ||
+		o.cacheList != "" || o.wait || o.compare || o.approx || o.emptyDirs || o.matchStdin || o.incompleteUploads ||
+		o.notificationPreview || o.owner != "" || len(o.metadataMatches) > 0) {
+		return fmt.Errorf("--list-from-inventory only knows the live objects and the columns of the report; it cannot be " +
+			"combined with --versions, --soft-deleted, --as-of, --shards, --checkpoint, --cache-list, --wait, --compare, " +
+			"--approx, --match-report-empty-dirs, --match-stdin, --incomplete-uploads, --bucket-notification-preview, " +
+			"--owner or --match-metadata")
+	}
+	if o.maxScanCost < 0 {
+		return fmt.Errorf("invalid --max-scan-cost %d: must not be negative", o.maxScanCost)
+	}
+	if o.retryBudget < 0 {
+		return fmt.Errorf("invalid --retry-budget %s: must not be negative", o.retryBudget)
+	}
+	if o.approx && !o.count {
+		return fmt.Errorf("--approx requires --count")
+	}
+	if o.minGroup < 0 {
+		return fmt.Errorf("invalid --min-group %d: must not be negative", o.minGroup)
+	}
+	if o.minGroup > 0 && o.histogramSegment == 0 && !o.duplicateBasenames {
+		return fmt.Errorf("--min-group requires --histogram or --duplicate-basenames")
+	}
+	if o.count && o.histogramSegment > 0 {
+		return fmt.Errorf("only one of --count and --histogram may be given")
+	}
+	if o.sizeHistogram && (o.count || o.histogramSegment > 0) {
+		return fmt.Errorf("--size-histogram cannot be combined with --count or --histogram")
+	}
+	if o.sizeBreaksSet && !o.sizeHistogram {
+		return fmt.Errorf("--size-breaks requires --size-histogram")
+	}
+	if o.timeHistogram != "" && (o.count || o.histogramSegment > 0 || o.sizeHistogram) {
+		return fmt.Errorf("--by-day and --by-hour cannot be combined with --count, --histogram or --size-histogram")
+	}
+	if (o.timeFieldSet || o.timeZone != time.UTC) && o.timeHistogram == "" {
+		return fmt.Errorf("--time-field and --timezone require --by-day or --by-hour")
+	}
+	if (o.count || o.histogramSegment > 0 || o.sizeHistogram || o.timeHistogram != "") && (len(o.outputFormats()) > 0 || o.matchReport || o.classSummary || o.summaryOnly || o.withGeneration || o.withMetageneration ||
+		len(o.perObjectOps()) > 0 ||
+		o.compare || o.matchStdin || o.wait || o.checkpoint != "" ||
+		o.sortBy != "" || o.limit > 0 || o.limitBytes > 0 || o.perBucketLimit > 0) {
+		return fmt.Errorf("--count, --histogram, --size-histogram, --by-day and --by-hour only print counts and cannot be combined with output formats, " +
+			"per-object operations, --compare, --match-stdin, --wait, --checkpoint, --sort or limits")
+	}
+	if o.normalizeDisplay && !o.normalizeSlashes {
+		return fmt.Errorf("--normalize-display requires --normalize-slashes")
+	}
+	// These list directories with a "/" delimiter or look up names, which
+	// both take the slashes as stored.
+	if o.normalizeSlashes && (o.dirs || o.emptyDirs || o.approx || o.findMissing != "" || o.exists) {
+		return fmt.Errorf("--normalize-slashes cannot be combined with --dirs, --match-report-empty-dirs, --approx, " +
+			"--find-missing or --exists, which take the slashes in names as stored")
+	}
+	// A match left unlisted would be skipped by the next run all the same.
+	if o.incremental != "" && (o.sinceFile != "" || o.matchStdin || o.incompleteUploads || o.exists || o.count || o.histogramSegment > 0 ||
+		o.sizeHistogram || o.timeHistogram != "" || o.compare || o.limit > 0 || o.limitBytes > 0 || o.perBucketLimit > 0 || o.topLargest > 0 || o.topOldest > 0 || o.newest || o.oldest || o.sample > 0) {
+		return fmt.Errorf("--incremental cannot be combined with --since-file, modes with their own report, " +
+			"or --limit, --limit-bytes, --per-bucket-limit, --top-largest, --top-oldest, --newest, --oldest and --sample, which leave matches unlisted")
+	}
+	if o.approx && o.dedupeBy != "" {
+		return fmt.Errorf("--approx cannot be combined with --dedupe-by, which needs every match")
+	}
+	if o.approx && o.includeNames != "" {
+		return fmt.Errorf("--approx cannot be combined with --include-names, which needs every match to tell which names are missing")
+	}
+	if o.owner != "" && !doublestar.ValidatePattern(o.owner) {
+		return fmt.Errorf("invalid --owner pattern %q", o.owner)
+	}
+	if o.depth < 0 {
+		return fmt.Errorf("invalid --depth %d: must be positive", o.depth)
+	}
+	if o.depth > 0 && !o.dirs {
+		return fmt.Errorf("--depth requires --dirs")
+	}
+	if o.dirs && o.conflictsWithDirListing() {
+		return fmt.Errorf("--dirs prints the directory tree and cannot be combined with other modes, " +
+			"output formats, per-object operations, object filters, sorting or limits")
+	}
+	if o.explore && (o.dirs || o.conflictsWithDirListing()) {
+		return fmt.Errorf("--explore browses one directory at a time and cannot be combined with other modes, " +
+			"output formats, per-object operations, object filters, sorting or limits")
+	}
+	if o.showCommon && !o.compare && o.compareToListing == "" {
+		return fmt.Errorf("--show-common requires --compare or --compare-to-listing")
+	}
+	// The prior listing records no attributes to filter on, so its objects
+	// would all seem removed.
+	if o.compareToListing != "" && (o.compare || o.matchStdin || o.count || o.histogramSegment > 0 || o.sizeHistogram || o.timeHistogram != "" || o.notificationPreview ||
+		o.incompleteUploads || o.emptyDirs || o.findMissing != "" || o.batchStat || o.twoPhase || o.wait || o.checkpoint != "" || o.duplicateBasenames ||
+		o.long || o.table || o.binary || o.json || o.sink != "" || o.splunkHEC != "" || o.writeListing != "" || o.emitScript != "" || o.manifest || o.selectMode || o.chunk > 0 ||
+		o.namesOnly || o.subst != nil || o.withGeneration || o.withMetageneration || o.withRetention || o.withCustomTime || o.lifecyclePreview ||
+		len(o.perObjectOps()) > 0 ||
+		o.versions || o.softDeleted || !o.asOf.IsZero() || o.owner != "" || o.includeNames != "" || o.sinceGeneration >= 0 || o.sinceFile != "" || o.incremental != "" ||
+		!o.since.IsZero() || len(o.metadataMatches) > 0 || o.underRetention || !o.customTimeOlderThan.IsZero() || len(o.partitionSpec) > 0 ||
+		o.dedupeBy != "" || o.sortBy != "" || o.ordered || o.topLargest > 0 || o.topOldest > 0 || o.newest || o.oldest || o.sample > 0 ||
+		o.limit > 0 || o.limitBytes > 0 || o.perBucketLimit > 0 || o.classSummary || o.summaryOnly || o.matchReport || o.metricsFile != "") {
+		return fmt.Errorf("--compare-to-listing prints its own report and cannot be combined with other modes, " +
+			"output formats other than --ndjson, per-object operations, attribute filters, sorting or limits")
+	}
+	if o.duplicateBasenames && (o.long || o.json || o.ndjson || o.sink != "" || o.splunkHEC != "" || o.emitScript != "" || o.manifest ||
+		o.selectMode || o.chunk > 0 || o.dedupeBy != "" || o.count || o.histogramSegment > 0 || o.sizeHistogram || o.timeHistogram != "" || o.compare ||
+		o.matchStdin || o.batchStat || o.incompleteUploads || o.notificationPreview ||
+		len(o.perObjectOps()) > 0) {
+		return fmt.Errorf("--duplicate-basenames prints its own report and cannot be combined with output formats, " +
+			"--dedupe-by, other modes or per-object operations")
+	}
+	if o.rename != nil && (len(o.outputFormats()) > 0 || o.duplicateBasenames || o.summaryOnly || o.complianceReport != "" || o.namesOnly || o.subst != nil ||
+		o.count || o.histogramSegment > 0 || o.sizeHistogram || o.timeHistogram != "" || o.compare || o.compareToListing != "" || o.matchStdin || o.batchStat ||
+		o.incompleteUploads || o.notificationPreview || o.emptyDirs || o.dirs || o.explore || o.findMissing != "" || o.exists ||
+		len(o.perObjectOps()) > 0) {
+		return fmt.Errorf("--rename prints its own plan and cannot be combined with output formats, " +
+			"options that change the printed names, other modes or per-object operations")
+	}
+	if o.compare && (len(o.outputFormats()) > 0 ||
+		len(o.perObjectOps()) > 0 || o.wait || o.checkpoint != "" || len(o.partitionSpec) > 0) {
+		return fmt.Errorf("--compare only prints names and cannot be combined with output formats, " +
+			"per-object operations, --wait, --checkpoint or --partition-spec")
+	}
+	if len(o.partitionSpec) > 0 {
+		for _, m := range o.partitionMatches {
+			if !slices.Contains(o.partitionSpec, m.key) {
+				return fmt.Errorf("--partition key %q is not one of the --partition-spec keys %s", m.key, strings.Join(o.partitionSpec, ","))
+			}
+		}
+	}
+	if o.maxNameLength < 0 || o.minSegments < 0 || o.maxSegments < 0 {
+		return fmt.Errorf("--max-name-length, --min-segments and --max-segments must not be negative")
+	}
+	if o.maxSegments > 0 && o.minSegments > o.maxSegments {
+		return fmt.Errorf("--min-segments %d is greater than --max-segments %d", o.minSegments, o.maxSegments)
+	}
+	if o.invert && o.maxNameLength == 0 && o.minSegments == 0 && o.maxSegments == 0 && o.nameTemplate == nil {
+		return fmt.Errorf("--invert requires --max-name-length, --min-segments, --max-segments or --name-template")
+	}
+	if o.head < 0 {
+		return fmt.Errorf("invalid --head %d: must not be negative", o.head)
+	}
+	if o.matchStdin && (o.compare || o.wait || o.checkpoint != "" || o.after != "" ||
+		len(o.outputFormats()) > 0 || o.withGeneration || o.withMetageneration ||
+		len(o.perObjectOps()) > 0 ||
+		o.versions || o.softDeleted || !o.asOf.IsZero() || o.owner != "" || o.includeNames != "" || o.sinceGeneration >= 0 || o.sinceFile != "" || o.incremental != "" ||
+		len(o.metadataMatches) > 0 || o.classSummary || o.summaryOnly || o.stats) {
+		return fmt.Errorf("--match-stdin only matches names and cannot be combined with options " +
+			"that need object metadata or access to the bucket")
+	}
+	if err := validateAllowlist(allowedBucketsEnv, o.allowedBucketsEnv); err != nil {
+		return err
+	}
+	if err := validateAllowlist("--allowed-buckets", o.allowedBuckets); err != nil {
+		return err
+	}
+	if o.sortBy != "" {
+		if err := validateSortKey(o.sortBy); err != nil {
+			return err
+		}
+		if o.sortBy == sortDate && o.extractDate == nil {
+			return fmt.Errorf("--sort date requires --extract-date")
+		}
+	}
+	if o.sortBufferLimit < 0 {
+		return fmt.Errorf("invalid --sort-buffer-limit %d: must not be negative", o.sortBufferLimit)
+	}
+	if o.grep != "" {
+		if _, err := newGrepPattern(o); err != nil {
+			return err
+		}
+	}
+	if (o.grepRegex || o.grepIgnoreCase || o.grepMaxBytes > 0) && o.grep == "" {
+		return fmt.Errorf("--grep-regex, --grep-ignore-case and --grep-max-bytes require --grep")
+	}
+	if o.verify && o.downloadTo == "" {
+		return fmt.Errorf("--verify requires --download-to")
+	}
+	if o.resume && o.downloadTo == "" {
+		return fmt.Errorf("--resume requires --download-to")
+	}
+	if o.csekKey != nil && o.head == 0 && !o.lineCount && o.resolveLinks == nil && o.grep == "" && o.downloadTo == "" {
+		return fmt.Errorf("--csek-key is only used to read object contents and requires --head, --line-count, --resolve-links, --grep or --download-to")
+	}
+	if o.maxBuffered < 0 {
+		return fmt.Errorf("invalid --max-buffered %d: must not be negative", o.maxBuffered)
+	}
+	if o.reverse && o.sortBy == "" {
+		return fmt.Errorf("--reverse requires --sort")
+	}
+	switch o.collate {
+	case collateByte, collateCaseInsensitive, collateLocale:
+	default:
+		return fmt.Errorf("invalid --collate %q: must be one of byte, case-insensitive, locale", o.collate)
+	}
+	if o.collate != collateByte && o.sortBy == "" {
+		return fmt.Errorf("--collate requires --sort")
+	}
+	if o.topLargest < 0 || o.topOldest < 0 {
+		return fmt.Errorf("--top-largest and --top-oldest must not be negative")
+	}
+	if o.topLargest > 0 || o.topOldest > 0 || o.newest || o.oldest {
+		if countTrue(o.topLargest > 0, o.topOldest > 0, o.newest, o.oldest) > 1 {
+			return fmt.Errorf("only one of --top-largest, --top-oldest, --newest and --oldest may be given")
+		}
+		if o.sortBy != "" || o.ordered || o.count || o.histogramSegment > 0 || o.sizeHistogram || o.timeHistogram != "" || o.compare || o.matchStdin || o.incompleteUploads || o.notificationPreview {
+			return fmt.Errorf("--top-largest, --top-oldest, --newest and --oldest choose their own order and cannot be combined with " +
+				"--sort, --ordered, --count, --histogram, --size-histogram, --compare, --match-stdin, --incomplete-uploads or --bucket-notification-preview")
+		}
+	}
+	if o.sample < 0 {
+		return fmt.Errorf("invalid --sample %d: must not be negative", o.sample)
+	}
+	if o.seedSet && o.sample == 0 {
+		return fmt.Errorf("--seed requires --sample")
+	}
+	if o.sample > 0 && (o.sortBy != "" || o.topLargest > 0 || o.topOldest > 0 || o.newest || o.oldest || o.count || o.histogramSegment > 0 || o.sizeHistogram || o.timeHistogram != "" ||
+		o.compare || o.matchStdin || o.incompleteUploads || o.notificationPreview) {
+		return fmt.Errorf("--sample cannot be combined with --sort, --top-largest, --top-oldest, --newest, --oldest, --count, --histogram, " +
+			"--size-histogram, --compare, --match-stdin, --incomplete-uploads or --bucket-notification-preview")
+	}
+	if o.ordered && o.sortBy != "" {
+		return fmt.Errorf("--ordered cannot be combined with --sort, which already orders the whole listing")
+	}
+	if o.reorderWindow < 0 {
+		return fmt.Errorf("invalid --reorder-window %d: must not be negative", o.reorderWindow)
+	}
+	if o.reorderWindow > 0 && !o.ordered {
+		return fmt.Errorf("--reorder-window requires --ordered")
+	}
+	switch o.dedupeBy {
+	case "", dedupeBasename, dedupeCRC32C, dedupeMD5:
+	default:
+		return fmt.Errorf("invalid --dedupe-by %q: must be one of basename, crc32c, md5", o.dedupeBy)
+	}
+	if o.limit < 0 {
+		return fmt.Errorf("invalid --limit %d: must not be negative", o.limit)
+	}
+	// Names read from stdin come without sizes.
+	if o.limitBytes > 0 && o.matchStdin {
+		return fmt.Errorf("--limit-bytes needs the size of each match and cannot be combined with --match-stdin")
+	}
+	if o.perBucketLimit < 0 {
+		return fmt.Errorf("invalid --per-bucket-limit %d: must not be negative", o.perBucketLimit)
+	}
+	if o.chunk < 0 {
+		return fmt.Errorf("invalid --chunk %d: must not be negative", o.chunk)
+	}
+	if o.chunk > 0 && (o.emitScript != "" || o.manifest || o.selectMode || o.ndjson || o.jsonPretty) {
+		return fmt.Errorf("--chunk cannot be combined with --emit-script, --manifest, --select, --ndjson or --json-pretty")
+	}
+	if o.segment < 0 {
+		return fmt.Errorf("invalid --segment %d: must not be negative", o.segment)
+	}
+	if o.segment > 0 && (o.chunk > 0 || o.emitScript != "" || o.manifest || o.selectMode || o.ndjson || o.jsonPretty) {
+		return fmt.Errorf("--segment cannot be combined with --chunk, --emit-script, --manifest, --select, --ndjson or --json-pretty")
+	}
+	// The groups rely on the order GCS lists names in.
+	if o.segment > 0 && (o.sortBy != "" || o.topLargest > 0 || o.topOldest > 0 || o.newest || o.oldest || o.sample > 0 || o.shards > 1 || o.prefixesFrom != "" ||
+		o.bucketConcurrency > 1 || o.inventory != "") {
+		return fmt.Errorf("--segment needs the matches in listing order and cannot be combined with --sort, --top-largest, " +
+			"--top-oldest, --newest, --oldest, --sample, --shards, --prefixes-from, --bucket-concurrency or --list-from-inventory")
+	}
+	if o.incompleteUploads && (o.compare || o.matchStdin || o.count || o.histogramSegment > 0 || o.sizeHistogram || o.timeHistogram != "" || o.notificationPreview || o.wait ||
+		o.checkpoint != "" || o.after != "" || o.patternFile != "" ||
+		len(o.outputFormats()) > 0 ||
+		o.namesOnly || o.subst != nil || o.withGeneration || o.withMetageneration || o.lifecyclePreview ||
+		len(o.perObjectOps()) > 0 ||
+		o.versions || o.softDeleted || !o.asOf.IsZero() || o.owner != "" || o.includeNames != "" || o.sinceGeneration >= 0 || o.sinceFile != "" || o.incremental != "" ||
+		len(o.metadataMatches) > 0 || o.maxNameLength > 0 || o.minSegments > 0 || o.maxSegments > 0 || o.nameTemplate != nil || o.dedupeBy != "" || o.sortBy != "" ||
+		len(o.partitionMatches) > 0 || len(o.partitionSpec) > 0 ||
+		o.ordered || o.limit > 0 || o.limitBytes > 0 || o.perBucketLimit > 0 || o.classSummary || o.summaryOnly || o.stats || o.metricsFile != "" || o.requireAllMatch || o.assertContentType != "" || o.detectMutation) {
+		return fmt.Errorf("--incomplete-uploads prints its own listing and cannot be combined with other modes, " +
+			"output formats, per-object operations, object filters, sorting or limits")
+	}
+	if o.emptyDirs && (o.compare || o.matchStdin || o.count || o.histogramSegment > 0 || o.sizeHistogram || o.timeHistogram != "" || o.notificationPreview || o.incompleteUploads ||
+		o.batchStat || o.wait || o.checkpoint != "" || o.cacheList != "" || o.duplicateBasenames ||
+		len(o.outputFormats()) > 0 ||
+		o.namesOnly || o.subst != nil || o.withGeneration || o.withMetageneration || o.lifecyclePreview ||
+		len(o.perObjectOps()) > 0 ||
+		o.includeNames != "" || o.dedupeBy != "" || o.sortBy != "" || o.ordered || o.topLargest > 0 || o.topOldest > 0 || o.newest || o.oldest || o.sample > 0 ||
+		o.limit > 0 || o.limitBytes > 0 || o.perBucketLimit > 0 || o.classSummary || o.summaryOnly || o.metricsFile != "" || o.requireAllMatch || o.assertContentType != "" || o.detectMutation) {
+		return fmt.Errorf("--match-report-empty-dirs prints its own report and cannot be combined with other modes, " +
+			"output formats, per-object operations, --include-names, --dedupe-by, sorting or limits")
+	}
+	if o.findMissing != "" && (o.compare || o.matchStdin || o.count || o.histogramSegment > 0 || o.sizeHistogram || o.timeHistogram != "" || o.notificationPreview || o.incompleteUploads ||
+		o.emptyDirs || o.batchStat || o.wait || o.checkpoint != "" || o.cacheList != "" || o.duplicateBasenames || o.patternFile != "" || o.inventory != "" ||
+		len(o.outputFormats()) > 0 ||
+		o.namesOnly || o.subst != nil || o.withGeneration || o.withMetageneration || o.lifecyclePreview ||
+		len(o.perObjectOps()) > 0 ||
+		o.versions || o.softDeleted || !o.asOf.IsZero() || o.owner != "" || o.includeNames != "" || o.sinceGeneration >= 0 || o.sinceFile != "" || o.incremental != "" ||
+		len(o.metadataMatches) > 0 || len(o.partitionMatches) > 0 || len(o.partitionSpec) > 0 ||
+		o.dedupeBy != "" || o.sortBy != "" || o.ordered || o.limit > 0 || o.limitBytes > 0 || o.perBucketLimit > 0 || o.classSummary || o.summaryOnly || o.metricsFile != "" || o.requireAllMatch || o.assertContentType != "" || o.detectMutation) {
+		return fmt.Errorf("--find-missing prints its own report and cannot be combined with other modes, " +
+			"output formats, per-object operations, object filters, sorting or limits")
+	}
+	if o.globSyntax != globDoublestar && o.globSyntax != globPath {
+		return fmt.Errorf("invalid --glob-syntax %q: must be %s or %s", o.globSyntax, globDoublestar, globPath)
+	}
+	if o.twoPhase && (o.owner != "" || o.softDeleted || !o.asOf.IsZero() || o.cacheList != "" || o.inventory != "" ||
+		o.batchStat || o.matchStdin || o.incompleteUploads || o.findMissing != "") {
+		return fmt.Errorf("--two-phase cannot be combined with --owner, which only the listing returns, --soft-deleted, --as-of, " +
+			"--cache-list, --list-from-inventory, --batch-stat, --match-stdin, --incomplete-uploads or --find-missing")
+	}
+	if o.poolMetrics && len(o.perObjectOps()) == 0 {
+		return fmt.Errorf("--pool-metrics reports on per-object operations and requires --stat, --head, --line-count, " +
+			"--resolve-links, --grep, --download-to, --exec, --sign-urls or --emit-iam-policy-bindings")
+	}
+	if o.signURLs < 0 || o.signURLs > maxSignedURLExpiry {
+		return fmt.Errorf("invalid --sign-urls %s: must be positive and at most %s", o.signURLs, maxSignedURLExpiry)
+	}
+	if o.signingAccount != "" && o.signURLs == 0 {
+		return fmt.Errorf("--signing-account requires --sign-urls")
+	}
+	// The records of --sign-urls are written as they are signed, which
+	// --ndjson allows but a --json array does not.
+	if o.signURLs > 0 && (o.long || o.json || o.table || o.sink != "" || o.splunkHEC != "" || o.namesOnly || o.subst != nil) {
+		return fmt.Errorf("--sign-urls prints its own lines and cannot be combined with -l, --json, --table, --sink, " +
+			"--names-only or --subst; use --ndjson for JSON records")
+	}
+	if o.emitIAMBindings && (o.long || o.json || o.table || o.sink != "" || o.splunkHEC != "" || o.namesOnly || o.subst != nil) {
+		return fmt.Errorf("--emit-iam-policy-bindings prints its own lines and cannot be combined with -l, --json, --table, " +
+			"--sink, --names-only or --subst; use --ndjson for JSON records")
+	}
+	if o.rateReport && (o.matchStdin || o.notificationPreview || o.incompleteUploads || o.compare || o.findMissing != "") {
+		return fmt.Errorf("--rate-report measures the listing of a pattern and cannot be combined with --match-stdin, " +
+			"--bucket-notification-preview, --incomplete-uploads, --compare or --find-missing")
+	}
+	if o.requireAllMatch && (o.limit > 0 || o.limitBytes > 0 || o.perBucketLimit > 0 || o.approx || o.compare || o.notificationPreview) {
+		return fmt.Errorf("--require-all-match cannot be combined with --limit, --per-bucket-limit or --approx, " +
+			"which stop before every match is seen, nor with --compare or --bucket-notification-preview")
+	}
+	if o.summaryOnly && (o.long || o.table || o.binary || o.json || o.ndjson || o.emitScript != "" || o.manifest || o.selectMode ||
+		o.chunk > 0 || o.complianceReport != "" || o.duplicateBasenames || o.matchReport ||
+		len(o.perObjectOps()) > 0 ||
+		o.sortBy != "" || o.ordered) {
+		return fmt.Errorf("--summary-only prints no matches and cannot be combined with output formats, " +
+			"per-object operations, --sort or --ordered")
+	}
+	if o.mutationSample < 0 {
+		return fmt.Errorf("invalid --mutation-sample %d: must not be negative", o.mutationSample)
+	}
+	// Past generations, soft-deleted objects and a saved listing would all
+	// differ from what a lookup finds now.
+	if o.detectMutation && (o.count || o.histogramSegment > 0 || o.sizeHistogram || o.timeHistogram != "" || o.compare || o.compareToListing != "" ||
+		o.matchStdin || o.notificationPreview || o.incompleteUploads || o.findMissing != "" || o.exists || o.batchStat || o.selfTest || o.emitSchema ||
+		o.versions || o.softDeleted || !o.asOf.IsZero() || o.inventory != "" || o.useCache) {
+		return fmt.Errorf("--detect-mutation checks the live matches of a listing and cannot be combined with other modes, " +
+			"--versions, --soft-deleted, --as-of, --list-from-inventory or --use-cache")
+	}
+	if o.assertContentType != "" && (o.count || o.histogramSegment > 0 || o.sizeHistogram || o.timeHistogram != "" || o.compare || o.compareToListing != "" ||
+		o.matchStdin || o.notificationPreview || o.selfTest || o.emitSchema) {
+		return fmt.Errorf("--assert-content-type checks the matches of a listing and cannot be combined with other modes")
+	}
+	if o.emitSchema && o.timeFormat.preset == timeUnix {
+		return fmt.Errorf("--emit-schema describes timestamps as TIMESTAMP columns and cannot be combined with --time-format unix")
+	}
+	if o.emitSchema && (o.long || o.binary || o.json || o.emitScript != "" || o.manifest || o.matchReport || o.count || o.histogramSegment > 0 || o.sizeHistogram || o.timeHistogram != "" || o.compare ||
+		o.matchStdin || o.selfTest || o.notificationPreview || o.incompleteUploads) {
+		return fmt.Errorf("--emit-schema describes the --ndjson records and cannot be combined with other " +
+			"output formats, --match-report-json or other modes")
+	}
+	// The plan is that of a listing or count; the other modes list
+	// otherwise or not at all.
+	if o.explain && (o.selfTest || o.emitSchema || o.exists || o.verifyListing != "" || o.findMissing != "" || o.matchStdin || o.notificationPreview ||
+		o.incompleteUploads || o.emptyDirs || o.dirs || o.explore || o.compare || o.compareToListing != "" || o.batchStat || o.inventory != "" || o.cacheList != "") {
+		return fmt.Errorf("--explain shows the plan of a listing and cannot be combined with other modes, --batch-stat, " +
+			"--inventory or --cache-list")
+	}
+	if o.invertMatch && (o.patternFile != "" || o.notificationPreview) {
+		return fmt.Errorf("--invert-match cannot be combined with --pattern-file or --bucket-notification-preview")
+	}
+	if o.patternFile != "" && (o.compare || o.matchStdin || o.notificationPreview || o.selfTest ||
+		o.emitScript != "" || o.manifest || o.selectMode) {
+		return fmt.Errorf("--pattern-file cannot be combined with --compare, --match-stdin, " +
+			"--bucket-notification-preview, --self-test, --emit-script, --manifest or --select")
+	}
+	seenTags := make(map[string]bool)
+	for _, t := range o.tags {
+		if seenTags[t.name] {
+			return fmt.Errorf("duplicate --tag %q", t.name)
+		}
+		seenTags[t.name] = true
+		if _, err := globMatch(o.globSyntax, t.pattern, ""); err != nil {
+			return fmt.Errorf("invalid --tag %s pattern %q: %w", t.name, t.pattern, err)
+		}
+	}
+	if o.allTags && len(o.tags) == 0 {
+		return fmt.Errorf("--all-tags requires --tag")
+	}
+	// Tags annotate the listing and take the place of --pattern-file labels.
+	if len(o.tags) > 0 && (o.patternFile != "" || len(o.reportModes()) > 0 || o.selfTest ||
+		o.emitScript != "" || o.manifest || o.selectMode) {
+		return fmt.Errorf("--tag annotates the listing and cannot be combined with --pattern-file, other modes, " +
+			"--emit-script, --manifest or --select")
+	}
+	if o.lifecyclePreview && (o.count || o.histogramSegment > 0 || o.sizeHistogram || o.timeHistogram != "" || o.compare || o.matchStdin || o.sink != "" || o.splunkHEC != "" || o.emitScript != "" || o.manifest ||
+		o.selectMode || o.chunk > 0 || o.namesOnly ||
+		len(o.perObjectOps()) > 0) {
+		return fmt.Errorf("--honor-lifecycle-preview annotates the listing and cannot be combined with --count, " +
+			"--histogram, --size-histogram, --compare, --match-stdin, --sink, --emit-script, --manifest, --select, --chunk, --names-only or per-object operations")
+	}
+	if o.batchStat && (o.matchStdin || o.compare || o.count || o.histogramSegment > 0 || o.sizeHistogram || o.timeHistogram != "" || o.notificationPreview || o.incompleteUploads ||
+		o.patternFile != "" || o.selectMode || o.emitScript != "" ||
+		len(o.perObjectOps()) > 0) {
+		return fmt.Errorf("--batch-stat reads patterns from stdin and outputs their matches' metadata; it cannot be " +
+			"combined with other modes, --pattern-file, --select, --emit-script or per-object operations")
+	}
+	if (o.emitScript != "" || o.manifest) && (o.stat || o.downloadTo != "" || o.head > 0 || o.lineCount || o.resolveLinks != nil || o.grep != "" || o.signURLs > 0 || o.emitIAMBindings) {
+		return fmt.Errorf("--emit-script and --manifest cannot be combined with --stat, --head, --line-count, --resolve-links, --grep, --download-to, --sign-urls or --emit-iam-policy-bindings")
+	}
+	return nil
+}
+
 // main is the entry point of the program.
-// It expects exactly one command-line argument: a GCS path like gs://bucket-name/prefix.
+// It expects exactly one positional argument: a GCS path like gs://bucket-name/prefix.
 // Example Usage:
 // go run . "gs://my-bucket/some-folder/*.csv"
 // go run . "gs://my-bucket/some-folder/**/data.txt"
 func main() {
-	// Check for help flags first
-	if len(os.Args) == 2 && (os.Args[1] == "-h" || os.Args[1] == "--help") {
+	if len(os.Args) > 1 && os.Args[1] == completePrefixCommand {
+		runCompletePrefix(os.Args[2:])
+	}
+	opts, args, err := parseFlags(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
 		showHelp()
 		os.Exit(0)
 	}
+	if err != nil {
+		// The flag package has already reported the problem and the usage.
+		os.Exit(1)
+	}
+	if err := opts.validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
 
-	// Check for the correct number of command-line arguments.
-	if len(os.Args) != 2 {
-		fmt.Fprintf(os.Stderr, "Usage: %s \"gs://bucket/object-pattern\"\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "Example: %s \"gs://my-bucket/logs/**/*.log\"\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "Use -h or --help for more information.\n")
+	// Check for the correct number of positional arguments. Flag parsing
+	// stops at the first positional argument, so a flag written after the
+	// pattern ends up here; say so instead of only printing the usage.
+	for _, arg := range args[min(1, len(args)):] {
+		if strings.HasPrefix(arg, "-") {
+			fmt.Fprintf(os.Stderr, "Error: options must come before the pattern, found %q after it\n", arg)
+			os.Exit(1)
+		}
+	}
+	if opts.selfTest {
+		if len(args) > 0 {
+			fmt.Fprintf(os.Stderr, "Error: --self-test takes no pattern\n")
+			os.Exit(1)
+		}
+		if err := runSelfTest(os.Stdout, opts.globSyntax); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	// The schema only depends on the options, so the patterns of the
+	// listing it describes may be given but are not needed.
+	if opts.emitSchema {
+		if err := emitSchema(os.Stdout, opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if opts.findMissing != "" {
+		if len(args) > 0 {
+			fmt.Fprintf(os.Stderr, "Error: --find-missing reads the objects from its file and takes no pattern\n")
+			os.Exit(1)
+		}
+		// The objects stand in for the pattern in the check below.
+		args = []string{opts.findMissing}
+	}
+	if opts.verifyListing != "" {
+		if len(args) > 0 {
+			fmt.Fprintf(os.Stderr, "Error: --verify-listing checks its file and takes no pattern\n")
+			os.Exit(1)
+		}
+		args = []string{opts.verifyListing}
+	}
+	if opts.patternFile != "" {
+		if len(args) > 0 {
+			fmt.Fprintf(os.Stderr, "Error: --pattern-file cannot be combined with patterns on the command line\n")
+			os.Exit(1)
+		}
+		if args, opts.labels, err = readPatternFile(opts.patternFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if opts.prefixesFrom != "" {
+		if len(args) != 1 {
+			fmt.Fprintf(os.Stderr, "Error: --prefixes-from takes a single pattern\n")
+			os.Exit(1)
+		}
+		if opts.prefixes, err = readPrefixes(opts.prefixesFrom); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if opts.batchStat {
+		if len(args) > 0 {
+			fmt.Fprintf(os.Stderr, "Error: --batch-stat reads its patterns from stdin, not the command line\n")
+			os.Exit(1)
+		}
+		if args, err = readPatterns(os.Stdin); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if len(args) == 0 || (opts.compare && len(args) != 2) || (opts.matchStdin && len(args) != 1) ||
+		(opts.notificationPreview && len(args) != 1) {
+		showUsage()
 		os.Exit(1)
 	}
+	if opts.explain {
+		if err := explainPlan(os.Stdout, args, opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
 
-	gcsPath := os.Args[1]
+	// Report writes to a closed pipe as EPIPE errors instead of letting the
+	// runtime kill the process, so `gcsls ... | head` can stop the listing
+	// cleanly.
+	signal.Ignore(syscall.SIGPIPE)
+
+	// The context is used to manage the lifecycle of API requests. Ctrl-C
+	// cancels it so the listing can stop cleanly and report how far it got;
+	// a second Ctrl-C kills the process as usual.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	if !opts.deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, opts.deadline)
+		defer cancel()
+	}
+
+	// finishTrace ends the run's span and sends the spans still buffered;
+	// it must be called before exiting once the run is over.
+	finishTrace := func(error) {}
+	if opts.trace {
+		if ctx, finishTrace, err = startTracing(ctx, os.Args[1:], os.Stderr); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
 
-	// The context is used to manage the lifecycle of API requests.
-	ctx := context.Background()
+	if opts.exists {
+		if len(args) != 1 {
+			fmt.Fprintf(os.Stderr, "Error: --exists takes the path of a single object\n")
+			os.Exit(existsFailed)
+		}
+		code := probeObject(ctx, args[0], opts, os.Stdout, os.Stderr)
+		finishTrace(nil)
+		// Exit like a shell does for a command killed by SIGINT.
+		if code == existsFailed && errors.Is(ctx.Err(), context.Canceled) {
+			code = 130
+		}
+		os.Exit(code)
+	}
 
 	// Call the core logic function and handle any errors.
-	if err := listObjectsWithWildcard(ctx, gcsPath); err != nil {
+	switch {
+	case opts.verifyListing != "":
+		err = verifyListing(ctx, opts.verifyListing, opts, os.Stdout, os.Stderr)
+	case opts.findMissing != "":
+		err = findMissing(ctx, opts.findMissing, opts, os.Stdin, os.Stdout, os.Stderr)
+	case opts.matchStdin:
+		err = matchNames(os.Stdin, args[0], opts, os.Stdout)
+	case opts.notificationPreview:
+		err = previewNotification(args[0], opts, os.Stdout)
+	case opts.incompleteUploads:
+		err = listIncompleteUploads(ctx, args, opts, os.Stdout, os.Stderr)
+	case opts.emptyDirs:
+		err = reportEmptyDirs(ctx, args, opts, os.Stdout, os.Stderr)
+	case opts.dirs:
+		err = listDirTree(ctx, args, opts, os.Stdout, os.Stderr)
+	case opts.explore:
+		err = exploreBucket(ctx, args, opts, os.Stdout, os.Stderr)
+	case opts.compareToListing != "":
+		err = compareToListing(ctx, opts.compareToListing, args, opts, os.Stdout, os.Stderr)
+	case opts.compare:
+		err = compareListings(ctx, args[0], args[1], opts, os.Stdout, os.Stderr)
+	case opts.count || opts.histogramSegment > 0 || opts.sizeHistogram || opts.timeHistogram != "":
+		err = countMatches(ctx, args, opts, os.Stdout, os.Stderr)
+	default:
+		err = listObjectsWithWildcard(ctx, args, opts, os.Stdout, os.Stderr)
+	}
+	finishTrace(err)
+	if err != nil {
+		// The reader went away after getting what it needed; that is success.
+		if isBrokenPipe(err) {
+			os.Exit(0)
+		}
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			log.Fatalf("Failed to list objects: deadline exceeded: %v", err)
+		}
+		// Exit like a shell does for a command killed by SIGINT.
+		if errors.Is(err, errInterrupted) || ctx.Err() != nil {
+			os.Exit(130)
+		}
+		// Like diff, differences are reported through the exit status alone.
+		if errors.Is(err, errListingsDiffer) || errors.Is(err, errObjectsMissing) || errors.Is(err, errRenameCollisions) || errors.Is(err, errContentTypeMismatch) ||
+			errors.Is(err, errListingUnverified) || errors.Is(err, errNoMatches) || errors.Is(err, errScanMutated) {
+			os.Exit(1)
+		}
+		// Leaving the picker is like interrupting the command.
+		if errors.Is(err, errSelectionCancelled) {
+			os.Exit(130)
+		}
 		log.Fatalf("Failed to list objects: %v", err)
 	}
 }
 
-// listObjectsWithWildcard lists objects in GCS that match a given path with wildcards.
-func listObjectsWithWildcard(ctx context.Context, gcsPath string) error {
-	// --- 1. Parse the GCS Path ---
-	// The path must start with "gs://".
-	if !strings.HasPrefix(gcsPath, "gs://") {
-		return fmt.Errorf("invalid GCS path: must start with gs://")
+// runCompletePrefix runs the complete-prefix subcommand with the options
+// and partial URL in args, and exits. A failure exits with status 1, so
+// that a completion script offers nothing rather than an error.
+func runCompletePrefix(args []string) {
+	opts, args, err := parseFlags(args)
+	if errors.Is(err, flag.ErrHelp) {
+		showHelp()
+		os.Exit(0)
+	}
+	if err != nil {
+		os.Exit(1)
+	}
+	if err := opts.validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s [OPTIONS] gs://bucket/partial-path\n", os.Args[0], completePrefixCommand)
+		os.Exit(1)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := completePrefix(ctx, args[0], opts, os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		stop()
+		os.Exit(1)
 	}
+	stop()
+	os.Exit(0)
+}
 
-	// Remove the "gs://" prefix to work with the bucket and object path.
-	pathWithoutScheme := strings.TrimPrefix(gcsPath, "gs://")
+// errInterrupted is returned when the listing stopped because of Ctrl-C. The
+// partial results have been written by then.
+var errInterrupted = errors.New("interrupted")
 
-	// Split the path into bucket name and the object pattern.
-	parts := strings.SplitN(pathWithoutScheme, "/", 2)
-	if len(parts) == 0 || parts[0] == "" {
-		return fmt.Errorf("invalid GCS path: bucket name is missing")
+// Sentinel errors a visit function returns to stop scanning early without
+// failing the listing.
+var (
+	// errLimitReached stops the whole listing once --limit matches are found.
+	errLimitReached = errors.New("match limit reached")
+	// errBucketLimitReached moves on to the next pattern once a bucket has
+	// produced --per-bucket-limit matches.
+	errBucketLimitReached = errors.New("per-bucket match limit reached")
+)
+
+// listObjectsWithWildcard lists objects in GCS that match the given paths with wildcards.
+// The listing is written to stdout; progress, warnings and statistics go to
+// status, so that stdout only carries results. Several paths are listed one
+// after the other into a single output.
+func listObjectsWithWildcard(ctx context.Context, gcsPaths []string, opts *options, stdout, status io.Writer) (err error) {
+	// --- 1. Parse the GCS Paths ---
+	// This also determines the prefix for each API query. Every path is
+	// checked before any request is made.
+	targets, err := resolveTargets(gcsPaths, opts)
+	if err != nil {
+		return err
 	}
-	bucketName := parts[0]
-	objectPattern := ""
-	if len(parts) > 1 {
-		objectPattern = parts[1]
+	if len(targets) > 1 {
+		if opts.checkpoint != "" {
+			return fmt.Errorf("--checkpoint supports a single pattern only")
+		}
+		if opts.downloadTo != "" && opts.layout == layoutRelative {
+			return fmt.Errorf("--layout %s supports a single pattern only", layoutRelative)
+		}
+		if opts.relative {
+			return fmt.Errorf("--relative supports a single pattern only")
+		}
 	}
 
-	// If the pattern is empty, it means we should list everything in the bucket.
-	// We'll use the "**" wildcard for this, which matches everything recursively.
-	if objectPattern == "" {
-		objectPattern = "**"
+	var execCmd *execCommand
+	if opts.exec != "" {
+		if execCmd, err = parseExecCommand(opts.exec); err != nil {
+			return err
+		}
+		execCmd.keepGoing = opts.keepGoing
+		execCmd.status = status
 	}
 
 	// --- 2. Initialize GCS Client ---
 	// This uses Application Default Credentials (ADC) to authenticate.
 	// Ensure you have authenticated via `gcloud auth application-default login`
 	// or that the environment is configured with a service account.
-	client, err := storage.NewClient(ctx)
+	client, err := newStorageClient(ctx, opts, status)
 	if err != nil {
-		return fmt.Errorf("failed to create GCS client: %w", err)
+		return err
 	}
 	defer client.Close()
 
-	bucket := client.Bucket(bucketName)
+	// The state is saved last, once everything down to the upload of
+	// --write-listing has succeeded, so that a failed run is repeated in
+	// full by the next one.
+	var state *incrementalState
+	if opts.incremental != "" {
+		if state, err = loadIncrementalState(opts.incremental); err != nil {
+			return err
+		}
+		opts.since = state.since
+		defer func() {
+			if err == nil {
+				err = state.save()
+			}
+		}()
+	}
 
-	// --- 3. Determine Prefix for API Query ---
-	// To make the GCS API call more efficient, we find the part of the pattern
-	// before any wildcards. This reduces the number of objects we have to
-	// process client-side.
-	prefix := getPrefixFromPattern(objectPattern)
+	// All regular output goes through a buffered writer that is flushed as
+	// lines are produced; diagnostics go straight to status. With
+	// --write-listing it goes to a GCS object instead, committed only if
+	// the run succeeds. --gzip compresses it either way, and the stream is
+	// closed however the run ends, so that what was listed decompresses.
+	newOut := newOutputWriter
+	if gzipOutput(opts) {
+		newOut = newGzipOutputWriter
+	}
+	var out *outputWriter
+	if opts.writeListing != "" {
+		var upload *listingUpload
+		if upload, err = openListingUpload(ctx, client, opts.writeListing, opts, status); err != nil {
+			return err
+		}
+		out = newOut(upload, opts.flushEvery)
+		defer func() { err = upload.finish(out, err) }()
+	} else {
+		if f, ok := stdout.(*os.File); ok && opts.gzip && term.IsTerminal(int(f.Fd())) {
+			return fmt.Errorf("--gzip will not write compressed output to a terminal; redirect stdout to a file or use --write-listing")
+		}
+		out = newOut(stdout, opts.flushEvery)
+	}
+	if opts.manifestChecksum {
+		out.checksum = sha256.New()
+	}
+	defer out.Close()
+	var errLog *errorLog
+	if opts.errorsTo != "" {
+		if errLog, err = openErrorLog(opts.errorsTo); err != nil {
+			return err
+		}
+		defer func() {
+			if closeErr := errLog.close(status); err == nil {
+				err = closeErr
+			}
+		}()
+	}
+	if err := resolveSinceFile(ctx, client, opts); err != nil {
+		return err
+	}
 
-	query := &storage.Query{
-		Prefix: prefix,
+	// --- 3. Prepare the Listing ---
+	// Buckets outside the --location regions are dropped before anything
+	// is listed in them.
+	var skippedBuckets []skippedBucket
+	if len(opts.locations) > 0 {
+		if targets, skippedBuckets, err = filterByLocation(ctx, client, targets, opts.locations); err != nil {
+			return err
+		}
+		if opts.verbose {
+			for _, b := range skippedBuckets {
+				fmt.Fprintf(status, "Skipping gs://%s in %s, outside --location\n", b.bucket, b.location)
+			}
+		}
+		if len(targets) == 0 {
+			return fmt.Errorf("every bucket of the patterns is outside --location %s", strings.Join(opts.locations, ","))
+		}
 	}
 
-	// --- 4. Iterate and Filter ---
-	fmt.Printf("Listing objects in gs://%s matching pattern: %s\n", bucketName, objectPattern)
+	// A checkpoint left by an interrupted run moves the start of the
+	// listing past everything that run already handled.
+	var progress *checkpoint
+	if opts.checkpoint != "" {
+		resume, err := loadCheckpoint(opts.checkpoint)
+		if err != nil {
+			return err
+		}
+		if resume > opts.after {
+			fmt.Fprintf(status, "Resuming after %q from checkpoint %s\n", resume, opts.checkpoint)
+			opts.after = resume
+		}
+		progress = newCheckpoint(opts.checkpoint)
+	}
 
-	it := bucket.Objects(ctx, query)
-	found := false
-	for {
-		attrs, err := it.Next()
-		if err == iterator.Done {
-			// End of the results.
-			break
+	// Patterns whose prefixes nest are matched during one scan.
+	scans := mergeTargets(targets)
+
+	names, err := loadNameSet(opts.includeNames)
+	if err != nil {
+		return err
+	}
+	filters := buildFilters(opts)
+	// The name set goes first, so that a name counts as found even when
+	// another filter drops its object.
+	if names != nil {
+		filters = append([]objectFilter{names.accept}, filters...)
+	}
+	owners := &ownerFilter{pattern: opts.owner}
+	if owners.active() {
+		filters = append(filters, owners.accept)
+	}
+	partitioned := &partitionSpecFilter{keys: opts.partitionSpec}
+	if partitioned.active() {
+		filters = append(filters, partitioned.accept)
+	}
+	// With --relative, the placeholder object named like the directory the
+	// names are relative to would print as an empty line.
+	if opts.relative {
+		base := targets[0].relativeBase()
+		filters = append(filters, func(attrs *storage.ObjectAttrs) bool {
+			return attrs.Name != base
+		})
+	}
+
+	// With --wait, poll until the patterns are satisfied before producing any
+	// output, so the listing below reflects the state that ended the wait.
+	if opts.wait {
+		if err := waitForMatches(ctx, client, scans, opts, filters, status); err != nil {
+			return err
 		}
+	}
+
+	// Deduplication remembers every key it has seen, so it only starts with
+	// the listing proper, after any --wait polling.
+	var dedupe *dedupeFilter
+	if opts.dedupeBy != "" {
+		dedupe = newDedupeFilter(opts.dedupeBy, opts.maxBuffered)
+		filters = append(filters, dedupe.accept)
+	}
+
+	// Per-object operations run on a bounded worker pool. The pool's context
+	// is cancelled on the first failure (unless --keep-going is set), which
+	// also stops the listing below from dispatching further work.
+	var ops []objectFunc
+	if opts.stat {
+		ops = append(ops, statObject(client, opts.humanTime, out))
+	}
+	if opts.head > 0 {
+		ops = append(ops, headObject(client, opts.csekKey, opts.head, out))
+	}
+	if opts.lineCount {
+		ops = append(ops, countLines(client, opts.csekKey, out))
+	}
+	if opts.signURLs > 0 {
+		signer, err := newURLSigner(client, opts.signURLs, opts.signingAccount, opts.versions, opts.ndjson, out)
 		if err != nil {
-			return fmt.Errorf("failed to iterate objects: %w", err)
+			return err
+		}
+		ops = append(ops, signer.sign)
+	}
+	if opts.emitIAMBindings {
+		ops = append(ops, newAccessReporter(client, opts.ndjson, out).report)
+	}
+	if opts.resolveLinks != nil {
+		ops = append(ops, resolveLinks(client, opts.csekKey, opts.resolveLinks, out))
+	}
+	if opts.grep != "" {
+		// The pattern was checked by validate, so this cannot fail.
+		re, _ := newGrepPattern(opts)
+		ops = append(ops, grepObject(client, opts.csekKey, re, opts.grepMaxBytes, out))
+	}
+	var downloads *downloader
+	if opts.downloadTo != "" {
+		downloads = newDownloader(client, opts.csekKey, opts.downloadTo, opts.layout, targets[0].prefix, out)
+		downloads.verify = opts.verify
+		downloads.resume = opts.resume
+		ops = append(ops, downloads.download)
+	}
+	// The batched '{} +' form collects URLs itself instead of using the pool,
+	// unless other operations need one; it then takes each object once they
+	// are done with it.
+	if execCmd != nil && (!execCmd.batch || len(ops) > 0) {
+		ops = append(ops, execCmd.run)
+	}
+	// ctx is replaced by the pool's context below; parent tells an
+	// interruption apart from the pool cancelling itself after a failure.
+	parent := ctx
+	var pool *workerPool
+	if len(ops) > 0 {
+		fn := chainObjectFuncs(ops)
+		if opts.objectTimeout > 0 {
+			fn = withObjectTimeout(fn, opts.objectTimeout)
+		}
+		if opts.trace {
+			fn = traceObjectFunc(fn)
+		}
+		var metrics *poolMetrics
+		if opts.poolMetrics {
+			metrics = newPoolMetrics(opts.concurrency, status)
+			fn = metrics.wrap(fn)
+		}
+		pool = newWorkerPool(ctx, opts.concurrency, opts.keepGoing, status, fn)
+		pool.errors = errLog
+		pool.metrics = metrics
+		// One worker finishes its objects in order already.
+		if opts.ordered && opts.concurrency > 1 {
+			pool.reorder = newReorderBuffer(out, cmp.Or(opts.reorderWindow, 4*opts.concurrency))
 		}
+		ctx = pool.ctx
+	}
 
-		// Client-side filtering using the doublestar library, which supports "**".
-		matched, err := doublestar.Match(objectPattern, attrs.Name)
+	// The lifecycle rules are fetched once per bucket, before the listing.
+	var lifecycle *lifecyclePreview
+	if opts.lifecyclePreview {
+		if lifecycle, err = loadLifecyclePreview(ctx, client, targets); err != nil {
+			return err
+		}
+	}
+
+	// Matches of a --pattern-file are labelled and counted per label.
+	labels := newObjectLabels(targets, opts)
+	tags := newObjectTagger(opts)
+	contentTypes := newContentTypeCheck(opts, status)
+
+	extra := annotations{lifecycle: lifecycle, labels: labels, tags: tags, times: opts.timeFormat.forJSON(),
+		metageneration: opts.withMetageneration, partitions: len(opts.partitionSpec) > 0, dates: opts.extractDate,
+		retention: opts.withRetention, customTime: opts.withCustomTime, now: time.Now()}
+	format := newFormatter(opts, targets, extra, status)
+	if opts.sink != "" {
+		s, err := openSink(ctx, opts.sink)
+		if err != nil {
+			return err
+		}
+		defer s.close()
+		format = &sinkFormatter{sink: s, spec: opts.sink, extra: extra}
+	}
+	if opts.splunkHEC != "" {
+		s, err := newHECSink(ctx, opts, status)
 		if err != nil {
-			return fmt.Errorf("invalid glob pattern '%s': %w", objectPattern, err)
+			return err
+		}
+		defer s.close()
+		format = &sinkFormatter{sink: s, spec: opts.splunkHEC, extra: extra}
+	}
+
+	// With --batch-stat, matches are output once their full metadata has
+	// been fetched, in the order they were listed.
+	var batch *batchFetcher
+	if opts.batchStat {
+		batch = newBatchFetcher(ctx, client, opts.concurrency, opts.keepGoing, status, func(attrs *storage.ObjectAttrs) error {
+			return format.object(out, attrs)
+		})
+		batch.errors = errLog
+		if opts.ndjsonErrors {
+			batch.emitError = func(listed *storage.ObjectAttrs, err error) error {
+				return writeErrorRecord(out, listed, err, opts.framedNDJSON)
+			}
+		}
+		ctx = batch.ctx
+	}
+
+	// deliver outputs a single matched object, either directly or by
+	// handing it to the per-object worker pool.
+	found := false
+	totals := newSummary()
+	mutations := newMutationCheck(opts)
+	var maxGeneration int64
+	deliver := func(attrs *storage.ObjectAttrs) error {
+		found = true
+		totals.add(attrs)
+		labels.count(attrs)
+		tags.count(attrs)
+		contentTypes.check(attrs)
+		maxGeneration = max(maxGeneration, attrs.Generation)
+		state.seen(attrs)
+		mutations.seen(attrs)
+		switch {
+		case pool != nil:
+			return pool.submit(attrs)
+		case batch != nil:
+			return batch.submit(attrs)
+		case execCmd != nil:
+			return execCmd.add(ctx, attrs)
+		}
+		if err := format.object(out, attrs); err != nil {
+			return fmt.Errorf("failed to write output: %w", err)
+		}
+		return nil
+	}
+
+	// emit takes each match from the scan and enforces the match limits.
+	// With --sort, --top-largest, --top-oldest or --sample, matches are
+	// held back until the scan is complete, and --limit then applies to
+	// their order.
+	stats := newScanStats(targetPrefixes(scans)...)
+	stats.checkpoint = progress
+	cache, err := openListCache(ctx, client, scans, opts, status)
+	if err != nil {
+		return err
+	}
+	defer cache.close()
+	stats.cache = cache
+	stats.throttle = opts.throttle
+	stats.rates = opts.rates
+	if opts.inventory != "" {
+		if stats.inventory, err = newInventoryReport(client, opts.inventory, opts.inventoryNameColumn); err != nil {
+			return err
+		}
+	}
+	// A cache or report that serves the listing needs no requests to the
+	// bucket at all.
+	stats.skippedBuckets = skippedBuckets
+	if !opts.assumeExists && !cache.serving() && stats.inventory == nil && len(opts.locations) == 0 {
+		if err := checkBucketsExist(ctx, client, scans); err != nil {
+			return err
 		}
+	}
+	if len(targets) > 1 {
+		stats.countPatterns(targets)
+	}
+	perBucket := make(map[string]int)
+	var held heldMatches
+	switch {
+	case opts.sortBy != "":
+		held = newSortBuffer(opts.sortBy, opts.reverse, opts.extractDate, opts.collate, sortSpillLimit(opts))
+		defer held.close()
+	case opts.topLargest > 0:
+		held = newTopBuffer(opts.topLargest, false)
+	case opts.topOldest > 0:
+		held = newTopBuffer(opts.topOldest, true)
+	case opts.newest:
+		held = newNewestBuffer()
+	case opts.oldest:
+		held = newTopBuffer(1, true)
+	case opts.sample > 0:
+		held = newSampleBuffer(opts.sample, opts.seed)
+	}
+	budget := newByteBudget(opts.limitBytes)
+	emit := func(attrs *storage.ObjectAttrs) error {
+		if err := dedupe.check(); err != nil {
+			return err
+		}
+		if held == nil && !budget.take(attrs) {
+			return errLimitReached
+		}
+		stats.matched++
+		perBucket[attrs.Bucket]++
+		if held != nil {
+			if err := held.add(attrs); err != nil {
+				return err
+			}
+		} else {
+			if err := deliver(attrs); err != nil {
+				return err
+			}
+			if opts.limit > 0 && stats.matched >= opts.limit {
+				return errLimitReached
+			}
+		}
+		if opts.perBucketLimit > 0 && perBucket[attrs.Bucket] >= opts.perBucketLimit {
+			return errBucketLimitReached
+		}
+		return nil
+	}
+
+	// --- 4. Iterate and Filter ---
+	if err := format.header(out, targets); err != nil {
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+	// The ages of --time-format relative and --stat count from this time,
+	// which saved output would otherwise not record.
+	if opts.humanTime && !format.machineReadable() && !opts.namesOnly {
+		fmt.Fprintf(out, "As of %s\n", formatTime(time.Now()))
+	}
+
+	var scanErr error
+	// An earlier pattern may already have used up a bucket's share.
+	bucketDone := func(t listTarget) bool {
+		return opts.perBucketLimit > 0 && perBucket[t.bucket] >= opts.perBucketLimit
+	}
+	if opts.ordered && len(scans) > 1 {
+		scanErr = scanOrdered(ctx, client, scans, opts, filters, stats, emit)
+	} else if opts.bucketConcurrency > 1 && len(scans) > 1 {
+		scanErr = scanBuckets(ctx, client, scans, opts.bucketConcurrency, opts, filters, stats, bucketDone, emit)
+	} else {
+		for _, t := range scans {
+			if bucketDone(t) {
+				continue
+			}
+			err := scanMatches(ctx, client, t, opts, filters, stats, emit)
+			if errors.Is(err, errBucketLimitReached) {
+				continue
+			}
+			if errors.Is(err, errLimitReached) {
+				break
+			}
+			if err != nil {
+				scanErr = err
+				break
+			}
+		}
+	}
+	if scanErr == nil {
+		scanErr = cache.commit(status)
+	}
+	if scanErr == nil && held != nil {
+		delivered := 0
+		scanErr = held.each(func(attrs *storage.ObjectAttrs) error {
+			if opts.limit > 0 && delivered == opts.limit || !budget.take(attrs) {
+				return errLimitReached
+			}
+			delivered++
+			return deliver(attrs)
+		})
+		if errors.Is(scanErr, errLimitReached) {
+			scanErr = nil
+		}
+	}
+
+	if parent.Err() != nil {
+		if pool != nil {
+			pool.wait()
+		}
+		if batch != nil {
+			batch.wait()
+		}
+		out.Flush()
+		if errors.Is(parent.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("stopped after %d objects, %d matches", stats.scanned, stats.matched)
+		}
+		fmt.Fprintf(status, "Interrupted after %d objects, %d matches\n", stats.scanned, stats.matched)
+		return errInterrupted
+	}
+	// Patterns are recorded as unmatched once the whole listing has been
+	// seen, even if per-object failures then fail the run.
+	if scanErr == nil && opts.limit == 0 && opts.limitBytes == 0 && opts.perBucketLimit == 0 {
+		stats.recordUnmatched(targets, errLog)
+	}
+	if pool != nil {
+		// A failed per-object operation cancels the listing; report that
+		// failure rather than the resulting context error.
+		err := pool.wait()
+		downloads.printVerified(status)
+		if err != nil {
+			return err
+		}
+	}
+	if batch != nil {
+		if err := batch.wait(); err != nil {
+			return err
+		}
+	}
+	if scanErr != nil {
+		return scanErr
+	}
+	if execCmd != nil && execCmd.batch {
+		// The pool's context is done once the pool is.
+		if err := execCmd.finish(parent); err != nil {
+			return err
+		}
+	}
+
+	// The listing is complete, so there is nothing left to resume.
+	if progress != nil {
+		if err := progress.remove(); err != nil {
+			return err
+		}
+	}
+
+	if err := format.footer(out); err != nil {
+		if errors.Is(err, errSelectionCancelled) || errors.Is(err, errRenameCollisions) {
+			return err
+		}
+		return fmt.Errorf("failed to write output: %w", err)
+	}
 
-		if matched {
-			fmt.Printf("gs://%s/%s\n", bucketName, attrs.Name)
-			found = true
+	// Machine-readable output must stay parseable, so status goes to stderr.
+	// The report of --summary-only shows that nothing matched.
+	// With --newest and --oldest, stdout is left empty for a script to
+	// check.
+	if !found && !opts.summaryOnly {
+		if format.machineReadable() || opts.newest || opts.oldest {
+			fmt.Fprintln(status, "No objects found matching the pattern.")
+		} else {
+			fmt.Fprintln(out, "No objects found matching the pattern.")
 		}
 	}
+	if owners.active() && !owners.seenOwner {
+		fmt.Fprintln(status, "Warning: no listed object reported an owner. Buckets with uniform "+
+			"bucket-level access do not record object owners, so --owner cannot match there.")
+	}
+	names.reportMissing(status)
+	partitioned.reportSkipped(status)
 
-	if !found {
-		fmt.Println("No objects found matching the pattern.")
+	if opts.matchReport {
+		if err := writeJSONRecord(out, stats.report(totals.bytes), opts.framedNDJSON); err != nil {
+			return fmt.Errorf("failed to write output: %w", err)
+		}
+	}
+	if batch != nil {
+		batch.printSummary(status, len(targets))
+	}
+	if opts.summaryOnly {
+		totals.printReport(out, stats.scanned, time.Since(stats.start))
+	} else if opts.classSummary && found {
+		totals.printClassSummary(out)
+	}
+	if labels != nil {
+		if format.machineReadable() {
+			labels.printCounts(status)
+		} else {
+			labels.printCounts(out)
+		}
+	}
+	if tags != nil {
+		if format.machineReadable() {
+			tags.printCounts(status)
+		} else {
+			tags.printCounts(out)
+		}
+	}
+	// The trailer goes last, after everything it sums up.
+	if opts.manifestChecksum {
+		if err := writeChecksumTrailer(out, totals.objects, opts.ndjson); err != nil {
+			return fmt.Errorf("failed to write output: %w", err)
+		}
+	}
+	if opts.stats {
+		if dedupe != nil {
+			stats.duplicates = &dedupe.dropped
+		}
+		stats.print(status)
+	}
+	stats.printRates(status)
+	if opts.metricsFile != "" {
+		if err := stats.appendMetrics(opts.metricsFile, targets, totals.bytes); err != nil {
+			return err
+		}
+	}
+	budget.report(status)
+	// Report the checkpoint for the next incremental run. With no new
+	// objects, the checkpoint stays where it was.
+	if opts.sinceGeneration >= 0 {
+		fmt.Fprintf(status, "Max generation: %d\n", max(maxGeneration, opts.sinceGeneration))
+	}
+	if err := mutations.result(parent, client, opts.concurrency, status); err != nil {
+		return err
+	}
+	if err := contentTypes.result(); err != nil {
+		return err
+	}
+	// A limit may stop the listing before a pattern's matches are reached.
+	if opts.limit == 0 && opts.limitBytes == 0 && opts.perBucketLimit == 0 {
+		if err := stats.checkMatched(targets, opts.requireAllMatch, status); err != nil {
+			return err
+		}
 	}
 
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+	if !found && (opts.newest || opts.oldest) {
+		return errNoMatches
+	}
 	return nil
 }
 
+// countTrue returns how many of the given conditions are true.
+func countTrue(conds ...bool) int {
+	n := 0
+	for _, c := range conds {
+		if c {
+			n++
+		}
+	}
+	return n
+}
+
+// parseGCSPath splits a gs://bucket/object-pattern path into the bucket name
+// and the (possibly empty) object pattern.
+func parseGCSPath(gcsPath string) (bucketName, objectPattern string, err error) {
+	// The path must start with "gs://".
+	if !strings.HasPrefix(gcsPath, "gs://") {
+		return "", "", fmt.Errorf("invalid GCS path: must start with gs://")
+	}
+
+	// Remove the "gs://" prefix to work with the bucket and object path.
+	pathWithoutScheme := strings.TrimPrefix(gcsPath, "gs://")
+
+	// Split the path into bucket name and the object pattern.
+	parts := strings.SplitN(pathWithoutScheme, "/", 2)
+	if len(parts) == 0 || parts[0] == "" {
+		return "", "", fmt.Errorf("invalid GCS path: bucket name is missing")
+	}
+	bucketName = parts[0]
+	if len(parts) > 1 {
+		objectPattern = parts[1]
+	}
+
+	// Wildcards only apply to object names. A bucket like "*" or "logs-?"
+	// would otherwise be sent to the API verbatim and fail with an unhelpful
+	// "bucket doesn't exist" error.
+	if strings.ContainsAny(bucketName, "*?[]{}") {
+		return "", "", fmt.Errorf("invalid GCS path: bucket name %q contains wildcard characters; "+
+			"globbing bucket names is not supported, wildcards may only appear in the object part; "+
+			"list the buckets first, for example with `gcloud storage ls`, and pass each one as its own pattern, "+
+			"such as gs://bucket-a/%s gs://bucket-b/%s", bucketName, objectPattern, objectPattern)
+	}
+
+	return bucketName, objectPattern, nil
+}
+
+// expandPattern applies the pattern shorthands: --ext, an empty pattern
+// for the whole bucket, and a trailing "/" for a whole directory.
+func expandPattern(objectPattern string, opts *options) (string, error) {
+	// --ext builds the pattern from a plain directory.
+	if len(opts.extensions) > 0 {
+		var err error
+		if objectPattern, err = extensionPattern(objectPattern, opts.extensions); err != nil {
+			return "", err
+		}
+	}
+
+	// If the pattern is empty, it means we should list everything in the bucket.
+	// We'll use the "**" wildcard for this, which matches everything recursively.
+	if objectPattern == "" {
+		objectPattern = "**"
+	}
+
+	// A pattern ending in "/" names a directory. Like the bucket root above,
+	// it lists everything under that directory unless --match-only-objects
+	// asks for the placeholder object literally named "dir/".
+	if strings.HasSuffix(objectPattern, "/") && !opts.matchOnlyObjects {
+		objectPattern += "**"
+	}
+	return objectPattern, nil
+}
+
 // getPrefixFromPattern extracts the part of a string before the first wildcard character.
-// Wildcards are considered to be '*', '?', and '['.
+// Wildcards are considered to be '*', '?', '[' and '{'.
 func getPrefixFromPattern(pattern string) string {
-	wildcardIndex := strings.IndexAny(pattern, "*?[")
-	if wildcardIndex == -1 {
-		// No wildcards, the whole pattern is a prefix.
-		return pattern
+	literal, _ := splitPattern(pattern)
+	return literal
+}
+
+// splitPattern splits a pattern at its first unescaped wildcard character
+// into the literal text before it and the rest of the pattern. A backslash
+// makes the next character literal, as it does in the glob, so the literal
+// has its escapes removed: `a\[b]/*` gives "a[b]/" and "*". Without this
+// the server-side prefix would keep the backslash, or stop at the escaped
+// bracket, and disagree with what the glob matches.
+func splitPattern(pattern string) (literal, rest string) {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*', '?', '[', '{':
+			return b.String(), pattern[i:]
+		case '\\':
+			if i+1 == len(pattern) {
+				// A trailing backslash escapes nothing and makes the
+				// pattern invalid; leave it to the glob to report.
+				return b.String(), pattern[i:]
+			}
+			i++
+			b.WriteByte(pattern[i])
+		default:
+			b.WriteByte(c)
+		}
 	}
-	// Return the substring up to the first wildcard.
-	return pattern[:wildcardIndex]
+	return b.String(), ""
 }
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// TestParseGCSPath checks how URLs split into bucket and pattern, and that
+// wildcards in the bucket name are refused with a pointer to listing the
+// buckets instead.
+func TestParseGCSPath(t *testing.T) {
+	tests := []struct {
+		url     string
+		bucket  string
+		pattern string
+		err     string
+	}{
+		{url: "gs://b/logs/*.log", bucket: "b", pattern: "logs/*.log"},
+		{url: "gs://b", bucket: "b"},
+		{url: "gs://b/", bucket: "b"},
+		{url: "gs://b/a?[0-9]", bucket: "b", pattern: "a?[0-9]"},
+		{url: "s3://b/x", err: "must start with gs://"},
+		{url: "gs:///x", err: "bucket name is missing"},
+		{url: "gs://*/x", err: `bucket name "*" contains wildcard characters`},
+		{url: "gs://b?/x", err: `bucket name "b?" contains wildcard characters`},
+		{url: "gs://{a,b}/x", err: "wildcard characters"},
+	}
+	for _, tt := range tests {
+		bucket, pattern, err := parseGCSPath(tt.url)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("parseGCSPath(%q) error = %v, want one containing %q", tt.url, err, tt.err)
+			}
+			if err != nil && strings.Contains(tt.err, "wildcard") && !strings.Contains(err.Error(), "gcloud storage ls") {
+				t.Errorf("parseGCSPath(%q) error = %v, want a pointer to listing the buckets", tt.url, err)
+			}
+			continue
+		}
+		if err != nil || bucket != tt.bucket || pattern != tt.pattern {
+			t.Errorf("parseGCSPath(%q) = %q, %q, %v, want %q, %q", tt.url, bucket, pattern, err, tt.bucket, tt.pattern)
+		}
+	}
+}
+
+// parseArgs parses and validates the command line args the way main does.
+func parseArgs(args []string) (*options, []string, error) {
+	opts, args, err := parseFlags(args)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := opts.validate(); err != nil {
+		return nil, nil, err
+	}
+	return opts, args, nil
+}
+
+// TestListingModes checks which generations each listing mode returns, and
+// the combinations of modes that are refused.
+func TestListingModes(t *testing.T) {
+	fake := newFakeGCS(t, "modes", nil, 100)
+	fake.objects = []fakeObject{
+		{name: "data/a", generation: 1, noncurrent: true},
+		{name: "data/a", generation: 2},
+		{name: "data/b", generation: 1},
+		{name: "data/c", generation: 3, softDeleted: true},
+	}
+	tests := []struct {
+		flags []string
+		want  []string
+	}{
+		{nil, []string{"data/a", "data/b"}},
+		{[]string{"--versions"}, []string{"data/a", "data/a", "data/b"}},
+		{[]string{"--soft-deleted"}, []string{"data/c"}},
+	}
+	for _, tt := range tests {
+		got := listedNames(t, append(tt.flags, "gs://modes/data/*")...)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("listing with %v = %v, want %v", tt.flags, got, tt.want)
+		}
+	}
+
+	for _, flags := range [][]string{
+		{"--soft-deleted", "--versions"},
+		{"--soft-deleted", "--as-of", "2024-01-01"},
+		{"--soft-deleted", "--stat"},
+		{"--soft-deleted", "--download-to", "out"},
+	} {
+		if _, _, err := parseArgs(append(flags, "gs://modes/data/*")); err == nil || !strings.Contains(err.Error(), "--soft-deleted cannot be combined") {
+			t.Errorf("parseArgs(%v) error = %v, want a refused combination", flags, err)
+		}
+	}
+}
+
+// TestTrailingSlashPatterns checks what gs://b/dir/, gs://b/dir/* and
+// gs://b/dir/** match, with and without --match-only-objects.
+func TestTrailingSlashPatterns(t *testing.T) {
+	newFakeGCS(t, "slash", []string{"dir/", "dir/a", "dir/sub/b", "dirt"}, 100)
+	tests := []struct {
+		pattern string
+		flags   []string
+		want    []string
+	}{
+		{"gs://slash/dir/", nil, []string{"dir/", "dir/a", "dir/sub/b"}},
+		{"gs://slash/dir/", []string{"--match-prefixes"}, []string{"dir/", "dir/a", "dir/sub/b"}},
+		{"gs://slash/dir/", []string{"--match-only-objects"}, []string{"dir/"}},
+		{"gs://slash/dir/*", nil, []string{"dir/", "dir/a"}},
+		{"gs://slash/dir/*", []string{"--match-only-objects"}, []string{"dir/", "dir/a"}},
+		{"gs://slash/dir/**", nil, []string{"dir/", "dir/a", "dir/sub/b"}},
+		{"gs://slash/dir/**", []string{"--match-only-objects"}, []string{"dir/", "dir/a", "dir/sub/b"}},
+	}
+	for _, tt := range tests {
+		got := listedNames(t, append(tt.flags, tt.pattern)...)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s with %v matched %v, want %v", tt.pattern, tt.flags, got, tt.want)
+		}
+	}
+	if _, _, err := parseArgs([]string{"--match-prefixes", "--match-only-objects", "gs://slash/dir/"}); err == nil {
+		t.Error("--match-prefixes with --match-only-objects was accepted")
+	}
+}
+
+// TestListingOutput checks the exact output written to the listing's
+// writer for a few formats, and that status messages go to the other one.
+func TestListingOutput(t *testing.T) {
+	newFakeGCS(t, "out", []string{"logs/a.log", "logs/b.log", "logs/c.txt"}, 100)
+	tests := []struct {
+		flags []string
+		want  string
+	}{
+		{nil, "Listing objects in gs://out matching pattern: logs/*.log\n" +
+			"gs://out/logs/a.log\n" +
+			"gs://out/logs/b.log\n"},
+		{[]string{"--names-only"}, "logs/a.log\nlogs/b.log\n"},
+		{[]string{"-l"}, "Listing objects in gs://out matching pattern: logs/*.log\n" +
+			"         1  2024-01-01T00:00:00Z  gs://out/logs/a.log\n" +
+			"         1  2024-01-01T00:00:00Z  gs://out/logs/b.log\n" +
+			"TOTAL: 2 objects, 2 bytes (2 B)\n"},
+		{[]string{"--ndjson"},
+			`{"bucket":"out","name":"logs/a.log","generation":1,"size":1,"updated":"2024-01-01T00:00:00Z","created":"2024-01-01T00:00:00Z","storageClass":""}` + "\n" +
+				`{"bucket":"out","name":"logs/b.log","generation":1,"size":1,"updated":"2024-01-01T00:00:00Z","created":"2024-01-01T00:00:00Z","storageClass":""}` + "\n"},
+	}
+	for _, tt := range tests {
+		got, err := runListing(t, append(tt.flags, "gs://out/logs/*.log")...)
+		if err != nil {
+			t.Fatalf("listing with %v: %v", tt.flags, err)
+		}
+		if got != tt.want {
+			t.Errorf("listing with %v wrote\n%s\nwant\n%s", tt.flags, got, tt.want)
+		}
+	}
+
+	// Machine-readable output keeps the no-match message out of the way.
+	opts, args, err := parseArgs([]string{"--names-only", "gs://out/none/*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out, status strings.Builder
+	if err := listObjectsWithWildcard(t.Context(), args, opts, &out, &status); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "" || !strings.Contains(status.String(), "No objects") {
+		t.Errorf("no-match message went to the output %q rather than the status %q", out.String(), status.String())
+	}
+}
+
+// TestSplitPattern checks where patterns split into their literal prefix
+// and the rest, and that escaped wildcards stay in the prefix unescaped.
+func TestSplitPattern(t *testing.T) {
+	tests := []struct {
+		pattern, literal, rest string
+	}{
+		{"logs/2024/*.log", "logs/2024/", "*.log"},
+		{"logs/app.log", "logs/app.log", ""},
+		{"", "", ""},
+		{"**", "", "**"},
+		{"a?c", "a", "?c"},
+		{"data/[ab]/*", "data/", "[ab]/*"},
+		{"x/{a,b}/*", "x/", "{a,b}/*"},
+		{`a\[b]/*`, "a[b]/", "*"},
+		{`a\*b/*.txt`, "a*b/", "*.txt"},
+		{`a\?/\{x}/*`, "a?/{x}/", "*"},
+		{`a\\b/*`, `a\b/`, "*"},
+		{`dir\`, "dir", `\`},
+	}
+	for _, tt := range tests {
+		literal, rest := splitPattern(tt.pattern)
+		if literal != tt.literal || rest != tt.rest {
+			t.Errorf("splitPattern(%q) = %q, %q, want %q, %q", tt.pattern, literal, rest, tt.literal, tt.rest)
+		}
+	}
+}
+
+// TestEscapedPrefix checks that a pattern with escaped wildcards in its
+// literal part lists with that literal as the prefix and matches the
+// objects named with it.
+func TestEscapedPrefix(t *testing.T) {
+	fake := newFakeGCS(t, "esc", []string{"a[b]/x", "a[b]/y/z", "ab/x", "a*b/c.txt", "aXb/c.txt"}, 100)
+	tests := []struct {
+		pattern string
+		prefix  string
+		want    []string
+	}{
+		{`gs://esc/a\[b]/*`, "a[b]/", []string{"a[b]/x"}},
+		{`gs://esc/a\*b/*.txt`, "a*b/", []string{"a*b/c.txt"}},
+		{`gs://esc/a[b]/*`, "a", []string{"ab/x"}},
+	}
+	for _, tt := range tests {
+		before := len(fake.prefixes())
+		got := listedNames(t, tt.pattern)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s matched %v, want %v", tt.pattern, got, tt.want)
+		}
+		if prefixes := fake.prefixes()[before:]; !slices.Equal(prefixes, []string{tt.prefix}) {
+			t.Errorf("%s listed with prefixes %q, want %q", tt.pattern, prefixes, tt.prefix)
+		}
+	}
+}
+
+// TestFlagGroups checks the groups of flags that validate excludes
+// together, which name the flags that are set as they are typed.
+func TestFlagGroups(t *testing.T) {
+	opts, _, err := parseFlags([]string{"--stat", "--grep", "x", "--by-hour", "--json", "--chunk", "10", "gs://b/**"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := opts.perObjectOps(), []string{"--stat", "--grep"}; !slices.Equal(got, want) {
+		t.Errorf("perObjectOps() = %v, want %v", got, want)
+	}
+	if got, want := opts.reportModes(), []string{"--by-hour"}; !slices.Equal(got, want) {
+		t.Errorf("reportModes() = %v, want %v", got, want)
+	}
+	if got, want := opts.outputFormats(), []string{"--json", "--chunk"}; !slices.Equal(got, want) {
+		t.Errorf("outputFormats() = %v, want %v", got, want)
+	}
+	plain, _, err := parseArgs([]string{"gs://b/**"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain.perObjectOps() != nil || plain.reportModes() != nil || plain.outputFormats() != nil {
+		t.Errorf("a plain listing sets %v, %v and %v", plain.perObjectOps(), plain.reportModes(), plain.outputFormats())
+	}
+}
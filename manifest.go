@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+
+	"cloud.google.com/go/storage"
+)
+
+// fileMtimeKey is the custom metadata key under which gsutil cp -P and
+// gsutil rsync -P record a file's modification time, in seconds since the
+// epoch. rsync compares it instead of the object's update time when set.
+const fileMtimeKey = "goog-reserved-file-mtime"
+
+// manifestHeader names the columns of --manifest.
+var manifestHeader = []string{"url", "size", "mtime", "crc32c"}
+
+// manifestFormatter renders matches as CSV rows of the fields gsutil rsync
+// compares when deciding what to copy: the size, the modification time in
+// seconds since the epoch, and the base64 CRC32C checksum.
+type manifestFormatter struct{}
+
+// header writes the column names.
+func (manifestFormatter) header(w io.Writer, targets []listTarget) error {
+	return writeCSVRecord(w, manifestHeader)
+}
+
+// object writes the row of one matched object. The mtime is the one gsutil
+// preserved from the source file if there is one, otherwise the object's
+// update time.
+func (manifestFormatter) object(w io.Writer, attrs *storage.ObjectAttrs) error {
+	mtime := strconv.FormatInt(attrs.Updated.Unix(), 10)
+	if v, ok := attrs.Metadata[fileMtimeKey]; ok {
+		mtime = v
+	}
+	return writeCSVRecord(w, []string{
+		newObjectResult(attrs).gsURL(),
+		strconv.FormatInt(attrs.Size, 10),
+		mtime,
+		encodeCRC32C(attrs.CRC32C),
+	})
+}
+
+// footer writes nothing; the manifest ends after its last row.
+func (manifestFormatter) footer(w io.Writer) error {
+	return nil
+}
+
+// machineReadable reports that a manifest must not contain status messages.
+func (manifestFormatter) machineReadable() bool {
+	return true
+}
+
+// writeCSVRecord writes one CSV record to w, quoting fields as needed.
+func writeCSVRecord(w io.Writer, record []string) error {
+	cw := csv.NewWriter(w)
+	cw.Write(record)
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// metricsHeader names the columns of a --metrics-file.
+var metricsHeader = []string{"timestamp", "bucket", "pattern", "scanned", "matched", "bytes", "duration_ms"}
+
+// appendMetrics appends one CSV record describing the run to the file at
+// path, creating it with a header line first if it does not exist yet.
+// With several patterns, the bucket and pattern columns list them separated
+// by spaces. size is the total size of the matches, or -1 when the run did
+// not fetch sizes, which leaves the column empty.
+//
+// The record is written with a single append, so runs sharing the file do
+// not interleave their lines.
+func (s *scanStats) appendMetrics(path string, targets []listTarget, size int64) error {
+	var buckets, patterns []string
+	for _, t := range targets {
+		if !slices.Contains(buckets, t.bucket) {
+			buckets = append(buckets, t.bucket)
+		}
+		patterns = append(patterns, t.pattern)
+	}
+	sizeField := ""
+	if size >= 0 {
+		sizeField = strconv.FormatInt(size, 10)
+	}
+	record := []string{
+		s.start.UTC().Format(time.RFC3339),
+		strings.Join(buckets, " "),
+		strings.Join(patterns, " "),
+		strconv.Itoa(s.scanned),
+		strconv.Itoa(s.matched),
+		sizeField,
+		strconv.FormatInt(time.Since(s.start).Milliseconds(), 10),
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open metrics file: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to open metrics file: %w", err)
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if info.Size() == 0 {
+		w.Write(metricsHeader)
+	}
+	w.Write(record)
+	w.Flush()
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to write metrics file: %w", err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// errObjectsMissing is returned by --find-missing when some of the expected
+// objects do not exist, so that scripts can test the exit status.
+var errObjectsMissing = errors.New("objects missing")
+
+// expectedObject is one object named in a --find-missing file.
+type expectedObject struct {
+	bucket string
+	name   string
+}
+
+// url returns the object's gs:// URL.
+func (o expectedObject) url() string {
+	return "gs://" + o.bucket + "/" + o.name
+}
+
+// readExpectedObjects reads the --find-missing file at path, or stdin for
+// "-". It holds one gs://bucket/name URL per line, with blank lines and
+// lines starting with # skipped, or is a --manifest CSV, recognized by its
+// header, whose url column is used. Names are exact: wildcards are not
+// expanded.
+func readExpectedObjects(path string, stdin io.Reader) ([]expectedObject, error) {
+	r := stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read --find-missing: %w", err)
+		}
+		defer f.Close()
+		r = f
+	}
+	br := bufio.NewReader(r)
+	var urls []string
+	if first, err := br.Peek(len(strings.Join(manifestHeader, ","))); err == nil && string(first) == strings.Join(manifestHeader, ",") {
+		rows := csv.NewReader(br)
+		rows.FieldsPerRecord = -1
+		records, err := rows.ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read --find-missing manifest %s: %w", path, err)
+		}
+		for _, record := range records[1:] {
+			urls = append(urls, record[0])
+		}
+	} else {
+		scanner := bufio.NewScanner(br)
+		for scanner.Scan() {
+			line := strings.TrimSuffix(scanner.Text(), "\r")
+			if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			urls = append(urls, line)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read --find-missing: %w", err)
+		}
+	}
+
+	var objects []expectedObject
+	for i, u := range urls {
+		bucket, name, err := parseGCSPath(u)
+		if err == nil && name == "" {
+			err = fmt.Errorf("no object name")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("--find-missing entry %d (%q): %w", i+1, u, err)
+		}
+		objects = append(objects, expectedObject{bucket: bucket, name: name})
+	}
+	if len(objects) == 0 {
+		return nil, fmt.Errorf("--find-missing file %s lists no objects", path)
+	}
+	return objects, nil
+}
+
+// findMissing prints, in the order of the file, the expected objects that
+// do not exist, and how many there were on status. It returns
+// errObjectsMissing when there were any.
+//
+// The names are grouped by bucket and directory. A directory with a single
+// expected name costs one metadata request for it. Otherwise the directory
+// is listed from its first expected name to its last, which costs one
+// request per page; if the listing needs more pages than there are names
+// still ahead of it, the remaining names are looked up one by one instead,
+// so no directory costs much more than the cheaper of the two ways.
+func findMissing(ctx context.Context, path string, opts *options, stdin io.Reader, stdout, status io.Writer) error {
+	objects, err := readExpectedObjects(path, stdin)
+	if err != nil {
+		return err
+	}
+	for _, o := range objects {
+		if err := checkBucketAllowed(o.bucket, opts); err != nil {
+			return err
+		}
+	}
+
+	client, err := newStorageClient(ctx, opts, status)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	type dirKey struct{ bucket, dir string }
+	groups := make(map[dirKey][]string)
+	var order []dirKey
+	for _, o := range objects {
+		// A placeholder like "logs/" is listed as a prefix, not an object,
+		// under a "/" delimiter, so it is always looked up on its own.
+		dir := o.name[:strings.LastIndex(o.name, "/")+1]
+		if strings.HasSuffix(o.name, "/") {
+			dir = o.name
+		}
+		k := dirKey{o.bucket, dir}
+		if _, ok := groups[k]; !ok {
+			order = append(order, k)
+		}
+		groups[k] = append(groups[k], o.name)
+	}
+
+	exists := make(map[expectedObject]bool)
+	var lookups []expectedObject
+	for _, k := range order {
+		names := groups[k]
+		slices.Sort(names)
+		names = slices.Compact(names)
+		if len(names) == 1 {
+			lookups = append(lookups, expectedObject{k.bucket, names[0]})
+			continue
+		}
+		found, rest, err := listExpected(ctx, client, k.bucket, k.dir, names, opts)
+		if err != nil {
+			return err
+		}
+		for _, name := range found {
+			exists[expectedObject{k.bucket, name}] = true
+		}
+		for _, name := range rest {
+			lookups = append(lookups, expectedObject{k.bucket, name})
+		}
+	}
+	found, err := statExpected(ctx, client, lookups, opts.concurrency)
+	if err != nil {
+		return err
+	}
+	for _, o := range found {
+		exists[o] = true
+	}
+
+	out := newOutputWriter(stdout, opts.flushEvery)
+	defer out.Flush()
+	missing := 0
+	for _, o := range objects {
+		if exists[o] {
+			continue
+		}
+		// A name listed twice is reported once.
+		exists[o] = true
+		missing++
+		if _, err := fmt.Fprintln(out, o.url()); err != nil {
+			return fmt.Errorf("failed to write output: %w", err)
+		}
+	}
+	if err := out.Flush(); err != nil {
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+	// Every distinct expected object is now in exists.
+	fmt.Fprintf(status, "%d of %d objects are missing\n", missing, len(exists))
+	if missing > 0 {
+		return errObjectsMissing
+	}
+	return nil
+}
+
+// listExpected lists the directory dir of bucket from the first of the
+// sorted names to the last, and returns the names it found. It gives up
+// once it has requested as many pages as there are names left to check,
+// and returns those names as rest, to be looked up one by one.
+func listExpected(ctx context.Context, client *storage.Client, bucket, dir string, names []string, opts *options) (found, rest []string, err error) {
+	query := &storage.Query{
+		Prefix:      dir,
+		Delimiter:   "/",
+		StartOffset: names[0],
+		// Object names cannot contain a NUL, so this ends right after the
+		// last name.
+		EndOffset: names[len(names)-1] + "\x00",
+	}
+	query.SetAttrSelection([]string{"Name"})
+	it := listObjects(ctx, client, bucket, query, opts)
+	pageSize := maxPageSize
+	if opts.pageSize > 0 {
+		pageSize = opts.pageSize
+	}
+	next, seen := 0, 0
+	for next < len(names) {
+		if seen > 0 && seen%pageSize == 0 && seen/pageSize >= len(names)-next {
+			return found, names[next:], nil
+		}
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to list gs://%s/%s: %w", bucket, dir, err)
+		}
+		seen++
+		listed := attrs.Name
+		if listed == "" {
+			listed = attrs.Prefix
+		}
+		for next < len(names) && names[next] < listed {
+			next++
+		}
+		if next < len(names) && names[next] == listed && attrs.Name != "" {
+			found = append(found, listed)
+			next++
+		}
+	}
+	return found, nil, nil
+}
+
+// statExpected looks up each object's metadata, up to concurrency at a
+// time, and returns those that exist. Any error other than the object not
+// existing fails the check.
+func statExpected(ctx context.Context, client *storage.Client, objects []expectedObject, concurrency int) ([]expectedObject, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		mu       sync.Mutex
+		found    []expectedObject
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	jobs := make(chan expectedObject)
+	for range min(concurrency, len(objects)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for o := range jobs {
+				_, err := client.Bucket(o.bucket).Object(o.name).Attrs(ctx)
+				mu.Lock()
+				switch {
+				case err == nil:
+					found = append(found, o)
+				case !errors.Is(err, storage.ErrObjectNotExist) && firstErr == nil:
+					firstErr = fmt.Errorf("failed to look up %s: %w", o.url(), err)
+					cancel()
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, o := range objects {
+		select {
+		case jobs <- o:
+		case <-ctx.Done():
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return found, ctx.Err()
+}
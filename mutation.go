@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// errScanMutated is returned by --detect-mutation when the matched set
+// changed while it was being listed, after reporting how, so that a
+// snapshot taken from the listing can be thrown away.
+var errScanMutated = errors.New("objects changed during the scan")
+
+// mutationReportLimit is how many changed objects --detect-mutation names
+// before only counting the rest.
+const mutationReportLimit = 10
+
+// mutationCheck looks for signs that the matches changed under the scan of
+// --detect-mutation. While listing, a match updated after the scan started
+// was written while it ran. Once the listing is done, a random sample of
+// the matches is looked up again, and one with another generation or
+// metageneration, or gone, changed after it was listed. Neither check sees
+// everything; the aim is to catch a busy prefix cheaply, not to prove a
+// quiet one.
+type mutationCheck struct {
+	started time.Time
+	sample  *sampleBuffer
+	// updated holds the matches written during the scan, up to
+	// mutationReportLimit, and updatedCount all of them.
+	updated      []*storage.ObjectAttrs
+	updatedCount int
+}
+
+// newMutationCheck returns the check of --detect-mutation, re-checking
+// sampleSize matches after the listing, or nil without the option.
+func newMutationCheck(opts *options) *mutationCheck {
+	if !opts.detectMutation {
+		return nil
+	}
+	return &mutationCheck{
+		started: time.Now(),
+		sample:  newSampleBuffer(opts.mutationSample, uint64(time.Now().UnixNano())),
+	}
+}
+
+// seen records a match of the scan. It does nothing on a nil check.
+func (m *mutationCheck) seen(attrs *storage.ObjectAttrs) {
+	if m == nil {
+		return
+	}
+	if attrs.Updated.After(m.started) {
+		if m.updatedCount < mutationReportLimit {
+			m.updated = append(m.updated, attrs)
+		}
+		m.updatedCount++
+	}
+	m.sample.add(attrs)
+}
+
+// result looks up the sampled matches again, with up to concurrency at a
+// time, and reports on status what changed during the scan. It returns
+// errScanMutated if anything did, and does nothing on a nil check.
+func (m *mutationCheck) result(ctx context.Context, client *storage.Client, concurrency int, status io.Writer) error {
+	if m == nil {
+		return nil
+	}
+	var sampled []*storage.ObjectAttrs
+	m.sample.each(func(attrs *storage.ObjectAttrs) error {
+		sampled = append(sampled, attrs)
+		return nil
+	})
+	changes, err := recheckObjects(ctx, client, sampled, concurrency)
+	if err != nil {
+		return err
+	}
+
+	for _, attrs := range m.updated {
+		fmt.Fprintf(status, "Changed during the scan: gs://%s/%s was updated at %s, after the scan started at %s\n",
+			attrs.Bucket, attrs.Name, attrs.Updated.UTC().Format(time.RFC3339), m.started.UTC().Format(time.RFC3339))
+	}
+	if more := m.updatedCount - len(m.updated); more > 0 {
+		fmt.Fprintf(status, "Changed during the scan: %d more objects updated after the scan started\n", more)
+	}
+	for i, c := range changes {
+		if i == mutationReportLimit {
+			fmt.Fprintf(status, "Changed after being listed: %d more of the sampled objects\n", len(changes)-i)
+			break
+		}
+		fmt.Fprintf(status, "Changed after being listed: %s\n", c)
+	}
+	fmt.Fprintf(status, "Mutation check: %d matches updated during the scan, %d of %d sampled matches changed since listed\n",
+		m.updatedCount, len(changes), len(sampled))
+	if m.updatedCount > 0 || len(changes) > 0 {
+		return errScanMutated
+	}
+	return nil
+}
+
+// recheckObjects looks up each listed object again and describes those
+// whose generation or metageneration changed, or that no longer exist, in
+// the order of listed.
+func recheckObjects(ctx context.Context, client *storage.Client, listed []*storage.ObjectAttrs, concurrency int) ([]string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		mu       sync.Mutex
+		changes  = make([]string, len(listed))
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	jobs := make(chan int)
+	for range min(concurrency, len(listed)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				was := listed[i]
+				url := "gs://" + was.Bucket + "/" + was.Name
+				now, err := client.Bucket(was.Bucket).Object(was.Name).Attrs(ctx)
+				mu.Lock()
+				switch {
+				case errors.Is(err, storage.ErrObjectNotExist):
+					changes[i] = url + " was deleted"
+				case err != nil:
+					if firstErr == nil {
+						firstErr = fmt.Errorf("failed to re-check %s: %w", url, err)
+						cancel()
+					}
+				case now.Generation != was.Generation:
+					changes[i] = fmt.Sprintf("%s was rewritten, generation %d -> %d", url, was.Generation, now.Generation)
+				case now.Metageneration != was.Metageneration:
+					changes[i] = fmt.Sprintf("%s had its metadata updated, metageneration %d -> %d", url, was.Metageneration, now.Metageneration)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := range listed {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var found []string
+	for _, c := range changes {
+		if c != "" {
+			found = append(found, c)
+		}
+	}
+	return found, nil
+}
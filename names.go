@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+// nameSet keeps only the objects whose names are in an --include-names
+// file, and remembers which of the names were found.
+type nameSet struct {
+	path string
+	// found maps each name to whether a scanned object had it.
+	found map[string]bool
+}
+
+// loadNameSet reads the --include-names file at path, one exact object
+// name per line. Names are taken verbatim, since object names may start or
+// end with spaces; only blank lines are skipped. It returns nil when path
+// is empty.
+func loadNameSet(path string) (*nameSet, error) {
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read --include-names: %w", err)
+	}
+	defer f.Close()
+	s := &nameSet{path: path, found: make(map[string]bool)}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		name := strings.TrimSuffix(scanner.Text(), "\r")
+		if name != "" {
+			s.found[name] = false
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read --include-names: %w", err)
+	}
+	if len(s.found) == 0 {
+		return nil, fmt.Errorf("--include-names file %s lists no names", path)
+	}
+	return s, nil
+}
+
+// accept is the objectFilter for the name set.
+func (s *nameSet) accept(attrs *storage.ObjectAttrs) bool {
+	if _, ok := s.found[attrs.Name]; !ok {
+		return false
+	}
+	s.found[attrs.Name] = true
+	return true
+}
+
+// reportMissing writes how many of the names no matched object had. It
+// does nothing for a nil set.
+func (s *nameSet) reportMissing(w io.Writer) {
+	if s == nil {
+		return
+	}
+	missing := 0
+	for _, found := range s.found {
+		if !found {
+			missing++
+		}
+	}
+	if missing > 0 {
+		fmt.Fprintf(w, "%d of the %d names in %s were not found\n", missing, len(s.found), s.path)
+	}
+}
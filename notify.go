@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// previewNotification prints the object name prefix that a Pub/Sub
+// notification on the bucket could filter on to see the objects matching
+// gcsPath, with a gcloud command to create it. Notifications only filter by
+// prefix, so a warning explains when the pattern matches more narrowly than
+// the notification would. No GCS request is made.
+func previewNotification(gcsPath string, opts *options, stdout io.Writer) error {
+	target, err := resolveTarget(gcsPath, opts)
+	if err != nil {
+		return err
+	}
+
+	out := newOutputWriter(stdout, opts.flushEvery)
+	defer out.Flush()
+
+	fmt.Fprintf(out, "Notification filter for %s:\n", target.url())
+	fmt.Fprintf(out, "  object_name_prefix: %q\n", target.prefix)
+	cmd := "gcloud storage buckets notifications create gs://" + target.bucket + " --topic=TOPIC"
+	if target.prefix != "" {
+		cmd += " --object-prefix=" + shellQuote(target.prefix)
+	}
+	fmt.Fprintf(out, "  gcloud: %s\n", cmd)
+
+	// Everything after the prefix that is not "**" narrows the match in a
+	// way that a prefix filter cannot.
+	_, rest := splitPattern(target.pattern)
+	switch {
+	case opts.ignoreCase || opts.urlDecode || opts.matchOn == matchOnURL:
+		fmt.Fprintf(out, "Warning: --ignore-case, --url-decode and --match-on url change how names match; "+
+			"the notification compares the stored name with the prefix exactly.\n")
+	case rest == "":
+		fmt.Fprintf(out, "Warning: the pattern names a single object, but the notification also fires for "+
+			"every object whose name starts with %q.\n", target.prefix)
+	case rest != "**":
+		fmt.Fprintf(out, "Warning: the pattern also needs %q to match after the prefix, which a notification "+
+			"cannot express; it fires for every object under the prefix, so the subscriber must filter.\n", rest)
+	}
+
+	if err := out.Flush(); err != nil {
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"iter"
+
+	"cloud.google.com/go/storage"
+)
+
+// errStreamStopped ends a scan whose stream the ordered merge no longer
+// reads.
+var errStreamStopped = errors.New("stream stopped")
+
+// orderedStream is one scan of an ordered merge, pulled one match at a
+// time. head is the next match, or nil once the scan is done.
+type orderedStream struct {
+	bucket string
+	next   func() (*storage.ObjectAttrs, bool)
+	stop   func()
+	head   *storage.ObjectAttrs
+	err    error
+}
+
+// scanOrdered runs the scans as one listing sorted by name, then bucket,
+// for --ordered. GCS returns each scan's objects in name order, so the
+// scans are merged as they go, always emitting the smallest of their next
+// matches: every scan holds at most one page in memory, but the first match
+// waits for the first page of every scan. The scans run as coroutines of
+// the caller, never at the same time, so they can share stats. Like the
+// sequential scan loop, it stops a bucket's scans when emit returns
+// errBucketLimitReached and the whole merge on errLimitReached.
+func scanOrdered(ctx context.Context, client *storage.Client, scans []listTarget, opts *options,
+	filters []objectFilter, stats *scanStats, emit func(*storage.ObjectAttrs) error) error {
+	order := objectOrder(sortName, false, nil, nil)
+	streams := make([]*orderedStream, len(scans))
+	for i, t := range scans {
+		s := &orderedStream{bucket: t.bucket}
+		s.next, s.stop = iter.Pull(func(yield func(*storage.ObjectAttrs) bool) {
+			s.err = scanMatches(ctx, client, t, opts, filters, stats, func(attrs *storage.ObjectAttrs) error {
+				if !yield(attrs) {
+					return errStreamStopped
+				}
+				return nil
+			})
+		})
+		defer s.stop()
+		streams[i] = s
+	}
+
+	// advance moves a stream to its next match, returning the error that
+	// ended its scan, if any.
+	advance := func(s *orderedStream) error {
+		attrs, ok := s.next()
+		if ok {
+			s.head = attrs
+			return nil
+		}
+		s.head = nil
+		return s.err
+	}
+	for _, s := range streams {
+		if err := advance(s); err != nil {
+			return err
+		}
+	}
+
+	// Scans are few, so a linear scan for the smallest head is fast
+	// enough. Ties go to the earlier scan.
+	for {
+		var first *orderedStream
+		for _, s := range streams {
+			if s.head != nil && (first == nil || order(s.head, first.head) < 0) {
+				first = s
+			}
+		}
+		if first == nil {
+			return nil
+		}
+		err := emit(first.head)
+		switch {
+		case errors.Is(err, errBucketLimitReached):
+			for _, s := range streams {
+				if s.bucket == first.bucket {
+					s.stop()
+					s.head = nil
+				}
+			}
+			continue
+		case errors.Is(err, errLimitReached):
+			return nil
+		case err != nil:
+			return err
+		}
+		if err := advance(first); err != nil {
+			return err
+		}
+	}
+}
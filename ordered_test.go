@@ -0,0 +1,39 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// TestOrdered checks that --ordered merges the scans of several buckets
+// into one listing sorted by name, then bucket, where the default lists
+// them one pattern after the other.
+func TestOrdered(t *testing.T) {
+	fake := newFakeGCS(t, "o1", nil, 1)
+	for _, o := range []string{"o1/a/1", "o1/a/3", "o1/b/6", "o2/a/2", "o2/a/3", "o2/b/5", "o3/a/0"} {
+		bucket, name, _ := strings.Cut(o, "/")
+		fake.objects = append(fake.objects, fakeObject{bucket: bucket, name: name, generation: 1})
+	}
+	patterns := []string{"gs://o2/**", "gs://o1/**", "gs://o3/a/*"}
+	tests := []struct {
+		flags []string
+		want  []string
+	}{
+		{nil, []string{"gs://o2/a/2", "gs://o2/a/3", "gs://o2/b/5", "gs://o1/a/1", "gs://o1/a/3", "gs://o1/b/6", "gs://o3/a/0"}},
+		{[]string{"--ordered"}, []string{"gs://o3/a/0", "gs://o1/a/1", "gs://o2/a/2", "gs://o1/a/3", "gs://o2/a/3", "gs://o2/b/5", "gs://o1/b/6"}},
+		{[]string{"--ordered", "--limit", "3"}, []string{"gs://o3/a/0", "gs://o1/a/1", "gs://o2/a/2"}},
+	}
+	for _, tt := range tests {
+		out, err := runListing(t, slices.Concat(tt.flags, patterns)...)
+		if err != nil {
+			t.Fatalf("listing with %v: %v", tt.flags, err)
+		}
+		// The first lines list the patterns.
+		lines := strings.Split(strings.TrimSpace(out), "\n")
+		got := slices.DeleteFunc(lines, func(l string) bool { return !strings.HasPrefix(l, "gs://") })
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("listing with %v = %v, want %v", tt.flags, got, tt.want)
+		}
+	}
+}
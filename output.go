@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"hash"
+	"io"
+	"syscall"
+)
+
+// outputWriter buffers standard output and flushes it every flushEvery
+// lines, so that a reader such as `head` sees results as they are found
+// while large listings still avoid one write system call per line. A
+// flushEvery of 0 only flushes when Flush is called.
+type outputWriter struct {
+	buf        *bufio.Writer
+	flushEvery int
+	lines      int
+	// err is the first write error; once set, every later write fails too.
+	err error
+	// checksum, when set, sums up everything written, for the
+	// --manifest-checksum trailer.
+	checksum hash.Hash
+	// gz, with --gzip, compresses the output on its way to the underlying
+	// writer. The periodic flushes only hand lines to it, which compresses
+	// far better than flushing the stream every line.
+	gz *gzip.Writer
+}
+
+// newOutputWriter returns an outputWriter over w.
+func newOutputWriter(w io.Writer, flushEvery int) *outputWriter {
+	return &outputWriter{buf: bufio.NewWriter(w), flushEvery: flushEvery}
+}
+
+// newGzipOutputWriter returns an outputWriter writing a gzip stream to w.
+// The stream is only complete once Close is called.
+func newGzipOutputWriter(w io.Writer, flushEvery int) *outputWriter {
+	gz := gzip.NewWriter(w)
+	return &outputWriter{buf: bufio.NewWriter(gz), flushEvery: flushEvery, gz: gz}
+}
+
+// Write buffers p, flushing once enough complete lines have accumulated.
+func (o *outputWriter) Write(p []byte) (int, error) {
+	if o.err != nil {
+		return 0, o.err
+	}
+	n, err := o.buf.Write(p)
+	if o.checksum != nil {
+		o.checksum.Write(p[:n])
+	}
+	if err == nil && o.flushEvery > 0 {
+		o.lines += bytes.Count(p, []byte{'\n'})
+		if o.lines >= o.flushEvery {
+			o.lines = 0
+			err = o.buf.Flush()
+		}
+	}
+	if err != nil {
+		o.err = err
+	}
+	return n, err
+}
+
+// Flush writes any buffered output. A gzip stream is flushed too, so that
+// a reader decompressing it as it comes in has every line written so far.
+func (o *outputWriter) Flush() error {
+	if o.err != nil {
+		return o.err
+	}
+	if err := o.buf.Flush(); err != nil {
+		o.err = err
+	} else if o.gz != nil {
+		o.err = o.gz.Flush()
+	}
+	return o.err
+}
+
+// Close flushes the output and ends a gzip stream with its trailer, without
+// which the output reads as truncated. It can be called more than once.
+func (o *outputWriter) Close() error {
+	if err := o.Flush(); err != nil || o.gz == nil {
+		return err
+	}
+	if err := o.gz.Close(); err != nil {
+		o.err = err
+	}
+	return o.err
+}
+
+// isBrokenPipe reports whether err comes from writing to a pipe whose reader
+// has gone away, e.g. `gcsls ... | head -5` after head has exited.
+func isBrokenPipe(err error) bool {
+	return errors.Is(err, syscall.EPIPE)
+}
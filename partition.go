@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"slices"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// hivePartition is one key=value directory segment of an object name, as
+// Hive-style partitioned tables lay out their files.
+type hivePartition struct {
+	key   string
+	value string
+}
+
+// hivePartitions returns the key=value segments among the directories of
+// an object name, in order. The base name is never a partition. Keys and
+// values are unescaped, since Hive writes characters such as "/" and "="
+// in them as %XX; a segment that does not decode is taken as it is.
+func hivePartitions(name string) []hivePartition {
+	segments := strings.Split(name, "/")
+	var parts []hivePartition
+	for _, s := range segments[:len(segments)-1] {
+		key, value, ok := strings.Cut(s, "=")
+		if !ok || key == "" {
+			continue
+		}
+		if k, err := url.PathUnescape(key); err == nil {
+			key = k
+		}
+		if v, err := url.PathUnescape(value); err == nil {
+			value = v
+		}
+		parts = append(parts, hivePartition{key: key, value: value})
+	}
+	return parts
+}
+
+// partitionMap returns the partitions of an object name by key, or nil
+// when it has none.
+func partitionMap(name string) map[string]string {
+	parts := hivePartitions(name)
+	if len(parts) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(parts))
+	for _, p := range parts {
+		if _, ok := m[p.key]; !ok {
+			m[p.key] = p.value
+		}
+	}
+	return m
+}
+
+// partitionMatch is one --partition KEY=GLOB condition on the partitions
+// of an object name.
+type partitionMatch struct {
+	key     string
+	pattern string
+}
+
+// parsePartitionMatch parses a --partition value.
+func parsePartitionMatch(v string) (partitionMatch, error) {
+	key, pattern, ok := strings.Cut(v, "=")
+	if !ok || key == "" {
+		return partitionMatch{}, fmt.Errorf("expected KEY=GLOB, got %q", v)
+	}
+	if !doublestar.ValidatePattern(pattern) {
+		return partitionMatch{}, fmt.Errorf("invalid pattern %q for partition key %q", pattern, key)
+	}
+	return partitionMatch{key: key, pattern: pattern}, nil
+}
+
+// accept is the objectFilter keeping objects whose value for the partition
+// key matches the glob. Objects without the key are dropped, and when a
+// name repeats the key, its first value counts.
+func (m partitionMatch) accept(attrs *storage.ObjectAttrs) bool {
+	for _, p := range hivePartitions(attrs.Name) {
+		if p.key == m.key {
+			// The pattern was validated when parsed, so Match cannot fail here.
+			matched, _ := doublestar.Match(m.pattern, p.value)
+			return matched
+		}
+	}
+	return false
+}
+
+// parsePartitionSpec parses a --partition-spec list of keys.
+func parsePartitionSpec(v string) ([]string, error) {
+	keys := strings.Split(v, ",")
+	for i, key := range keys {
+		key = strings.TrimSpace(key)
+		if key == "" || strings.ContainsAny(key, "=/") {
+			return nil, fmt.Errorf("invalid partition key %q in %q", key, v)
+		}
+		if slices.Contains(keys[:i], key) {
+			return nil, fmt.Errorf("partition key %q is repeated in %q", key, v)
+		}
+		keys[i] = key
+	}
+	return keys, nil
+}
+
+// partitionSpecFilter keeps objects whose partitions are exactly the keys
+// of --partition-spec, in order, so that stray files beside a partitioned
+// table (a _SUCCESS marker, a file from an older layout) are left out. It
+// counts the objects it drops, keeping one name as an example.
+type partitionSpecFilter struct {
+	keys    []string
+	skipped int
+	example string
+}
+
+// active reports whether a partition spec was given.
+func (f *partitionSpecFilter) active() bool {
+	return len(f.keys) > 0
+}
+
+// accept is the objectFilter for the partition spec. Directories that are
+// not key=value segments, such as the table's own prefix, are ignored.
+func (f *partitionSpecFilter) accept(attrs *storage.ObjectAttrs) bool {
+	parts := hivePartitions(attrs.Name)
+	ok := len(parts) == len(f.keys)
+	for i := 0; ok && i < len(parts); i++ {
+		ok = parts[i].key == f.keys[i]
+	}
+	if !ok {
+		if f.skipped == 0 {
+			f.example = attrs.Name
+		}
+		f.skipped++
+	}
+	return ok
+}
+
+// reportSkipped writes how many objects did not follow the spec.
+func (f *partitionSpecFilter) reportSkipped(w io.Writer) {
+	if f.skipped > 0 {
+		fmt.Fprintf(w, "Skipped %d objects not partitioned by %s, such as %s\n",
+			f.skipped, strings.Join(f.keys, ","), f.example)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+// readPatternFile reads the labelled patterns of --pattern-file, one
+// "label: gs://bucket/pattern" per line. Blank lines and lines starting
+// with # are skipped. It returns the patterns and their labels in file
+// order.
+func readPatternFile(path string) (patterns, labels []string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read pattern file: %w", err)
+	}
+	defer f.Close()
+
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		label, pattern, ok := strings.Cut(line, ":")
+		label, pattern = strings.TrimSpace(label), strings.TrimSpace(pattern)
+		if !ok || label == "" || !strings.HasPrefix(pattern, "gs://") {
+			return nil, nil, fmt.Errorf("%s:%d: expected \"label: gs://bucket/pattern\", got %q", path, n, line)
+		}
+		if strings.ContainsAny(label, " \t") {
+			return nil, nil, fmt.Errorf("%s:%d: label %q must not contain spaces", path, n, label)
+		}
+		if seen[label] {
+			return nil, nil, fmt.Errorf("%s:%d: duplicate label %q", path, n, label)
+		}
+		seen[label] = true
+		patterns = append(patterns, pattern)
+		labels = append(labels, label)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to read pattern file: %w", err)
+	}
+	if len(patterns) == 0 {
+		return nil, nil, fmt.Errorf("pattern file %s has no patterns", path)
+	}
+	return patterns, labels, nil
+}
+
+// objectLabels finds the label of the pattern a matched object belongs to
+// and counts the matches per label. An object matching several patterns
+// gets the label of the first of them in the file.
+type objectLabels struct {
+	targets []listTarget
+	match   map[string]func(string) (int, error)
+	group   map[string][]listTarget
+	counts  map[string]int
+}
+
+// newObjectLabels returns the labeller for targets read from a pattern
+// file, or nil if the targets have no labels.
+func newObjectLabels(targets []listTarget, opts *options) *objectLabels {
+	if len(targets) == 0 || targets[0].label == "" {
+		return nil
+	}
+	l := &objectLabels{
+		targets: targets,
+		match:   make(map[string]func(string) (int, error)),
+		group:   make(map[string][]listTarget),
+		counts:  make(map[string]int),
+	}
+	for _, t := range targets {
+		l.group[t.bucket] = append(l.group[t.bucket], t)
+	}
+	for bucket, group := range l.group {
+		l.match[bucket] = newTargetMatcher(listTarget{bucket: bucket, group: group}, opts)
+	}
+	return l
+}
+
+// of returns the label of the object, or "" without labels.
+func (l *objectLabels) of(attrs *storage.ObjectAttrs) string {
+	if l == nil {
+		return ""
+	}
+	match, ok := l.match[attrs.Bucket]
+	if !ok {
+		return ""
+	}
+	// The name already matched during the scan, so this cannot fail.
+	i, _ := match(attrs.Name)
+	if i < 0 {
+		return ""
+	}
+	return l.group[attrs.Bucket][i].label
+}
+
+// count records one delivered match under its label.
+func (l *objectLabels) count(attrs *storage.ObjectAttrs) {
+	if l != nil {
+		l.counts[l.of(attrs)]++
+	}
+}
+
+// printCounts writes the number of matches per label, in file order.
+func (l *objectLabels) printCounts(w io.Writer) {
+	fmt.Fprintf(w, "\nMatches per label:\n")
+	for _, t := range l.targets {
+		fmt.Fprintf(w, "  %8d  %s\n", l.counts[t.label], t.label)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// objectFunc is a per-object operation, such as fetching full metadata,
+// that issues its own API call for a single matched object.
+type objectFunc func(ctx context.Context, attrs *storage.ObjectAttrs) error
+
+// chainObjectFuncs combines several per-object operations into one that runs
+// them in order, stopping at the first failure.
+func chainObjectFuncs(fns []objectFunc) objectFunc {
+	if len(fns) == 1 {
+		return fns[0]
+	}
+	return func(ctx context.Context, attrs *storage.ObjectAttrs) error {
+		for _, fn := range fns {
+			if err := fn(ctx, attrs); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// withObjectTimeout bounds each call of fn by its own deadline, so that one
+// hung object fails alone instead of stalling the run. The error then says
+// that the operation timed out, unless the run as a whole was stopped.
+func withObjectTimeout(fn objectFunc, timeout time.Duration) objectFunc {
+	return func(ctx context.Context, attrs *storage.ObjectAttrs) error {
+		opCtx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		err := fn(opCtx, attrs)
+		if err != nil && ctx.Err() == nil && errors.Is(opCtx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("timed out after %s: %w", timeout, err)
+		}
+		return err
+	}
+}
+
+// workerPool runs an objectFunc for each submitted object on a bounded
+// number of goroutines. Without keepGoing, the first failure cancels the
+// pool's context so no further objects are dispatched.
+type workerPool struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	jobs   chan *storage.ObjectAttrs
+	wg     sync.WaitGroup
+	closed sync.Once
+
+	keepGoing bool
+	// status receives the per-object errors reported under keepGoing,
+	// unless errors records every failure instead.
+	status io.Writer
+	errors *errorLog
+	// metrics, when set, times the dispatches for --pool-metrics.
+	metrics *poolMetrics
+	// reorder, when set, writes the operations' output in listing order.
+	reorder *reorderBuffer
+
+	mu       sync.Mutex
+	firstErr error
+	failures int
+	total    int
+}
+
+// newWorkerPool starts concurrency workers that apply fn to submitted objects.
+// The returned pool's ctx should be used by the caller for any work that must
+// stop when the pool is cancelled. Failures under keepGoing are reported to
+// status.
+func newWorkerPool(ctx context.Context, concurrency int, keepGoing bool, status io.Writer, fn objectFunc) *workerPool {
+	ctx, cancel := context.WithCancel(ctx)
+	jobs := make(chan *storage.ObjectAttrs)
+	p := &workerPool{
+		ctx:       ctx,
+		cancel:    cancel,
+		jobs:      jobs,
+		keepGoing: keepGoing,
+		status:    status,
+	}
+	for i := 0; i < concurrency; i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for attrs := range jobs {
+				ctx, done := p.reorder.start(p.ctx, attrs)
+				// Skip queued work once the pool has been cancelled.
+				if p.ctx.Err() == nil {
+					if err := fn(ctx, attrs); err != nil {
+						p.fail(attrs, err)
+					}
+				}
+				done()
+			}
+		}()
+	}
+	return p
+}
+
+// fail records a per-object failure, cancelling the pool unless keepGoing is set.
+func (p *workerPool) fail(attrs *storage.ObjectAttrs, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Operations aborted because of an earlier failure are not failures themselves.
+	if p.firstErr != nil && errors.Is(err, context.Canceled) {
+		return
+	}
+	p.failures++
+	if p.firstErr == nil {
+		p.firstErr = fmt.Errorf("gs://%s/%s: %w", attrs.Bucket, attrs.Name, err)
+	}
+	logged := p.errors.object(attrs, err)
+	if p.keepGoing {
+		if !logged {
+			fmt.Fprintf(p.status, "Error: gs://%s/%s: %v\n", attrs.Bucket, attrs.Name, err)
+		}
+		return
+	}
+	p.cancel()
+}
+
+// submit hands an object to the next free worker, blocking while all workers
+// are busy. It returns the context error once the pool has been cancelled.
+func (p *workerPool) submit(attrs *storage.ObjectAttrs) error {
+	defer p.metrics.dispatching()()
+	p.reorder.reserve(attrs)
+	select {
+	case p.jobs <- attrs:
+		p.mu.Lock()
+		p.total++
+		p.mu.Unlock()
+		return nil
+	case <-p.ctx.Done():
+		return p.ctx.Err()
+	}
+}
+
+// wait stops accepting work, waits for in-flight operations to finish, and
+// returns the first failure (or a failure count under keepGoing). It is safe
+// to call more than once.
+func (p *workerPool) wait() error {
+	p.closed.Do(func() { close(p.jobs) })
+	p.wg.Wait()
+	p.cancel()
+	p.metrics.finish()
+	werr := p.reorder.flush()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.firstErr == nil {
+		if werr != nil {
+			return fmt.Errorf("failed to write output: %w", werr)
+		}
+		return nil
+	}
+	if p.keepGoing {
+		return fmt.Errorf("%d of %d per-object operations failed", p.failures, p.total)
+	}
+	return p.firstErr
+}
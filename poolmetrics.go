@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// poolMetricsInterval is how often --pool-metrics reports on the pool.
+const poolMetricsInterval = 5 * time.Second
+
+// poolMetrics samples the per-object worker pool for --pool-metrics: how
+// many workers are running an operation, how many matches wait for one, and
+// how the time divides between busy workers, idle ones, and a listing held
+// up for lack of a free worker. The counters are atomics updated once per
+// operation and per dispatch, which costs next to nothing beside an API
+// call.
+type poolMetrics struct {
+	workers int
+	status  io.Writer
+	start   time.Time
+
+	active  atomic.Int64
+	waiting atomic.Int64
+	done    atomic.Int64
+	// busy is the total time spent in operations, summed over workers,
+	// and blocked the total time the listing waited to dispatch a match.
+	busy    atomic.Int64
+	blocked atomic.Int64
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	stopped  chan struct{}
+}
+
+// newPoolMetrics starts reporting on a pool of workers to status every
+// poolMetricsInterval, until finish is called.
+func newPoolMetrics(workers int, status io.Writer) *poolMetrics {
+	m := &poolMetrics{
+		workers: workers,
+		status:  status,
+		start:   time.Now(),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go m.report()
+	return m
+}
+
+// wrap counts each call of fn, a per-object operation, as a busy worker.
+func (m *poolMetrics) wrap(fn objectFunc) objectFunc {
+	return func(ctx context.Context, attrs *storage.ObjectAttrs) error {
+		m.active.Add(1)
+		start := time.Now()
+		err := fn(ctx, attrs)
+		m.busy.Add(int64(time.Since(start)))
+		m.active.Add(-1)
+		m.done.Add(1)
+		return err
+	}
+}
+
+// dispatching counts a match waiting for a free worker, and returns the
+// function to call once a worker has taken it. It does nothing on nil
+// metrics.
+func (m *poolMetrics) dispatching() func() {
+	if m == nil {
+		return func() {}
+	}
+	m.waiting.Add(1)
+	start := time.Now()
+	return func() {
+		m.blocked.Add(int64(time.Since(start)))
+		m.waiting.Add(-1)
+	}
+}
+
+// report prints a line for each interval, with the state of the pool at
+// its end and what happened during it:
+//
+//	Pool: 8/8 workers active, 1 waiting; last 5s: 212 done, 97% busy, 1.2s idle, listing blocked 4.6s
+func (m *poolMetrics) report() {
+	defer close(m.stopped)
+	ticker := time.NewTicker(poolMetricsInterval)
+	defer ticker.Stop()
+	var lastDone, lastBusy, lastBlocked int64
+	last := m.start
+	for {
+		select {
+		case <-m.stop:
+			return
+		case now := <-ticker.C:
+			done, busy, blocked := m.done.Load(), m.busy.Load(), m.blocked.Load()
+			elapsed := now.Sub(last)
+			capacity := time.Duration(m.workers) * elapsed
+			busyIn := time.Duration(busy - lastBusy)
+			fmt.Fprintf(m.status, "Pool: %d/%d workers active, %d waiting; last %s: %d done, %.0f%% busy, %s idle, listing blocked %s\n",
+				m.active.Load(), m.workers, m.waiting.Load(), elapsed.Round(time.Second), done-lastDone,
+				100*busyIn.Seconds()/capacity.Seconds(), max(capacity-busyIn, 0).Round(100*time.Millisecond),
+				time.Duration(blocked-lastBlocked).Round(100*time.Millisecond))
+			lastDone, lastBusy, lastBlocked, last = done, busy, blocked, now
+		}
+	}
+}
+
+// finish stops the reports and prints the totals of the run, with a hint
+// at which way to move --concurrency: a listing that was often blocked
+// had too few workers, and workers that were often idle had too little to
+// do. It does nothing on nil metrics, and only prints once.
+func (m *poolMetrics) finish() {
+	if m == nil {
+		return
+	}
+	m.stopOnce.Do(func() {
+		close(m.stop)
+		<-m.stopped
+		elapsed := time.Since(m.start)
+		capacity := time.Duration(m.workers) * elapsed
+		busy, blocked := time.Duration(m.busy.Load()), time.Duration(m.blocked.Load())
+		utilization := busy.Seconds() / capacity.Seconds()
+		fmt.Fprintf(m.status, "Pool totals: %d operations on %d workers in %s, %.0f%% busy, %s idle, listing blocked %s\n",
+			m.done.Load(), m.workers, elapsed.Round(time.Millisecond), 100*utilization,
+			max(capacity-busy, 0).Round(100*time.Millisecond), blocked.Round(100*time.Millisecond))
+		switch {
+		case blocked > elapsed/2:
+			fmt.Fprintln(m.status, "The workers were saturated for most of the run; a higher --concurrency may go faster")
+		case utilization < 0.5:
+			fmt.Fprintln(m.status, "The workers were idle for most of the run, waiting for the listing; a lower --concurrency would do")
+		}
+	})
+}
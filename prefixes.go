@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// readPrefixes reads the --prefixes-from file at path, one object name
+// prefix per line. Blank lines and lines starting with "#" are skipped.
+func readPrefixes(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read --prefixes-from: %w", err)
+	}
+	defer f.Close()
+	var prefixes []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		prefixes = append(prefixes, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read --prefixes-from: %w", err)
+	}
+	if len(prefixes) == 0 {
+		return nil, fmt.Errorf("--prefixes-from file %s lists no prefixes", path)
+	}
+	return prefixes, nil
+}
+
+// narrowPrefixes returns the prefixes to list for a pattern whose literal
+// prefix is base, in name order: each of prefixes lengthened to base if
+// base extends it, and none that cannot match the pattern at all. A
+// prefix that repeats or extends another is dropped, since the other's
+// listing returns its objects already, so no object is listed twice.
+func narrowPrefixes(base string, prefixes []string) []string {
+	var narrowed []string
+	for _, p := range prefixes {
+		switch {
+		case strings.HasPrefix(p, base):
+			narrowed = append(narrowed, p)
+		case strings.HasPrefix(base, p):
+			narrowed = append(narrowed, base)
+		}
+	}
+	// In name order, a prefix that extends another comes right after it or
+	// after other prefixes that extend it too.
+	slices.Sort(narrowed)
+	var disjoint []string
+	for _, p := range narrowed {
+		if n := len(disjoint); n > 0 && strings.HasPrefix(p, disjoint[n-1]) {
+			continue
+		}
+		disjoint = append(disjoint, p)
+	}
+	return disjoint
+}
+
+// prefixOf returns the one of prefixes, disjoint and in name order as
+// narrowPrefixes returns them, that name starts with, or "" if none does.
+func prefixOf(prefixes []string, name string) string {
+	// The prefix of a name sorts before it, and after any prefix that is
+	// not one of its own.
+	i := sort.SearchStrings(prefixes, name)
+	if i < len(prefixes) && prefixes[i] == name {
+		return name
+	}
+	if i > 0 && strings.HasPrefix(name, prefixes[i-1]) {
+		return prefixes[i-1]
+	}
+	return ""
+}
+
+// listPrefixes lists query under each of prefixes instead of its own
+// prefix, on up to n listings at a time, and returns a next function with
+// the contract of ObjectIterator.Next and a stop function, as listShards
+// does. The objects arrive as the listings return them, so not in name
+// order.
+func listPrefixes(ctx context.Context, client *storage.Client, bucket string, query *storage.Query, prefixes []string, n int,
+	opts *options) (next func() (*storage.ObjectAttrs, error), stop func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	todo := make(chan string, len(prefixes))
+	for _, p := range prefixes {
+		todo <- p
+	}
+	close(todo)
+	results := make(chan shardResult, n)
+	var wg sync.WaitGroup
+	for range min(n, len(prefixes)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range todo {
+				q := *query
+				q.Prefix = p
+				next := listMatches(ctx, client, bucket, &q, opts)
+				for {
+					attrs, err := next()
+					if err == iterator.Done {
+						break
+					}
+					select {
+					case results <- shardResult{attrs: attrs, err: err}:
+					case <-ctx.Done():
+						return
+					}
+					if err != nil {
+						return
+					}
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	next = func() (*storage.ObjectAttrs, error) {
+		r, ok := <-results
+		if !ok {
+			return nil, iterator.Done
+		}
+		return r.attrs, r.err
+	}
+	stop = func() {
+		cancel()
+		for range results {
+		}
+	}
+	return next, stop
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"cloud.google.com/go/storage"
+)
+
+// checkBucketsExist looks up each bucket the scans will list before the
+// first listing request, so that a mistyped name is reported as such rather
+// than as the API's 404 on the object listing. Any other failure, such as
+// a 403 for a caller who may list objects but not read bucket metadata, is
+// left for the listing itself to report. --assume-exists skips the lookups.
+func checkBucketsExist(ctx context.Context, client *storage.Client, scans []listTarget) error {
+	seen := make(map[string]bool)
+	for _, t := range scans {
+		if seen[t.bucket] {
+			continue
+		}
+		seen[t.bucket] = true
+		_, err := client.Bucket(t.bucket).Attrs(ctx)
+		if errors.Is(err, storage.ErrBucketNotExist) {
+			return fmt.Errorf("bucket gs://%s does not exist; check the name and the project it belongs to", t.bucket)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	return nil
+}
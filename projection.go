@@ -0,0 +1,66 @@
+package main
+
+// attrSelection returns the object attributes the listing needs under the
+// given options, so that the API can leave every other field out of its
+// responses. A plain listing only needs names; each output format, filter
+// and per-object operation adds the fields it reads. Per-object operations
+// that fetch their own metadata, like --stat, need nothing extra, and
+// neither does the listing of --two-phase, whose survivors are fetched in
+// full.
+func attrSelection(opts *options) []string {
+	if opts.twoPhase {
+		return twoPhaseAttrs
+	}
+	fields := []string{"Name", "Bucket"}
+	add := func(cond bool, names ...string) {
+		if cond {
+			fields = append(fields, names...)
+		}
+	}
+	structured := opts.json || opts.ndjson || opts.sink != "" || opts.splunkHEC != ""
+
+	// Generation-pinned URLs and reads, and the generation filters.
+	add(opts.versions || opts.withGeneration || opts.sinceGeneration >= 0 ||
+		opts.head > 0 || opts.lineCount || opts.resolveLinks != nil || opts.grep != "" || opts.batchStat || opts.downloadTo != "" || opts.emitScript != "" || structured, "Generation")
+	add(opts.long || structured || opts.head > 0 || opts.lineCount || opts.grep != "" || opts.classSummary || opts.matchReport || opts.metricsFile != "" ||
+		opts.sortBy == sortSize || opts.topLargest > 0 || opts.sizeHistogram || opts.timeHistogram != "" || opts.limitBytes > 0 || opts.explore, "Size")
+	add(opts.long || structured || opts.sortBy == sortUpdated || opts.sinceFile != "" || opts.incremental != "" || opts.topOldest > 0 || opts.newest || opts.oldest, "Updated")
+	add(structured, "Created", "StorageClass", "ContentType")
+	add(opts.timeHistogram != "" && opts.timeField == timeFieldUpdated, "Updated")
+	add(opts.timeHistogram != "" && opts.timeField == timeFieldCreated, "Created")
+	add(opts.classSummary, "StorageClass")
+	add(opts.summaryOnly, "Size", "StorageClass")
+	add(opts.table, "Size", "Updated", "StorageClass")
+	// Lifecycle conditions look at the age, class and times of each object.
+	add(opts.lifecyclePreview, "Created", "StorageClass", "CustomTime", "Deleted")
+	// A resumed listing tells the generations of a name apart.
+	add(opts.listingMode == listingConsistent, "Generation")
+	// Noncurrent generations are told apart by their deletion time.
+	add(opts.liveOnly, "Deleted")
+	// Reconstructing a past state needs each generation's lifetime.
+	add(!opts.asOf.IsZero(), "Generation", "Created", "Deleted")
+	add(opts.owner != "", "Owner")
+	add(len(opts.metadataMatches) > 0, "Metadata")
+	add(opts.dedupeBy == dedupeCRC32C, "CRC32C", "Size")
+	add(opts.dedupeBy == dedupeMD5, "MD5")
+	// Reads of encrypted objects need to know the key they were written with.
+	add(opts.head > 0 || opts.lineCount || opts.resolveLinks != nil || opts.grep != "" || opts.downloadTo != "", "CustomerKeySHA256")
+	// Links are told apart by their content type or metadata.
+	add(opts.resolveLinks != nil, "ContentType", "Metadata")
+	add(opts.assertContentType != "", "ContentType")
+	add(opts.manifest, "Size", "Updated", "Metadata", "CRC32C")
+	add(opts.withMetageneration, "Generation", "Metageneration")
+	add(opts.detectMutation, "Updated", "Generation", "Metageneration")
+	add(opts.verify, "CRC32C")
+	// A download is resumed from its size and checked against its CRC32C,
+	// and a gzip-encoded object, which is served decompressed, is always
+	// downloaded whole.
+	add(opts.downloadTo != "", "Size", "CRC32C", "ContentEncoding")
+	// Telling changed objects apart from a prior listing.
+	add(opts.compareToListing != "", "Generation", "Size", "CRC32C")
+	add(opts.withRetention || opts.underRetention, "Retention")
+	add(opts.withCustomTime || !opts.customTimeOlderThan.IsZero(), "CustomTime")
+	add(opts.complianceReport != "", "TemporaryHold", "EventBasedHold", "Retention", "RetentionExpirationTime")
+	add(opts.binary, "Generation", "Size", "Updated", "Created", "StorageClass", "ContentType", "CRC32C")
+	return fields
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+// TestAttrSelection checks the fields requested from the listing for
+// various sets of flags: a plain listing asks for names only, and each
+// option adds the fields it reads.
+func TestAttrSelection(t *testing.T) {
+	tests := []struct {
+		name  string
+		flags []string
+		want  []string
+		// exact is set when nothing beyond want may be requested.
+		exact bool
+	}{
+		{name: "plain", want: []string{"Name", "Bucket"}, exact: true},
+		{name: "names only", flags: []string{"--names-only"}, want: []string{"Name", "Bucket"}, exact: true},
+		{name: "stat fetches its own", flags: []string{"--stat"}, want: []string{"Name", "Bucket"}, exact: true},
+		{name: "long", flags: []string{"-l"}, want: []string{"Name", "Bucket", "Size", "Updated"}, exact: true},
+		{name: "json", flags: []string{"--json"},
+			want: []string{"Name", "Bucket", "Generation", "Size", "Updated", "Created", "StorageClass", "ContentType"}},
+		{name: "sort by size", flags: []string{"--sort", "size"}, want: []string{"Name", "Bucket", "Size"}, exact: true},
+		{name: "sort by time", flags: []string{"--sort", "updated"}, want: []string{"Name", "Bucket", "Updated"}, exact: true},
+		{name: "owner filter", flags: []string{"--owner", "user-*"}, want: []string{"Name", "Bucket", "Owner"}, exact: true},
+		{name: "dedupe by md5", flags: []string{"--dedupe-by", "md5"}, want: []string{"Name", "Bucket", "MD5"}, exact: true},
+		{name: "dedupe by crc32c", flags: []string{"--dedupe-by", "crc32c"}, want: []string{"Name", "Bucket", "CRC32C", "Size"}, exact: true},
+		{name: "download", flags: []string{"--download-to", "out", "--resume"},
+			want: []string{"Name", "Bucket", "Generation", "CustomerKeySHA256", "Size", "CRC32C", "ContentEncoding"}, exact: true},
+		{name: "two-phase", flags: []string{"--two-phase", "-l"}, want: twoPhaseAttrs, exact: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, _, err := parseArgs(append(tt.flags, "gs://b/logs/**"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := attrSelection(opts)
+			for _, f := range tt.want {
+				if !slices.Contains(got, f) {
+					t.Errorf("attrSelection() = %v, missing %s", got, f)
+				}
+			}
+			if tt.exact {
+				for _, f := range got {
+					if !slices.Contains(tt.want, f) {
+						t.Errorf("attrSelection() = %v, want only %v", got, tt.want)
+						break
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// rateRecorder collects the timings behind --rate-report: the latency of
+// every object listing request, measured in the transport up to the
+// response headers, and the time the scan spent waiting for the next
+// listed object. The rest of the run went to matching and writing, so
+// comparing the two tells a slow bucket from a slow client.
+type rateRecorder struct {
+	mu sync.Mutex
+	// requests counts listing requests, including failed attempts, and
+	// pages holds the latency of each one that succeeded.
+	requests int
+	pages    []time.Duration
+	waiting  time.Duration
+}
+
+// isObjectListing reports whether req lists the objects of a bucket, the
+// JSON API's GET /storage/v1/b/BUCKET/o.
+func isObjectListing(req *http.Request) bool {
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	n := len(parts)
+	return n >= 4 && parts[n-4] == "v1" && parts[n-3] == "b" && parts[n-1] == "o"
+}
+
+// request records one listing request that took latency; ok is whether it
+// returned a page. A nil recorder records nothing.
+func (r *rateRecorder) request(latency time.Duration, ok bool) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests++
+	if ok {
+		r.pages = append(r.pages, latency)
+	}
+}
+
+// timed wraps a listing's next function to add up the time spent in it.
+func (r *rateRecorder) timed(next func() (*storage.ObjectAttrs, error)) func() (*storage.ObjectAttrs, error) {
+	return func() (*storage.ObjectAttrs, error) {
+		start := time.Now()
+		attrs, err := next()
+		r.mu.Lock()
+		r.waiting += time.Since(start)
+		r.mu.Unlock()
+		return attrs, err
+	}
+}
+
+// print writes the rate report for a run that scanned and matched the
+// given numbers of objects in elapsed.
+func (r *rateRecorder) print(w io.Writer, scanned, matched int, elapsed time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	seconds := max(elapsed.Seconds(), 1e-3)
+	fmt.Fprintf(w, "\nRate report:\n")
+	fmt.Fprintf(w, "  Elapsed:         %s\n", elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "  Objects scanned: %d (%.1f/s)\n", scanned, float64(scanned)/seconds)
+	fmt.Fprintf(w, "  Objects matched: %d (%.1f/s)\n", matched, float64(matched)/seconds)
+	fmt.Fprintf(w, "  List requests:   %d (%.1f/s)\n", r.requests, float64(r.requests)/seconds)
+	if len(r.pages) > 0 {
+		pages := slices.Clone(r.pages)
+		slices.Sort(pages)
+		fmt.Fprintf(w, "  Page latency:    p50 %s, p99 %s, max %s\n",
+			percentile(pages, 50).Round(time.Millisecond), percentile(pages, 99).Round(time.Millisecond),
+			pages[len(pages)-1].Round(time.Millisecond))
+	}
+	waiting := min(r.waiting, elapsed)
+	fmt.Fprintf(w, "  Listing wait:    %s (%.0f%%)\n", waiting.Round(time.Millisecond), waiting.Seconds()/seconds*100)
+	fmt.Fprintf(w, "  Client time:     %s (matching, output and setup)\n", (elapsed - waiting).Round(time.Millisecond))
+}
+
+// percentile returns the p-th percentile of sorted durations, by the
+// nearest-rank method.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[max(rank, 1)-1]
+}
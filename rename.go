@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"unicode"
+
+	"cloud.google.com/go/storage"
+)
+
+// errRenameCollisions is returned by --rename when the plan has
+// collisions, after printing all of it, so that a copy step fed by it can
+// refuse to run.
+var errRenameCollisions = errors.New("rename plan has collisions")
+
+// parseRename parses the --rename expression, a --subst expression that may
+// be written the sed way, with a leading "s": s/old/new/.
+func parseRename(expr string) (*substitution, error) {
+	if len(expr) > 1 && expr[0] == 's' && unicode.IsPunct(rune(expr[1])) {
+		expr = expr[1:]
+	}
+	return parseSubstitution(expr)
+}
+
+// renameMove is one object of a --rename plan.
+type renameMove struct {
+	attrs *storage.ObjectAttrs
+	to    string
+}
+
+// renameFormatter collects the new name of every match and, once the
+// listing is done, prints the plan, one "old -> new" line per object whose
+// name changes. A move is flagged as a collision if another match moves to
+// the same name, or if a match that keeps its name already has it, since
+// copying both would leave only one.
+type renameFormatter struct {
+	paths pathRenderer
+	subst *substitution
+	moves []renameMove
+	// names holds the matches already planned, so that several
+	// generations of one object count once, and kept those whose name
+	// stays as it is.
+	names map[string]bool
+	kept  map[string]bool
+	// limit is the --max-buffered cap on the number of names held.
+	limit  int
+	status io.Writer
+}
+
+// newRenameFormatter returns a formatter printing the plan of subst,
+// holding at most limit names, or any number if limit is 0.
+func newRenameFormatter(paths pathRenderer, subst *substitution, limit int, status io.Writer) *renameFormatter {
+	return &renameFormatter{
+		paths:  paths,
+		subst:  subst,
+		names:  make(map[string]bool),
+		kept:   make(map[string]bool),
+		limit:  limit,
+		status: status,
+	}
+}
+
+// header writes nothing; collisions are only known at the end.
+func (f *renameFormatter) header(w io.Writer, targets []listTarget) error {
+	return nil
+}
+
+// object plans the new name of a matched object.
+func (f *renameFormatter) object(w io.Writer, attrs *storage.ObjectAttrs) error {
+	key := attrs.Bucket + "/" + attrs.Name
+	if f.names[key] {
+		return nil
+	}
+	f.names[key] = true
+	if to := f.subst.apply(attrs.Name); to != attrs.Name {
+		f.moves = append(f.moves, renameMove{attrs: attrs, to: to})
+	} else {
+		f.kept[key] = true
+	}
+	return checkBuffered(len(f.names), f.limit, "--rename")
+}
+
+// footer writes the plan in listing order, marking each collision, then
+// a summary on status. It returns errRenameCollisions if there were any.
+func (f *renameFormatter) footer(w io.Writer) error {
+	targets := make(map[string]int)
+	for _, m := range f.moves {
+		targets[m.attrs.Bucket+"/"+m.to]++
+	}
+	collisions := 0
+	for _, m := range f.moves {
+		dst := *m.attrs
+		dst.Name = m.to
+		line := f.paths.render(m.attrs) + " -> " + f.paths.render(&dst)
+		if key := m.attrs.Bucket + "/" + m.to; targets[key] > 1 || f.kept[key] {
+			collisions++
+			line += "  (collision)"
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(f.status, "Rename plan: %d to rename, %d unchanged, %d colliding\n", len(f.moves), len(f.names)-len(f.moves), collisions)
+	if collisions > 0 {
+		return errRenameCollisions
+	}
+	return nil
+}
+
+// machineReadable reports that the plan may be fed to a copy step, so
+// status messages go to stderr.
+func (f *renameFormatter) machineReadable() bool {
+	return true
+}
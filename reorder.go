@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"maps"
+	"slices"
+	"sync"
+
+	"cloud.google.com/go/storage"
+)
+
+// reorderKey is the context key under which a per-object operation finds
+// the buffer for its object's output under a reorderBuffer.
+type reorderKey struct{}
+
+// reorderBuffer puts the output of parallel per-object operations back into
+// listing order for --ordered. Each object is numbered as it is submitted to
+// the pool, its operations write into a buffer of its own, and a finished
+// buffer is written out as soon as those of all earlier objects have been.
+// At most window objects are numbered past the oldest one whose output is
+// still due, so a slow object holds up the listing once the window is full,
+// rather than letting finished output pile up behind it.
+type reorderBuffer struct {
+	w      io.Writer
+	window int
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	seqs map[*storage.ObjectAttrs]int
+	// next is the number of the next object submitted, and due that of the
+	// next one to write out.
+	next int
+	due  int
+	done map[int]*bytes.Buffer
+	err  error
+}
+
+// newReorderBuffer returns a buffer writing to w in listing order, with
+// window objects at most in flight or waiting.
+func newReorderBuffer(w io.Writer, window int) *reorderBuffer {
+	r := &reorderBuffer{
+		w:      w,
+		window: window,
+		seqs:   make(map[*storage.ObjectAttrs]int),
+		done:   make(map[int]*bytes.Buffer),
+	}
+	r.cond = sync.NewCond(&r.mu)
+	return r
+}
+
+// reserve numbers attrs as the next object of the listing, first waiting
+// while the window is full. It does nothing on a nil buffer.
+func (r *reorderBuffer) reserve(attrs *storage.ObjectAttrs) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// The oldest object of a full window is with a worker or queued for
+	// one, and finishing it wakes this up.
+	for r.next-r.due >= r.window {
+		r.cond.Wait()
+	}
+	r.seqs[attrs] = r.next
+	r.next++
+}
+
+// start returns the context for the operations on attrs, which gives them
+// the object's buffer, and the function to call once they are done or were
+// skipped. It does nothing on a nil buffer.
+func (r *reorderBuffer) start(ctx context.Context, attrs *storage.ObjectAttrs) (context.Context, func()) {
+	if r == nil {
+		return ctx, func() {}
+	}
+	buf := new(bytes.Buffer)
+	return context.WithValue(ctx, reorderKey{}, buf), func() { r.finish(attrs, buf) }
+}
+
+// finish marks the output of attrs complete and writes out every buffer
+// that is now due.
+func (r *reorderBuffer) finish(attrs *storage.ObjectAttrs, buf *bytes.Buffer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	seq := r.seqs[attrs]
+	delete(r.seqs, attrs)
+	r.done[seq] = buf
+	for {
+		b, ok := r.done[r.due]
+		if !ok {
+			break
+		}
+		r.write(b)
+		delete(r.done, r.due)
+		r.due++
+	}
+	r.cond.Broadcast()
+}
+
+// write writes one object's output, keeping the first error.
+func (r *reorderBuffer) write(b *bytes.Buffer) {
+	if r.err == nil {
+		_, r.err = r.w.Write(b.Bytes())
+	}
+}
+
+// flush writes out what is left once the pool has stopped: the output of
+// objects that came after one that was never run, because the listing
+// stopped between numbering and dispatching it. It returns the first write
+// error, and does nothing on a nil buffer.
+func (r *reorderBuffer) flush() error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, seq := range slices.Sorted(maps.Keys(r.done)) {
+		r.write(r.done[seq])
+	}
+	clear(r.done)
+	return r.err
+}
+
+// to returns where an operation on the object of ctx writes: the object's
+// own buffer under a reorderBuffer, or lw itself.
+func (lw *lockedWriter) to(ctx context.Context) io.Writer {
+	if buf, ok := ctx.Value(reorderKey{}).(*bytes.Buffer); ok {
+		return buf
+	}
+	return lw
+}
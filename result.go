@@ -0,0 +1,23 @@
+package main
+
+import "cloud.google.com/go/storage"
+
+// objectResult is one object a listing matched: the bucket it was listed
+// from and its attributes. It holds the URL rule that the features which
+// hand objects on, such as --exec and --emit-script, follow, so that they
+// do not each put buckets and names together.
+type objectResult struct {
+	bucket string
+	attrs  *storage.ObjectAttrs
+}
+
+// newObjectResult returns the result for an object of a listing.
+func newObjectResult(attrs *storage.ObjectAttrs) objectResult {
+	return objectResult{bucket: attrs.Bucket, attrs: attrs}
+}
+
+// gsURL returns the object's gs://bucket/name URL, with the name as
+// stored, the form gsutil and gcloud storage accept.
+func (r objectResult) gsURL() string {
+	return "gs://" + r.bucket + "/" + r.attrs.Name
+}
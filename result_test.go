@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+
+	"cloud.google.com/go/storage"
+)
+
+// TestObjectResult checks the URLs of objectResult, which keep the name as
+// stored.
+func TestObjectResult(t *testing.T) {
+	tests := []struct {
+		name, gsURL string
+	}{
+		{"logs/2024/a.log", "gs://b/logs/2024/a.log"},
+		{"a b/c#1?.txt", "gs://b/a b/c#1?.txt"},
+		{"logs//x", "gs://b/logs//x"},
+		{"logs/%41", "gs://b/logs/%41"},
+	}
+	for _, tt := range tests {
+		r := newObjectResult(&storage.ObjectAttrs{Bucket: "b", Name: tt.name})
+		if got := r.gsURL(); got != tt.gsURL {
+			t.Errorf("gsURL of %q = %q, want %q", tt.name, got, tt.gsURL)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// underRetention returns the filter of --under-retention, which keeps
+// objects whose retention configuration forbids deleting or replacing
+// them at now.
+func underRetention(now time.Time) objectFilter {
+	return func(attrs *storage.ObjectAttrs) bool {
+		return attrs.Retention != nil && attrs.Retention.RetainUntil.After(now)
+	}
+}
+
+// describeRetention returns the --with-retention annotation of an object:
+// its mode and the time it is retained until, noting a time that has
+// passed, or "none".
+func describeRetention(r *storage.ObjectRetention, now time.Time) string {
+	if r == nil {
+		return "none"
+	}
+	s := r.Mode + " until " + formatTime(r.RetainUntil)
+	if !r.RetainUntil.After(now) {
+		s += " (expired)"
+	}
+	return s
+}
+
+// recordRetention is the "retention" field of a JSON record: left out
+// without --with-retention, and null for an object with no retention
+// configuration.
+type recordRetention struct {
+	set bool
+	r   *storage.ObjectRetention
+	// times renders the retain-until time.
+	times timeFormat
+}
+
+// IsZero reports whether the field is left out of the record.
+func (r recordRetention) IsZero() bool {
+	return !r.set
+}
+
+// MarshalJSON writes the mode and retain-until time, or null.
+func (r recordRetention) MarshalJSON() ([]byte, error) {
+	if r.r == nil {
+		return []byte("null"), nil
+	}
+	return json.Marshal(struct {
+		Mode        string `json:"mode"`
+		RetainUntil string `json:"retainUntil"`
+	}{r.r.Mode, r.times.render(r.r.RetainUntil)})
+}
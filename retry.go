@@ -0,0 +1,349 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
+)
+
+// Retry backoff bounds. The delay before retry n is drawn uniformly from
+// [0, min(retryMaxDelay, retryBaseDelay*2^n)], the "full jitter" scheme, so
+// that many clients failing at once do not retry in lockstep.
+const (
+	retryBaseDelay = 200 * time.Millisecond
+	retryMaxDelay  = 30 * time.Second
+)
+
+// Circuit breaker settings: once at least breakerMinRequests of the last
+// breakerWindow requests have completed and breakerFailureRatio of them
+// failed, every further request fails immediately.
+const (
+	breakerWindow       = 20
+	breakerMinRequests  = 10
+	breakerFailureRatio = 0.5
+)
+
+// newStorageClient creates the GCS client with gcsls's own retry layer in
+// place of the library's, so that retries follow --retry-budget and stop
+// when the circuit breaker opens. Retries are logged to status with
+// --verbose. The library's retries are turned off for the whole client;
+// the --write-listing upload, which the retry layer passes through, turns
+// them back on for its own object handle.
+func newStorageClient(ctx context.Context, opts *options, status io.Writer) (client *storage.Client, err error) {
+	ctx, span := tracer.Start(ctx, "gcsls.newClient")
+	defer func() { endSpan(span, err) }()
+	httpClient, err := newHTTPClient(ctx, opts, status)
+	if err != nil {
+		return nil, err
+	}
+	client, err = storage.NewClient(ctx, option.WithHTTPClient(httpClient))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCS client: %w", err)
+	}
+	client.SetRetry(storage.WithPolicy(storage.RetryNever))
+	return client, nil
+}
+
+// newHTTPClient returns an authenticated HTTP client for GCS requests that
+// retries through retryTransport. The storage client is built on it, and
+// requests the library does not cover use it directly.
+func newHTTPClient(ctx context.Context, opts *options, status io.Writer) (*http.Client, error) {
+	retries := &retryTransport{
+		base:     http.DefaultTransport,
+		budget:   opts.retryBudget,
+		breaker:  &circuitBreaker{},
+		throttle: opts.throttle,
+		rates:    opts.rates,
+		clock:    systemClock{},
+
+		onlyIdempotent: opts.retryOnlyIdempotent,
+	}
+	if opts.retryJitterSeedSet {
+		retries.jitter = rand.New(rand.NewPCG(opts.retryJitterSeed, opts.retryJitterSeed))
+	}
+	if opts.verbose {
+		retries.log = status
+	}
+
+	// The storage library wires up its own authentication unless it is
+	// given an HTTP client, so the credentials are set up here instead.
+	authOpts := []option.ClientOption{option.WithScopes(storage.ScopeFullControl, "https://www.googleapis.com/auth/cloud-platform")}
+	if os.Getenv("STORAGE_EMULATOR_HOST") != "" {
+		authOpts = []option.ClientOption{option.WithoutAuthentication()}
+	}
+	var base http.RoundTripper = retries
+	if opts.trace {
+		base = tracingTransport{base: retries}
+	}
+	transport, err := htransport.NewTransport(ctx, base, authOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCS client: %w", err)
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+// retryTransport retries failed idempotent requests with jittered
+// exponential backoff until the time spent waiting reaches the budget.
+// Only GET and HEAD requests go through it; the upload of --write-listing
+// is the only other kind gcsls sends, and openListingUpload has the
+// library retry that on its object handle, since the client's retries are
+// turned off.
+type retryTransport struct {
+	base    http.RoundTripper
+	budget  time.Duration
+	breaker *circuitBreaker
+	// throttle, when set, paces every attempt and is told about each 429.
+	throttle *adaptiveThrottle
+	// rates, when set, records the latency of each listing request.
+	rates *rateRecorder
+	// onlyIdempotent, for --retry-only-idempotent, leaves the requests
+	// repeatable rejects unretried.
+	onlyIdempotent bool
+	// log, when set, receives a line per retry and per listing query.
+	log io.Writer
+	// clock times the attempts and waits between them.
+	clock retryClock
+	// jitter, when set, draws the backoff delays in place of the global
+	// source, so that a seed repeats them; it is guarded by mu.
+	jitter *rand.Rand
+
+	mu    sync.Mutex
+	spent time.Duration
+}
+
+// RoundTrip sends the request, retrying transient failures.
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return t.base.RoundTrip(req)
+	}
+	if t.log != nil {
+		if q, ok := describeListing(req); ok {
+			t.logf("%s", q)
+		}
+	}
+	listing := t.rates != nil && isObjectListing(req)
+	retry := !t.onlyIdempotent || repeatable(req)
+	for attempt := 0; ; attempt++ {
+		if err := t.breaker.check(); err != nil {
+			return nil, err
+		}
+		if err := t.throttle.wait(req.Context()); err != nil {
+			return nil, err
+		}
+		start := t.clock.Now()
+		resp, err := t.base.RoundTrip(req)
+		// A cancelled request is not a GCS failure.
+		if req.Context().Err() != nil {
+			return resp, err
+		}
+		if listing {
+			t.rates.request(t.clock.Now().Sub(start), err == nil && resp.StatusCode == http.StatusOK)
+		}
+		if t.throttle != nil && resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+			t.logf("Rate limited; slowing down to %.1f requests/s", t.throttle.rateLimited())
+		}
+		reason := retryReason(resp, err)
+		t.breaker.record(reason == "")
+		if reason == "" {
+			return resp, err
+		}
+		if !retry {
+			t.logf("Not retrying unpinned read %s %s: %s", req.Method, req.URL.Path, reason)
+			return resp, err
+		}
+
+		delay := t.backoff(attempt)
+		if !t.spend(delay) {
+			t.logf("Retry budget of %s used up; giving up on %s %s: %s", t.budget, req.Method, req.URL.Path, reason)
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+		}
+		t.logf("Retrying %s %s in %s (attempt %d): %s", req.Method, req.URL.Path, delay.Round(time.Millisecond), attempt+2, reason)
+
+		if err := t.clock.Sleep(req.Context(), delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// backoff draws the delay before the retry that follows attempt, counted
+// from 0.
+func (t *retryTransport) backoff(attempt int) time.Duration {
+	step := min(retryMaxDelay, retryBaseDelay<<min(attempt, 16))
+	if t.jitter == nil {
+		return rand.N(step + 1)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return time.Duration(t.jitter.Int64N(int64(step) + 1))
+}
+
+// retryClock is the time of retryTransport: the real one, or one that a
+// test advances by hand to follow the backoff without waiting for it.
+type retryClock interface {
+	Now() time.Time
+	// Sleep waits for d, or until ctx is done, returning its error.
+	Sleep(ctx context.Context, d time.Duration) error
+}
+
+// systemClock is the real time.
+type systemClock struct{}
+
+// Now returns the current time.
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// Sleep waits for d unless ctx ends first.
+func (systemClock) Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// repeatable reports whether sending req again is sure to return what the
+// first attempt would have. Listings and metadata lookups describe the
+// bucket as it is when they are answered, so a repeat is as good as the
+// first try. A read of object content is only repeatable when it names a
+// generation: otherwise the object may have been replaced in between and
+// the retry would return the new version. The storage library resumes a
+// read cut off mid-stream with a ranged request pinned to the generation
+// it started reading, so only the first request of an unpinned read is
+// affected.
+func repeatable(req *http.Request) bool {
+	q := req.URL.Query()
+	// Content is read through the JSON API with alt=media or through the
+	// XML API at /BUCKET/OBJECT; the JSON API's other requests are under
+	// /storage/v1/.
+	path := strings.Trim(req.URL.Path, "/")
+	content := q.Get("alt") == "media" || (!strings.HasPrefix(path, "storage/") && strings.Contains(path, "/"))
+	return !content || q.Has("generation")
+}
+
+// retryReason describes why a request is worth retrying, or returns "" if
+// it succeeded or failed permanently.
+func retryReason(resp *http.Response, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	switch resp.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusInternalServerError,
+		http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return resp.Status
+	}
+	return ""
+}
+
+// spend reserves delay from the retry budget, reporting false when the
+// budget does not cover it.
+func (t *retryTransport) spend(delay time.Duration) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.spent+delay > t.budget {
+		return false
+	}
+	t.spent += delay
+	return true
+}
+
+// describeListing returns a line describing the request if it starts an
+// object listing: the first page of a JSON API objects list, or of an XML
+// API list of multipart uploads. It shows the server-side prefix actually
+// sent, so that --verbose reveals how the patterns were planned, and flags
+// a listing of the whole bucket.
+func describeListing(req *http.Request) (string, bool) {
+	q := req.URL.Query()
+	if q.Get("pageToken") != "" || q.Get("key-marker") != "" {
+		return "", false
+	}
+	var what, bucket string
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	switch {
+	case len(parts) >= 4 && parts[len(parts)-4] == "v1" && parts[len(parts)-3] == "b" && parts[len(parts)-1] == "o":
+		what, bucket = "Listing", parts[len(parts)-2]
+	case len(parts) == 1 && q.Has("uploads"):
+		what, bucket = "Listing incomplete uploads in", parts[0]
+	default:
+		return "", false
+	}
+	line := fmt.Sprintf("%s gs://%s/ with prefix %q", what, bucket, q.Get("prefix"))
+	if q.Get("prefix") == "" {
+		line += " (the whole bucket)"
+	}
+	if d := q.Get("delimiter"); d != "" {
+		line += fmt.Sprintf(", delimiter %q", d)
+	}
+	if s := q.Get("startOffset"); s != "" {
+		line += fmt.Sprintf(", start offset %q", s)
+	}
+	if e := q.Get("endOffset"); e != "" {
+		line += fmt.Sprintf(", end offset %q", e)
+	}
+	return line, true
+}
+
+// logf writes a debug line if logging is enabled.
+func (t *retryTransport) logf(format string, args ...any) {
+	if t.log != nil {
+		fmt.Fprintf(t.log, format+"\n", args...)
+	}
+}
+
+// circuitBreaker tracks the outcome of recent requests and trips when too
+// many of them failed. It never closes again: a scan that trips it aborts.
+type circuitBreaker struct {
+	mu       sync.Mutex
+	outcomes [breakerWindow]bool
+	n        int
+	next     int
+	failures int
+	open     bool
+}
+
+// record adds the outcome of one request.
+func (b *circuitBreaker) record(ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.n == breakerWindow && !b.outcomes[b.next] {
+		b.failures--
+	}
+	if b.n < breakerWindow {
+		b.n++
+	}
+	b.outcomes[b.next] = ok
+	b.next = (b.next + 1) % breakerWindow
+	if !ok {
+		b.failures++
+	}
+	if b.n >= breakerMinRequests && float64(b.failures) >= breakerFailureRatio*float64(b.n) {
+		b.open = true
+	}
+}
+
+// check returns an error once the breaker has tripped.
+func (b *circuitBreaker) check() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.open {
+		return fmt.Errorf("giving up: %d of the last %d requests to GCS failed", b.failures, b.n)
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"cmp"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scriptedTransport answers each request with the next status of its
+// script, repeating the last one once the script runs out.
+type scriptedTransport struct {
+	statuses []int
+	requests int
+}
+
+// RoundTrip returns a response with the next status.
+func (s *scriptedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	status := s.statuses[min(s.requests, len(s.statuses)-1)]
+	s.requests++
+	return &http.Response{StatusCode: status, Status: http.StatusText(status),
+		Body: io.NopCloser(strings.NewReader("")), Request: req}, nil
+}
+
+// newTestRetryTransport returns a retryTransport over base with the given
+// budget, the manual clock of --self-test and a seeded jitter source.
+func newTestRetryTransport(base http.RoundTripper, budget time.Duration, seed uint64) (*retryTransport, *manualClock) {
+	clock := &manualClock{}
+	return &retryTransport{
+		base:    base,
+		budget:  budget,
+		breaker: &circuitBreaker{},
+		clock:   clock,
+		jitter:  rand.New(rand.NewPCG(seed, seed)),
+	}, clock
+}
+
+// TestBackoff checks that the backoff delays stay within the doubling
+// steps, capped at retryMaxDelay, and that a seed repeats them exactly.
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		step    time.Duration
+	}{
+		{0, retryBaseDelay},
+		{1, 2 * retryBaseDelay},
+		{3, 8 * retryBaseDelay},
+		{7, retryMaxDelay},
+		{40, retryMaxDelay},
+	}
+	a, _ := newTestRetryTransport(nil, time.Hour, 42)
+	b, _ := newTestRetryTransport(nil, time.Hour, 42)
+	for _, tt := range tests {
+		for range 100 {
+			d := a.backoff(tt.attempt)
+			if d < 0 || d > tt.step {
+				t.Fatalf("backoff(%d) = %v, want within [0, %v]", tt.attempt, d, tt.step)
+			}
+			if e := b.backoff(tt.attempt); e != d {
+				t.Fatalf("backoff(%d) with the same seed = %v, then %v", tt.attempt, d, e)
+			}
+		}
+	}
+}
+
+// TestRetryTransport checks which failures are retried, how often, and the
+// delays waited in between, without sleeping.
+func TestRetryTransport(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		url            string
+		statuses       []int
+		budget         time.Duration
+		onlyIdempotent bool
+		wantRequests   int
+		wantStatus     int
+	}{
+		{name: "success", statuses: []int{200}, wantRequests: 1, wantStatus: 200},
+		{name: "transient failures", statuses: []int{503, 500, 200}, wantRequests: 3, wantStatus: 200},
+		{name: "rate limited", statuses: []int{429, 200}, wantRequests: 2, wantStatus: 200},
+		{name: "permanent failure", statuses: []int{404}, wantRequests: 1, wantStatus: 404},
+		{name: "no budget", statuses: []int{503}, budget: -1, wantRequests: 1, wantStatus: 503},
+		{name: "upload passed through", method: http.MethodPost, statuses: []int{503}, wantRequests: 1, wantStatus: 503},
+		{name: "unpinned read, idempotent only", url: "https://storage.googleapis.com/storage/v1/b/b/o/x?alt=media",
+			statuses: []int{503, 200}, onlyIdempotent: true, wantRequests: 1, wantStatus: 503},
+		{name: "pinned read, idempotent only", url: "https://storage.googleapis.com/storage/v1/b/b/o/x?alt=media&generation=7",
+			statuses: []int{503, 200}, onlyIdempotent: true, wantRequests: 2, wantStatus: 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := &scriptedTransport{statuses: tt.statuses}
+			budget := time.Hour
+			if tt.budget != 0 {
+				budget = tt.budget
+			}
+			rt, clock := newTestRetryTransport(base, budget, 1)
+			rt.onlyIdempotent = tt.onlyIdempotent
+			method := cmp.Or(tt.method, http.MethodGet)
+			url := cmp.Or(tt.url, "https://storage.googleapis.com/storage/v1/b/b/o?prefix=logs%2F")
+			req, err := http.NewRequest(method, url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := rt.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if base.requests != tt.wantRequests || resp.StatusCode != tt.wantStatus {
+				t.Errorf("sent %d requests ending in %d, want %d ending in %d", base.requests, resp.StatusCode, tt.wantRequests, tt.wantStatus)
+			}
+			if len(clock.sleeps) != max(tt.wantRequests-1, 0) {
+				t.Errorf("slept %d times, want %d", len(clock.sleeps), tt.wantRequests-1)
+			}
+
+			// The same seed waits the same delays.
+			again, againClock := newTestRetryTransport(&scriptedTransport{statuses: tt.statuses}, budget, 1)
+			again.onlyIdempotent = tt.onlyIdempotent
+			req, _ = http.NewRequest(method, url, nil)
+			if _, err := again.RoundTrip(req); err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(clock.sleeps, againClock.sleeps) {
+				t.Errorf("delays %v, then %v with the same seed", clock.sleeps, againClock.sleeps)
+			}
+		})
+	}
+}
+
+// TestRetryBudget checks that retries stop once the delays would exceed
+// the budget.
+func TestRetryBudget(t *testing.T) {
+	base := &scriptedTransport{statuses: []int{503}}
+	rt, clock := newTestRetryTransport(base, 5*time.Second, 3)
+	req, _ := http.NewRequest(http.MethodGet, "https://storage.googleapis.com/storage/v1/b/b/o", nil)
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 503 {
+		t.Errorf("got status %d, want the last failure, 503", resp.StatusCode)
+	}
+	var total time.Duration
+	for _, d := range clock.sleeps {
+		total += d
+	}
+	if total > 5*time.Second {
+		t.Errorf("waited %v in all, over the 5s budget", total)
+	}
+	if base.requests != len(clock.sleeps)+1 {
+		t.Errorf("sent %d requests for %d retries", base.requests, len(clock.sleeps))
+	}
+}
+
+// TestCircuitBreaker checks when the breaker trips over a sequence of
+// request outcomes.
+func TestCircuitBreaker(t *testing.T) {
+	repeat := func(ok bool, n int) []bool {
+		return slices.Repeat([]bool{ok}, n)
+	}
+	tests := []struct {
+		name     string
+		outcomes []bool
+		open     bool
+	}{
+		{"all succeed", repeat(true, 50), false},
+		{"too few requests", repeat(false, breakerMinRequests-1), false},
+		{"enough failures", repeat(false, breakerMinRequests), true},
+		{"half failed", slices.Concat(repeat(true, 5), repeat(false, 5)), true},
+		{"under half failed", slices.Concat(repeat(true, 6), repeat(false, 4)), false},
+		{"old failures leave the window", slices.Concat(repeat(false, 4), repeat(true, 6), repeat(true, breakerWindow)), false},
+		{"failures after a long success", slices.Concat(repeat(true, 100), repeat(false, breakerWindow/2)), true},
+		{"alternating", slices.Concat(repeat(true, 1), slices.Repeat([]bool{false, true}, 20)), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b circuitBreaker
+			for _, ok := range tt.outcomes {
+				b.record(ok)
+			}
+			if err := b.check(); (err != nil) != tt.open {
+				t.Errorf("check() = %v, want open %v", err, tt.open)
+			}
+		})
+	}
+}
+
+// TestRetryTransportTripsBreaker checks that a tripped breaker fails
+// requests without sending them.
+func TestRetryTransportTripsBreaker(t *testing.T) {
+	base := &scriptedTransport{statuses: []int{503}}
+	rt, _ := newTestRetryTransport(base, time.Hour, 5)
+	req, _ := http.NewRequest(http.MethodGet, "https://storage.googleapis.com/storage/v1/b/b/o", nil)
+	if _, err := rt.RoundTrip(req); err == nil || !strings.Contains(err.Error(), "giving up") {
+		t.Fatalf("RoundTrip error = %v, want the breaker's", err)
+	}
+	if base.requests != breakerMinRequests {
+		t.Errorf("sent %d requests, want %d before the breaker tripped", base.requests, breakerMinRequests)
+	}
+	sent := base.requests
+	if _, err := rt.RoundTrip(req); err == nil {
+		t.Fatal("RoundTrip after the breaker tripped succeeded")
+	}
+	if base.requests != sent {
+		t.Errorf("sent %d requests after the breaker tripped", base.requests-sent)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"cmp"
+	"math/rand/v2"
+	"slices"
+
+	"cloud.google.com/go/storage"
+)
+
+// sampleBuffer keeps a uniformly random sample of n matches for --sample
+// by reservoir sampling: the first n matches fill the reservoir, and the
+// ith match after that replaces a random one of them with probability n/i.
+// Every match thus ends up in the sample with the same probability while
+// memory stays at n objects.
+type sampleBuffer struct {
+	n    int
+	rng  *rand.Rand
+	seen int
+	kept []sampledMatch
+}
+
+// sampledMatch is a match in the reservoir with its position in the
+// listing, which orders the sample's output.
+type sampledMatch struct {
+	index int
+	attrs *storage.ObjectAttrs
+}
+
+// newSampleBuffer returns a buffer sampling n matches, drawing on a random
+// source seeded with seed; the same seed and listing give the same sample.
+func newSampleBuffer(n int, seed uint64) *sampleBuffer {
+	return &sampleBuffer{n: n, rng: rand.New(rand.NewPCG(seed, seed))}
+}
+
+// add offers one match to the reservoir.
+func (b *sampleBuffer) add(attrs *storage.ObjectAttrs) error {
+	b.seen++
+	if len(b.kept) < b.n {
+		b.kept = append(b.kept, sampledMatch{index: b.seen, attrs: attrs})
+	} else if j := b.rng.IntN(b.seen); j < b.n {
+		b.kept[j] = sampledMatch{index: b.seen, attrs: attrs}
+	}
+	return nil
+}
+
+// each calls fn for every sampled object in listing order, stopping at
+// the first error.
+func (b *sampleBuffer) each(fn func(*storage.ObjectAttrs) error) error {
+	sorted := slices.Clone(b.kept)
+	slices.SortFunc(sorted, func(x, y sampledMatch) int {
+		return cmp.Compare(x.index, y.index)
+	})
+	for _, m := range sorted {
+		if err := fn(m.attrs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// close does nothing; the sample is only in memory.
+func (b *sampleBuffer) close() {}
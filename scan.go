@@ -0,0 +1,387 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// Values accepted by --match-on.
+const (
+	matchOnName = "name"
+	matchOnURL  = "url"
+)
+
+// buildQuery returns the listing query for a server-side prefix under the
+// given options.
+func buildQuery(prefix string, opts *options) *storage.Query {
+	query := &storage.Query{
+		Prefix: prefix,
+		// Reconstructing a past state needs every generation, not just the live ones.
+		Versions:    opts.versions || !opts.asOf.IsZero(),
+		SoftDeleted: opts.softDeleted,
+	}
+	// Object owners are only part of the full projection, which the API
+	// returns only when explicitly asked for.
+	if opts.owner != "" {
+		query.Projection = storage.ProjectionFull
+	}
+	// Only ask for the fields that will be used. The attribute names are
+	// fixed and valid, so this cannot fail.
+	query.SetAttrSelection(attrSelection(opts))
+	// StartOffset is inclusive; the object named by --after itself is
+	// dropped by its filter.
+	if opts.after != "" {
+		query.StartOffset = opts.after
+	}
+	return query
+}
+
+// maxPageSize is the largest number of objects the API returns per listing
+// page, which is also its default.
+const maxPageSize = 1000
+
+// listObjects starts listing bucket with query, using the --page-size
+// page size when one is set.
+func listObjects(ctx context.Context, client *storage.Client, bucket string, query *storage.Query, opts *options) *storage.ObjectIterator {
+	it := client.Bucket(bucket).Objects(ctx, query)
+	if opts.pageSize > 0 {
+		it.PageInfo().MaxSize = opts.pageSize
+	}
+	return it
+}
+
+// checkScanCost fails once the objects scanned imply more listing requests
+// than --max-scan-cost allows. Each request returns up to a page of objects
+// and is billed as one Class A operation; the number of objects under a
+// prefix is not known in advance, so the budget is enforced as the scan
+// goes, by the time the first object beyond the last allowed page is seen.
+func checkScanCost(stats *scanStats, opts *options) error {
+	if opts.maxScanCost == 0 {
+		return nil
+	}
+	pageSize := maxPageSize
+	if opts.pageSize > 0 {
+		pageSize = opts.pageSize
+	}
+	if stats.scanned <= opts.maxScanCost*pageSize {
+		return nil
+	}
+	return fmt.Errorf("stopped after scanning %d objects, which took more than the --max-scan-cost of %d listing requests "+
+		"at %d objects per page", stats.scanned, opts.maxScanCost, pageSize)
+}
+
+// queryPrefix returns the server-side prefix for a pattern in bucket: the
+// literal part before the first wildcard, shortened with --url-decode to the
+// part that encoded names share, with --ignore-case to the part before names
+// can differ in case, and with --normalize-slashes to the part before names
+// can differ in repeated slashes. With --match-on url, the
+// literal part is a prefix of the URL, so only what follows "gs://bucket/"
+// narrows the names.
+func queryPrefix(bucket, pattern string, opts *options) string {
+	// A pattern that may match anywhere in the name has no prefix in common
+	// with its matches.
+	if opts.unanchored {
+		return ""
+	}
+	prefix := getPrefixFromPattern(pattern)
+	if opts.matchOn == matchOnURL {
+		bucketURL := "gs://" + bucket + "/"
+		if !strings.HasPrefix(prefix, bucketURL) {
+			return ""
+		}
+		prefix = strings.TrimPrefix(prefix, bucketURL)
+	}
+	if opts.urlDecode {
+		prefix = encodedSafePrefix(prefix)
+	}
+	if opts.ignoreCase {
+		prefix = caseSafePrefix(prefix)
+	}
+	if opts.normalizeSlashes {
+		prefix = slashSafePrefix(prefix)
+	}
+	return prefix
+}
+
+// caseSafePrefix shortens prefix to the part before its first character
+// with another case, such as a letter, since GCS compares prefixes
+// case-sensitively: --ignore-case matches Logs/2024/ and LOGS/2024/ with
+// logs/2024/*, so only what comes before the l is shared by every match.
+// Digits and punctuation have no case, so a date-partitioned layout such
+// as 2024/Jan/ still narrows the listing to 2024/.
+func caseSafePrefix(prefix string) string {
+	if i := strings.IndexFunc(prefix, func(r rune) bool { return unicode.SimpleFold(r) != r }); i >= 0 {
+		return prefix[:i]
+	}
+	return prefix
+}
+
+// newNameMatcher returns a function reporting whether an object name
+// matches the target's pattern. Matching uses the doublestar library, which
+// supports "**", unless --glob-syntax path asks for path.Match. With
+// --ignore-case, both sides are folded to lower case, and queryPrefix stops
+// the server-side prefix before the first letter.
+// With --url-decode, names are decoded before matching, and with
+// --normalize-slashes, repeated slashes in the pattern and the name are
+// collapsed. With --match-on url the pattern is matched against the
+// object's full gs:// URL. With --unanchored the pattern may match any
+// part of the name.
+func newNameMatcher(target listTarget, opts *options) func(name string) (bool, error) {
+	pattern := target.pattern
+	original := pattern
+	ignoreCase, urlDecode, syntax, unanchored := opts.ignoreCase, opts.urlDecode, opts.globSyntax, opts.unanchored
+	var bucketURL string
+	if opts.matchOn == matchOnURL {
+		bucketURL = "gs://" + target.bucket + "/"
+	}
+	if ignoreCase {
+		pattern = strings.ToLower(pattern)
+	}
+	normalize := opts.normalizeSlashes
+	if normalize {
+		pattern = collapseSlashes(pattern)
+	}
+	return func(name string) (bool, error) {
+		if urlDecode {
+			name = decodeName(name)
+		}
+		if normalize {
+			name = collapseSlashes(name)
+		}
+		name = bucketURL + name
+		if ignoreCase {
+			name = strings.ToLower(name)
+		}
+		match := globMatch
+		if unanchored {
+			match = globMatchAnywhere
+		}
+		matched, err := match(syntax, pattern, name)
+		if err != nil {
+			return false, fmt.Errorf("invalid glob pattern '%s': %w", original, err)
+		}
+		return matched, nil
+	}
+}
+
+// newTargetMatcher returns a function reporting the index of the first of
+// the target's patterns that an object name matches, or -1 if none does.
+// With --match-empty-prefix-objects=false, a pattern never matches the
+// object named exactly like its literal prefix, such as "logs/" for
+// logs/**. With --invert-match, a name matching none of the patterns is
+// credited to the first one instead, and a name matching any is not.
+func newTargetMatcher(target listTarget, opts *options) func(name string) (int, error) {
+	patterns := target.patterns()
+	var matchers []func(string) (bool, error)
+	// --normalize-slashes cuts the listing prefix short, so the placeholder
+	// is the pattern's literal part, which a name matches once collapsed.
+	placeholders := make([]string, len(patterns))
+	for i, t := range patterns {
+		matchers = append(matchers, newNameMatcher(t, opts))
+		placeholders[i] = t.prefix
+		if opts.normalizeSlashes && opts.matchOn == matchOnName {
+			placeholders[i] = collapseSlashes(getPrefixFromPattern(t.pattern))
+		}
+	}
+	match := func(name string) (int, error) {
+		collapsed := name
+		if opts.normalizeSlashes {
+			collapsed = collapseSlashes(name)
+		}
+		for i, m := range matchers {
+			if !opts.matchPrefixObjects && placeholders[i] != "" && collapsed == placeholders[i] {
+				continue
+			}
+			matched, err := m(name)
+			if err != nil {
+				return -1, err
+			}
+			if matched {
+				return i, nil
+			}
+		}
+		return -1, nil
+	}
+	if !opts.invertMatch {
+		return match
+	}
+	return func(name string) (int, error) {
+		i, err := match(name)
+		if err != nil || i >= 0 {
+			return -1, err
+		}
+		return 0, nil
+	}
+}
+
+// scanMatches lists the objects under the target's prefix and calls visit
+// for each one that matches one of its patterns and passes every filter. It stops at
+// the first error returned by visit and returns that error unchanged.
+// With --shards, the prefix is listed by several queries at once and the
+// objects are visited as they arrive, which is not in name order, and so
+// with --prefixes-from, whose prefixes are listed instead of the target's.
+func scanMatches(ctx context.Context, client *storage.Client, target listTarget,
+	opts *options, filters []objectFilter, stats *scanStats, visit func(*storage.ObjectAttrs) error) error {
+	// With --as-of, matched generations are collected per name and only the
+	// one that was live at the requested time is visited.
+	var asOf *asOfResolver
+	if !opts.asOf.IsZero() {
+		asOf = &asOfResolver{at: opts.asOf}
+	}
+
+	match := newTargetMatcher(target, opts)
+
+	// With several patterns in one scan, --stats credits each match to the
+	// first pattern it matched.
+	if stats.perPattern != nil {
+		next := visit
+		visit = func(attrs *storage.ObjectAttrs) error {
+			if i, _ := match(attrs.Name); i >= 0 {
+				stats.perPattern[target.patterns()[i].url()]++
+			}
+			return next(attrs)
+		}
+	}
+	var prefixes []string
+	if opts.prefixes != nil {
+		prefixes = narrowPrefixes(target.prefix, opts.prefixes)
+		if opts.stats {
+			stats.countPrefixes(prefixes)
+			next := visit
+			visit = func(attrs *storage.ObjectAttrs) error {
+				stats.perPrefix[prefixOf(prefixes, attrs.Name)]++
+				return next(attrs)
+			}
+		}
+	}
+
+	// handle processes one listed object.
+	handle := func(attrs *storage.ObjectAttrs) error {
+		i, err := match(attrs.Name)
+		if err != nil {
+			return err
+		}
+		if i < 0 {
+			return nil
+		}
+
+		if asOf != nil {
+			attrs = asOf.add(attrs)
+			if attrs == nil {
+				return nil
+			}
+		}
+		if !acceptAll(filters, attrs) {
+			return nil
+		}
+		return visit(attrs)
+	}
+
+	query := buildQuery(target.prefix, opts)
+	stats.cache.prepare(query)
+	var next func() (*storage.ObjectAttrs, error)
+	switch {
+	case stats.cache.serving():
+		var stop func()
+		var err error
+		if next, stop, err = stats.cache.list(target.bucket, query); err != nil {
+			return err
+		}
+		defer stop()
+	case stats.inventory != nil:
+		var stop func()
+		var err error
+		if next, stop, err = stats.inventory.list(ctx, target.bucket, query); err != nil {
+			return err
+		}
+		defer stop()
+	case opts.shards > 1:
+		var stop func()
+		next, stop = listShards(ctx, client, target.bucket, query, opts.shards, opts)
+		defer stop()
+	case opts.prefixes != nil:
+		var stop func()
+		next, stop = listPrefixes(ctx, client, target.bucket, query, prefixes, opts.prefixConcurrency, opts)
+		defer stop()
+	default:
+		next = listMatches(ctx, client, target.bucket, query, opts)
+	}
+	// Scans of other buckets run while this one waits for GCS.
+	if stats.shared != nil {
+		list := next
+		next = func() (*storage.ObjectAttrs, error) {
+			stats.shared.Unlock()
+			defer stats.shared.Lock()
+			return list()
+		}
+	}
+	if stats.rates != nil {
+		next = stats.rates.timed(next)
+	}
+	// With --two-phase, the listing only returns names, and the objects are
+	// scanned as they are listed, before most are left out by name.
+	if opts.twoPhase {
+		nameFilters := buildNameFilters(opts)
+		keep := func(attrs *storage.ObjectAttrs) bool {
+			i, err := match(attrs.Name)
+			// A name that cannot be matched fails the scan in handle.
+			return err != nil || i >= 0 && acceptAll(nameFilters, attrs)
+		}
+		var stop func()
+		next, stop = twoPhaseFetch(ctx, client, next, keep, func() error {
+			stats.scanned++
+			return checkScanCost(stats, opts)
+		}, opts.concurrency)
+		defer stop()
+	}
+	for {
+		// The iterator only sees the context when it fetches a page, so
+		// check it per object to stop promptly in the middle of a page.
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		attrs, err := next()
+		if err == iterator.Done {
+			// End of the results.
+			stats.cache.finished(target, query)
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to iterate objects: %w", err)
+		}
+		if err := stats.cache.record(attrs); err != nil {
+			return err
+		}
+		if !opts.twoPhase {
+			stats.scanned++
+			if err := checkScanCost(stats, opts); err != nil {
+				return err
+			}
+		}
+		if err := handle(attrs); err != nil {
+			return err
+		}
+		if stats.progress != nil {
+			stats.progress(stats.scanned, stats.matched)
+		}
+		if stats.checkpoint != nil {
+			if err := stats.checkpoint.passed(attrs.Name); err != nil {
+				return err
+			}
+		}
+	}
+
+	// The last object name seen by the resolver is only complete once the
+	// listing ends.
+	if asOf != nil {
+		if attrs := asOf.flush(); attrs != nil && acceptAll(filters, attrs) {
+			return visit(attrs)
+		}
+	}
+	return nil
+}
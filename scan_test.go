@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// cancelWriter cancels a context once it has been written the given number
+// of lines, the way an interrupt arrives while a page is being printed.
+type cancelWriter struct {
+	bytes.Buffer
+	lines  int
+	cancel context.CancelFunc
+}
+
+// Write records p and cancels once enough lines have been written.
+func (w *cancelWriter) Write(p []byte) (int, error) {
+	n, err := w.Buffer.Write(p)
+	if strings.Count(w.String(), "\n") >= w.lines {
+		w.cancel()
+	}
+	return n, err
+}
+
+// TestListingStopsOnCancel checks that cancelling the context in the middle
+// of a listing of many pages stops it within the page being read, with an
+// error, instead of scanning the rest of the bucket.
+func TestListingStopsOnCancel(t *testing.T) {
+	var names []string
+	for i := range 1000 {
+		names = append(names, fmt.Sprintf("logs/%04d.log", i))
+	}
+	fake := newFakeGCS(t, "cancel", names, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// The header and the first 14 objects: the cancel lands on page 2.
+	out := &cancelWriter{lines: 15, cancel: cancel}
+
+	opts, args, err := parseArgs([]string{"--assume-exists", "gs://cancel/logs/**"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	err = listObjectsWithWildcard(ctx, args, opts, out, io.Discard)
+	if !errors.Is(err, errInterrupted) {
+		t.Fatalf("listing returned %v, want %v", err, errInterrupted)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("listing took %v to stop", elapsed)
+	}
+	if n := fake.pagesServed(); n > 2 {
+		t.Errorf("listing fetched %d of 100 pages after the cancel on page 2", n)
+	}
+	if n := strings.Count(out.String(), "\n"); n != 15 {
+		t.Errorf("listing printed %d lines, want 15, up to the cancel", n)
+	}
+}
+
+// TestListingStopsAtDeadline checks that a listing whose deadline passes
+// reports how far it got rather than the interruption of a signal.
+func TestListingStopsAtDeadline(t *testing.T) {
+	var names []string
+	for i := range 1000 {
+		names = append(names, fmt.Sprintf("logs/%04d.log", i))
+	}
+	fake := newFakeGCS(t, "deadline", names, 10)
+	fake.onPage = func(page int) {
+		if page == 2 {
+			time.Sleep(200 * time.Millisecond)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	opts, args, err := parseArgs([]string{"--assume-exists", "gs://deadline/logs/**"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = listObjectsWithWildcard(ctx, args, opts, io.Discard, io.Discard)
+	if err == nil || errors.Is(err, errInterrupted) {
+		t.Fatalf("listing returned %v, want a deadline error", err)
+	}
+	if n := fake.pagesServed(); n > 2 {
+		t.Errorf("listing fetched %d pages after its deadline", n)
+	}
+}
+
+// TestMatchOn checks what a pattern matches against object names and, with
+// --match-on url, against full gs:// URLs, including an object whose name
+// itself looks like a URL.
+func TestMatchOn(t *testing.T) {
+	newFakeGCS(t, "url", []string{"logs/app/1.log", "logs/app/2.txt", "gs://url/logs/app/3.log"}, 100)
+	tests := []struct {
+		flags   []string
+		pattern string
+		want    []string
+	}{
+		{nil, "gs://url/logs/*/*.log", []string{"logs/app/1.log"}},
+		{nil, "gs://url/gs://url/logs/*/*.log", []string{"gs://url/logs/app/3.log"}},
+		{[]string{"--match-on", "name"}, "gs://url/gs://url/logs/*/*.log", []string{"gs://url/logs/app/3.log"}},
+		{[]string{"--match-on", "url"}, "gs://url/gs://url/logs/*/*.log", []string{"logs/app/1.log"}},
+		{[]string{"--match-on", "url"}, "gs://url/logs/*/*.log", nil},
+		{[]string{"--match-on", "url"}, "gs://url/**/*.log", []string{"gs://url/logs/app/3.log", "logs/app/1.log"}},
+	}
+	for _, tt := range tests {
+		got := listedNames(t, append(tt.flags, tt.pattern)...)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s with %v matched %v, want %v", tt.pattern, tt.flags, got, tt.want)
+		}
+	}
+	if _, _, err := parseArgs([]string{"--match-on", "path", "gs://url/**"}); err == nil {
+		t.Error("--match-on path was accepted")
+	}
+}
+
+// TestMatchEmptyPrefixObjects checks that the placeholder object named
+// exactly like the pattern's literal prefix matches by default and is left
+// out with --match-empty-prefix-objects=false, without affecting the rest.
+func TestMatchEmptyPrefixObjects(t *testing.T) {
+	newFakeGCS(t, "empty", []string{"logs/", "logs/a.log", "logs/app/", "logs/app/b.log"}, 100)
+	tests := []struct {
+		flags   []string
+		pattern string
+		want    []string
+	}{
+		{nil, "gs://empty/logs/**", []string{"logs/", "logs/a.log", "logs/app/", "logs/app/b.log"}},
+		{[]string{"--match-empty-prefix-objects=false"}, "gs://empty/logs/**", []string{"logs/a.log", "logs/app/", "logs/app/b.log"}},
+		{nil, "gs://empty/logs/*", []string{"logs/", "logs/a.log"}},
+		{[]string{"--match-empty-prefix-objects=false"}, "gs://empty/logs/*", []string{"logs/a.log"}},
+		{[]string{"--match-empty-prefix-objects=false"}, "gs://empty/logs/app/**", []string{"logs/app/b.log"}},
+	}
+	for _, tt := range tests {
+		got := listedNames(t, append(tt.flags, tt.pattern)...)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s with %v matched %v, want %v", tt.pattern, tt.flags, got, tt.want)
+		}
+	}
+}
+
+// TestInvertMatch checks that --invert-match keeps the objects under the
+// prefix that the pattern does not match, in listings and counts alike.
+func TestInvertMatch(t *testing.T) {
+	newFakeGCS(t, "inv", []string{"exports/a.parquet", "exports/x/b.parquet", "exports/c.csv", "exports/d/e.json", "exports/readme", "other/z"}, 100)
+	tests := []struct {
+		flags []string
+		want  []string
+	}{
+		{nil, []string{"exports/a.parquet", "exports/x/b.parquet"}},
+		{[]string{"--invert-match"}, []string{"exports/c.csv", "exports/d/e.json", "exports/readme"}},
+	}
+	for _, tt := range tests {
+		got := listedNames(t, append(tt.flags, "gs://inv/exports/**/*.parquet")...)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("listing with %v = %v, want %v", tt.flags, got, tt.want)
+		}
+
+		opts, args, err := parseArgs(append(tt.flags, "--count", "gs://inv/exports/**/*.parquet"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out strings.Builder
+		if err := countMatches(t.Context(), args, opts, &out, io.Discard); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := strings.TrimSpace(out.String()), strconv.Itoa(len(tt.want)); got != want {
+			t.Errorf("--count with %v printed %q, want %s", tt.flags, got, want)
+		}
+	}
+}
+
+// TestCaseSafePrefix checks where --ignore-case cuts the literal prefix
+// short: before the first character that has another case.
+func TestCaseSafePrefix(t *testing.T) {
+	tests := []struct {
+		prefix, want string
+	}{
+		{"", ""},
+		{"logs/2024/", ""},
+		{"2024/Jan/", "2024/"},
+		{"2024/01/", "2024/01/"},
+		{"2024_01-02/Été/", "2024_01-02/"},
+	}
+	for _, tt := range tests {
+		if got := caseSafePrefix(tt.prefix); got != tt.want {
+			t.Errorf("caseSafePrefix(%q) = %q, want %q", tt.prefix, got, tt.want)
+		}
+	}
+}
+
+// TestIgnoreCase checks that --ignore-case finds the names whose literal
+// prefix differs in case from the pattern's, which a listing by the
+// pattern's own prefix would miss.
+func TestIgnoreCase(t *testing.T) {
+	fake := newFakeGCS(t, "case", []string{"LOGS/2024/b.CSV", "Logs/2024/a.csv", "logs/2024/c.csv", "logs/2025/d.csv", "2024/JAN/y", "2024/Jan/x", "2025/jan/z"}, 100)
+	tests := []struct {
+		flags      []string
+		pattern    string
+		want       []string
+		wantPrefix string
+	}{
+		{nil, "logs/2024/*.csv", []string{"logs/2024/c.csv"}, "logs/2024/"},
+		{[]string{"--ignore-case"}, "logs/2024/*.csv", []string{"LOGS/2024/b.CSV", "Logs/2024/a.csv", "logs/2024/c.csv"}, ""},
+		{[]string{"--ignore-case"}, "Logs/2024/*.CSV", []string{"LOGS/2024/b.CSV", "Logs/2024/a.csv", "logs/2024/c.csv"}, ""},
+		{nil, "2024/Jan/*", []string{"2024/Jan/x"}, "2024/Jan/"},
+		{[]string{"--ignore-case"}, "2024/Jan/*", []string{"2024/JAN/y", "2024/Jan/x"}, "2024/"},
+	}
+	for _, tt := range tests {
+		before := len(fake.prefixes())
+		got := listedNames(t, append(tt.flags, "gs://case/"+tt.pattern)...)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s with %v matched %v, want %v", tt.pattern, tt.flags, got, tt.want)
+		}
+		if prefixes := fake.prefixes()[before:]; !slices.Equal(prefixes, []string{tt.wantPrefix}) {
+			t.Errorf("%s with %v listed with prefixes %q, want %q", tt.pattern, tt.flags, prefixes, tt.wantPrefix)
+		}
+	}
+}
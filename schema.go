@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// bigQueryField is one column of a BigQuery JSON schema. A RECORD column
+// has the fields nested in it.
+type bigQueryField struct {
+	Name   string          `json:"name"`
+	Type   string          `json:"type"`
+	Mode   string          `json:"mode"`
+	Fields []bigQueryField `json:"fields,omitempty"`
+}
+
+// recordSchema returns the BigQuery schema of the objectRecord written by
+// --ndjson under the options. It is derived from the record's struct tags,
+// so the two cannot drift apart. Fields that are left out when empty are
+// NULLABLE, and fields that only some options add are only included with
+// those options.
+func recordSchema(opts *options) []bigQueryField {
+	optional := map[string]bool{
+		"label":          opts.patternFile != "",
+		"tags":           len(opts.tags) > 0,
+		"lifecycle":      opts.lifecyclePreview,
+		"metageneration": opts.withMetageneration,
+		"partitions":     len(opts.partitionSpec) > 0,
+		"date":           opts.extractDate != nil,
+		"retention":      opts.withRetention,
+		"customTime":     opts.withCustomTime,
+	}
+	var fields []bigQueryField
+	t := reflect.TypeOf(objectRecord{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, flags, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if include, ok := optional[name]; ok && !include {
+			continue
+		}
+		typ := f.Tag.Get("bigquery")
+		// The only map is the partitions, whose keys the spec names.
+		var nested []bigQueryField
+		mode := "REQUIRED"
+		if strings.Contains(flags, "omitempty") || strings.Contains(flags, "omitzero") {
+			mode = "NULLABLE"
+		}
+		if typ == "" {
+			switch f.Type.Kind() {
+			case reflect.Int64:
+				typ = "INTEGER"
+			case reflect.Struct:
+				// The only record besides the date, which has its own type,
+				// is the retention.
+				typ = "RECORD"
+				nested = []bigQueryField{{Name: "mode", Type: "STRING", Mode: "REQUIRED"},
+					{Name: "retainUntil", Type: "TIMESTAMP", Mode: "REQUIRED"}}
+			case reflect.Slice:
+				// The only list is the tags, of which every object has one
+				// at least.
+				typ, mode = "STRING", "REPEATED"
+			case reflect.Map:
+				typ = "RECORD"
+				for _, key := range opts.partitionSpec {
+					nested = append(nested, bigQueryField{Name: key, Type: "STRING", Mode: "REQUIRED"})
+				}
+			default:
+				typ = "STRING"
+			}
+		}
+		fields = append(fields, bigQueryField{Name: name, Type: typ, Mode: mode, Fields: nested})
+	}
+	return fields
+}
+
+// emitSchema prints the BigQuery schema for --ndjson output as a JSON
+// array, the form `bq mk --schema` and `bq load --schema` accept.
+func emitSchema(w io.Writer, opts *options) error {
+	b, err := json.MarshalIndent(recordSchema(opts), "", "  ")
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "%s\n", b); err != nil {
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+// Script kinds accepted by --emit-script.
+const (
+	scriptGsutilRm = "gsutil-rm"
+	scriptTFImport = "tf-import"
+)
+
+// scriptFormatter renders matched objects as lines of a shell script that
+// performs a mutation with another tool, since gcsls itself is read-only.
+type scriptFormatter struct {
+	kind string
+	// versions pins each command to the listed generation.
+	versions bool
+	// resources tracks Terraform resource names already used, so that
+	// objects whose names sanitize to the same identifier stay distinct.
+	resources map[string]int
+	// status receives the warnings for the objects left out of the script.
+	status io.Writer
+}
+
+// newScriptFormatter returns a scriptFormatter for the given --emit-script
+// kind, warning on status about the objects it cannot write a command for.
+func newScriptFormatter(kind string, versions bool, status io.Writer) *scriptFormatter {
+	return &scriptFormatter{kind: kind, versions: versions, resources: make(map[string]int), status: status}
+}
+
+// header writes the script preamble.
+func (s *scriptFormatter) header(w io.Writer, targets []listTarget) error {
+	_, err := fmt.Fprintf(w, "#!/bin/sh\n# %s commands generated by gcsls for %s\nset -e\n",
+		s.kind, describeTargets(targets))
+	return err
+}
+
+// object writes the command for one matched object.
+func (s *scriptFormatter) object(w io.Writer, attrs *storage.ObjectAttrs) error {
+	var err error
+	switch s.kind {
+	case scriptGsutilRm:
+		url := newObjectResult(attrs).gsURL()
+		// gsutil expands wildcards in the URL itself, after the shell, and
+		// has no way to escape them, so the command could remove other
+		// objects than this one.
+		if strings.ContainsAny(attrs.Name, "*?[") {
+			fmt.Fprintf(s.status, "Warning: skipped %q: gsutil rm would expand the wildcard characters in its name\n", url)
+			return nil
+		}
+		if s.versions {
+			url = fmt.Sprintf("%s#%d", url, attrs.Generation)
+		}
+		_, err = fmt.Fprintf(w, "gsutil rm %s\n", shellQuote(url))
+	case scriptTFImport:
+		address := "google_storage_bucket_object." + s.resourceName(attrs.Name)
+		id := attrs.Bucket + "/" + attrs.Name
+		_, err = fmt.Fprintf(w, "terraform import %s %s\n", shellQuote(address), shellQuote(id))
+	}
+	return err
+}
+
+// footer writes nothing; a script simply ends after its last command.
+func (s *scriptFormatter) footer(w io.Writer) error {
+	return nil
+}
+
+// machineReadable reports that scripts must not contain status messages.
+func (s *scriptFormatter) machineReadable() bool {
+	return true
+}
+
+// resourceName derives a unique Terraform resource name from an object name.
+// Terraform names may only contain letters, digits, underscores and dashes,
+// and must start with a letter or underscore.
+func (s *scriptFormatter) resourceName(objectName string) string {
+	var b strings.Builder
+	for _, r := range objectName {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-':
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	name := b.String()
+	if name == "" || !(name[0] == '_' || (name[0] >= 'a' && name[0] <= 'z') || (name[0] >= 'A' && name[0] <= 'Z')) {
+		name = "_" + name
+	}
+
+	s.resources[name]++
+	if n := s.resources[name]; n > 1 {
+		name = fmt.Sprintf("%s_%d", name, n)
+	}
+	return name
+}
+
+// shellQuote quotes s for safe use as a single POSIX shell word.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+// segmentKey returns the group of an object under --segment n: its bucket
+// and the first n /-separated segments of its name, ending in "/" if the
+// name goes on. Since GCS lists names in order, and every name starting
+// with a given prefix sorts together, the objects of a group arrive one
+// after the other.
+func segmentKey(attrs *storage.ObjectAttrs, n int) string {
+	parts := strings.SplitAfterN(attrs.Name, "/", n+1)
+	if len(parts) > n {
+		parts = parts[:n]
+	}
+	return attrs.Bucket + "/" + strings.Join(parts, "")
+}
+
+// segmentFormatter writes the lines of a text formatter with a delimiter
+// line between groups of --segment, flushing each group once the next one
+// starts, so that a consumer can process one partition at a time.
+type segmentFormatter struct {
+	formatter
+	segment int
+	key     string
+	started bool
+}
+
+// object writes one object, preceded by a delimiter when it starts a new
+// group.
+func (f *segmentFormatter) object(w io.Writer, attrs *storage.ObjectAttrs) error {
+	key := segmentKey(attrs, f.segment)
+	if f.started && key != f.key {
+		if _, err := fmt.Fprintln(w, chunkDelimiter); err != nil {
+			return err
+		}
+		if err := flushChunk(w); err != nil {
+			return err
+		}
+	}
+	f.key, f.started = key, true
+	return f.formatter.object(w, attrs)
+}
+
+// segmentedJSONFormatter prints the matched objects as one JSON array per
+// line for each group of --segment. Only the current group is held in
+// memory.
+type segmentedJSONFormatter struct {
+	segment int
+	extra   annotations
+	key     string
+	records []objectRecord
+}
+
+// header writes nothing; every group is a complete array.
+func (f *segmentedJSONFormatter) header(w io.Writer, targets []listTarget) error {
+	return nil
+}
+
+// object adds an object to its group, first writing the previous group if
+// the object starts a new one.
+func (f *segmentedJSONFormatter) object(w io.Writer, attrs *storage.ObjectAttrs) error {
+	key := segmentKey(attrs, f.segment)
+	if len(f.records) > 0 && key != f.key {
+		if err := f.writeGroup(w); err != nil {
+			return err
+		}
+		if err := flushChunk(w); err != nil {
+			return err
+		}
+	}
+	f.key = key
+	f.records = append(f.records, f.extra.record(attrs))
+	return nil
+}
+
+// footer writes the last group.
+func (f *segmentedJSONFormatter) footer(w io.Writer) error {
+	return f.writeGroup(w)
+}
+
+// writeGroup writes the pending records as one array line.
+func (f *segmentedJSONFormatter) writeGroup(w io.Writer) error {
+	if len(f.records) == 0 {
+		return nil
+	}
+	b, err := json.Marshal(f.records)
+	if err != nil {
+		return err
+	}
+	f.records = f.records[:0]
+	_, err = fmt.Fprintf(w, "%s\n", b)
+	return err
+}
+
+// machineReadable reports that JSON output must not contain status messages.
+func (f *segmentedJSONFormatter) machineReadable() bool {
+	return true
+}
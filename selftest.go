@@ -0,0 +1,391 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// selfTestCase is one row of the --self-test table: whether name is
+// expected to match the object part of a pattern, and what the case shows.
+type selfTestCase struct {
+	pattern string
+	name    string
+	want    bool
+	note    string
+}
+
+// selfTestCases covers the glob rules users most often ask about.
+var selfTestCases = []selfTestCase{
+	{"*.csv", "a.csv", true, "* matches within one level"},
+	{"*.csv", "a/b.csv", false, "* does not cross /"},
+	{"data/*", "data/a/b", false, "* does not cross / below a directory either"},
+	{"**/x.txt", "x.txt", true, "** also matches the top level"},
+	{"**/x.txt", "a/b/x.txt", true, "** crosses any number of levels"},
+	{"a/**/b", "a/b", true, "/**/ may match a single /"},
+	{"logs/**", "logs/", true, "dir/** includes the placeholder object dir/"},
+	{"logs/", "logs/app/1.log", true, "a trailing / matches everything below it"},
+	{"", "any/object", true, "an empty pattern matches the whole bucket"},
+	{"a?c", "abc", true, "? matches one character"},
+	{"a?c", "a/c", false, "? does not match /"},
+	{"[ab]*", "bx", true, "[ab] matches one of the listed characters"},
+	{"[!a]*", "bx", true, "[!a] matches any character but a"},
+	{"{a,b}.txt", "b.txt", true, "{a,b} matches either alternative"},
+	{"*.log", ".log", true, "* also matches an empty string"},
+	{"*.CSV", "a.csv", false, "matching is case-sensitive without --ignore-case"},
+	{`\*`, "*", true, `\ makes the next character literal`},
+	{`a\[b]/*`, "a[b]/x", true, `an escaped [ stays part of the prefix`},
+	{`a\*b/*.txt`, "a*b/c.txt", true, `an escaped * stays part of the prefix`},
+	{`{x,y}/*`, "x/1", true, `{ ends the prefix like the other wildcards`},
+}
+
+// selfTestPathCases covers where --glob-syntax path, the rules of
+// path.Match, differs from the default.
+var selfTestPathCases = []selfTestCase{
+	{"*.csv", "a.csv", true, "* matches within one level"},
+	{"*.csv", "a/b.csv", false, "* does not cross /"},
+	{"**/x.txt", "a/b/x.txt", false, "** is two stars and does not cross / either"},
+	{"**/x.txt", "x.txt", false, "**/ needs a directory level"},
+	{"a/**/b", "a/x/b", true, "/**/ matches exactly one level"},
+	{"a/**/b", "a/b", false, "/**/ does not match a single /"},
+	{"logs/**", "logs/app/1.log", true, "a trailing /** still matches everything below"},
+	{"logs/", "logs/app/1.log", true, "a trailing / matches everything below it"},
+	{"", "any/object", true, "an empty pattern matches the whole bucket"},
+	{"{a,b}.txt", "b.txt", false, "{ and } are ordinary characters"},
+	{"{a,b}.txt", "{a,b}.txt", true, "so they only match themselves"},
+	{"[^a]*", "bx", true, "[^a] matches any character but a"},
+	{"[!a]*", "!x", true, "[!a] matches ! or a; ! does not negate"},
+	{"a?c", "a/c", false, "? does not match /"},
+	{`\*`, "*", true, `\ makes the next character literal`},
+}
+
+// selfTestIgnoreCaseCases covers --ignore-case, including names whose
+// literal prefix differs in case from the pattern's, which the listing
+// must still reach.
+var selfTestIgnoreCaseCases = []selfTestCase{
+	{"*.CSV", "a.csv", true, "with --ignore-case, case does not matter"},
+	{"Logs/*.txt", "logs/a.txt", true, "not in the literal prefix either"},
+	{"logs/2024/*", "LOGS/2024/x", true, "the prefix stops before the first letter"},
+	{"2024/Jan/*", "2024/JAN/x", true, "digits and punctuation still narrow it"},
+	{"data/*.csv", "DATA/sub/a.CSV", false, "* still does not cross /"},
+}
+
+// selfTestUnanchoredCases covers --unanchored, under which the pattern
+// matches any part of the name and the listing starts at the top.
+var selfTestUnanchoredCases = []selfTestCase{
+	{"report", "a/b/q1-report.pdf", true, "with --unanchored, the pattern may match any part"},
+	{"foo*.log", "x/foo1.log.gz", true, "including the middle of a segment"},
+	{"logs/*.txt", "app/logs/a.txt", true, "a pattern with / may start at any level"},
+	{"logs/*.txt", "logs/a/b.txt", false, "* still does not cross /"},
+	{"a?c", "x/a/c", false, "nor does ?"},
+	{"*.CSV", "a.csv", false, "case still matters without --ignore-case"},
+}
+
+// selfTestSlashCases covers how --normalize-slashes changes what matches.
+var selfTestSlashCases = []selfTestCase{
+	{"logs/24/*.log", "logs//24/x.log", true, "with --normalize-slashes, // matches a single /"},
+	{"logs/*.log", "logs//x.log", true, "the empty level between // is gone"},
+	{"logs/*/x.log", "logs//x.log", false, "so * cannot match it"},
+	{"logs//2024/*", "logs/2024/x.log", true, "repeated slashes in the pattern collapse too"},
+	{"a/b/c", "a///b//c", true, "any run of slashes counts as one"},
+	{"logs/**", "logs//", true, "dir/** includes the placeholder dir// as well"},
+}
+
+// strictTestCase is one row of the --strict-glob part of the --self-test
+// table: whether the pattern, listed with --after set to after, is
+// expected to pass the check.
+type strictTestCase struct {
+	pattern string
+	after   string
+	want    bool
+	note    string
+}
+
+// strictTestCases covers which patterns --strict-glob lets through.
+var strictTestCases = []strictTestCase{
+	{"**", "", false, "** lists the whole bucket"},
+	{"", "", false, "so does an empty pattern"},
+	{"*.csv", "", false, "a leading wildcard leaves no prefix"},
+	{"a*", "", false, "a one-character prefix barely narrows the listing"},
+	{"{x,y}/*", "", false, "{ ends the prefix like the other wildcards"},
+	{"ab*", "", true, "two characters are enough"},
+	{"logs/*.csv", "", true, "a leading directory narrows the listing"},
+	{"**", "logs/", true, "--after bounds a listing without a prefix"},
+}
+
+// resumeTestCase is one row of the --listing-mode part of the --self-test
+// table: a bucket holding objects, given in listing order as NAME or
+// NAME#GENERATION, whose listing with --listing-mode consistent fails after
+// failAfter[i] objects on its i-th attempt, and what the scan is expected
+// to see, ending in "failed" if the listing gives up. With rewind set, a
+// restarted listing ignores the offset and starts from the first object.
+type resumeTestCase struct {
+	objects   string
+	failAfter []int
+	rewind    bool
+	want      string
+	note      string
+}
+
+// resumeTestCases covers how a resumed listing avoids returning an object
+// twice.
+var resumeTestCases = []resumeTestCase{
+	{"a b c d", []int{2}, false, "a b c d", "the restart from b skips b"},
+	{"a b c d", []int{2}, true, "a b c d", "a restart from the start skips a and b"},
+	{"a b c", []int{0, 1, 1}, false, "a b c", "restarts that return something new go on"},
+	{"x#1 x#2 x#3 y", []int{2}, false, "x#1 x#2 x#3 y", "generations of the last name are told apart"},
+	{"a b", []int{1, 0, 0, 0}, false, "a failed", "three restarts without anything new give up"},
+}
+
+// retryTestCase is one row of the retry part of the --self-test table: a
+// request that GCS answers with failures 503 responses before it succeeds,
+// sent with a retry budget of budget, and how it is expected to end, as
+// the last status and the number of attempts.
+type retryTestCase struct {
+	failures int
+	budget   time.Duration
+	want     string
+	note     string
+}
+
+// retryTestCases covers when a failed request is retried.
+var retryTestCases = []retryTestCase{
+	{0, 2 * time.Minute, "200 after 1", "a success is not retried"},
+	{3, 2 * time.Minute, "200 after 4", "each failure is retried after a backoff"},
+	{3, time.Nanosecond, "503 after 1", "an exhausted budget returns the failure"},
+	{30, 2 * time.Minute, "open after 10", "the breaker opens when half of 10 fail"},
+}
+
+// runSelfTest checks the matcher of the glob syntax against its table of
+// cases, selfTestCases or selfTestPathCases, then checks --strict-glob
+// against strictTestCases, resumed listings against resumeTestCases and
+// retries against retryTestCases, and prints one line per case. It
+// returns an error if any case fails.
+func runSelfTest(w io.Writer, syntax string) error {
+	opts := &options{matchOn: matchOnName, globSyntax: syntax}
+	cases := selfTestCases
+	if syntax == globPath {
+		cases = selfTestPathCases
+	}
+	// The matching options that change what a pattern means have tables
+	// of their own.
+	tables := []struct {
+		opts  *options
+		cases []selfTestCase
+	}{
+		{opts, cases},
+		{&options{matchOn: matchOnName, globSyntax: syntax, ignoreCase: true}, selfTestIgnoreCaseCases},
+		{&options{matchOn: matchOnName, globSyntax: syntax, normalizeSlashes: true}, selfTestSlashCases},
+		{&options{matchOn: matchOnName, globSyntax: syntax, unanchored: true}, selfTestUnanchoredCases},
+	}
+	failed, matchCases := 0, 0
+	for _, t := range tables {
+		matchCases += len(t.cases)
+		for _, c := range t.cases {
+			got, err := selfTestMatch(c, t.opts)
+			status := "PASS"
+			if err != nil || got != c.want {
+				status = "FAIL"
+				failed++
+			}
+			verb := "matches"
+			if !c.want {
+				verb = "does not match"
+			}
+			// Quoted by hand so that backslashes show as typed.
+			fmt.Fprintf(w, "%s  %-14s %-15s %-18s %s\n", status, `"`+c.pattern+`"`, verb, `"`+c.name+`"`, c.note)
+			if err != nil {
+				fmt.Fprintf(w, "      error: %v\n", err)
+			}
+		}
+	}
+	for _, c := range strictTestCases {
+		_, err := resolveTarget("gs://bucket/"+c.pattern, &options{matchOn: matchOnName, globSyntax: syntax, strictGlob: true, after: c.after})
+		status := "PASS"
+		if (err == nil) != c.want {
+			status = "FAIL"
+			failed++
+		}
+		verb := "passes"
+		if !c.want {
+			verb = "fails"
+		}
+		after := ""
+		if c.after != "" {
+			after = "--after " + c.after
+		}
+		fmt.Fprintf(w, "%s  %-14s %-15s %-18s %s\n", status, `"`+c.pattern+`"`, verb+" strict", after, c.note)
+	}
+	for _, c := range resumeTestCases {
+		got := selfTestResume(c)
+		status := "PASS"
+		if got != c.want {
+			status = "FAIL"
+			failed++
+		}
+		fmt.Fprintf(w, "%s  %-14s %-15s %-18s %s\n", status, `"`+c.objects+`"`, "resumes as", `"`+got+`"`, c.note)
+	}
+	for _, c := range retryTestCases {
+		got, ok := selfTestRetry(c)
+		status := "PASS"
+		if !ok || got != c.want {
+			status = "FAIL"
+			failed++
+		}
+		fmt.Fprintf(w, "%s  %-14s %-15s %-18s %s\n", status, fmt.Sprintf("%d x 503", c.failures), "retries as", `"`+got+`"`, c.note)
+	}
+	total := matchCases + len(strictTestCases) + len(resumeTestCases) + len(retryTestCases)
+	if failed > 0 {
+		return fmt.Errorf("self-test failed: %d of %d cases", failed, total)
+	}
+	fmt.Fprintf(w, "All %d cases passed.\n", total)
+	return nil
+}
+
+// selfTestResume lists the case's objects through resumeListing, failing
+// as the case says, and returns what the listing returned.
+func selfTestResume(c resumeTestCase) string {
+	var objects []*storage.ObjectAttrs
+	for _, o := range strings.Fields(c.objects) {
+		name, generation, _ := strings.Cut(o, "#")
+		g, _ := strconv.ParseInt(generation, 10, 64)
+		objects = append(objects, &storage.ObjectAttrs{Name: name, Generation: g})
+	}
+	attempt := 0
+	next := resumeListing(context.Background(), "", func(start string) func() (*storage.ObjectAttrs, error) {
+		failAfter := -1
+		if attempt < len(c.failAfter) {
+			failAfter = c.failAfter[attempt]
+		}
+		attempt++
+		i, n := 0, 0
+		for !c.rewind && i < len(objects) && objects[i].Name < start {
+			i++
+		}
+		return func() (*storage.ObjectAttrs, error) {
+			if n == failAfter {
+				return nil, errors.New("listing failed")
+			}
+			if i == len(objects) {
+				return nil, iterator.Done
+			}
+			i, n = i+1, n+1
+			return objects[i-1], nil
+		}
+	})
+	var got []string
+	for {
+		attrs, err := next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			got = append(got, "failed")
+			break
+		}
+		if attrs.Generation != 0 {
+			got = append(got, attrs.Name+"#"+strconv.FormatInt(attrs.Generation, 10))
+		} else {
+			got = append(got, attrs.Name)
+		}
+	}
+	return strings.Join(got, " ")
+}
+
+// manualClock is a retryClock whose time only moves when the transport
+// sleeps, recording each sleep.
+type manualClock struct {
+	now    time.Time
+	sleeps []time.Duration
+}
+
+// Now returns the time the sleeps so far add up to.
+func (c *manualClock) Now() time.Time {
+	return c.now
+}
+
+// Sleep records d and moves the time on by it at once.
+func (c *manualClock) Sleep(ctx context.Context, d time.Duration) error {
+	c.sleeps = append(c.sleeps, d)
+	c.now = c.now.Add(d)
+	return nil
+}
+
+// failingTransport answers failures requests with 503 before it answers
+// 200, counting the attempts.
+type failingTransport struct {
+	failures int
+	attempts int
+}
+
+// RoundTrip answers the request without sending it.
+func (t *failingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.attempts++
+	code := http.StatusOK
+	if t.attempts <= t.failures {
+		code = http.StatusServiceUnavailable
+	}
+	return &http.Response{StatusCode: code, Status: fmt.Sprintf("%d %s", code, http.StatusText(code)),
+		Body: io.NopCloser(strings.NewReader("")), Request: req}, nil
+}
+
+// selfTestRetry sends the case's request twice through a retryTransport
+// with the same jitter seed and a manual clock, and returns how the first
+// ended. ok reports that both waited the same delays, each within the
+// backoff step of its retry.
+func selfTestRetry(c retryTestCase) (got string, ok bool) {
+	var runs [2][]time.Duration
+	for i := range runs {
+		clock := &manualClock{}
+		base := &failingTransport{failures: c.failures}
+		t := &retryTransport{base: base, budget: c.budget, breaker: &circuitBreaker{}, clock: clock,
+			jitter: rand.New(rand.NewPCG(1, 1))}
+		req, _ := http.NewRequest(http.MethodGet, "https://storage.googleapis.com/storage/v1/b/bucket/o", nil)
+		resp, err := t.RoundTrip(req)
+		if i == 0 {
+			switch {
+			case err != nil && strings.HasPrefix(err.Error(), "giving up"):
+				got = fmt.Sprintf("open after %d", base.attempts)
+			case err != nil:
+				got = err.Error()
+			default:
+				got = fmt.Sprintf("%d after %d", resp.StatusCode, base.attempts)
+			}
+		}
+		runs[i] = clock.sleeps
+	}
+	for attempt, d := range runs[0] {
+		if d > min(retryMaxDelay, retryBaseDelay<<attempt) {
+			return got, false
+		}
+	}
+	return got, slices.Equal(runs[0], runs[1])
+}
+
+// selfTestMatch expands the case's pattern as a listing would and matches
+// the name against it. A name the glob matches must also start with the
+// pattern's literal prefix, or a listing would never see it.
+func selfTestMatch(c selfTestCase, opts *options) (bool, error) {
+	pattern, err := expandPattern(c.pattern, opts)
+	if err != nil {
+		return false, err
+	}
+	ok, err := newNameMatcher(listTarget{bucket: "bucket", pattern: pattern}, opts)(c.name)
+	if err != nil || !ok {
+		return ok, err
+	}
+	if prefix := queryPrefix("bucket", pattern, opts); !strings.HasPrefix(c.name, prefix) {
+		return false, fmt.Errorf("the glob matches, but the listing prefix %q excludes the name", prefix)
+	}
+	return true, nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestSelfTest checks that every built-in --self-test case passes for both
+// glob syntaxes.
+func TestSelfTest(t *testing.T) {
+	for _, syntax := range []string{globDoublestar, globPath} {
+		var out strings.Builder
+		if err := runSelfTest(&out, syntax); err != nil {
+			t.Errorf("--self-test with --glob-syntax %s: %v\n%s", syntax, err, out.String())
+		}
+		if strings.Contains(out.String(), "FAIL") || !strings.Contains(out.String(), "PASS") {
+			t.Errorf("--self-test with --glob-syntax %s printed:\n%s", syntax, out.String())
+		}
+	}
+}
+
+// TestSelfTestReportsFailure checks that a case the matcher disagrees with
+// is reported and fails the self-test.
+func TestSelfTestReportsFailure(t *testing.T) {
+	saved := selfTestCases
+	t.Cleanup(func() { selfTestCases = saved })
+	selfTestCases = append(saved[:len(saved):len(saved)], selfTestCase{"*.csv", "a/b.csv", true, "a wrong expectation"})
+
+	var out strings.Builder
+	if err := runSelfTest(&out, globDoublestar); err == nil {
+		t.Error("--self-test passed with a wrong case")
+	}
+	if !strings.Contains(out.String(), "FAIL") || !strings.Contains(out.String(), "a wrong expectation") {
+		t.Errorf("--self-test did not report the wrong case:\n%s", out.String())
+	}
+}
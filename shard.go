@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// shardAlphabet holds, in byte order, the characters most object names
+// continue with after a prefix. --shards splits the name range at evenly
+// spaced characters of it; names continuing with anything else fall into
+// the first or last shard, which are open-ended.
+const shardAlphabet = "-.0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ_abcdefghijklmnopqrstuvwxyz"
+
+// maxShards is the most shards the alphabet can split a prefix into.
+const maxShards = len(shardAlphabet)
+
+// shardQueries splits query into n queries over consecutive, disjoint
+// ranges of the names under its prefix, using StartOffset and EndOffset.
+// A shard that lies entirely before the query's own StartOffset is
+// dropped.
+func shardQueries(query *storage.Query, n int) []*storage.Query {
+	var queries []*storage.Query
+	start := query.StartOffset
+	for i := 0; i < n; i++ {
+		q := *query
+		if i > 0 {
+			q.StartOffset = max(start, query.Prefix+string(shardAlphabet[i*len(shardAlphabet)/n]))
+		}
+		if i < n-1 {
+			q.EndOffset = query.Prefix + string(shardAlphabet[(i+1)*len(shardAlphabet)/n])
+			if q.EndOffset <= q.StartOffset {
+				continue
+			}
+		}
+		queries = append(queries, &q)
+	}
+	return queries
+}
+
+// shardResult is one object listed by a shard, or the error that ended it.
+type shardResult struct {
+	attrs *storage.ObjectAttrs
+	err   error
+}
+
+// listShards lists the shards of query concurrently and returns a next
+// function with the contract of ObjectIterator.Next: it returns the objects
+// of all shards as they arrive, so not in name order, then iterator.Done.
+// The first shard error is returned by next. stop cancels the shards still
+// running and waits for them to finish; it must be called once the caller
+// is done.
+func listShards(ctx context.Context, client *storage.Client, bucket string, query *storage.Query, n int,
+	opts *options) (next func() (*storage.ObjectAttrs, error), stop func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	queries := shardQueries(query, n)
+	results := make(chan shardResult, len(queries))
+	var wg sync.WaitGroup
+	for _, q := range queries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			next := listMatches(ctx, client, bucket, q, opts)
+			for {
+				attrs, err := next()
+				if err == iterator.Done {
+					return
+				}
+				select {
+				case results <- shardResult{attrs: attrs, err: err}:
+				case <-ctx.Done():
+					return
+				}
+				if err != nil {
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	next = func() (*storage.ObjectAttrs, error) {
+		r, ok := <-results
+		if !ok {
+			return nil, iterator.Done
+		}
+		return r.attrs, r.err
+	}
+	stop = func() {
+		cancel()
+		for range results {
+		}
+	}
+	return next, stop
+}
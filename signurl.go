@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// maxSignedURLExpiry is the longest lifetime GCS allows a V4 signed URL.
+const maxSignedURLExpiry = 7 * 24 * time.Hour
+
+// signedURLRecord is the --ndjson record of --sign-urls.
+type signedURLRecord struct {
+	Bucket    string `json:"bucket"`
+	Name      string `json:"name"`
+	SignedURL string `json:"signedUrl"`
+	Expires   string `json:"expires"`
+}
+
+// urlSigner signs a download URL for each matched object. All the URLs of a
+// run share one expiry time, so that the links handed out together also
+// stop working together.
+type urlSigner struct {
+	client *storage.Client
+	opts   storage.SignedURLOptions
+	// versions pins each URL to the listed generation, as the listing of
+	// --versions holds several of one object.
+	versions bool
+	ndjson   bool
+	out      *lockedWriter
+}
+
+// newURLSigner returns a signer of URLs valid for expiry from now. The
+// URLs are signed with the private key of the service account key file in
+// GOOGLE_APPLICATION_CREDENTIALS, if there is one, without any request.
+// Otherwise each is signed by the IAM signBlob method, as account or, by
+// default, as the service account of the GCE metadata server, which takes
+// a request per object; the worker pool runs them --concurrency at a time.
+func newURLSigner(client *storage.Client, expiry time.Duration, account string, versions, ndjson bool, w io.Writer) (*urlSigner, error) {
+	s := &urlSigner{
+		client: client,
+		opts: storage.SignedURLOptions{
+			Scheme:         storage.SigningSchemeV4,
+			Method:         "GET",
+			Expires:        time.Now().Add(expiry),
+			GoogleAccessID: account,
+		},
+		versions: versions,
+		ndjson:   ndjson,
+		out:      &lockedWriter{w: w},
+	}
+	if account != "" {
+		return s, nil
+	}
+	email, key, err := serviceAccountKey(os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"))
+	if err != nil {
+		return nil, err
+	}
+	s.opts.GoogleAccessID, s.opts.PrivateKey = email, key
+	return s, nil
+}
+
+// serviceAccountKey returns the account and private key of the credentials
+// file at path, or nothing if there is no file or it holds another kind of
+// credentials, such as those of a user.
+func serviceAccountKey(path string) (email string, key []byte, err error) {
+	if path == "" {
+		return "", nil, nil
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil, nil
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read credentials for --sign-urls: %w", err)
+	}
+	var creds struct {
+		Type        string `json:"type"`
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+	}
+	if err := json.Unmarshal(b, &creds); err != nil {
+		return "", nil, fmt.Errorf("invalid credentials file %s: %w", path, err)
+	}
+	if creds.Type != "service_account" || creds.PrivateKey == "" {
+		return "", nil, nil
+	}
+	return creds.ClientEmail, []byte(creds.PrivateKey), nil
+}
+
+// sign is the objectFunc printing the object's URL and its signed URL,
+// tab-separated, or with --ndjson a signedURLRecord.
+func (s *urlSigner) sign(ctx context.Context, attrs *storage.ObjectAttrs) error {
+	opts := s.opts
+	if s.versions {
+		opts.QueryParameters = map[string][]string{"generation": {fmt.Sprint(attrs.Generation)}}
+	}
+	signed, err := s.client.Bucket(attrs.Bucket).SignedURL(attrs.Name, &opts)
+	if err != nil {
+		return fmt.Errorf("failed to sign URL: %w", err)
+	}
+	if s.ndjson {
+		return writeJSONLine(s.out.to(ctx), signedURLRecord{
+			Bucket:    attrs.Bucket,
+			Name:      attrs.Name,
+			SignedURL: signed,
+			Expires:   s.opts.Expires.UTC().Format(time.RFC3339),
+		})
+	}
+	_, err = fmt.Fprintf(s.out.to(ctx), "gs://%s/%s\t%s\n", attrs.Bucket, attrs.Name, signed)
+	return err
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// resolveSinceFile sets opts.since to the modification time of the
+// --since-file reference: the update time of a gs://bucket/object, or the
+// modification time of a local file. It does nothing without --since-file.
+func resolveSinceFile(ctx context.Context, client *storage.Client, opts *options) error {
+	ref := opts.sinceFile
+	if ref == "" {
+		return nil
+	}
+	if !strings.HasPrefix(ref, "gs://") {
+		info, err := os.Stat(ref)
+		if err != nil {
+			return fmt.Errorf("failed to read --since-file: %w", err)
+		}
+		opts.since = info.ModTime()
+		return nil
+	}
+
+	bucket, name, err := parseGCSPath(ref)
+	if err != nil {
+		return fmt.Errorf("invalid --since-file %q: %w", ref, err)
+	}
+	if name == "" || strings.HasSuffix(name, "/") {
+		return fmt.Errorf("invalid --since-file %q: must name an object", ref)
+	}
+	if err := checkBucketAllowed(bucket, opts); err != nil {
+		return err
+	}
+	attrs, err := client.Bucket(bucket).Object(name).Attrs(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read --since-file %s: %w", ref, err)
+	}
+	opts.since = attrs.Updated
+	return nil
+}
+
+// updatedSince keeps the objects updated strictly after since.
+func updatedSince(since time.Time) objectFilter {
+	return func(attrs *storage.ObjectAttrs) bool {
+		return attrs.Updated.After(since)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+	pubsub "google.golang.org/api/pubsub/v1"
+)
+
+// Destinations accepted by --sink.
+const (
+	sinkPubSub = "pubsub"
+	sinkUnix   = "unix"
+	sinkTCP    = "tcp"
+)
+
+// pubsubBatchSize is how many messages go into one Pub/Sub publish request,
+// well below the API's limit of 1000.
+const pubsubBatchSize = 100
+
+// sink receives one record per matched object instead of stdout.
+type sink interface {
+	// send delivers the record for one match.
+	send(rec objectRecord) error
+	// close delivers anything still buffered and releases the destination.
+	// It is safe to call more than once.
+	close() error
+}
+
+// parseSinkSpec splits a --sink value into its scheme and destination: a
+// "project/topic" pair for Pub/Sub, a socket path or a host:port address.
+func parseSinkSpec(spec string) (scheme, dest string, err error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid --sink %q: %w", spec, err)
+	}
+	switch u.Scheme {
+	case sinkPubSub:
+		topic := strings.TrimPrefix(u.Path, "/")
+		if u.Host == "" || topic == "" || strings.Contains(topic, "/") {
+			return "", "", fmt.Errorf("invalid --sink %q: expected pubsub://project/topic", spec)
+		}
+		return u.Scheme, u.Host + "/" + topic, nil
+	case sinkUnix:
+		if u.Path == "" {
+			return "", "", fmt.Errorf("invalid --sink %q: expected unix:///path/to/socket", spec)
+		}
+		return u.Scheme, u.Path, nil
+	case sinkTCP:
+		if u.Host == "" || u.Port() == "" {
+			return "", "", fmt.Errorf("invalid --sink %q: expected tcp://host:port", spec)
+		}
+		return u.Scheme, u.Host, nil
+	default:
+		return "", "", fmt.Errorf("invalid --sink %q: scheme must be one of pubsub, unix, tcp", spec)
+	}
+}
+
+// openSink connects to the destination named by spec. The connection is
+// made up front so that an unreachable destination fails before the
+// listing starts.
+func openSink(ctx context.Context, spec string) (sink, error) {
+	scheme, dest, err := parseSinkSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+	if scheme == sinkPubSub {
+		return newPubSubSink(ctx, dest)
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, scheme, dest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to sink %s: %w", spec, err)
+	}
+	return &socketSink{conn: conn, w: bufio.NewWriter(conn)}, nil
+}
+
+// socketSink writes one JSON record per line to a Unix or TCP socket.
+type socketSink struct {
+	conn   net.Conn
+	w      *bufio.Writer
+	closed bool
+}
+
+// send writes the record as one line.
+func (s *socketSink) send(rec objectRecord) error {
+	return writeJSONLine(s.w, rec)
+}
+
+// close flushes the buffered lines and closes the connection.
+func (s *socketSink) close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	err := s.w.Flush()
+	if cerr := s.conn.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// pubSubSink publishes one message per record to a Pub/Sub topic, batching
+// messages into as few requests as possible.
+type pubSubSink struct {
+	ctx     context.Context
+	topics  *pubsub.ProjectsTopicsService
+	topic   string
+	pending []*pubsub.PubsubMessage
+	closed  bool
+}
+
+// newPubSubSink creates a publisher for dest, given as "project/topic".
+// Requests go to the Pub/Sub emulator when PUBSUB_EMULATOR_HOST is set, as
+// with the official client libraries.
+func newPubSubSink(ctx context.Context, dest string) (*pubSubSink, error) {
+	var clientOpts []option.ClientOption
+	if host := os.Getenv("PUBSUB_EMULATOR_HOST"); host != "" {
+		clientOpts = append(clientOpts, option.WithEndpoint("http://"+host+"/"), option.WithoutAuthentication())
+	}
+	svc, err := pubsub.NewService(ctx, clientOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Pub/Sub client: %w", err)
+	}
+	project, topic, _ := strings.Cut(dest, "/")
+	return &pubSubSink{
+		ctx:    ctx,
+		topics: pubsub.NewProjectsTopicsService(svc),
+		topic:  "projects/" + project + "/topics/" + topic,
+	}, nil
+}
+
+// send queues the record and publishes once a batch is full.
+func (s *pubSubSink) send(rec objectRecord) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	s.pending = append(s.pending, &pubsub.PubsubMessage{Data: base64.StdEncoding.EncodeToString(b)})
+	if len(s.pending) >= pubsubBatchSize {
+		return s.publish()
+	}
+	return nil
+}
+
+// publish sends the queued messages in one request.
+func (s *pubSubSink) publish() error {
+	if len(s.pending) == 0 {
+		return nil
+	}
+	req := &pubsub.PublishRequest{Messages: s.pending}
+	if _, err := s.topics.Publish(s.topic, req).Context(s.ctx).Do(); err != nil {
+		return fmt.Errorf("failed to publish to %s: %w", s.topic, err)
+	}
+	s.pending = nil
+	return nil
+}
+
+// close publishes the last partial batch.
+func (s *pubSubSink) close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.publish()
+}
+
+// sinkFormatter sends each matched object to a sink instead of printing it.
+type sinkFormatter struct {
+	sink  sink
+	spec  string
+	extra annotations
+	count int
+}
+
+// header writes nothing; the matches go to the sink.
+func (f *sinkFormatter) header(w io.Writer, targets []listTarget) error {
+	return nil
+}
+
+// object sends the object's record to the sink.
+func (f *sinkFormatter) object(w io.Writer, attrs *storage.ObjectAttrs) error {
+	f.count++
+	return f.sink.send(f.extra.record(attrs))
+}
+
+// footer delivers any buffered records and reports how many were sent.
+func (f *sinkFormatter) footer(w io.Writer) error {
+	if err := f.sink.close(); err != nil {
+		return err
+	}
+	if f.count == 0 {
+		return nil
+	}
+	_, err := fmt.Fprintf(w, "Sent %d matches to %s\n", f.count, f.spec)
+	return err
+}
+
+// machineReadable reports that stdout only carries status messages.
+func (f *sinkFormatter) machineReadable() bool {
+	return false
+}
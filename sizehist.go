@@ -0,0 +1,124 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+// defaultSizeBreaks are the --size-histogram breakpoints without
+// --size-breaks: small files, ordinary ones, large ones and very large ones.
+const defaultSizeBreaks = "1K,1M,100M"
+
+// parseSize parses a byte count with an optional binary unit suffix, K, M,
+// G or T, which may be followed by "iB" or "B": 512, 64K, 1MiB.
+func parseSize(s string) (int64, error) {
+	num := strings.TrimSuffix(strings.TrimSuffix(s, "B"), "i")
+	shift := 0
+	if n := len(num); n > 0 {
+		if i := strings.IndexByte("KMGT", num[n-1]); i >= 0 {
+			shift = 10 * (i + 1)
+			num = num[:n-1]
+		}
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n < 0 || n > (1<<62)>>shift {
+		return 0, fmt.Errorf("invalid size %q: expected a byte count such as 512, 64K or 1M", s)
+	}
+	return n << shift, nil
+}
+
+// sizeBreak is one --size-breaks breakpoint, kept as written for the
+// histogram's range labels.
+type sizeBreak struct {
+	bytes int64
+	label string
+}
+
+// parseSizeBreaks parses the comma-separated, increasing breakpoints of
+// --size-breaks.
+func parseSizeBreaks(v string) ([]sizeBreak, error) {
+	var breaks []sizeBreak
+	for _, s := range strings.Split(v, ",") {
+		s = strings.TrimSpace(s)
+		n, err := parseSize(s)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return nil, fmt.Errorf("invalid breakpoint %q: must be positive", s)
+		}
+		if len(breaks) > 0 && n <= breaks[len(breaks)-1].bytes {
+			return nil, fmt.Errorf("breakpoints must be increasing, but %s follows %s", s, breaks[len(breaks)-1].label)
+		}
+		breaks = append(breaks, sizeBreak{bytes: n, label: s})
+	}
+	return breaks, nil
+}
+
+// sizeHistogram counts the matches and their bytes per size range for
+// --size-histogram. Range i holds sizes below breaks[i] and at least the
+// breakpoint before it; the last range holds everything from the last
+// breakpoint up.
+type sizeHistogram struct {
+	breaks  []sizeBreak
+	objects []int64
+	bytes   []int64
+}
+
+// newSizeHistogram returns an empty histogram over the ranges the
+// breakpoints divide sizes into.
+func newSizeHistogram(breaks []sizeBreak) *sizeHistogram {
+	return &sizeHistogram{breaks: breaks, objects: make([]int64, len(breaks)+1), bytes: make([]int64, len(breaks)+1)}
+}
+
+// add counts one match in the range of its size.
+func (h *sizeHistogram) add(attrs *storage.ObjectAttrs) {
+	i, found := slices.BinarySearchFunc(h.breaks, attrs.Size, func(b sizeBreak, size int64) int {
+		return cmp.Compare(b.bytes, size)
+	})
+	if found {
+		// A size equal to a breakpoint starts the range above it.
+		i++
+	}
+	h.objects[i]++
+	h.bytes[i] += attrs.Size
+}
+
+// label names range i, as in "<1K", "1K-1M" and ">=100M".
+func (h *sizeHistogram) label(i int) string {
+	switch {
+	case i == 0:
+		return "<" + h.breaks[0].label
+	case i == len(h.breaks):
+		return ">=" + h.breaks[i-1].label
+	}
+	return h.breaks[i-1].label + "-" + h.breaks[i].label
+}
+
+// print writes one line per range, the empty ones included so the shape
+// of the distribution shows, with the number of objects, their total size
+// and their share of the bytes, then the totals.
+func (h *sizeHistogram) print(w io.Writer) error {
+	var objects, bytes int64
+	for i := range h.objects {
+		objects += h.objects[i]
+		bytes += h.bytes[i]
+	}
+	for i := range h.objects {
+		share := 0.0
+		if bytes > 0 {
+			share = float64(h.bytes[i]) / float64(bytes) * 100
+		}
+		if _, err := fmt.Fprintf(w, "%-16s %10d %14s %6.1f%%\n", h.label(i), h.objects[i], formatBytes(h.bytes[i]), share); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "%-16s %10d %14s\n", "TOTAL", objects, formatBytes(bytes))
+	return err
+}
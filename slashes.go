@@ -0,0 +1,32 @@
+package main
+
+import "strings"
+
+// collapseSlashes replaces each run of slashes in name with a single one,
+// so that logs//2024/x.log reads as logs/2024/x.log for --normalize-slashes.
+func collapseSlashes(name string) string {
+	if !strings.Contains(name, "//") {
+		return name
+	}
+	var b strings.Builder
+	b.Grow(len(name))
+	for i := 0; i < len(name); i++ {
+		if name[i] == '/' && i > 0 && name[i-1] == '/' {
+			continue
+		}
+		b.WriteByte(name[i])
+	}
+	return b.String()
+}
+
+// slashSafePrefix shortens prefix, the literal part of a pattern, to what
+// every stored name that collapses into it must start with: the text up to
+// and including its first slash. Past that slash the stored name may repeat
+// it, so logs/2024/ only narrows the listing to logs/, which also holds
+// logs//2024/.
+func slashSafePrefix(prefix string) string {
+	if i := strings.IndexByte(prefix, '/'); i >= 0 {
+		return prefix[:i+1]
+	}
+	return prefix
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+// TestNormalizeSlashes checks that --normalize-slashes matches names with
+// repeated slashes as if they had one, and that the listing shows their
+// real names unless --normalize-display is set as well.
+func TestNormalizeSlashes(t *testing.T) {
+	fake := newFakeGCS(t, "slash", []string{"logs//2024/x.log", "logs///2024/y.log", "logs/2024/z.log", "logs/2024//a/w.log", "logs/other/v.log"}, 100)
+	tests := []struct {
+		flags      []string
+		want       []string
+		wantPrefix string
+	}{
+		{nil, []string{"logs/2024/z.log"}, "logs/2024/"},
+		{[]string{"--normalize-slashes"}, []string{"logs///2024/y.log", "logs//2024/x.log", "logs/2024/z.log"}, "logs/"},
+		{[]string{"--normalize-slashes", "--normalize-display"}, []string{"logs/2024/y.log", "logs/2024/x.log", "logs/2024/z.log"}, "logs/"},
+	}
+	for _, tt := range tests {
+		before := len(fake.prefixes())
+		got := listedNames(t, append(tt.flags, "gs://slash/logs/2024/*.log")...)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("listing with %v = %v, want %v", tt.flags, got, tt.want)
+		}
+		// Past the first slash, a stored name may repeat it.
+		if prefixes := fake.prefixes()[before:]; !slices.Equal(prefixes, []string{tt.wantPrefix}) {
+			t.Errorf("listing with %v used prefixes %q, want %q", tt.flags, prefixes, tt.wantPrefix)
+		}
+	}
+	if _, _, err := parseArgs([]string{"--normalize-display", "gs://slash/logs/**"}); err == nil {
+		t.Error("--normalize-display without --normalize-slashes was accepted")
+	}
+}
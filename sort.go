@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+// Sort keys accepted by --sort.
+const (
+	sortName    = "name"
+	sortSize    = "size"
+	sortUpdated = "updated"
+	// sortDepth orders by the number of "/"-separated segments, so that
+	// shallow objects come before the objects nested below them.
+	sortDepth = "depth"
+	// sortDate orders by the date --extract-date finds in the name, which
+	// no comparator below can know.
+	sortDate = "date"
+)
+
+// objectComparators orders objects for each sort key. Every key falls back
+// to the name, then the bucket, so that the order is fully determined.
+var objectComparators = map[string]func(a, b *storage.ObjectAttrs) int{
+	sortName: func(a, b *storage.ObjectAttrs) int {
+		return 0
+	},
+	sortSize: func(a, b *storage.ObjectAttrs) int {
+		return cmp.Compare(a.Size, b.Size)
+	},
+	sortUpdated: func(a, b *storage.ObjectAttrs) int {
+		return a.Updated.Compare(b.Updated)
+	},
+	sortDepth: func(a, b *storage.ObjectAttrs) int {
+		return cmp.Compare(strings.Count(a.Name, "/"), strings.Count(b.Name, "/"))
+	},
+}
+
+// Name orders accepted by --collate.
+const (
+	collateByte            = "byte"
+	collateCaseInsensitive = "case-insensitive"
+	collateLocale          = "locale"
+)
+
+// nameCollation returns the comparison of names for a --collate mode, or
+// nil for byte order, the order GCS lists names in. The locale order is
+// that of the language of $LC_ALL, $LC_COLLATE or $LANG, the first one
+// set, or the Unicode default order for C, POSIX or a language it does
+// not know.
+func nameCollation(mode string) func(a, b string) int {
+	switch mode {
+	case collateCaseInsensitive:
+		return func(a, b string) int {
+			return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+		}
+	case collateLocale:
+		c := collate.New(collationLanguage(cmp.Or(os.Getenv("LC_ALL"), os.Getenv("LC_COLLATE"), os.Getenv("LANG"))))
+		return c.CompareString
+	}
+	return nil
+}
+
+// collationLanguage returns the language of a POSIX locale name such as
+// de_DE.UTF-8, or language.Und if it names none.
+func collationLanguage(locale string) language.Tag {
+	locale, _, _ = strings.Cut(locale, ".")
+	locale, _, _ = strings.Cut(locale, "@")
+	if locale == "" || locale == "C" || locale == "POSIX" {
+		return language.Und
+	}
+	tag, err := language.Parse(strings.ReplaceAll(locale, "_", "-"))
+	if err != nil {
+		return language.Und
+	}
+	return tag
+}
+
+// validateSortKey checks a --sort value.
+func validateSortKey(key string) error {
+	if _, ok := objectComparators[key]; !ok && key != sortDate {
+		return fmt.Errorf("invalid --sort %q: must be one of date, depth, name, size, updated", key)
+	}
+	return nil
+}
+
+// objectOrder returns the comparison for key, reversed when reverse is set.
+// dates is the --extract-date expression the date key sorts by, and names,
+// when set, the --collate order of names, which byte order then breaks
+// ties of, as between names differing only in case.
+func objectOrder(key string, reverse bool, dates *dateExtractor, names func(a, b string) int) func(a, b *storage.ObjectAttrs) int {
+	compare := objectComparators[key]
+	if key == sortDate {
+		compare = func(a, b *storage.ObjectAttrs) int {
+			return dates.compare(a.Name, b.Name)
+		}
+	}
+	if names == nil {
+		names = func(a, b string) int { return 0 }
+	}
+	return func(a, b *storage.ObjectAttrs) int {
+		c := cmp.Or(compare(a, b), names(a.Name, b.Name), strings.Compare(a.Name, b.Name), strings.Compare(a.Bucket, b.Bucket))
+		if reverse {
+			return -c
+		}
+		return c
+	}
+}
+
+// sortBuffer collects the matches for --sort. Once it holds limit objects,
+// they are sorted and written to a temporary file as one run, and the
+// output is produced by merging the runs, so memory use stays bounded by
+// the limit however many objects match.
+type sortBuffer struct {
+	order func(a, b *storage.ObjectAttrs) int
+	limit int
+	held  []*storage.ObjectAttrs
+	runs  []*os.File
+}
+
+// newSortBuffer returns a buffer sorting by key, with names in the order of
+// the --collate mode collation, that spills to disk every limit objects; a
+// limit of 0 keeps everything in memory.
+func newSortBuffer(key string, reverse bool, dates *dateExtractor, collation string, limit int) *sortBuffer {
+	return &sortBuffer{order: objectOrder(key, reverse, dates, nameCollation(collation)), limit: limit}
+}
+
+// add buffers one object, spilling a run to disk when the buffer is full.
+func (b *sortBuffer) add(attrs *storage.ObjectAttrs) error {
+	b.held = append(b.held, attrs)
+	if b.limit > 0 && len(b.held) >= b.limit {
+		return b.spill()
+	}
+	return nil
+}
+
+// spill writes the buffered objects to a new run file in sorted order.
+func (b *sortBuffer) spill() error {
+	slices.SortStableFunc(b.held, b.order)
+	f, err := os.CreateTemp("", "gcsls-sort-*")
+	if err != nil {
+		return fmt.Errorf("failed to create sort buffer file: %w", err)
+	}
+	b.runs = append(b.runs, f)
+	w := bufio.NewWriter(f)
+	enc := gob.NewEncoder(w)
+	for _, attrs := range b.held {
+		if err := enc.Encode(attrs); err != nil {
+			return fmt.Errorf("failed to write sort buffer file: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to write sort buffer file: %w", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to read sort buffer file: %w", err)
+	}
+	b.held = nil
+	return nil
+}
+
+// each calls fn for every buffered object in sorted order, stopping at the
+// first error.
+func (b *sortBuffer) each(fn func(*storage.ObjectAttrs) error) error {
+	if len(b.runs) == 0 {
+		slices.SortStableFunc(b.held, b.order)
+		for _, attrs := range b.held {
+			if err := fn(attrs); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if len(b.held) > 0 {
+		if err := b.spill(); err != nil {
+			return err
+		}
+	}
+
+	// Merge the runs, always taking the smallest of their next objects.
+	// Runs are few, so a linear scan for the smallest is fast enough. Ties
+	// go to the earlier run, which keeps the sort stable.
+	decoders := make([]*gob.Decoder, len(b.runs))
+	heads := make([]*storage.ObjectAttrs, len(b.runs))
+	next := func(i int) error {
+		attrs := &storage.ObjectAttrs{}
+		if err := decoders[i].Decode(attrs); err != nil {
+			if err == io.EOF {
+				heads[i] = nil
+				return nil
+			}
+			return fmt.Errorf("failed to read sort buffer file: %w", err)
+		}
+		heads[i] = attrs
+		return nil
+	}
+	for i, f := range b.runs {
+		decoders[i] = gob.NewDecoder(bufio.NewReader(f))
+		if err := next(i); err != nil {
+			return err
+		}
+	}
+	for {
+		first := -1
+		for i, h := range heads {
+			if h != nil && (first < 0 || b.order(h, heads[first]) < 0) {
+				first = i
+			}
+		}
+		if first < 0 {
+			return nil
+		}
+		if err := fn(heads[first]); err != nil {
+			return err
+		}
+		if err := next(first); err != nil {
+			return err
+		}
+	}
+}
+
+// close removes the run files.
+func (b *sortBuffer) close() {
+	for _, f := range b.runs {
+		f.Close()
+		os.Remove(f.Name())
+	}
+	b.runs = nil
+}
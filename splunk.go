@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// splunkTokenEnv names the environment variable read for the HEC token when
+// --splunk-token is not given, which keeps the token out of the process
+// list.
+const splunkTokenEnv = "SPLUNK_HEC_TOKEN"
+
+// splunkEventPath is the HEC endpoint for JSON events, used when the
+// --splunk-hec URL has no path.
+const splunkEventPath = "/services/collector/event"
+
+// parseSplunkURL checks the --splunk-hec URL and adds the event endpoint
+// if it only names the collector.
+func parseSplunkURL(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid --splunk-hec %q: %w", raw, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid --splunk-hec %q: expected https://host:port", raw)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = splunkEventPath
+	}
+	return u.String(), nil
+}
+
+// splunkEvent is the envelope HEC expects around each record.
+type splunkEvent struct {
+	Time       float64      `json:"time,omitempty"`
+	Source     string       `json:"source"`
+	Sourcetype string       `json:"sourcetype"`
+	Event      objectRecord `json:"event"`
+}
+
+// hecSink posts the records as events to a Splunk HTTP Event Collector.
+// Events are sent in batches of up to batch, and whatever is pending is
+// sent every interval, so that a slow listing still shows up in the index
+// as it goes. A batch is posted while holding the lock, so a
+// collector that pushes back, or a retry in progress, holds up the listing
+// rather than letting events pile up in memory.
+type hecSink struct {
+	ctx      context.Context
+	client   *http.Client
+	url      string
+	token    string
+	batch    int
+	interval time.Duration
+	// budget bounds the time spent waiting between retries of one batch,
+	// as --retry-budget does for GCS requests.
+	budget time.Duration
+	// log receives a line per retry with --verbose, or is nil.
+	log io.Writer
+
+	mu      sync.Mutex
+	pending bytes.Buffer
+	events  int
+	// err is the failure of a timed flush, returned by the next send.
+	err    error
+	closed bool
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// newHECSink returns a sink posting to the --splunk-hec collector, with
+// batching and retries set by opts. The collector's health endpoint is not
+// part of HEC's event API, so unlike --sink nothing is contacted up front:
+// a wrong URL or token fails with the first batch.
+func newHECSink(ctx context.Context, opts *options, status io.Writer) (*hecSink, error) {
+	endpoint, err := parseSplunkURL(opts.splunkHEC)
+	if err != nil {
+		return nil, err
+	}
+	s := &hecSink{
+		ctx:      ctx,
+		client:   &http.Client{Timeout: time.Minute},
+		url:      endpoint,
+		token:    opts.splunkToken,
+		batch:    opts.splunkBatch,
+		interval: opts.splunkFlushInterval,
+		budget:   opts.retryBudget,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if opts.verbose {
+		s.log = status
+	}
+	go s.flushEvery()
+	return s, nil
+}
+
+// send queues the record as an event and posts the batch once it is full.
+func (s *hecSink) send(rec objectRecord) error {
+	ev := splunkEvent{Source: "gcsls", Sourcetype: "_json", Event: rec}
+	// The update time is RFC 3339, or seconds with --time-format unix.
+	if t, err := time.Parse(time.RFC3339Nano, rec.Updated); err == nil {
+		ev.Time = float64(t.UnixMilli()) / 1000
+	} else if secs, err := strconv.ParseFloat(rec.Updated, 64); err == nil {
+		ev.Time = secs
+	}
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.pending.Write(b)
+	s.pending.WriteByte('\n')
+	s.events++
+	if s.events >= s.batch {
+		return s.post()
+	}
+	return nil
+}
+
+// flushEvery posts the pending events every interval until close.
+func (s *hecSink) flushEvery() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			if s.err == nil {
+				s.err = s.post()
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// post sends the pending events in one request, retrying on rate limiting,
+// server errors and network failures with the same backoff as GCS
+// requests. The caller holds the lock.
+func (s *hecSink) post() error {
+	if s.events == 0 {
+		return nil
+	}
+	body := s.pending.Bytes()
+	var waited time.Duration
+	for attempt := 0; ; attempt++ {
+		retryAfter, err := s.postOnce(body)
+		if err == nil {
+			break
+		}
+		if retryAfter < 0 {
+			return err
+		}
+		delay := max(retryAfter, rand.N(min(retryMaxDelay, retryBaseDelay<<min(attempt, 16))+1))
+		if waited+delay > s.budget {
+			return fmt.Errorf("%w (gave up after %d attempts)", err, attempt+1)
+		}
+		waited += delay
+		if s.log != nil {
+			fmt.Fprintf(s.log, "Retrying Splunk HEC post of %d events in %s: %v\n", s.events, delay.Round(time.Millisecond), err)
+		}
+		if err := (systemClock{}).Sleep(s.ctx, delay); err != nil {
+			return err
+		}
+	}
+	s.pending.Reset()
+	s.events = 0
+	return nil
+}
+
+// postOnce makes one request with body. On failure it returns how long to
+// wait before trying again, at least 0, or -1 if the failure is permanent,
+// such as a rejected token or malformed events.
+func (s *hecSink) postOnce(body []byte) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return -1, err
+	}
+	req.Header.Set("Authorization", "Splunk "+s.token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to post to Splunk HEC: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return 0, nil
+	}
+	// HEC explains a failure as {"text": "...", "code": N}.
+	var reply struct {
+		Text string `json:"text"`
+	}
+	json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&reply)
+	err = fmt.Errorf("Splunk HEC rejected %d events: %s", s.events, resp.Status)
+	if reply.Text != "" {
+		err = fmt.Errorf("Splunk HEC rejected %d events: %s: %s", s.events, resp.Status, reply.Text)
+	}
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+		return -1, err
+	}
+	var wait time.Duration
+	if secs, perr := strconv.Atoi(resp.Header.Get("Retry-After")); perr == nil && secs > 0 {
+		wait = time.Duration(secs) * time.Second
+	}
+	return wait, err
+}
+
+// close stops the timed flushes and posts the last partial batch.
+func (s *hecSink) close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.mu.Unlock()
+	close(s.stop)
+	<-s.done
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	return s.post()
+}
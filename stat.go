@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// lockedWriter serializes writes from concurrent workers so that each
+// object's output block is written without interleaving.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// Write writes p to the underlying writer while holding the lock.
+func (lw *lockedWriter) Write(p []byte) (int, error) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	return lw.w.Write(p)
+}
+
+// statObject returns an objectFunc that fetches the full metadata of an
+// object with its own API call and prints it in a gsutil-stat-like block.
+// With human, the creation and update times are followed by their age.
+func statObject(client *storage.Client, human bool, w io.Writer) objectFunc {
+	out := &lockedWriter{w: w}
+	return func(ctx context.Context, listed *storage.ObjectAttrs) error {
+		attrs, err := client.Bucket(listed.Bucket).Object(listed.Name).Attrs(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to fetch object attributes: %w", err)
+		}
+
+		// Build the whole block first so it is written in a single call.
+		var b strings.Builder
+		fmt.Fprintf(&b, "gs://%s/%s:\n", attrs.Bucket, attrs.Name)
+		fmt.Fprintf(&b, "    Creation time:    %s\n", statTime(attrs.Created, human))
+		fmt.Fprintf(&b, "    Update time:      %s\n", statTime(attrs.Updated, human))
+		fmt.Fprintf(&b, "    Storage class:    %s\n", attrs.StorageClass)
+		fmt.Fprintf(&b, "    Content-Length:   %d\n", attrs.Size)
+		fmt.Fprintf(&b, "    Content-Type:     %s\n", attrs.ContentType)
+		fmt.Fprintf(&b, "    Hash (crc32c):    %s\n", encodeCRC32C(attrs.CRC32C))
+		if len(attrs.MD5) > 0 {
+			fmt.Fprintf(&b, "    Hash (md5):       %s\n", base64.StdEncoding.EncodeToString(attrs.MD5))
+		}
+		fmt.Fprintf(&b, "    ETag:             %s\n", attrs.Etag)
+		fmt.Fprintf(&b, "    Generation:       %d\n", attrs.Generation)
+		fmt.Fprintf(&b, "    Metageneration:   %d\n", attrs.Metageneration)
+
+		_, err = io.WriteString(out.to(ctx), b.String())
+		return err
+	}
+}
+
+// statTime renders a time of --stat, as gsutil does, with its age after it
+// if human is set.
+func statTime(t time.Time, human bool) string {
+	s := t.UTC().Format(time.RFC1123)
+	if human {
+		s += " (" + humanizeTime(t) + ")"
+	}
+	return s
+}
+
+// encodeCRC32C renders a CRC32C checksum the way GCS and gsutil display it:
+// the big-endian bytes of the checksum, base64-encoded.
+func encodeCRC32C(crc uint32) string {
+	b := []byte{byte(crc >> 24), byte(crc >> 16), byte(crc >> 8), byte(crc)}
+	return base64.StdEncoding.EncodeToString(b)
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// scanStats counts how much of the listing was scanned versus matched, to
+// help judge how effective the server-side prefix is for a pattern.
+type scanStats struct {
+	// prefixes holds the server-side prefix of each listed pattern.
+	prefixes []string
+	scanned  int
+	matched  int
+	start    time.Time
+	// duplicates, when set, is the number of matches dropped by --dedupe-by.
+	duplicates *int
+	// checkpoint, when set, records progress as objects are scanned.
+	checkpoint *checkpoint
+	// cache, when set, serves the listings from a --cache-list file or
+	// records them into one.
+	cache *listCache
+	// inventory, when set, serves the listings from a Storage Insights
+	// inventory report.
+	inventory *inventoryReport
+	// throttle, when set, is the --throttle-on-429 state, whose current
+	// rate is shown.
+	throttle *adaptiveThrottle
+	// rates, when set, collects the timings of --rate-report.
+	rates *rateRecorder
+	// patterns lists the pattern URLs when matches are counted per pattern,
+	// and perPattern holds the counts.
+	patterns   []string
+	perPattern map[string]int
+	// listed holds the --prefixes-from prefixes when matches are counted
+	// per prefix, and perPrefix holds the counts.
+	listed    []string
+	perPrefix map[string]int
+	// progress, when set, is called with the running totals after each
+	// scanned object.
+	progress func(scanned, matched int)
+	// skippedBuckets lists the buckets --location left out.
+	skippedBuckets []skippedBucket
+	// shared, when set, is the lock of --bucket-concurrency, which a scan
+	// holds except while waiting for the listing.
+	shared *sync.Mutex
+}
+
+// newScanStats starts timing a scan that uses the given server-side prefixes.
+func newScanStats(prefixes ...string) *scanStats {
+	return &scanStats{prefixes: prefixes, start: time.Now()}
+}
+
+// countPatterns starts counting the matches of each target's pattern.
+func (s *scanStats) countPatterns(targets []listTarget) {
+	s.perPattern = make(map[string]int)
+	for _, t := range targets {
+		s.patterns = append(s.patterns, t.url())
+	}
+}
+
+// countPrefixes starts counting the matches under each of prefixes.
+func (s *scanStats) countPrefixes(prefixes []string) {
+	s.listed = prefixes
+	s.perPrefix = make(map[string]int)
+}
+
+// checkMatched warns on w about every pattern that matched nothing when
+// several were listed, since that often means a typo. With
+// requireAll, it then fails if any pattern, or the only one, matched
+// nothing.
+func (s *scanStats) checkMatched(targets []listTarget, requireAll bool, w io.Writer) error {
+	unmatched := 0
+	if s.perPattern == nil {
+		if s.matched == 0 {
+			unmatched = len(targets)
+		}
+	} else {
+		for _, url := range s.patterns {
+			if s.perPattern[url] == 0 {
+				fmt.Fprintf(w, "pattern %s: 0 matches\n", url)
+				unmatched++
+			}
+		}
+	}
+	if requireAll && unmatched > 0 && len(targets) == 1 {
+		return fmt.Errorf("the pattern matched nothing")
+	}
+	if requireAll && unmatched > 0 {
+		return fmt.Errorf("%d of %d patterns matched nothing", unmatched, len(targets))
+	}
+	return nil
+}
+
+// recordUnmatched records in errs each pattern that matched nothing.
+func (s *scanStats) recordUnmatched(targets []listTarget, errs *errorLog) {
+	if s.perPattern == nil {
+		if s.matched == 0 {
+			errs.pattern(targets[0].url())
+		}
+		return
+	}
+	for _, url := range s.patterns {
+		if s.perPattern[url] == 0 {
+			errs.pattern(url)
+		}
+	}
+}
+
+// scanReport is the --match-report-json record that ends an NDJSON stream.
+// Its "type" field tells it apart from the object records.
+type scanReport struct {
+	Type   string `json:"type"`
+	Prefix string `json:"prefix,omitempty"`
+	// Prefixes replaces Prefix when several patterns were listed.
+	Prefixes   []string `json:"prefixes,omitempty"`
+	Scanned    int      `json:"scanned"`
+	Matched    int      `json:"matched"`
+	Bytes      int64    `json:"bytes"`
+	DurationMs int64    `json:"durationMs"`
+}
+
+// report returns the statistics as a summary record, with bytes being the
+// total size of the matched objects.
+func (s *scanStats) report(bytes int64) scanReport {
+	r := scanReport{
+		Type:       "summary",
+		Scanned:    s.scanned,
+		Matched:    s.matched,
+		Bytes:      bytes,
+		DurationMs: time.Since(s.start).Milliseconds(),
+	}
+	if len(s.prefixes) == 1 {
+		r.Prefix = s.prefixes[0]
+	} else {
+		r.Prefixes = s.prefixes
+	}
+	return r
+}
+
+// print writes the scan statistics report.
+func (s *scanStats) print(w io.Writer) {
+	ratio := 0.0
+	if s.scanned > 0 {
+		ratio = float64(s.matched) / float64(s.scanned) * 100
+	}
+	fmt.Fprintf(w, "\nScan statistics:\n")
+	if len(s.prefixes) == 1 {
+		fmt.Fprintf(w, "  Prefix:          %q\n", s.prefixes[0])
+	} else {
+		quoted := make([]string, len(s.prefixes))
+		for i, p := range s.prefixes {
+			quoted[i] = strconv.Quote(p)
+		}
+		fmt.Fprintf(w, "  Prefixes:        %s\n", strings.Join(quoted, ", "))
+	}
+	if len(s.skippedBuckets) > 0 {
+		fmt.Fprintf(w, "  Buckets skipped: %d outside --location\n", len(s.skippedBuckets))
+		for _, b := range s.skippedBuckets {
+			fmt.Fprintf(w, "    gs://%s (%s)\n", b.bucket, b.location)
+		}
+	}
+	fmt.Fprintf(w, "  Objects scanned: %d\n", s.scanned)
+	fmt.Fprintf(w, "  Objects matched: %d\n", s.matched)
+	if s.duplicates != nil {
+		fmt.Fprintf(w, "  Duplicates:      %d dropped\n", *s.duplicates)
+	}
+	fmt.Fprintf(w, "  Match ratio:     %.1f%%\n", ratio)
+	if s.perPattern != nil {
+		fmt.Fprintf(w, "  Matches per pattern:\n")
+		for _, url := range s.patterns {
+			fmt.Fprintf(w, "    %8d  %s\n", s.perPattern[url], url)
+		}
+	}
+	if s.perPrefix != nil {
+		fmt.Fprintf(w, "  Matches per prefix:\n")
+		for _, p := range s.listed {
+			fmt.Fprintf(w, "    %8d  %q\n", s.perPrefix[p], p)
+		}
+	}
+	if s.throttle != nil {
+		fmt.Fprintf(w, "  Request rate:    %s\n", s.throttle.summary())
+	}
+	fmt.Fprintf(w, "  Elapsed:         %s\n", time.Since(s.start).Round(time.Millisecond))
+}
+
+// printRates writes the --rate-report, if one was asked for.
+func (s *scanStats) printRates(w io.Writer) {
+	if s.rates != nil {
+		s.rates.print(w, s.scanned, s.matched, time.Since(s.start))
+	}
+}
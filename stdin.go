@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// matchNames applies the pattern of gcsPath to object names read from r,
+// one per line, and prints the URL of each match. No GCS request is made.
+// Lines may hold bare object names or gs:// URLs; URLs for other buckets
+// never match.
+func matchNames(r io.Reader, gcsPath string, opts *options, stdout io.Writer) error {
+	target, err := resolveTarget(gcsPath, opts)
+	if err != nil {
+		return err
+	}
+	match := newTargetMatcher(target, opts)
+	contains := func(string) bool { return true }
+	if opts.contains != "" {
+		contains = nameContains(opts)
+	}
+	bucketURL := "gs://" + target.bucket + "/"
+
+	out := newOutputWriter(stdout, opts.flushEvery)
+	defer out.Flush()
+
+	scanner := bufio.NewScanner(r)
+	// Object names can be up to 1024 bytes; allow generous line lengths.
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		name := strings.TrimSuffix(scanner.Text(), "\r")
+		if strings.HasPrefix(name, "gs://") {
+			if !strings.HasPrefix(name, bucketURL) {
+				continue
+			}
+			name = strings.TrimPrefix(name, bucketURL)
+		}
+		if name == "" {
+			continue
+		}
+		i, err := match(name)
+		if err != nil {
+			return err
+		}
+		if i >= 0 && contains(name) && (opts.ignore == nil || !opts.ignore.ignored(name)) {
+			if opts.urlDecode {
+				name = decodeName(name)
+			}
+			if _, err := fmt.Fprintf(out, "%s%s\n", bucketURL, name); err != nil {
+				return fmt.Errorf("failed to write output: %w", err)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read names: %w", err)
+	}
+
+	if err := out.Flush(); err != nil {
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+	return nil
+}
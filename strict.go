@@ -0,0 +1,21 @@
+package main
+
+import "fmt"
+
+// strictMinPrefix is the shortest literal prefix --strict-glob accepts.
+// Shorter prefixes narrow the listing so little that the pattern is
+// matched against nearly every object in the bucket.
+const strictMinPrefix = 2
+
+// checkStrictGlob rejects a target under --strict-glob whose server-side
+// prefix is shorter than strictMinPrefix, unless --after bounds the
+// listing instead, and explains how to narrow the pattern.
+func checkStrictGlob(t listTarget, opts *options) error {
+	if !opts.strictGlob || len(t.prefix) >= strictMinPrefix || opts.after != "" {
+		return nil
+	}
+	return fmt.Errorf("--strict-glob: %s would list nearly every object in gs://%s, since its literal prefix %q "+
+		"is shorter than %d characters; start the pattern with the directory to search, "+
+		"as in gs://%s/logs/**/*.csv, or bound the listing with --after",
+		t.url(), t.bucket, t.prefix, strictMinPrefix, t.bucket)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestStrictGlob checks which patterns --strict-glob lets through, and
+// that a rejected one is told how to narrow the listing.
+func TestStrictGlob(t *testing.T) {
+	tests := []struct {
+		flags []string
+		url   string
+		ok    bool
+	}{
+		{nil, "gs://b/**", false},
+		{nil, "gs://b", false},
+		{nil, "gs://b/*.csv", false},
+		{nil, "gs://b/a*", false},
+		{nil, "gs://b/{x,y}/*", false},
+		{nil, "gs://b/ab*", true},
+		{nil, "gs://b/logs/*.csv", true},
+		{nil, "gs://b/logs/", true},
+		{[]string{"--after", "logs/"}, "gs://b/**", true},
+		// --ignore-case stops the server-side prefix at the first letter.
+		{[]string{"--ignore-case"}, "gs://b/logs/*.csv", false},
+		{[]string{"--ignore-case"}, "gs://b/2024/logs/*.csv", true},
+	}
+	for _, tt := range tests {
+		opts, _, err := parseArgs(append([]string{"--strict-glob"}, append(tt.flags, tt.url)...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = resolveTargets([]string{tt.url}, opts)
+		if tt.ok && err != nil {
+			t.Errorf("%s with %v was rejected: %v", tt.url, tt.flags, err)
+		}
+		if !tt.ok && (err == nil || !strings.Contains(err.Error(), "start the pattern with the directory to search")) {
+			t.Errorf("%s with %v: error = %v, want one with guidance", tt.url, tt.flags, err)
+		}
+	}
+
+	// Without --strict-glob, every pattern is let through.
+	opts, _, err := parseArgs([]string{"gs://b/**"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := resolveTargets([]string{"gs://b/**"}, opts); err != nil {
+		t.Errorf("gs://b/** without --strict-glob: %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// substitution is a parsed --subst expression.
+type substitution struct {
+	re          *regexp.Regexp
+	replacement string
+}
+
+// sedBackref matches a sed-style \N back-reference in a replacement.
+var sedBackref = regexp.MustCompile(`\\([0-9])`)
+
+// parseSubstitution parses a sed-like "/pattern/replacement/" expression.
+// Any character may stand in for "/" as the delimiter, and a backslash
+// before the delimiter makes it literal. The pattern uses Go's regexp
+// syntax; the replacement may refer to groups as $1, ${name}, or sed's \1.
+func parseSubstitution(expr string) (*substitution, error) {
+	if expr == "" {
+		return nil, fmt.Errorf("empty substitution")
+	}
+	if r := rune(expr[0]); r >= utf8.RuneSelf || r == '\\' || !(unicode.IsPunct(r) || unicode.IsSymbol(r)) {
+		return nil, fmt.Errorf("substitution %q must start with a punctuation delimiter such as /", expr)
+	}
+	delim := expr[:1]
+
+	var parts []string
+	var cur strings.Builder
+	rest := expr[1:]
+	for i := 0; i < len(rest); i++ {
+		switch {
+		case strings.HasPrefix(rest[i:], "\\"+delim):
+			cur.WriteString(delim)
+			i++
+		case rest[i:i+1] == delim:
+			parts = append(parts, cur.String())
+			cur.Reset()
+		default:
+			cur.WriteByte(rest[i])
+		}
+	}
+	if len(parts) != 2 || cur.Len() > 0 {
+		return nil, fmt.Errorf("substitution %q must have the form %spattern%sreplacement%s", expr, delim, delim, delim)
+	}
+
+	re, err := regexp.Compile(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern in substitution %q: %w", expr, err)
+	}
+	return &substitution{re: re, replacement: sedBackref.ReplaceAllString(parts[1], "$${$1}")}, nil
+}
+
+// apply returns name with every match of the pattern replaced.
+func (s *substitution) apply(name string) string {
+	return s.re.ReplaceAllString(name, s.replacement)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// summary accumulates totals over the matched objects of a listing.
+type summary struct {
+	objects int
+	bytes   int64
+	byClass map[string]*classTotals
+}
+
+// classTotals holds the object count and total size for one storage class.
+type classTotals struct {
+	objects int
+	bytes   int64
+}
+
+// newSummary returns an empty summary.
+func newSummary() *summary {
+	return &summary{byClass: make(map[string]*classTotals)}
+}
+
+// add records one matched object in the summary.
+func (s *summary) add(attrs *storage.ObjectAttrs) {
+	s.objects++
+	s.bytes += attrs.Size
+
+	class := attrs.StorageClass
+	if class == "" {
+		class = "UNKNOWN"
+	}
+	t, ok := s.byClass[class]
+	if !ok {
+		t = &classTotals{}
+		s.byClass[class] = t
+	}
+	t.objects++
+	t.bytes += attrs.Size
+}
+
+// storageClassOrder lists the storage classes from hottest to coldest, which
+// is the order used in the class summary table.
+var storageClassOrder = []string{"STANDARD", "NEARLINE", "COLDLINE", "ARCHIVE"}
+
+// printClassSummary writes a table of object counts and bytes per storage class.
+func (s *summary) printClassSummary(w io.Writer) {
+	classes := make([]string, 0, len(s.byClass))
+	for _, class := range storageClassOrder {
+		if _, ok := s.byClass[class]; ok {
+			classes = append(classes, class)
+		}
+	}
+	// Legacy or unexpected classes (e.g. MULTI_REGIONAL) follow in name order.
+	var others []string
+	for class := range s.byClass {
+		if !containsString(storageClassOrder, class) {
+			others = append(others, class)
+		}
+	}
+	sort.Strings(others)
+	classes = append(classes, others...)
+
+	fmt.Fprintf(w, "\n%-16s %10s %14s\n", "STORAGE CLASS", "OBJECTS", "BYTES")
+	for _, class := range classes {
+		t := s.byClass[class]
+		fmt.Fprintf(w, "%-16s %10d %14s\n", class, t.objects, formatBytes(t.bytes))
+	}
+	fmt.Fprintf(w, "%-16s %10d %14s\n", "TOTAL", s.objects, formatBytes(s.bytes))
+}
+
+// printReport writes the report of --summary-only: how many objects
+// matched of those scanned and their size, the totals per storage class,
+// and how long the listing took.
+func (s *summary) printReport(w io.Writer, scanned int, elapsed time.Duration) {
+	fmt.Fprintf(w, "Objects matched: %d of %d scanned\n", s.objects, scanned)
+	fmt.Fprintf(w, "Total size:      %s (%d bytes)\n", formatBytes(s.bytes), s.bytes)
+	if s.objects > 0 {
+		s.printClassSummary(w)
+	}
+	fmt.Fprintf(w, "\nElapsed:         %s\n", elapsed.Round(time.Millisecond))
+}
+
+// summaryOnlyFormatter prints nothing of the matches themselves, for
+// --summary-only, whose report follows the listing.
+type summaryOnlyFormatter struct{}
+
+// header writes nothing; not even the banner is printed.
+func (summaryOnlyFormatter) header(w io.Writer, targets []listTarget) error {
+	return nil
+}
+
+// object writes nothing.
+func (summaryOnlyFormatter) object(w io.Writer, attrs *storage.ObjectAttrs) error {
+	return nil
+}
+
+// footer writes nothing; the report is printed with the other totals.
+func (summaryOnlyFormatter) footer(w io.Writer) error {
+	return nil
+}
+
+// machineReadable reports that the report leaves room for status
+// messages beside it.
+func (summaryOnlyFormatter) machineReadable() bool {
+	return false
+}
+
+// formatBytes renders a byte count using binary units (KiB, MiB, ...).
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// containsString reports whether list contains s.
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"cloud.google.com/go/storage"
+	"golang.org/x/term"
+)
+
+// tableColumns are the headings of --table, in order.
+var tableColumns = [...]string{"Name", "Size", "Updated", "Class"}
+
+// tableFormatter collects the matches and, once the listing is done,
+// draws them as a table with box-drawing borders, sizing each column to
+// its widest cell. Names longer than nameWidth are cut with an ellipsis.
+type tableFormatter struct {
+	paths pathRenderer
+	times timeFormat
+	// nameWidth caps the name column, or is 0 for no cap; termWidth, when
+	// set, is the width the whole table must fit.
+	nameWidth int
+	termWidth int
+	// limit is the --max-buffered cap on the number of rows held.
+	limit int
+	rows  [][len(tableColumns)]string
+	bytes int64
+}
+
+// newTableFormatter returns a table formatter. Times are relative unless
+// --time-format asks otherwise. Without --table-name-width the name column
+// is capped so that the table fits a terminal on stdout, and left whole
+// when stdout is not a terminal.
+func newTableFormatter(paths pathRenderer, opts *options) *tableFormatter {
+	f := &tableFormatter{paths: paths, times: opts.timeFormat, nameWidth: opts.tableNameWidth, limit: opts.maxBuffered}
+	if f.times == (timeFormat{}) {
+		f.times = timeFormat{preset: timeRelative}
+	}
+	if f.nameWidth == 0 {
+		if width, _, err := term.GetSize(int(os.Stdout.Fd())); err == nil && width > 0 {
+			f.termWidth = width
+		}
+	}
+	return f
+}
+
+// header writes the human-readable listing banner.
+func (f *tableFormatter) header(w io.Writer, targets []listTarget) error {
+	return pathFormatter{paths: f.paths}.header(w, targets)
+}
+
+// object adds the row of a matched object. A label would be separated from
+// the name by a tab, which has no width in a table, so spaces are used.
+func (f *tableFormatter) object(w io.Writer, attrs *storage.ObjectAttrs) error {
+	name := strings.ReplaceAll(f.paths.annotate(attrs, f.paths.render(attrs)), "\t", "  ")
+	f.rows = append(f.rows, [len(tableColumns)]string{
+		name, formatBytes(attrs.Size), f.times.render(attrs.Updated), attrs.StorageClass,
+	})
+	f.bytes += attrs.Size
+	return checkBuffered(len(f.rows), f.limit, "--table")
+}
+
+// footer draws the table and a line with the totals. Nothing is drawn when
+// there were no matches.
+func (f *tableFormatter) footer(w io.Writer) error {
+	if len(f.rows) == 0 {
+		return nil
+	}
+	var widths [len(tableColumns)]int
+	for i, heading := range tableColumns {
+		widths[i] = utf8.RuneCountInString(heading)
+	}
+	for _, row := range f.rows {
+		for i, cell := range row {
+			widths[i] = max(widths[i], utf8.RuneCountInString(cell))
+		}
+	}
+	// Every column takes its padding and the border to its left, and the
+	// last border closes the row.
+	limit := f.nameWidth
+	if f.termWidth > 0 {
+		limit = f.termWidth - 4
+		for _, width := range widths[1:] {
+			limit -= width + 3
+		}
+		limit = max(limit, utf8.RuneCountInString(tableColumns[0]))
+	}
+	if limit > 0 {
+		widths[0] = min(widths[0], limit)
+	}
+
+	lines := []string{tableRule(widths[:], "┌", "┬", "┐"), tableRow(widths[:], tableColumns[:], 1), tableRule(widths[:], "├", "┼", "┤")}
+	for _, row := range f.rows {
+		row[0] = truncateWidth(row[0], widths[0])
+		lines = append(lines, tableRow(widths[:], row[:], 1))
+	}
+	lines = append(lines, tableRule(widths[:], "└", "┴", "┘"))
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "TOTAL: %d objects, %d bytes (%s)\n", len(f.rows), f.bytes, formatBytes(f.bytes))
+	return err
+}
+
+// machineReadable reports that the table is meant for reading; status
+// messages may share stdout.
+func (f *tableFormatter) machineReadable() bool {
+	return false
+}
+
+// tableRule returns a horizontal border for columns of the given widths.
+func tableRule(widths []int, left, middle, right string) string {
+	parts := make([]string, len(widths))
+	for i, width := range widths {
+		parts[i] = strings.Repeat("─", width+2)
+	}
+	return left + strings.Join(parts, middle) + right
+}
+
+// tableRow returns one row of cells padded to the column widths. The cell
+// of column right, such as a size, is aligned to the right; -1 aligns
+// none.
+func tableRow(widths []int, cells []string, right int) string {
+	parts := make([]string, len(cells))
+	for i, cell := range cells {
+		pad := strings.Repeat(" ", max(widths[i]-utf8.RuneCountInString(cell), 0))
+		if i == right {
+			parts[i] = " " + pad + cell + " "
+		} else {
+			parts[i] = " " + cell + pad + " "
+		}
+	}
+	return "│" + strings.Join(parts, "│") + "│"
+}
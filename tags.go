@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+// untaggedLabel is the tag of matches that no --tag pattern matches.
+const untaggedLabel = "untagged"
+
+// objectTag is one --tag: matches whose name matches pattern get the tag.
+type objectTag struct {
+	name    string
+	pattern string
+}
+
+// parseTag parses the NAME=GLOB of --tag. The glob is checked once the
+// --glob-syntax that matches it is known.
+func parseTag(v string) (objectTag, error) {
+	name, pattern, ok := strings.Cut(v, "=")
+	if !ok || name == "" || pattern == "" {
+		return objectTag{}, fmt.Errorf("expected NAME=GLOB, got %q", v)
+	}
+	if strings.ContainsAny(name, " \t,") {
+		return objectTag{}, fmt.Errorf("tag %q must not contain spaces or commas", name)
+	}
+	if name == untaggedLabel {
+		return objectTag{}, fmt.Errorf("tag %q is given to the matches no tag matches", name)
+	}
+	return objectTag{name: name, pattern: pattern}, nil
+}
+
+// objectTagger tags matched objects by the --tag patterns their names
+// match, in the order given, and counts the matches per tag. With all
+// unset an object gets only the first tag that matches it.
+type objectTagger struct {
+	tags   []objectTag
+	syntax string
+	all    bool
+	counts map[string]int
+}
+
+// newObjectTagger returns the tagger for the options, or nil without
+// --tag.
+func newObjectTagger(opts *options) *objectTagger {
+	if len(opts.tags) == 0 {
+		return nil
+	}
+	return &objectTagger{tags: opts.tags, syntax: opts.globSyntax, all: opts.allTags, counts: make(map[string]int)}
+}
+
+// of returns the tags of the object, untaggedLabel alone if none matches
+// it, or nil without tags.
+func (t *objectTagger) of(attrs *storage.ObjectAttrs) []string {
+	if t == nil {
+		return nil
+	}
+	var names []string
+	for _, tag := range t.tags {
+		// The patterns were checked by validate, so this cannot fail.
+		if ok, _ := globMatch(t.syntax, tag.pattern, attrs.Name); ok {
+			names = append(names, tag.name)
+			if !t.all {
+				break
+			}
+		}
+	}
+	if names == nil {
+		names = []string{untaggedLabel}
+	}
+	return names
+}
+
+// count records one delivered match under each of its tags.
+func (t *objectTagger) count(attrs *storage.ObjectAttrs) {
+	if t == nil {
+		return
+	}
+	for _, name := range t.of(attrs) {
+		t.counts[name]++
+	}
+}
+
+// printCounts writes the number of matches per tag, in the order the tags
+// were given, followed by the untagged ones.
+func (t *objectTagger) printCounts(w io.Writer) {
+	fmt.Fprintf(w, "\nMatches per tag:\n")
+	for _, tag := range t.tags {
+		fmt.Fprintf(w, "  %8d  %s\n", t.counts[tag.name], tag.name)
+	}
+	fmt.Fprintf(w, "  %8d  %s\n", t.counts[untaggedLabel], untaggedLabel)
+}
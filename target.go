@@ -0,0 +1,132 @@
+package main
+
+import (
+	"slices"
+	"strings"
+)
+
+// listTarget is one gs:// pattern to list, after parsing and expansion of
+// the pattern shorthands.
+type listTarget struct {
+	bucket  string
+	pattern string
+	// prefix is the literal part of the pattern sent to the API to narrow
+	// the listing.
+	prefix string
+	// group, when set, holds every target that one scan of prefix covers,
+	// in the order they were given; see mergeTargets.
+	group []listTarget
+	// label names the pattern in the output when it comes from a
+	// --pattern-file.
+	label string
+}
+
+// resolveTarget parses a gs:// path into a listTarget.
+func resolveTarget(gcsPath string, opts *options) (listTarget, error) {
+	bucketName, objectPattern, err := parseGCSPath(gcsPath)
+	if err != nil {
+		return listTarget{}, err
+	}
+	if err := checkBucketAllowed(bucketName, opts); err != nil {
+		return listTarget{}, err
+	}
+	if objectPattern, err = expandPattern(objectPattern, opts); err != nil {
+		return listTarget{}, err
+	}
+	t := listTarget{
+		bucket:  bucketName,
+		pattern: objectPattern,
+		// To make the GCS API call more efficient, we find the part of the
+		// pattern before any wildcards. This reduces the number of objects
+		// we have to process client-side.
+		prefix: queryPrefix(bucketName, objectPattern, opts),
+	}
+	if err := checkStrictGlob(t, opts); err != nil {
+		return listTarget{}, err
+	}
+	return t, nil
+}
+
+// resolveTargets resolves every gs:// path, so that a mistake in any of
+// them is reported before the first API call.
+func resolveTargets(gcsPaths []string, opts *options) ([]listTarget, error) {
+	targets := make([]listTarget, 0, len(gcsPaths))
+	for _, p := range gcsPaths {
+		t, err := resolveTarget(p, opts)
+		if err != nil {
+			return nil, err
+		}
+		if opts.labels != nil {
+			t.label = opts.labels[len(targets)]
+		}
+		targets = append(targets, t)
+	}
+	return targets, nil
+}
+
+// mergeTargets combines targets in the same bucket whose prefixes nest, so
+// that patterns such as logs/*.log and logs/**/*.json are matched during a
+// single scan of logs/ instead of one scan each. Merging never lists an
+// object that a separate scan would not have listed, and an object matching
+// several of the patterns is reported once.
+func mergeTargets(targets []listTarget) []listTarget {
+	var scans []listTarget
+	for _, t := range targets {
+		i := slices.IndexFunc(scans, func(s listTarget) bool {
+			return s.bucket == t.bucket && (strings.HasPrefix(t.prefix, s.prefix) || strings.HasPrefix(s.prefix, t.prefix))
+		})
+		if i < 0 {
+			scans = append(scans, t)
+			continue
+		}
+		s := &scans[i]
+		if s.group == nil {
+			s.group = []listTarget{*s}
+		}
+		s.group = append(s.group, t)
+		if len(t.prefix) < len(s.prefix) {
+			s.prefix = t.prefix
+		}
+	}
+	return scans
+}
+
+// patterns returns the targets whose patterns a scan of t matches against.
+func (t listTarget) patterns() []listTarget {
+	if t.group != nil {
+		return t.group
+	}
+	return []listTarget{t}
+}
+
+// relativeBase returns the directory part of the pattern's literal prefix,
+// which is removed from names shown relative to the pattern. It comes from
+// the pattern as written, which with --url-decode may differ from the
+// shortened server-side prefix.
+func (t listTarget) relativeBase() string {
+	literal := getPrefixFromPattern(t.pattern)
+	return literal[:strings.LastIndex(literal, "/")+1]
+}
+
+// url returns the target in gs://bucket/pattern form.
+func (t listTarget) url() string {
+	return "gs://" + t.bucket + "/" + t.pattern
+}
+
+// describeTargets returns the targets' URLs separated by spaces.
+func describeTargets(targets []listTarget) string {
+	urls := make([]string, len(targets))
+	for i, t := range targets {
+		urls[i] = t.url()
+	}
+	return strings.Join(urls, " ")
+}
+
+// targetPrefixes returns the server-side prefix of each target.
+func targetPrefixes(targets []listTarget) []string {
+	prefixes := make([]string, len(targets))
+	for i, t := range targets {
+		prefixes[i] = t.prefix
+	}
+	return prefixes
+}
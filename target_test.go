@@ -0,0 +1,70 @@
+package main
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestMergeTargets checks which patterns share a scan: those in the same
+// bucket whose prefixes nest, scanned from the shortest prefix.
+func TestMergeTargets(t *testing.T) {
+	tests := []struct {
+		urls []string
+		// want is the bucket and prefix of each scan with the number of
+		// patterns it matches.
+		want []string
+	}{
+		{[]string{"gs://b/logs/*.log"}, []string{"b/logs/ 1"}},
+		{[]string{"gs://b/logs/*.log", "gs://b/logs/*.json", "gs://b/logs/app/**/*.gz"}, []string{"b/logs/ 3"}},
+		{[]string{"gs://b/logs/app/*.log", "gs://b/logs/*.json"}, []string{"b/logs/ 2"}},
+		{[]string{"gs://b/logs/*.log", "gs://b/data/*.log"}, []string{"b/logs/ 1", "b/data/ 1"}},
+		{[]string{"gs://a/logs/*.log", "gs://b/logs/*.log"}, []string{"a/logs/ 1", "b/logs/ 1"}},
+		{[]string{"gs://b/logs/*.log", "gs://b/**/*.log"}, []string{"b/ 2"}},
+	}
+	opts, _, err := parseArgs([]string{"gs://b/x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		targets, err := resolveTargets(tt.urls, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, s := range mergeTargets(targets) {
+			got = append(got, s.bucket+"/"+s.prefix+" "+strconv.Itoa(len(s.patterns())))
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("mergeTargets(%v) = %v, want %v", tt.urls, got, tt.want)
+		}
+	}
+}
+
+// TestMergedScan checks that patterns under one prefix are matched in a
+// single listing, each match is printed once, and --stats counts the
+// matches of each pattern.
+func TestMergedScan(t *testing.T) {
+	fake := newFakeGCS(t, "m", []string{"logs/a.log", "logs/b.json", "logs/app/c.log.gz", "logs/app/d.txt", "other/e.log"}, 100)
+	opts, args, err := parseArgs([]string{"--stats", "--names-only", "gs://m/logs/*.log", "gs://m/logs/*.json", "gs://m/logs/**/*.gz", "gs://m/logs/**/*.log*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out, status strings.Builder
+	if err := listObjectsWithWildcard(t.Context(), args, opts, &out, &status); err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.prefixes(); !slices.Equal(got, []string{"logs/"}) {
+		t.Errorf("listings with prefixes %q, want one of logs/", got)
+	}
+	if got, want := strings.Fields(out.String()), []string{"logs/a.log", "logs/app/c.log.gz", "logs/b.json"}; !slices.Equal(got, want) {
+		t.Errorf("matched %v, want %v", got, want)
+	}
+	// A match is credited to the first pattern it matches.
+	for _, line := range []string{"1  gs://m/logs/*.log", "1  gs://m/logs/*.json", "1  gs://m/logs/**/*.gz", "0  gs://m/logs/**/*.log*"} {
+		if !strings.Contains(status.String(), line) {
+			t.Errorf("--stats missing %q:\n%s", line, status.String())
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// templateFragments are the regular expressions the built-in placeholders
+// of --name-template stand for. Any other {name} matches one non-empty
+// part of a segment, and {name:REGEX} matches REGEX.
+var templateFragments = map[string]string{
+	"yyyy": `[0-9]{4}`,
+	"mm":   `(?:0[1-9]|1[0-2])`,
+	"dd":   `(?:0[1-9]|[12][0-9]|3[01])`,
+	"hh":   `(?:[01][0-9]|2[0-3])`,
+	"uuid": `[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`,
+	"int":  `[0-9]+`,
+	"hex":  `[0-9a-fA-F]+`,
+}
+
+// templatePlaceholder matches a placeholder name, with its optional
+// expression after a colon.
+var templatePlaceholder = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)(?::(.+))?$`)
+
+// parseNameTemplate turns a --name-template such as
+// logs/{service}/{yyyy}/{mm}/{dd}/{uuid}.log into a regular expression
+// matching the whole names that conform to it. Text outside the braces is
+// literal.
+func parseNameTemplate(template string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	rest := template
+	for rest != "" {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			if strings.Contains(rest, "}") {
+				return nil, fmt.Errorf("invalid --name-template %q: unmatched }", template)
+			}
+			b.WriteString(regexp.QuoteMeta(rest))
+			break
+		}
+		if strings.Contains(rest[:open], "}") {
+			return nil, fmt.Errorf("invalid --name-template %q: unmatched }", template)
+		}
+		b.WriteString(regexp.QuoteMeta(rest[:open]))
+		// The expression of {name:REGEX} may have braces of its own, as
+		// in [0-9]{4}, so the placeholder ends at the brace that balances
+		// the opening one.
+		depth, end := 0, -1
+		for i := open; i < len(rest) && end < 0; i++ {
+			switch rest[i] {
+			case '{':
+				depth++
+			case '}':
+				if depth--; depth == 0 {
+					end = i
+				}
+			}
+		}
+		if end < 0 {
+			return nil, fmt.Errorf("invalid --name-template %q: unmatched {", template)
+		}
+		m := templatePlaceholder.FindStringSubmatch(rest[open+1 : end])
+		if m == nil {
+			return nil, fmt.Errorf("invalid --name-template %q: bad placeholder {%s}", template, rest[open+1:end])
+		}
+		switch fragment, builtin := templateFragments[m[1]]; {
+		case m[2] != "":
+			if _, err := regexp.Compile(m[2]); err != nil {
+				return nil, fmt.Errorf("invalid --name-template %q: placeholder {%s}: %w", template, m[1], err)
+			}
+			b.WriteString("(?:" + m[2] + ")")
+		case builtin:
+			b.WriteString(fragment)
+		default:
+			b.WriteString(`[^/]+?`)
+		}
+		rest = rest[end+1:]
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
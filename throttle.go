@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Adaptive throttling settings for --throttle-on-429. Each rate limit
+// response halves the request rate, down to throttleMinRate, and the rate
+// then grows by throttleRamp requests per second for every second without
+// one, the additive-increase/multiplicative-decrease scheme TCP uses.
+const (
+	throttleMinRate = 1.0
+	throttleRamp    = 1.0
+)
+
+// adaptiveThrottle paces requests once GCS starts answering 429 Too Many
+// Requests. Until the first one it does not limit anything. The rate it
+// starts from is half the average rate up to then. Once the rate has grown
+// back to that average, the limit is lifted again.
+type adaptiveThrottle struct {
+	mu    sync.Mutex
+	start time.Time
+	sent  int
+	// rate is the current limit in requests per second, or 0 when requests
+	// are not limited; ceiling is the rate at which the limit is lifted.
+	rate    float64
+	ceiling float64
+	// next is the earliest time the next request may be sent, and adjusted
+	// is when rate last changed.
+	next     time.Time
+	adjusted time.Time
+	limited  int
+}
+
+// newAdaptiveThrottle returns a throttle that does not limit anything yet.
+func newAdaptiveThrottle() *adaptiveThrottle {
+	return &adaptiveThrottle{start: time.Now()}
+}
+
+// wait blocks until the next request may be sent, or ctx is done. A nil
+// throttle never waits.
+func (t *adaptiveThrottle) wait(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	now := time.Now()
+	t.ramp(now)
+	var delay time.Duration
+	if t.rate > 0 {
+		at := t.next
+		if at.Before(now) {
+			at = now
+		}
+		t.next = at.Add(time.Duration(float64(time.Second) / t.rate))
+		delay = at.Sub(now)
+	}
+	t.sent++
+	t.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// ramp raises the limit for the time since it last changed, lifting it
+// once it reaches the ceiling. The caller holds mu.
+func (t *adaptiveThrottle) ramp(now time.Time) {
+	if t.rate == 0 {
+		return
+	}
+	t.rate += throttleRamp * now.Sub(t.adjusted).Seconds()
+	t.adjusted = now
+	if t.rate >= t.ceiling {
+		t.rate = 0
+	}
+}
+
+// rateLimited halves the request rate after a 429 response and returns the
+// new limit in requests per second.
+func (t *adaptiveThrottle) rateLimited() float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	t.ramp(now)
+	t.limited++
+	current := t.rate
+	if current == 0 {
+		if t.ceiling == 0 {
+			t.ceiling = float64(t.sent) / max(now.Sub(t.start).Seconds(), 1e-3)
+		}
+		current = t.ceiling
+	}
+	t.rate = max(throttleMinRate, current/2)
+	t.adjusted = now
+	return t.rate
+}
+
+// summary describes the throttle's state for --stats.
+func (t *adaptiveThrottle) summary() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.ramp(time.Now())
+	switch {
+	case t.limited == 0:
+		return "not throttled (no rate limit responses)"
+	case t.rate == 0:
+		return fmt.Sprintf("back to full speed after %d rate limit responses", t.limited)
+	default:
+		return fmt.Sprintf("%.1f requests/s after %d rate limit responses", t.rate, t.limited)
+	}
+}
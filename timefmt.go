@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Presets accepted by --time-format besides a Go layout.
+const (
+	timeRFC3339  = "rfc3339"
+	timeUnix     = "unix"
+	timeDate     = "date"
+	timeRelative = "relative"
+)
+
+// timeFormat renders the update and creation times of the output. The zero
+// value renders UTC RFC 3339, the default.
+type timeFormat struct {
+	// preset is one of the presets, or "" when layout is a Go layout.
+	preset string
+	layout string
+}
+
+// parseTimeFormat parses a --time-format value: a preset name, or a Go
+// reference-time layout such as "2006-01-02 15:04".
+func parseTimeFormat(v string) (timeFormat, error) {
+	switch v {
+	case timeRFC3339:
+		return timeFormat{preset: v, layout: time.RFC3339}, nil
+	case timeDate:
+		return timeFormat{preset: v, layout: time.DateOnly}, nil
+	case timeUnix, timeRelative:
+		return timeFormat{preset: v}, nil
+	}
+	// A layout without any reference-time element formats every time the
+	// same way, which is almost certainly a typo for a preset.
+	probe := time.Date(2001, time.March, 4, 5, 6, 7, 0, time.UTC)
+	if v == "" || probe.Format(v) == v {
+		return timeFormat{}, fmt.Errorf("expected rfc3339, unix, date, relative or a Go time layout such as 2006-01-02T15:04")
+	}
+	return timeFormat{layout: v}, nil
+}
+
+// render formats t in UTC, or returns "" for the zero time. relative times
+// are measured from now.
+func (f timeFormat) render(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	switch f.preset {
+	case timeUnix:
+		return strconv.FormatInt(t.Unix(), 10)
+	case timeRelative:
+		return humanizeTime(t)
+	}
+	if f.layout == "" {
+		return formatTime(t)
+	}
+	return t.UTC().Format(f.layout)
+}
+
+// humanizeTime describes how long ago t was, such as "3d4h ago", for
+// --time-format relative and --human-time.
+func humanizeTime(t time.Time) string {
+	// Clock skew can put a fresh object slightly in the future.
+	return formatAge(max(time.Since(t), 0)) + " ago"
+}
+
+// forJSON returns the format of the JSON records, which keep RFC 3339 so
+// that they stay parseable unless unix times were asked for.
+func (f timeFormat) forJSON() timeFormat {
+	if f.preset == timeUnix {
+		return f
+	}
+	return timeFormat{}
+}
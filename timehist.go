@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// Periods of --by-day and --by-hour.
+const (
+	timeBucketDay  = "day"
+	timeBucketHour = "hour"
+)
+
+// Object times accepted by --time-field.
+const (
+	timeFieldUpdated = "updated"
+	timeFieldCreated = "created"
+)
+
+// timePeriod is the number and bytes of the matches in one period, keyed
+// by when it starts.
+type timePeriod struct {
+	start   time.Time
+	objects int64
+	bytes   int64
+}
+
+// timeHistogram counts the matches and their bytes per day or hour for
+// --by-day and --by-hour, as they stream past. Only a counter per period
+// seen is kept, so even a listing of years by the hour stays small. Periods
+// follow the wall clock of loc: a day runs from midnight to midnight, and
+// the hour repeated when daylight saving time ends is counted twice, once
+// per offset.
+type timeHistogram struct {
+	unit    string
+	field   string
+	loc     *time.Location
+	periods map[int64]*timePeriod
+}
+
+// newTimeHistogram returns an empty histogram of periods of unit, by the
+// field time of each object, in loc.
+func newTimeHistogram(unit, field string, loc *time.Location) *timeHistogram {
+	return &timeHistogram{unit: unit, field: field, loc: loc, periods: make(map[int64]*timePeriod)}
+}
+
+// truncate returns the start of the period holding t.
+func (h *timeHistogram) truncate(t time.Time) time.Time {
+	t = t.In(h.loc)
+	if h.unit == timeBucketDay {
+		y, m, d := t.Date()
+		return time.Date(y, m, d, 0, 0, 0, 0, h.loc)
+	}
+	// Going back by the minutes past the hour, rather than truncating the
+	// instant, keeps zones with half-hour offsets on their own hours.
+	return t.Add(-time.Duration(t.Minute())*time.Minute - time.Duration(t.Second())*time.Second - time.Duration(t.Nanosecond()))
+}
+
+// next returns the start of the period after the one starting at start.
+func (h *timeHistogram) next(start time.Time) time.Time {
+	if h.unit == timeBucketDay {
+		y, m, d := start.Date()
+		return time.Date(y, m, d+1, 0, 0, 0, 0, h.loc)
+	}
+	return h.truncate(start.Add(time.Hour))
+}
+
+// add counts one match in the period of its time.
+func (h *timeHistogram) add(attrs *storage.ObjectAttrs) {
+	t := attrs.Updated
+	if h.field == timeFieldCreated {
+		t = attrs.Created
+	}
+	start := h.truncate(t)
+	p, ok := h.periods[start.Unix()]
+	if !ok {
+		p = &timePeriod{start: start}
+		h.periods[start.Unix()] = p
+	}
+	p.objects++
+	p.bytes += attrs.Size
+}
+
+// label names the period starting at start, as in "2024-06-01" or
+// "2024-06-01T13:00+02:00". Hours carry their offset, which tells the two
+// hours of the same wall-clock time apart.
+func (h *timeHistogram) label(start time.Time) string {
+	if h.unit == timeBucketDay {
+		return start.Format(time.DateOnly)
+	}
+	return start.Format("2006-01-02T15:04-07:00")
+}
+
+// print writes one line per period from the first to the last, oldest
+// first, with the number of matches, their total size and their share of
+// the bytes, then the totals. The periods in between without a match are
+// included, since a day with no ingest is what monitoring looks for.
+func (h *timeHistogram) print(w io.Writer) error {
+	var objects, bytes int64
+	var first, last time.Time
+	for _, p := range h.periods {
+		objects += p.objects
+		bytes += p.bytes
+		if first.IsZero() || p.start.Before(first) {
+			first = p.start
+		}
+		if p.start.After(last) {
+			last = p.start
+		}
+	}
+	if len(h.periods) > 0 {
+		for start := first; !start.After(last); start = h.next(start) {
+			p := h.periods[start.Unix()]
+			if p == nil {
+				p = &timePeriod{}
+			}
+			share := 0.0
+			if bytes > 0 {
+				share = float64(p.bytes) / float64(bytes) * 100
+			}
+			if _, err := fmt.Fprintf(w, "%-22s %10d %14s %6.1f%%\n", h.label(start), p.objects, formatBytes(p.bytes), share); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := fmt.Fprintf(w, "%-22s %10d %14s\n", "TOTAL", objects, formatBytes(bytes))
+	return err
+}
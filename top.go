@@ -0,0 +1,110 @@
+package main
+
+import (
+	"cmp"
+	"container/heap"
+	"errors"
+	"slices"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+// errNoMatches is returned by --newest and --oldest when nothing matched,
+// so that a script taking the name from stdout can tell there is none.
+var errNoMatches = errors.New("no objects matched")
+
+// heldMatches holds matches back until the scan is complete and then
+// delivers them in its own order: every match for --sort, only the first
+// few for --top-largest and --top-oldest, or a random few for --sample.
+type heldMatches interface {
+	add(attrs *storage.ObjectAttrs) error
+	each(fn func(*storage.ObjectAttrs) error) error
+	close()
+}
+
+// topBuffer keeps the first n matches in order, such as the n largest,
+// without holding the rest: a heap whose root is the last of the kept
+// matches lets each new match replace it or be dropped in O(log n), so
+// memory stays at n objects however many match.
+type topBuffer struct {
+	order func(a, b *storage.ObjectAttrs) int
+	n     int
+	kept  []*storage.ObjectAttrs
+}
+
+// newTopBuffer returns a buffer keeping the n largest matches, or with
+// oldest the n least recently updated.
+func newTopBuffer(n int, oldest bool) *topBuffer {
+	order := objectOrder(sortSize, true, nil, nil)
+	if oldest {
+		order = objectOrder(sortUpdated, false, nil, nil)
+	}
+	return &topBuffer{order: order, n: n}
+}
+
+// newNewestBuffer returns a buffer keeping only the most recently updated
+// match for --newest. Among matches updated at the same time it keeps the
+// first by name, as --oldest does.
+func newNewestBuffer() *topBuffer {
+	order := func(a, b *storage.ObjectAttrs) int {
+		return cmp.Or(b.Updated.Compare(a.Updated), strings.Compare(a.Name, b.Name), strings.Compare(a.Bucket, b.Bucket))
+	}
+	return &topBuffer{order: order, n: 1}
+}
+
+// Len is part of heap.Interface.
+func (b *topBuffer) Len() int {
+	return len(b.kept)
+}
+
+// Less is part of heap.Interface. It inverts the order, so that the last
+// kept match is at the root.
+func (b *topBuffer) Less(i, j int) bool {
+	return b.order(b.kept[i], b.kept[j]) > 0
+}
+
+// Swap is part of heap.Interface.
+func (b *topBuffer) Swap(i, j int) {
+	b.kept[i], b.kept[j] = b.kept[j], b.kept[i]
+}
+
+// Push is part of heap.Interface.
+func (b *topBuffer) Push(x any) {
+	b.kept = append(b.kept, x.(*storage.ObjectAttrs))
+}
+
+// Pop is part of heap.Interface.
+func (b *topBuffer) Pop() any {
+	last := b.kept[len(b.kept)-1]
+	b.kept = b.kept[:len(b.kept)-1]
+	return last
+}
+
+// add keeps the object if it is among the first n so far.
+func (b *topBuffer) add(attrs *storage.ObjectAttrs) error {
+	switch {
+	case len(b.kept) < b.n:
+		heap.Push(b, attrs)
+	case b.order(attrs, b.kept[0]) < 0:
+		b.kept[0] = attrs
+		heap.Fix(b, 0)
+	}
+	return nil
+}
+
+// each calls fn for every kept object in order, stopping at the first
+// error.
+func (b *topBuffer) each(fn func(*storage.ObjectAttrs) error) error {
+	sorted := slices.Clone(b.kept)
+	slices.SortFunc(sorted, b.order)
+	for _, attrs := range sorted {
+		if err := fn(attrs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// close does nothing; the kept objects are only in memory.
+func (b *topBuffer) close() {}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans of --trace. Until setupTracing installs a
+// tracer provider, the global one hands out spans that record nothing, so
+// the instrumentation costs next to nothing without --trace.
+var tracer = otel.Tracer("github.com/biolog71/gcsls")
+
+// traceFlushTimeout bounds the export of the spans still buffered when the
+// run ends, which gets a context of its own so that it happens even after
+// an interruption or deadline.
+const traceFlushTimeout = 5 * time.Second
+
+// startTracing sets up --trace and starts the span covering the run of
+// args, returning its context and the function that ends it with the
+// run's error and exports what is left, which must be called before the
+// process exits. The run's parent is the span in $TRACEPARENT, in W3C
+// Trace Context form, when a surrounding pipeline passes one down, so
+// that the run shows up within its trace. A failed export is reported on
+// status as a warning.
+func startTracing(ctx context.Context, args []string, status io.Writer) (context.Context, func(error), error) {
+	shutdown, err := setupTracing(ctx, status)
+	if err != nil {
+		return ctx, nil, err
+	}
+	if parent := os.Getenv("TRACEPARENT"); parent != "" {
+		carrier := propagation.MapCarrier{"traceparent": parent, "tracestate": os.Getenv("TRACESTATE")}
+		ctx = propagation.TraceContext{}.Extract(ctx, carrier)
+	}
+	ctx, span := tracer.Start(ctx, "gcsls", trace.WithAttributes(attribute.StringSlice("gcsls.args", args)))
+	return ctx, func(runErr error) {
+		endSpan(span, runErr)
+		flushCtx, cancel := context.WithTimeout(context.Background(), traceFlushTimeout)
+		defer cancel()
+		if err := shutdown(flushCtx); err != nil {
+			fmt.Fprintf(status, "Warning: failed to export traces: %v\n", err)
+		}
+	}, nil
+}
+
+// endSpan ends span, marking it failed with err if set.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// tracingTransport wraps the request for each page of an object listing,
+// retries included, in a span recording the query and the outcome. Other
+// requests are sent as they are.
+type tracingTransport struct {
+	base http.RoundTripper
+}
+
+// RoundTrip sends the request, in a span if it lists objects.
+func (t tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !isObjectListing(req) {
+		return t.base.RoundTrip(req)
+	}
+	q := req.URL.Query()
+	ctx, span := tracer.Start(req.Context(), "gcsls.listPage", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("gcs.prefix", q.Get("prefix")),
+			attribute.Bool("gcs.continued", q.Get("pageToken") != ""),
+		))
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if resp != nil {
+		span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+		if err == nil && resp.StatusCode >= http.StatusBadRequest {
+			span.SetStatus(codes.Error, resp.Status)
+		}
+	}
+	endSpan(span, err)
+	return resp, err
+}
+
+// traceObjectFunc wraps each call of fn, a per-object operation, in a span
+// naming the object.
+func traceObjectFunc(fn objectFunc) objectFunc {
+	return func(ctx context.Context, attrs *storage.ObjectAttrs) error {
+		ctx, span := tracer.Start(ctx, "gcsls.object", trace.WithAttributes(
+			attribute.String("gcs.bucket", attrs.Bucket),
+			attribute.String("gcs.object", attrs.Name),
+		))
+		err := fn(ctx, attrs)
+		endSpan(span, err)
+		return err
+	}
+}
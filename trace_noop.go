@@ -0,0 +1,15 @@
+//go:build notrace
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// setupTracing reports that --trace needs the OTLP exporter, which the
+// notrace build tag leaves out for a smaller binary.
+func setupTracing(ctx context.Context, status io.Writer) (shutdown func(context.Context) error, err error) {
+	return nil, errors.New("--trace needs a gcsls built with tracing support, without -tags notrace")
+}
@@ -0,0 +1,41 @@
+//go:build !notrace
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// setupTracing installs the tracer provider of --trace, which exports the
+// spans over OTLP/HTTP as the standard OTEL_EXPORTER_OTLP_* variables
+// configure, to localhost:4318 by default. OTEL_SERVICE_NAME and
+// OTEL_RESOURCE_ATTRIBUTES describe the process, whose service name is
+// otherwise gcsls. Failed exports are reported on status as warnings. The
+// returned function sends the spans still buffered and must be called
+// before the process exits.
+func setupTracing(ctx context.Context, status io.Writer) (shutdown func(context.Context) error, err error) {
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up --trace: %w", err)
+	}
+	res, err := resource.Merge(resource.NewSchemaless(semconv.ServiceName("gcsls")), resource.Environment())
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up --trace: %w", err)
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		fmt.Fprintf(status, "Warning: failed to export traces: %v\n", err)
+	}))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return provider.Shutdown, nil
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// errSelectionCancelled is returned when the user leaves an interactive
+// picker without choosing anything.
+var errSelectionCancelled = errors.New("selection cancelled")
+
+// Keys recognized by the interactive terminal UI.
+const (
+	keyNone = iota
+	keyRune
+	keyEnter
+	keyBackspace
+	keyUp
+	keyDown
+	keyLeft
+	keyRight
+	keyCancel
+)
+
+// interactiveTerminal reports whether the user can interact with a picker:
+// input must come from a terminal and the UI is drawn on stderr, which leaves
+// stdout free for command substitution like $(gcsls --select ...).
+func interactiveTerminal() bool {
+	return term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(os.Stderr.Fd()))
+}
+
+// terminalUI draws on stderr and reads single key presses from a terminal
+// in raw mode.
+type terminalUI struct {
+	in    *os.File
+	out   io.Writer
+	state *term.State
+	// drawn is the number of lines drawn by the previous frame, which the
+	// next frame overwrites.
+	drawn int
+}
+
+// newTerminalUI switches stdin into raw mode. The caller must call close.
+func newTerminalUI() (*terminalUI, error) {
+	state, err := term.MakeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up terminal: %w", err)
+	}
+	return &terminalUI{in: os.Stdin, out: os.Stderr, state: state}, nil
+}
+
+// close clears the UI and restores the terminal.
+func (t *terminalUI) close() {
+	t.draw(nil)
+	term.Restore(int(t.in.Fd()), t.state)
+}
+
+// width returns the terminal width, falling back to 80 columns.
+func (t *terminalUI) width() int {
+	if w, _, err := term.GetSize(int(os.Stderr.Fd())); err == nil && w > 0 {
+		return w
+	}
+	return 80
+}
+
+// height returns the terminal height, falling back to 24 rows.
+func (t *terminalUI) height() int {
+	if _, h, err := term.GetSize(int(os.Stderr.Fd())); err == nil && h > 0 {
+		return h
+	}
+	return 24
+}
+
+// draw replaces the previous frame with lines, truncating each to the
+// terminal width. Raw mode needs explicit carriage returns.
+func (t *terminalUI) draw(lines []string) {
+	var b strings.Builder
+	if t.drawn > 0 {
+		fmt.Fprintf(&b, "\r\x1b[%dA", t.drawn)
+	}
+	b.WriteString("\r\x1b[J")
+	width := t.width()
+	for _, line := range lines {
+		b.WriteString(truncateWidth(line, width-1))
+		b.WriteString("\r\n")
+	}
+	t.drawn = len(lines)
+	io.WriteString(t.out, b.String())
+}
+
+// readKey blocks until a key is pressed and returns it, with the typed rune
+// for keyRune.
+func (t *terminalUI) readKey() (int, rune, error) {
+	buf := make([]byte, 16)
+	n, err := t.in.Read(buf)
+	if err != nil {
+		return keyNone, 0, err
+	}
+	b := buf[:n]
+	switch {
+	case len(b) >= 3 && b[0] == 0x1b && b[1] == '[':
+		switch b[2] {
+		case 'A':
+			return keyUp, 0, nil
+		case 'B':
+			return keyDown, 0, nil
+		case 'C':
+			return keyRight, 0, nil
+		case 'D':
+			return keyLeft, 0, nil
+		}
+		return keyNone, 0, nil
+	case b[0] == 0x1b, b[0] == 0x03: // Esc, Ctrl-C
+		return keyCancel, 0, nil
+	case b[0] == '\r' || b[0] == '\n':
+		return keyEnter, 0, nil
+	case b[0] == 0x7f || b[0] == 0x08:
+		return keyBackspace, 0, nil
+	case b[0] == 0x10: // Ctrl-P
+		return keyUp, 0, nil
+	case b[0] == 0x0e: // Ctrl-N
+		return keyDown, 0, nil
+	case b[0] < 0x20:
+		return keyNone, 0, nil
+	}
+	r := []rune(string(b))
+	if len(r) == 0 {
+		return keyNone, 0, nil
+	}
+	return keyRune, r[0], nil
+}
+
+// pickItem shows a type-to-filter list of items and returns the chosen one.
+// Typing narrows the list to items containing the typed characters in order
+// (case-insensitively); arrow keys move the cursor and Enter chooses.
+func pickItem(items []string, title string) (string, error) {
+	ui, err := newTerminalUI()
+	if err != nil {
+		return "", err
+	}
+	defer ui.close()
+
+	var query []rune
+	cursor, offset := 0, 0
+	for {
+		visible := filterFuzzy(items, string(query))
+		rows := min(len(visible), max(ui.height()-3, 1))
+		cursor = min(max(cursor, 0), max(len(visible)-1, 0))
+		// Scroll so the cursor stays within the visible window.
+		if cursor < offset {
+			offset = cursor
+		}
+		if cursor >= offset+rows {
+			offset = cursor - rows + 1
+		}
+
+		lines := []string{fmt.Sprintf("%s (%d/%d)  > %s", title, len(visible), len(items), string(query))}
+		for i := offset; i < offset+rows && i < len(visible); i++ {
+			marker := "  "
+			if i == cursor {
+				marker = "> "
+			}
+			lines = append(lines, marker+visible[i])
+		}
+		ui.draw(lines)
+
+		key, r, err := ui.readKey()
+		if err != nil {
+			return "", err
+		}
+		switch key {
+		case keyRune:
+			query = append(query, r)
+			cursor, offset = 0, 0
+		case keyBackspace:
+			if len(query) > 0 {
+				query = query[:len(query)-1]
+				cursor, offset = 0, 0
+			}
+		case keyUp:
+			cursor--
+		case keyDown:
+			cursor++
+		case keyEnter:
+			if len(visible) > 0 {
+				return visible[cursor], nil
+			}
+		case keyCancel:
+			return "", errSelectionCancelled
+		}
+	}
+}
+
+// filterFuzzy returns the items that contain the runes of query in order,
+// ignoring case.
+func filterFuzzy(items []string, query string) []string {
+	if query == "" {
+		return items
+	}
+	q := []rune(strings.ToLower(query))
+	var out []string
+	for _, item := range items {
+		i := 0
+		for _, r := range strings.ToLower(item) {
+			if i < len(q) && r == q[i] {
+				i++
+			}
+		}
+		if i == len(q) {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// truncateWidth shortens s to at most width runes, marking the cut with an
+// ellipsis.
+func truncateWidth(s string, width int) string {
+	r := []rune(s)
+	if width <= 0 || len(r) <= width {
+		return s
+	}
+	if width == 1 {
+		return "…"
+	}
+	return string(r[:width-1]) + "…"
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// twoPhaseAttrs is what the first phase of --two-phase asks the listing
+// for: enough to match the name and pin the fetch to the listed version.
+var twoPhaseAttrs = []string{"Name", "Bucket", "Generation"}
+
+// twoPhaseFetch wraps a listing of names for --two-phase. Each listed
+// object is passed to scanned, and those keep accepts, by their name
+// alone, have their full attributes fetched, up to concurrency at a time.
+// The returned next yields the fetched attributes in listing order; an
+// object deleted between the listing and its fetch is left out. stop
+// abandons the fetches in flight.
+//
+// Everything but the fetches runs in the caller's goroutine, so scanned
+// and keep may update the scan's state without locking.
+func twoPhaseFetch(ctx context.Context, client *storage.Client, list func() (*storage.ObjectAttrs, error),
+	keep func(*storage.ObjectAttrs) bool, scanned func() error, concurrency int) (next func() (*storage.ObjectAttrs, error), stop func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	var (
+		// queue holds the fetches in flight, in listing order.
+		queue []chan fetchResult
+		done  bool
+	)
+	next = func() (*storage.ObjectAttrs, error) {
+		for {
+			for !done && len(queue) < concurrency {
+				listed, err := list()
+				if err == iterator.Done {
+					done = true
+					break
+				}
+				if err != nil {
+					return nil, err
+				}
+				if err := scanned(); err != nil {
+					return nil, err
+				}
+				if !keep(listed) {
+					continue
+				}
+				res := make(chan fetchResult, 1)
+				queue = append(queue, res)
+				go func() {
+					attrs, err := client.Bucket(listed.Bucket).Object(listed.Name).Generation(listed.Generation).Attrs(ctx)
+					res <- fetchResult{listed: listed, attrs: attrs, err: err}
+				}()
+			}
+			if len(queue) == 0 {
+				return nil, iterator.Done
+			}
+			r := <-queue[0]
+			queue = queue[1:]
+			switch {
+			case errors.Is(r.err, storage.ErrObjectNotExist):
+				continue
+			case r.err != nil:
+				return nil, fmt.Errorf("gs://%s/%s: failed to fetch object attributes: %w", r.listed.Bucket, r.listed.Name, r.err)
+			}
+			return r.attrs, nil
+		}
+	}
+	return next, cancel
+}
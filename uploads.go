@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// multipartUpload is one in-progress XML API multipart upload.
+type multipartUpload struct {
+	Key       string    `xml:"Key"`
+	UploadID  string    `xml:"UploadId"`
+	Initiated time.Time `xml:"Initiated"`
+}
+
+// listMultipartUploadsResult is one page of the XML API's
+// ListMultipartUploads response.
+type listMultipartUploadsResult struct {
+	Uploads            []multipartUpload `xml:"Upload"`
+	IsTruncated        bool              `xml:"IsTruncated"`
+	NextKeyMarker      string            `xml:"NextKeyMarker"`
+	NextUploadIDMarker string            `xml:"NextUploadIdMarker"`
+}
+
+// xmlEndpoint returns the base URL of the GCS XML API, honoring
+// STORAGE_EMULATOR_HOST like the storage library does.
+func xmlEndpoint() string {
+	host := os.Getenv("STORAGE_EMULATOR_HOST")
+	switch {
+	case host == "":
+		return "https://storage.googleapis.com"
+	case strings.Contains(host, "://"):
+		return strings.TrimSuffix(host, "/")
+	default:
+		return "http://" + host
+	}
+}
+
+// listIncompleteUploads prints the multipart uploads that were started but
+// neither completed nor aborted, for object names matching the patterns,
+// with the time each was started. The storage library has no call for
+// this, so it uses the XML API, which only knows about XML multipart
+// uploads: resumable uploads through the JSON API cannot be listed.
+func listIncompleteUploads(ctx context.Context, gcsPaths []string, opts *options, stdout, status io.Writer) error {
+	targets, err := resolveTargets(gcsPaths, opts)
+	if err != nil {
+		return err
+	}
+	client, err := newHTTPClient(ctx, opts, status)
+	if err != nil {
+		return err
+	}
+
+	out := newOutputWriter(stdout, opts.flushEvery)
+	defer out.Flush()
+
+	now := time.Now()
+	found := 0
+	for _, t := range mergeTargets(targets) {
+		match := newTargetMatcher(t, opts)
+		err := listMultipartUploads(ctx, client, t.bucket, t.prefix, opts.pageSize, func(u multipartUpload) error {
+			i, err := match(u.Key)
+			if err != nil || i < 0 {
+				return err
+			}
+			found++
+			_, err = fmt.Fprintf(out, "%s  %8s  gs://%s/%s  upload_id=%s\n",
+				formatTime(u.Initiated), formatAge(now.Sub(u.Initiated)), t.bucket, u.Key, u.UploadID)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if found == 0 {
+		fmt.Fprintln(out, "No incomplete uploads found matching the pattern.")
+	}
+	if err := out.Flush(); err != nil {
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+	return nil
+}
+
+// listMultipartUploads calls visit for every incomplete multipart upload
+// in the bucket whose object name starts with prefix, page by page.
+func listMultipartUploads(ctx context.Context, client *http.Client, bucket, prefix string, pageSize int,
+	visit func(multipartUpload) error) error {
+	var keyMarker, uploadIDMarker string
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		query := url.Values{"uploads": {""}, "prefix": {prefix}}
+		if keyMarker != "" {
+			query.Set("key-marker", keyMarker)
+			query.Set("upload-id-marker", uploadIDMarker)
+		}
+		if pageSize > 0 {
+			query.Set("max-uploads", strconv.Itoa(pageSize))
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+			xmlEndpoint()+"/"+url.PathEscape(bucket)+"?"+query.Encode(), nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return fmt.Errorf("failed to list incomplete uploads in gs://%s: %w", bucket, err)
+		}
+		var page listMultipartUploadsResult
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+			return fmt.Errorf("failed to list incomplete uploads in gs://%s: %s: %s", bucket, resp.Status, strings.TrimSpace(string(body)))
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read incomplete uploads in gs://%s: %w", bucket, err)
+		}
+
+		for _, u := range page.Uploads {
+			if err := visit(u); err != nil {
+				return err
+			}
+		}
+		// A truncated page without a marker would repeat forever.
+		if !page.IsTruncated || page.NextKeyMarker == "" {
+			return nil
+		}
+		keyMarker, uploadIDMarker = page.NextKeyMarker, page.NextUploadIDMarker
+	}
+}
+
+// formatAge renders a duration in days and hours, hours and minutes, or
+// minutes, whichever is the largest unit that fits.
+func formatAge(d time.Duration) string {
+	switch {
+	case d >= 24*time.Hour:
+		return fmt.Sprintf("%dd%dh", d/(24*time.Hour), d%(24*time.Hour)/time.Hour)
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh%dm", d/time.Hour, d%time.Hour/time.Minute)
+	default:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// errEnoughMatches stops a --wait poll as soon as the expected number of
+// matches has been seen; the rest of the listing is not needed.
+var errEnoughMatches = errors.New("enough matches")
+
+// waitForMatches re-lists the targets every --interval until at least
+// --expect-count objects match across them, reporting progress to status.
+// It gives up once --timeout elapses or the context is cancelled.
+func waitForMatches(ctx context.Context, client *storage.Client, targets []listTarget,
+	opts *options, filters []objectFilter, status io.Writer) error {
+	var deadline <-chan time.Time
+	if opts.timeout > 0 {
+		timer := time.NewTimer(opts.timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	for attempt := 1; ; attempt++ {
+		count := 0
+		for _, t := range targets {
+			err := scanMatches(ctx, client, t, opts, filters, newScanStats(t.prefix),
+				func(*storage.ObjectAttrs) error {
+					count++
+					if count >= opts.expectCount {
+						return errEnoughMatches
+					}
+					return nil
+				})
+			if errors.Is(err, errEnoughMatches) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+		fmt.Fprintf(status, "Waiting: %d of %d matching objects (attempt %d)\n", count, opts.expectCount, attempt)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-deadline:
+			return fmt.Errorf("timed out after %s waiting for %d matching objects", opts.timeout, opts.expectCount)
+		case <-time.After(opts.interval):
+		}
+	}
+}
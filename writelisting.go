@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+// listingUpload streams the output of --write-listing into a GCS object.
+// The object only comes into being when the upload is committed, so a run
+// that fails leaves whatever was there before untouched.
+type listingUpload struct {
+	url    string
+	w      *storage.Writer
+	cancel context.CancelFunc
+	// status is told where the listing went.
+	status io.Writer
+}
+
+// parseListingURL checks the --write-listing destination, which names a
+// single object.
+func parseListingURL(url string) (bucket, name string, err error) {
+	bucket, name, err = parseGCSPath(url)
+	if err != nil {
+		return "", "", err
+	}
+	if name == "" || strings.HasSuffix(name, "/") {
+		return "", "", fmt.Errorf("%q names no object; give the full object URL, such as gs://bucket/reports/listing.ndjson", url)
+	}
+	return bucket, name, nil
+}
+
+// listingContentType returns the content type of the written listing,
+// following the output format.
+func listingContentType(opts *options) string {
+	switch {
+	case opts.framedNDJSON:
+		return "application/octet-stream"
+	case opts.ndjson:
+		return "application/x-ndjson"
+	case opts.json:
+		return "application/json"
+	case opts.manifest:
+		return "text/csv"
+	case opts.binary:
+		return "application/octet-stream"
+	}
+	return "text/plain; charset=utf-8"
+}
+
+// gzipOutput reports whether the output is compressed: with --gzip, or
+// when the --write-listing object is named like a gzip file.
+func gzipOutput(opts *options) bool {
+	return opts.gzip || strings.HasSuffix(opts.writeListing, ".gz")
+}
+
+// openListingUpload starts the upload of the listing to url.
+func openListingUpload(ctx context.Context, client *storage.Client, url string, opts *options, status io.Writer) (*listingUpload, error) {
+	bucket, name, err := parseListingURL(url)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	// Each chunk of a resumable upload is sent to the same session, so
+	// repeating it cannot write anything twice. Uploads pass through gcsls's
+	// retry layer untouched and newStorageClient turns the library's
+	// retries off, so they are turned back on for this handle alone.
+	obj := client.Bucket(bucket).Object(name).Retryer(storage.WithPolicy(storage.RetryAlways))
+	w := obj.NewWriter(ctx)
+	w.ContentType = listingContentType(opts)
+	// A .gz object is a gzip file, downloaded as it is. Any other name
+	// keeps the format's content type with a gzip content encoding, which
+	// GCS decompresses on the way to clients that do not ask for it
+	// compressed.
+	switch {
+	case strings.HasSuffix(name, ".gz"):
+		w.ContentType = "application/gzip"
+	case opts.gzip:
+		w.ContentEncoding = "gzip"
+	}
+	return &listingUpload{url: url, w: w, cancel: cancel, status: status}, nil
+}
+
+// Write sends p on to the upload.
+func (u *listingUpload) Write(p []byte) (int, error) {
+	return u.w.Write(p)
+}
+
+// finish ends the upload once the run is over: after a run that failed
+// with runErr, the upload is abandoned and runErr returned; otherwise the
+// buffered output is flushed, a gzip stream closed, and the object
+// committed. Close is where the
+// upload really happens, so its error is the one that says whether the
+// listing was written.
+func (u *listingUpload) finish(out *outputWriter, runErr error) error {
+	if runErr == nil {
+		if runErr = out.Close(); runErr != nil {
+			runErr = fmt.Errorf("failed to write output: %w", runErr)
+		}
+	}
+	if runErr != nil {
+		u.cancel()
+		u.w.Close()
+		return runErr
+	}
+	defer u.cancel()
+	if err := u.w.Close(); err != nil {
+		return fmt.Errorf("failed to write listing to %s: %w", u.url, err)
+	}
+	fmt.Fprintf(u.status, "Wrote listing to %s (%s)\n", u.url, formatBytes(u.w.Attrs().Size))
+	return nil
+}
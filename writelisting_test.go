@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestListingUploadRetried checks that an upload of --write-listing that
+// fails with a transient error is sent again rather than failing the run.
+func TestListingUploadRetried(t *testing.T) {
+	var mu sync.Mutex
+	attempts := 0
+	var stored string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/upload/storage/v1/b/out/o") {
+			http.NotFound(w, r)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		if attempts == 1 {
+			http.Error(w, `{"error":{"code":503,"message":"backend unavailable"}}`, http.StatusServiceUnavailable)
+			return
+		}
+		stored = string(body)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"kind":"storage#object","bucket":"out","name":"listing.txt","generation":"1"}`)
+	}))
+	defer srv.Close()
+	t.Setenv("STORAGE_EMULATOR_HOST", strings.TrimPrefix(srv.URL, "http://"))
+
+	opts, _, err := parseArgs([]string{"gs://in/**"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	client, err := newStorageClient(ctx, opts, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	u, err := openListingUpload(ctx, client, "gs://out/listing.txt", opts, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := newOutputWriter(u, 0)
+	if _, err := io.WriteString(out, "gs://in/a\ngs://in/b\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.finish(out, nil); err != nil {
+		t.Fatalf("finish: %v", err)
+	}
+	if attempts != 2 {
+		t.Errorf("got %d upload attempts, want 2", attempts)
+	}
+	if !strings.Contains(stored, "gs://in/a\ngs://in/b\n") {
+		t.Errorf("uploaded body %q does not hold the listing", stored)
+	}
+}